@@ -0,0 +1,72 @@
+package edit
+
+import (
+	"fmt"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Navigation mode and the history listing can take up the whole terminal, and
+// what they show stays in the scrollback after they are done. With
+// le:alt-screen on, the editor switches to the alternate screen while they
+// are shown, and back to the primary screen, as it was, when they are done,
+// so that they leave nothing behind.
+
+// Escape sequences to switch to the alternate screen, saving the cursor,
+// clearing it and moving home, and to switch back and restore the cursor.
+const (
+	enterAltScreen = "\033[?1049h\033[H"
+	exitAltScreen  = "\033[?1049l"
+)
+
+func init() {
+	addEditorBuiltin("le:alt-screen", builtinAltScreen)
+}
+
+// fullScreen returns whether bs shows a mode that takes up the terminal.
+func fullScreen(bs *editorState) bool {
+	return bs.navigation != nil || bs.historyListing != nil
+}
+
+// switchScreen switches to the alternate screen or back, in the next frame,
+// if bs starts or ends showing a full-screen mode. The writer starts drawing
+// afresh on the alternate screen, and goes on from what it had drawn on the
+// primary screen when it switches back to it.
+func (w *writer) switchScreen(bs *editorState, width int) {
+	alt := w.altScreen && !w.caps.dumb && fullScreen(bs)
+	if alt == (w.primaryBuf != nil) {
+		return
+	}
+	if alt {
+		w.primaryBuf = w.oldBuf
+		w.oldBuf = w.newBuffer(width)
+		w.screenSwitch = enterAltScreen
+	} else {
+		w.oldBuf = w.primaryBuf
+		w.primaryBuf = nil
+		w.screenSwitch = exitAltScreen
+	}
+}
+
+// builtinAltScreen implements the le:alt-screen builtin. With no arguments, it
+// prints whether full-screen modes are shown on the alternate screen. With
+// one, on or off, it turns that on or off.
+func builtinAltScreen(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.altScreen))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.altScreen = true
+		case "off":
+			ed.altScreen = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,31 @@
+package edit
+
+import "testing"
+
+func TestSwitchScreen(t *testing.T) {
+	o := newOptions()
+	primary := o.newBuffer(80)
+	w := &writer{oldBuf: primary, options: o}
+	listing := &editorState{historyListing: &historyListing{}}
+
+	w.altScreen = false
+	w.switchScreen(listing, 80)
+	if w.screenSwitch != "" || w.oldBuf != primary {
+		t.Errorf("switched screens with le:alt-screen off")
+	}
+
+	w.altScreen = true
+	w.switchScreen(listing, 80)
+	if w.screenSwitch != enterAltScreen || w.primaryBuf != primary || w.oldBuf == primary {
+		t.Errorf("did not switch to the alternate screen for the history listing")
+	}
+	w.screenSwitch = ""
+	w.switchScreen(listing, 80)
+	if w.screenSwitch != "" {
+		t.Errorf("switched screens again on the alternate screen")
+	}
+	w.switchScreen(&editorState{}, 80)
+	if w.screenSwitch != exitAltScreen || w.primaryBuf != nil || w.oldBuf != primary {
+		t.Errorf("did not switch back to the primary screen, as it was drawn")
+	}
+}
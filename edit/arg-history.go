@@ -0,0 +1,95 @@
+package edit
+
+import (
+	"strings"
+
+	"github.com/xiaq/elvish/parse"
+)
+
+// argKey identifies the values of an argument in argIndex. An empty flag
+// stands for the first argument of the command.
+type argKey struct {
+	command, flag string
+}
+
+// argIndex indexes the words of the history by command and flag, for
+// completing values used before. The values of each key are kept in the
+// order they appear in the history.
+type argIndex map[argKey][]string
+
+// add indexes the arguments in line. Flags are words starting with "-"; the
+// word following a flag, if not a flag itself, is its value.
+func (ai argIndex) add(line string) {
+	for _, words := range splitWords(line) {
+		if len(words) < 2 || words[0] == "" {
+			continue
+		}
+		command := words[0]
+		if words[1] != "" {
+			ai[argKey{command, ""}] = append(ai[argKey{command, ""}], words[1])
+		}
+		for i := 2; i < len(words); i++ {
+			flag, value := words[i-1], words[i]
+			if strings.HasPrefix(flag, "-") && value != "" && !strings.HasPrefix(value, "-") {
+				ai[argKey{command, flag}] = append(ai[argKey{command, flag}], value)
+			}
+		}
+	}
+}
+
+// values returns the values of flag of command, the most recent first and
+// without duplicates.
+func (ai argIndex) values(command, flag string) []string {
+	all := ai[argKey{command, flag}]
+	seen := make(map[string]bool)
+	var values []string
+	for i := len(all) - 1; i >= 0; i-- {
+		if !seen[all[i]] {
+			seen[all[i]] = true
+			values = append(values, all[i])
+		}
+	}
+	return values
+}
+
+// splitWords splits line into the words of each of its forms. A word is the
+// text of adjacent string literals, quotes included; words made of anything
+// else, like variables and closures, are kept as "".
+func splitWords(line string) [][]string {
+	var forms [][]string
+	var words []string
+	word, plain, inWord := "", true, false
+	endWord := func() {
+		if inWord {
+			if !plain {
+				word = ""
+			}
+			words = append(words, word)
+		}
+		word, plain, inWord = "", true, false
+	}
+	for item := range parse.Lex("<history>", line).Chan() {
+		switch item.Typ {
+		case parse.ItemSpace:
+			endWord()
+		case parse.ItemSemicolon, parse.ItemPipe, parse.ItemEndOfLine,
+			parse.ItemAmpersand, parse.ItemEOF:
+			endWord()
+			if len(words) > 0 {
+				forms = append(forms, words)
+			}
+			words = nil
+		case parse.ItemBare, parse.ItemSingleQuoted, parse.ItemDoubleQuoted:
+			word += item.Val
+			inWord = true
+		default:
+			plain = false
+			inWord = true
+		}
+	}
+	endWord()
+	if len(words) > 0 {
+		forms = append(forms, words)
+	}
+	return forms
+}
@@ -0,0 +1,50 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var splitWordsTests = []struct {
+	line string
+	want [][]string
+}{
+	{"ssh host", [][]string{{"ssh", "host"}}},
+	{"echo `a b`c; ls | wc -l", [][]string{{"echo", "`a b`c"}, {"ls"}, {"wc", "-l"}}},
+	{"echo $a x", [][]string{{"echo", "", "x"}}},
+	{"", nil},
+}
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range splitWordsTests {
+		if out := splitWords(tt.line); !reflect.DeepEqual(out, tt.want) {
+			t.Errorf("splitWords(%q) => %q, want %q", tt.line, out, tt.want)
+		}
+	}
+}
+
+var argIndexTests = []struct {
+	command, flag string
+	want          []string
+}{
+	{"ssh", "", []string{"web", "db"}},
+	{"aws", "--profile", []string{"prod", "dev"}},
+	{"aws", "-v", nil},
+	{"ls", "", nil},
+}
+
+func TestArgIndex(t *testing.T) {
+	ai := make(argIndex)
+	for _, line := range []string{
+		"ssh db", "aws s3 --profile dev ls", "ssh web",
+		"aws --profile prod -v", "aws ec2 --profile dev; ls"} {
+		ai.add(line)
+	}
+	// The value used most recently comes first
+	ai.add("aws --profile prod")
+	for _, tt := range argIndexTests {
+		if out := ai.values(tt.command, tt.flag); !reflect.DeepEqual(out, tt.want) {
+			t.Errorf("values(%q, %q) => %q, want %q", tt.command, tt.flag, out, tt.want)
+		}
+	}
+}
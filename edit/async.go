@@ -0,0 +1,32 @@
+package edit
+
+// The state of an Editor, editorState and most of the other fields, is owned
+// by the goroutine running ReadLine; nothing else reads or changes it, so it
+// needs no locks. Other goroutines, like ones finding completions in the
+// background or watching jobs, send it messages instead: post queues a
+// function to be run by ReadLine, and wakes it up to run it and redraw.
+// Functions posted while ReadLine is not running are run when it starts.
+//
+// Methods that may be called from any goroutine, like Notify and SetPreedit,
+// post functions. The metrics, which are also measured in the background, are
+// the only state that is locked.
+
+// post queues f to be run by the goroutine running ReadLine, and wakes it up.
+// It can be called from any goroutine, and never blocks for long.
+func (ed *Editor) post(f func()) {
+	ed.asyncMutex.Lock()
+	ed.posted = append(ed.posted, f)
+	ed.asyncMutex.Unlock()
+	ed.Redraw()
+}
+
+// runPosted runs the functions posted, in the order they were posted.
+func (ed *Editor) runPosted() {
+	ed.asyncMutex.Lock()
+	fs := ed.posted
+	ed.posted = nil
+	ed.asyncMutex.Unlock()
+	for _, f := range fs {
+		f()
+	}
+}
@@ -4,37 +4,79 @@ import (
 	"github.com/xiaq/elvish/parse"
 )
 
-var (
-	attrForPrompt            = ""
-	attrForRprompt           = "7"
-	attrForCompleted         = ";4"
-	attrForMode              = "1;7;33"
-	attrForTip               = ""
-	attrForCurrentCompletion = ";7"
-	attrForCompletedHistory  = "4"
-	attrForSelectedFile      = ";7"
-)
+// attrs are the attributes of the styles, which are SGR parameters. Styles
+// are named in the style registry of theme.go.
+type attrs struct {
+	attrForPrompt            string
+	attrForRprompt           string
+	attrForCompleted         string
+	attrForMode              string
+	attrForTip               string
+	attrForCurrentCompletion string
+	attrForCompletedHistory  string
+	attrForSelectedFile      string
+	attrForScrollMark        string
+	attrForEOLMarker         string
+	attrForLineError         string
+	attrForSuggestion        string
+	attrForSelection         string
+	attrForDescription       string
+	attrForGroupHeader       string
+	attrForPreedit           string
+	attrForMessage           string
+	attrForInstant           string
+	attrForSnippetField      string
+	attrForMatch             string
+	// The attributes of the types of items of syntax highlighting.
+	attrForType map[parse.ItemType]string
+}
+
+// defaultAttrs returns the attributes of the default theme.
+func defaultAttrs() attrs {
+	return attrs{
+		attrForPrompt:            "",
+		attrForRprompt:           "7",
+		attrForCompleted:         ";4",
+		attrForMode:              "1;7;33",
+		attrForTip:               "",
+		attrForCurrentCompletion: ";7",
+		attrForCompletedHistory:  "4",
+		attrForSelectedFile:      ";7",
+		attrForScrollMark:        "1",
+		attrForEOLMarker:         "7",
+		attrForLineError:         ";4",
+		attrForSuggestion:        "2",
+		attrForSelection:         ";7",
+		attrForDescription:       "2",
+		attrForGroupHeader:       "1;4",
+		attrForPreedit:           "4",
+		attrForMessage:           "1",
+		attrForInstant:           "2",
+		attrForSnippetField:      ";7",
+		attrForMatch:             ";7;33",
 
-var attrForType = map[parse.ItemType]string{
-	parse.ItemSpace:             "36", // only applies to comments
-	parse.ItemSingleQuoted:      "33",
-	parse.ItemDoubleQuoted:      "33",
-	parse.ItemRedirLeader:       "32",
-	parse.ItemStatusRedirLeader: "32",
-	parse.ItemPipe:              "32",
-	parse.ItemError:             "31",
-	parse.ItemQuestionLParen:    "34;1",
-	parse.ItemLParen:            "34;1",
-	parse.ItemRParen:            "34;1",
-	parse.ItemLBracket:          "34;1",
-	parse.ItemRBracket:          "34;1",
-	parse.ItemLBrace:            "34;1",
-	parse.ItemRBrace:            "34;1",
-	parse.ItemAmpersand:         "1",
-	parse.ItemDollar:            "35",
+		attrForType: map[parse.ItemType]string{
+			parse.ItemSpace:             "36", // only applies to comments
+			parse.ItemSingleQuoted:      "33",
+			parse.ItemDoubleQuoted:      "33",
+			parse.ItemRedirLeader:       "32",
+			parse.ItemStatusRedirLeader: "32",
+			parse.ItemPipe:              "32",
+			parse.ItemError:             "31",
+			parse.ItemQuestionLParen:    "34;1",
+			parse.ItemLParen:            "34;1",
+			parse.ItemRParen:            "34;1",
+			parse.ItemLBracket:          "34;1",
+			parse.ItemRBracket:          "34;1",
+			parse.ItemLBrace:            "34;1",
+			parse.ItemRBrace:            "34;1",
+			parse.ItemAmpersand:         "1",
+			parse.ItemDollar:            "35",
 
-	ItemValidCommand:    "32",
-	ItemInvalidCommand:  "31",
-	ItemValidVariable:   "35",
-	ItemInvalidVariable: "31",
+			ItemValidCommand:    "32",
+			ItemInvalidCommand:  "31",
+			ItemValidVariable:   "35",
+			ItemInvalidVariable: "31",
+		},
+	}
 }
@@ -0,0 +1,61 @@
+package edit
+
+// With the theme "auto", which main applies unless ~/.elvish/theme exists,
+// the theme is picked by the background color of the terminal: "light" on a
+// light background and "default" on a dark one. The background is queried
+// with OSC 11 along with the capabilities, and again on SIGWINCH and when the
+// window regains focus, since some terminals follow the light or dark mode of
+// the OS. Terminals that don't reply fall back to DefaultThemeName.
+
+// backgroundQuery queries the background color of the terminal.
+const backgroundQuery = "\033]11;?\033\\"
+
+// background is the kind of the background color of the terminal.
+type background int
+
+// Possible values for background.
+const (
+	unknownBackground background = iota
+	darkBackground
+	lightBackground
+)
+
+// autoThemeName returns the name of the theme "auto" stands for.
+func (ed *Editor) autoThemeName() string {
+	switch ed.termBackground {
+	case lightBackground:
+		return "light"
+	case darkBackground:
+		return "default"
+	default:
+		return DefaultThemeName()
+	}
+}
+
+// updateBackground records the background if rep reports it, and if it has
+// changed and the theme is "auto", switches to the theme for it.
+func (ed *Editor) updateBackground(rep *termReply) {
+	if rep.typ != replyColor || len(rep.params) != 4 || rep.params[0] != 11 {
+		return
+	}
+	r, g, b := rep.params[1], rep.params[2], rep.params[3]
+	bg := darkBackground
+	if (299*r+587*g+114*b)/1000 > 0x7fff {
+		bg = lightBackground
+	}
+	if bg == ed.termBackground {
+		return
+	}
+	ed.termBackground = bg
+	if ed.autoTheme {
+		themes[ed.autoThemeName()].apply(&ed.attrs)
+	}
+}
+
+// queryBackground queries the background again, if the theme is "auto". The
+// reply is handled as it is read.
+func (ed *Editor) queryBackground() {
+	if ed.autoTheme && !ed.writer.caps.dumb {
+		ed.writer.file.WriteString(ed.writer.caps.wrapQuery(backgroundQuery))
+	}
+}
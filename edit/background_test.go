@@ -0,0 +1,43 @@
+package edit
+
+import "testing"
+
+var updateBackgroundTests = []struct {
+	rep termReply
+	bg  background
+}{
+	{termReply{replyColor, []int{11, 0xffff, 0xffff, 0xffff}}, lightBackground},
+	{termReply{replyColor, []int{11, 0xfdfd, 0xf6f6, 0xe3e3}}, lightBackground},
+	{termReply{replyColor, []int{11, 0, 0x2b2b, 0x3636}}, darkBackground},
+	// Not replies about the background
+	{termReply{replyColor, []int{10, 0xffff, 0xffff, 0xffff}}, unknownBackground},
+	{termReply{replyDA1, []int{62}}, unknownBackground},
+}
+
+func TestUpdateBackground(t *testing.T) {
+	for _, tt := range updateBackgroundTests {
+		ed := &Editor{options: newOptions()}
+		ed.updateBackground(&tt.rep)
+		if ed.termBackground != tt.bg {
+			t.Errorf("updateBackground(%v) => background %v, want %v", tt.rep, ed.termBackground, tt.bg)
+		}
+	}
+}
+
+func TestAutoTheme(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.termBackground = darkBackground
+	if err := ed.LoadTheme("auto"); err != nil || ed.attrForMode != themes["default"]["mode"] {
+		t.Errorf("LoadTheme(auto) on dark background => %v, mode %q, want theme default", err, ed.attrForMode)
+	}
+	ed.updateBackground(&termReply{replyColor, []int{11, 0xffff, 0xffff, 0xffff}})
+	if ed.attrForMode != themes["light"]["mode"] {
+		t.Errorf("light background with theme auto => mode %q, want theme light", ed.attrForMode)
+	}
+	// Other themes stay when the background changes
+	ed.LoadTheme("mono")
+	ed.updateBackground(&termReply{replyColor, []int{11, 0, 0, 0}})
+	if ed.attrForMode != themes["mono"]["mode"] {
+		t.Errorf("dark background with theme mono => mode %q, want theme mono", ed.attrForMode)
+	}
+}
@@ -0,0 +1,170 @@
+package edit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Arguments of commands that only ship completions for bash are completed by
+// running bash. The completion script of bash, usually from the
+// bash-completion package, is sourced in a bash started without rc files and
+// input, the completion of the command is loaded, and the words of the line
+// are passed in COMP_WORDS like when bash completes. Completion functions
+// (complete -F) are called, and other completion specs are passed to compgen;
+// the words in COMPREPLY are the candidates. Bash is found and checked by the
+// exec filter like the command of a form, and runs with the environment of
+// the evaluator, except for BASH_ENV and ENV, which would make it source
+// files despite --norc. Bash is killed, with any process it has started,
+// after BashCompletionTimeout.
+
+// BashCompletionTimeout is how long bash is given to find completions.
+const BashCompletionTimeout = 2 * time.Second
+
+// bashCompletionScripts are where the completion script of bash is looked for.
+var bashCompletionScripts = []string{
+	"/usr/share/bash-completion/bash_completion",
+	"/usr/local/share/bash-completion/bash_completion",
+	"/etc/bash_completion",
+}
+
+func init() {
+	addEditorBuiltin("le:bash-completion", builtinBashCompletion)
+}
+
+// findBashCompletionScript returns the first of bashCompletionScripts that
+// exists, which editors use until it is changed with le:bash-completion, or ""
+// if there is none.
+func findBashCompletionScript() string {
+	for _, script := range bashCompletionScripts {
+		if _, err := os.Stat(script); err == nil {
+			return script
+		}
+	}
+	return ""
+}
+
+// bashAdapter is run by bash with the completion script, followed by the
+// words of the line, the last one being completed.
+const bashAdapter = `
+source "$1" >/dev/null 2>&1
+shift
+COMP_WORDS=("$@")
+COMP_CWORD=$(($# - 1))
+COMP_LINE="${COMP_WORDS[*]}"
+COMP_POINT=${#COMP_LINE}
+cmd=$1
+cur=${COMP_WORDS[COMP_CWORD]}
+prev=${COMP_WORDS[COMP_CWORD-1]}
+if ! spec=$(complete -p "$cmd" 2>/dev/null); then
+	if type -t __load_completion >/dev/null; then
+		__load_completion "$cmd" >/dev/null 2>&1
+	elif type -t _completion_loader >/dev/null; then
+		_completion_loader "$cmd" >/dev/null 2>&1
+	fi
+	spec=$(complete -p "$cmd" 2>/dev/null) || exit 1
+fi
+case $spec in
+*" -F _minimal "*)
+	# The fallback of bash-completion for commands without completions,
+	# which only completes file names
+	exit 1
+	;;
+*" -F "*)
+	f=${spec##* -F }
+	f=${f%% *}
+	COMPREPLY=()
+	"$f" "$cmd" "$cur" "$prev" >/dev/null 2>&1
+	;;
+*)
+	opts=${spec#complete }
+	opts=${opts% *}
+	eval "COMPREPLY=(\$(compgen $opts -- \"\$cur\"))" 2>/dev/null
+	;;
+esac
+printf '%s\n' "${COMPREPLY[@]}"
+`
+
+// bashCompleter prepares bash to find the completions of the last of words,
+// the words of the line, with script. The function returned runs it and
+// returns the completions.
+func bashCompleter(ev *eval.Evaluator, script string, words []string) (func() ([]string, error), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), BashCompletionTimeout)
+	args := append([]string{"--norc", "--noprofile", "-c", bashAdapter, "bash", script}, words...)
+	cmd, err := ev.ExternalCommand(ctx, "", "bash", args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	env := cmd.Env[:0]
+	for _, kv := range cmd.Env {
+		if !strings.HasPrefix(kv, "BASH_ENV=") && !strings.HasPrefix(kv, "ENV=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = env
+	killGroupOnCancel(cmd)
+	return func() ([]string, error) {
+		defer cancel()
+		return bashCompletions(cmd)
+	}, nil
+}
+
+// bashCompletions runs cmd, bash prepared by bashCompleter, and returns the
+// completions it prints.
+func bashCompletions(cmd *exec.Cmd) ([]string, error) {
+	var out limitedBuffer
+	cmd.Stdout = &out
+	err := cmd.Run()
+	// Processes it has left running are not needed any longer
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(out.String(), "\n") {
+		name = strings.TrimRight(name, " ")
+		if name != "" && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names, nil
+}
+
+// builtinBashCompletion implements the le:bash-completion builtin. With no
+// arguments, it prints the completion script of bash used, or off. With one
+// argument, it sets the script; off turns bash completion off.
+func builtinBashCompletion(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		if ed.bashCompletionScript == "" {
+			fmt.Fprintln(ev.OutFile(), "off")
+		} else {
+			fmt.Fprintln(ev.OutFile(), ed.bashCompletionScript)
+		}
+		return ""
+	case 1:
+		script := args[0].String()
+		if script == "off" {
+			ed.bashCompletionScript = ""
+			return ""
+		}
+		if _, err := os.Stat(script); err != nil {
+			return err.Error()
+		}
+		ed.bashCompletionScript = script
+		return ""
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,76 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// fakeBashCompletion defines completions for two commands, one with a
+// function and one with a word list.
+const fakeBashCompletion = `
+_fake() {
+	case $3 in
+	fake) COMPREPLY=($(compgen -W "start stop status" -- "$2")) ;;
+	*) COMPREPLY=(--force) ;;
+	esac
+}
+complete -F _fake fake
+complete -W "red green" colors
+complete -F _minimal plain
+`
+
+var bashCompletionsTests = []struct {
+	words  []string
+	wanted []string
+}{
+	{[]string{"fake", "st"}, []string{"start", "stop", "status"}},
+	{[]string{"fake", "start", ""}, []string{"--force"}},
+	{[]string{"colors", "g"}, []string{"green"}},
+	{[]string{"plain", ""}, nil},
+	{[]string{"nosuchcommand", ""}, nil},
+}
+
+func TestBashCompletions(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "bash_completion")
+	if err := ioutil.WriteFile(script, []byte(fakeBashCompletion), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files in BASH_ENV are not sourced
+	bashEnv := filepath.Join(dir, "bash_env")
+	if err := ioutil.WriteFile(bashEnv, []byte("echo injected\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BASH_ENV", bashEnv)
+	defer os.Unsetenv("BASH_ENV")
+
+	ev := eval.NewEvaluator()
+	for _, tt := range bashCompletionsTests {
+		bash, err := bashCompleter(ev, script, tt.words)
+		if err != nil {
+			t.Fatalf("bashCompleter(script, %q) => error %v", tt.words, err)
+		}
+		if out, _ := bash(); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("bash completion of %q => %q, want %q", tt.words, out, tt.wanted)
+		}
+	}
+
+	ev.SetExecFilter(eval.WhitelistExecFilter(nil))
+	if _, err := bashCompleter(ev, script, []string{"fake", ""}); err == nil {
+		t.Errorf("bashCompleter with a filter vetoing bash => no error")
+	}
+}
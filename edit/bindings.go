@@ -0,0 +1,242 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+var modeNames = map[bufferMode]string{
+	modeInsert:     "insert",
+	modeCommand:    "command",
+	modeCompletion: "completion",
+	modeNavigation: "navigation",
+	modeHistory:    "history",
+	modeSnippet:    "snippet",
+	modePaste:      "paste",
+	modeViPending:  "vi-pending",
+	modeVisual:     "visual",
+	modeLiteral:    "literal",
+	modeMinibuffer: "minibuffer",
+	modePalette:    "palette",
+
+	modeHistoryListing: "history-listing",
+}
+
+func init() {
+	addEditorBuiltin("le:bind", builtinBind)
+	addEditorBuiltin("le:bindings", builtinBindings)
+}
+
+func findMode(name string) (bufferMode, error) {
+	for mode, n := range modeNames {
+		if n == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("no mode named %s", name)
+}
+
+var errBadKey = errors.New("bad key")
+
+// parseKey parses the output of Key.String back into a Key.
+func parseKey(s string) (Key, error) {
+	var k Key
+	for {
+		switch {
+		case strings.HasPrefix(s, "Ctrl-"):
+			k.Mod |= Ctrl
+			s = s[len("Ctrl-"):]
+			continue
+		case strings.HasPrefix(s, "Alt-"):
+			k.Mod |= Alt
+			s = s[len("Alt-"):]
+			continue
+		case strings.HasPrefix(s, "Shift-"):
+			k.Mod |= Shift
+			s = s[len("Shift-"):]
+			continue
+		}
+		break
+	}
+	for r, name := range KeyNames {
+		if s == name {
+			k.Rune = r
+			return k, nil
+		}
+	}
+	for i, name := range FunctionKeyNames {
+		if i > 0 && s == name {
+			k.Rune = rune(-i)
+			return k, nil
+		}
+	}
+	if r, size := utf8.DecodeRuneInString(s); size > 0 && size == len(s) {
+		k.Rune = r
+		return k, nil
+	}
+	return ZeroKey, errBadKey
+}
+
+// unreadableKeys are keys that the reader never produces, since terminals
+// send them as the same bytes as other keys.
+var unreadableKeys = map[Key]Key{
+	Key{'I', Ctrl}: Key{Tab, 0},
+	Key{'J', Ctrl}: Key{Enter, 0},
+}
+
+// keyConflicts returns warnings about k in the binding table kb:
+//
+// Ctrl-[ is the Escape key, which starts the sequences that terminals send for
+// Alt- keys; when both are bound, Escape followed quickly by another key is
+// read as an Alt- key, and Escape alone is only recognized after EscTimeout.
+//
+// Alt-[ and Alt-O start function key sequences; they are only recognized when
+// nothing follows within EscTimeout.
+//
+// Keys in unreadableKeys are never read at all.
+func keyConflicts(kb map[Key]string, k Key) []string {
+	var warnings []string
+	escape := Key{'[', Ctrl}
+	if k == escape {
+		// Find the first Alt- key, to be deterministic
+		var alt *Key
+		for k2 := range kb {
+			if k2.Mod&Alt != 0 && (alt == nil || k2.String() < alt.String()) {
+				k2 := k2
+				alt = &k2
+			}
+		}
+		if alt != nil {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s conflicts with Alt- keys like %s; it is only read when nothing follows within %v",
+				k, *alt, EscTimeout))
+		}
+	} else if k.Mod&Alt != 0 {
+		if _, ok := kb[escape]; ok {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s conflicts with %s; it is read as %s when typed slowly as Escape and %s",
+				k, escape, escape, Key{k.Rune, k.Mod &^ Alt}))
+		}
+	}
+	if k == (Key{'[', Alt}) || k == (Key{'O', Alt}) {
+		warnings = append(warnings, fmt.Sprintf(
+			"%s starts function key sequences; it is only read when nothing follows within %v",
+			k, EscTimeout))
+	}
+	if k2, ok := unreadableKeys[k]; ok {
+		warnings = append(warnings, fmt.Sprintf(
+			"%s is sent the same as %s and is never read", k, k2))
+	}
+	return warnings
+}
+
+// bindKey binds k to the editor builtin named name in mode. It returns
+// warnings about the binding being overridden and conflicts.
+func (o *options) bindKey(mode bufferMode, k Key, name string) ([]string, error) {
+	if leBuiltins[name] == nil {
+		return nil, fmt.Errorf("no editor builtin named %s", name)
+	}
+	kb, ok := o.keyBindings[mode]
+	if !ok {
+		return nil, fmt.Errorf("no binding for mode %s", modeNames[mode])
+	}
+	var warnings []string
+	if old, ok := kb[k]; ok && old != name {
+		warnings = append(warnings, fmt.Sprintf("%s was bound to %s", k, old))
+	}
+	kb[k] = name
+	return append(warnings, keyConflicts(kb, k)...), nil
+}
+
+// dumpBindings writes the binding table of mode to w, with keys sorted,
+// followed by warnings about conflicts.
+func (o *options) dumpBindings(w io.Writer, mode bufferMode) {
+	kb := o.keyBindings[mode]
+	keys := make([]Key, 0, len(kb))
+	for k := range kb {
+		keys = append(keys, k)
+	}
+	sort.Sort(keySlice(keys))
+
+	fmt.Fprintf(w, "%s:\n", modeNames[mode])
+	var warnings []string
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-16s %s\n", k, kb[k])
+		if k.Mod&Alt == 0 {
+			// Conflicts between Ctrl-[ and Alt- keys are reported once, on
+			// Ctrl-[
+			warnings = append(warnings, keyConflicts(kb, k)...)
+		}
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  warning: %s\n", warning)
+	}
+}
+
+type keySlice []Key
+
+func (ks keySlice) Len() int      { return len(ks) }
+func (ks keySlice) Swap(i, j int) { ks[i], ks[j] = ks[j], ks[i] }
+func (ks keySlice) Less(i, j int) bool {
+	return ks[i].String() < ks[j].String()
+}
+
+// builtinBind implements the le:bind builtin, which takes a mode, a key and
+// the name of an editor builtin, and binds the key in the mode. Warnings are
+// printed.
+func builtinBind(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 3 {
+		return "args error"
+	}
+	mode, err := findMode(args[0].String())
+	if err != nil {
+		return err.Error()
+	}
+	k, err := parseKey(args[1].String())
+	if err != nil {
+		return err.Error()
+	}
+	warnings, err := ed.bindKey(mode, k, args[2].String())
+	if err != nil {
+		return err.Error()
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(ev.OutFile(), "warning:", warning)
+	}
+	return ""
+}
+
+// builtinBindings implements the le:bindings builtin, which prints the key
+// bindings of the given modes, or all modes if none is given.
+func builtinBindings(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	var modes []bufferMode
+	for _, arg := range args {
+		mode, err := findMode(arg.String())
+		if err != nil {
+			return err.Error()
+		}
+		modes = append(modes, mode)
+	}
+	if len(modes) == 0 {
+		for mode := range modeNames {
+			modes = append(modes, mode)
+		}
+		sort.Sort(modeSlice(modes))
+	}
+	for _, mode := range modes {
+		ed.dumpBindings(ev.OutFile(), mode)
+	}
+	return ""
+}
+
+type modeSlice []bufferMode
+
+func (ms modeSlice) Len() int           { return len(ms) }
+func (ms modeSlice) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+func (ms modeSlice) Less(i, j int) bool { return ms[i] < ms[j] }
@@ -0,0 +1,64 @@
+package edit
+
+import "testing"
+
+var parseKeyTests = []Key{
+	Key{'a', 0},
+	Key{'[', Ctrl},
+	Key{Enter, Alt},
+	Key{Left, Shift | Alt | Ctrl},
+	Key{PageDown, 0},
+	DefaultBinding,
+}
+
+func TestParseKey(t *testing.T) {
+	for _, k := range parseKeyTests {
+		if out, err := parseKey(k.String()); out != k || err != nil {
+			t.Errorf("parseKey(%q) => (%v, %v), want (%v, nil)", k.String(), out, err, k)
+		}
+	}
+	if _, err := parseKey("Ctrl-foo"); err == nil {
+		t.Errorf("parseKey(%q) => no error, want error", "Ctrl-foo")
+	}
+}
+
+var keyConflictsTests = []struct {
+	kb       map[Key]string
+	k        Key
+	nwarning int
+}{
+	{map[Key]string{Key{'a', 0}: ""}, Key{'a', 0}, 0},
+	{map[Key]string{Key{'[', Ctrl}: "", Key{'a', Alt}: ""}, Key{'[', Ctrl}, 1},
+	{map[Key]string{Key{'[', Ctrl}: "", Key{'a', Alt}: ""}, Key{'a', Alt}, 1},
+	{map[Key]string{Key{'[', Alt}: ""}, Key{'[', Alt}, 1},
+	{map[Key]string{Key{'I', Ctrl}: ""}, Key{'I', Ctrl}, 1},
+}
+
+func TestKeyConflicts(t *testing.T) {
+	for _, tt := range keyConflictsTests {
+		if out := keyConflicts(tt.kb, tt.k); len(out) != tt.nwarning {
+			t.Errorf("keyConflicts(%v, %v) => %v, want %d warnings", tt.kb, tt.k, out, tt.nwarning)
+		}
+	}
+}
+
+func TestBindKey(t *testing.T) {
+	k := Key{'x', Alt}
+	o := newOptions()
+
+	if _, err := o.bindKey(modeCommand, k, "no-such-builtin"); err == nil {
+		t.Errorf("bindKey to nonexistent builtin => no error, want error")
+	}
+	if warnings, err := o.bindKey(modeCommand, k, "start-insert"); len(warnings) != 0 || err != nil {
+		t.Errorf("bindKey => (%v, %v), want no warnings", warnings, err)
+	}
+	if warnings, _ := o.bindKey(modeCommand, k, "move-dot-left"); len(warnings) != 1 {
+		t.Errorf("rebinding => %v, want one warning", warnings)
+	}
+	if o.keyBindings[modeCommand][k] != "move-dot-left" {
+		t.Errorf("binding not changed")
+	}
+	if _, ok := defaultKeyBindings[modeCommand][k]; ok {
+		t.Errorf("default bindings changed")
+	}
+}
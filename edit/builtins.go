@@ -1,11 +1,10 @@
 package edit
 
 import (
-	"fmt"
-	"strings"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/xiaq/elvish/eval"
 	"github.com/xiaq/elvish/util"
 )
 
@@ -35,7 +34,6 @@ var leBuiltins = map[string]leBuiltin{
 	"start-command":   startCommand,
 	"kill-line-left":  killLineLeft,
 	"kill-line-right": killLineRight,
-	"kill-word-left":  killWordLeft,
 	"kill-rune-left":  killRuneLeft,
 	"kill-rune-right": killRuneRight,
 	"move-dot-left":   moveDotLeft,
@@ -45,9 +43,31 @@ var leBuiltins = map[string]leBuiltin{
 	"insert-key":      insertKey,
 	"return-line":     returnLine,
 	"return-eof":      returnEORight,
+	"calc":            calc,
 	"default-command": defaultCommand,
 	"default-insert":  defaultInsert,
 
+	"accept-suggestion-or-move-dot-right": acceptSuggestionOrMoveDotRight,
+	"move-dot-up-or-start-history":        moveDotUpOrStartHistory,
+
+	"move-dot-left-word":      moveDotLeftBy(alnumWords),
+	"move-dot-right-word":     moveDotRightBy(alnumWords),
+	"kill-word-left":          killLeftBy(alnumWords),
+	"kill-word-right":         killRightBy(alnumWords),
+	"move-dot-left-big-word":  moveDotLeftBy(bigWords),
+	"move-dot-right-big-word": moveDotRightBy(bigWords),
+	"kill-big-word-left":      killLeftBy(bigWords),
+	"kill-big-word-right":     killRightBy(bigWords),
+	"move-dot-left-token":     moveDotLeftBy(tokenWords),
+	"move-dot-right-token":    moveDotRightBy(tokenWords),
+	"kill-token-left":         killLeftBy(tokenWords),
+	"kill-token-right":        killRightBy(tokenWords),
+
+	"extend-selection-left":       extendSelection(moveDotLeft),
+	"extend-selection-right":      extendSelection(moveDotRight),
+	"extend-selection-left-word":  extendSelection(moveDotLeftBy(alnumWords)),
+	"extend-selection-right-word": extendSelection(moveDotRightBy(alnumWords)),
+
 	// Completion mode
 	"start-completion":   startCompletion,
 	"cancel-completion":  cancelCompletion,
@@ -70,7 +90,77 @@ var leBuiltins = map[string]leBuiltin{
 	"start-history":       startHistory,
 	"select-history-prev": selectHistoryPrev,
 	"select-history-next": selectHistoryNext,
+	"toggle-dir-history":  toggleDirHistory,
 	"default-history":     defaultHistory,
+
+	// Snippet mode
+	"start-snippet":                startSnippet,
+	"select-snippet-up":            selectSnippetUp,
+	"select-snippet-down":          selectSnippetDown,
+	"accept-snippet":               acceptSnippet,
+	"cancel-snippet":               cancelSnippet,
+	"default-snippet":              defaultSnippet,
+	"next-placeholder-or-complete": nextPlaceholderOrComplete,
+
+	// Paste mode
+	"accept-paste":  acceptPaste,
+	"cancel-paste":  cancelPaste,
+	"default-paste": defaultPaste,
+
+	// Vi-pending mode
+	"vi-command-key":    viCommandKey,
+	"cancel-vi-command": cancelViCommand,
+
+	// Visual mode
+	"start-visual":   startVisual,
+	"cancel-visual":  cancelVisual,
+	"visual-delete":  visualOperator('d'),
+	"visual-yank":    visualOperator('y'),
+	"visual-change":  visualOperator('c'),
+	"default-visual": defaultVisual,
+
+	// Literal mode
+	"start-literal": startLiteral,
+	"start-digraph": startDigraph,
+	"literal-key":   literalKey,
+
+	// Binding hints
+	"show-bindings": showBindings,
+
+	// Minibuffer mode
+	"accept-minibuffer":         acceptMinibuffer,
+	"cancel-minibuffer":         cancelMinibuffer,
+	"minibuffer-kill-rune-left": minibufferKillRuneLeft,
+	"minibuffer-kill-line-left": minibufferKillLineLeft,
+	"minibuffer-move-dot-left":  minibufferMoveDotLeft,
+	"minibuffer-move-dot-right": minibufferMoveDotRight,
+	"default-minibuffer":        defaultMinibuffer,
+	"search-history":            searchHistory,
+	"rename-nav":                renameNav,
+	"remove-nav":                removeNav,
+	"query-replace":             queryReplace,
+
+	// History listing mode
+	"start-history-listing":          startHistoryListing,
+	"select-history-listing-up":      selectHistoryListingUp,
+	"select-history-listing-down":    selectHistoryListingDown,
+	"toggle-history-listing":         toggleHistoryListing,
+	"kill-history-listing-rune-left": killHistoryListingRuneLeft,
+	"accept-history-listing":         acceptHistoryListing,
+	"cancel-history-listing":         cancelHistoryListing,
+	"default-history-listing":        defaultHistoryListing,
+}
+
+func init() {
+	// The palette runs other builtins, so its builtins can't be in the
+	// initializer of leBuiltins
+	leBuiltins["start-palette"] = startPalette
+	leBuiltins["select-palette-up"] = selectPaletteUp
+	leBuiltins["select-palette-down"] = selectPaletteDown
+	leBuiltins["kill-palette-rune-left"] = killPaletteRuneLeft
+	leBuiltins["accept-palette"] = acceptPalette
+	leBuiltins["cancel-palette"] = cancelPalette
+	leBuiltins["default-palette"] = defaultPalette
 }
 
 func startInsert(ed *Editor, k Key) *leReturn {
@@ -79,7 +169,7 @@ func startInsert(ed *Editor, k Key) *leReturn {
 }
 
 func defaultCommand(ed *Editor, k Key) *leReturn {
-	ed.pushTip(fmt.Sprintf("Unbound: %s", k))
+	ed.pushTip(ed.trf("Unbound: %s", k))
 	return nil
 }
 
@@ -101,19 +191,6 @@ func killLineRight(ed *Editor, k Key) *leReturn {
 	return nil
 }
 
-// NOTE(xiaq): A word is now defined as a series of non-whitespace chars.
-func killWordLeft(ed *Editor, k Key) *leReturn {
-	if ed.dot == 0 {
-		return nil
-	}
-	space := strings.LastIndexFunc(
-		strings.TrimRightFunc(ed.line[:ed.dot], unicode.IsSpace),
-		unicode.IsSpace) + 1
-	ed.line = ed.line[:space] + ed.line[ed.dot:]
-	ed.dot = space
-	return nil
-}
-
 func killRuneLeft(ed *Editor, k Key) *leReturn {
 	if ed.dot > 0 {
 		_, w := utf8.DecodeLastRuneInString(ed.line[:ed.dot])
@@ -160,6 +237,15 @@ func moveDotUp(ed *Editor, k Key) *leReturn {
 	return nil
 }
 
+// moveDotUpOrStartHistory moves the dot up, or on the first line starts
+// history mode, recalling entries starting with the text before the dot.
+func moveDotUpOrStartHistory(ed *Editor, k Key) *leReturn {
+	if util.FindLastSOL(ed.line[:ed.dot]) == 0 {
+		return startHistory(ed, k)
+	}
+	return moveDotUp(ed, k)
+}
+
 func moveDotDown(ed *Editor, k Key) *leReturn {
 	eol := util.FindFirstEOL(ed.line[ed.dot:]) + ed.dot
 	if eol == len(ed.line) {
@@ -181,7 +267,12 @@ func insertKey(ed *Editor, k Key) *leReturn {
 }
 
 func returnLine(ed *Editor, k Key) *leReturn {
-	return &leReturn{action: exitReadLine, readLineReturn: LineRead{Line: ed.line}}
+	ret := &leReturn{action: exitReadLine, readLineReturn: LineRead{Line: ed.line}}
+	if d, ok := ed.findDanger(ed.line); ok {
+		ed.confirmDanger(d, ret)
+		return nil
+	}
+	return ret
 }
 
 func returnEORight(ed *Editor, k Key) *leReturn {
@@ -191,6 +282,26 @@ func returnEORight(ed *Editor, k Key) *leReturn {
 	return nil
 }
 
+// calc evaluates the line as an arithmetic expression with eval.Calc and
+// shows the result in the tips. When called again before the line is changed,
+// it replaces the line with the result.
+func calc(ed *Editor, k Key) *leReturn {
+	if ed.calcResult != "" && ed.line == ed.calcLine {
+		ed.line = ed.calcResult
+		ed.dot = len(ed.line)
+		ed.calcResult, ed.calcLine = "", ""
+		return nil
+	}
+	result, err := eval.Calc(ed.line)
+	if err != nil {
+		ed.pushTip(ed.trf("calc: %s", err))
+		return nil
+	}
+	ed.calcResult, ed.calcLine = result, ed.line
+	ed.pushTip(ed.trf("= %s (%s again to insert)", result, k))
+	return nil
+}
+
 func selectCandUp(ed *Editor, k Key) *leReturn {
 	ed.completion.prev(false)
 	return nil
@@ -230,7 +341,7 @@ func defaultInsert(ed *Editor, k Key) *leReturn {
 	if k.Mod == 0 && k.Rune > 0 && unicode.IsGraphic(k.Rune) {
 		return insertKey(ed, k)
 	}
-	ed.pushTip(fmt.Sprintf("Unbound: %s", k))
+	ed.pushTip(ed.trf("Unbound: %s", k))
 	return nil
 }
 
@@ -241,6 +352,11 @@ func defaultCompletion(ed *Editor, k Key) *leReturn {
 }
 
 func startNavigation(ed *Editor, k Key) *leReturn {
+	// Navigation mode changes directory behind the back of cd
+	if ed.ev.Restricted() {
+		ed.pushTip(ed.tr("navigation mode is disabled in restricted mode"))
+		return nil
+	}
 	ed.mode = modeNavigation
 	ed.navigation = newNavigation()
 	return &leReturn{}
@@ -273,12 +389,12 @@ func defaultNavigation(ed *Editor, k Key) *leReturn {
 }
 
 func startHistory(ed *Editor, k Key) *leReturn {
-	ed.history.prefix = ed.line[:ed.dot]
-	ed.history.current = len(ed.histories)
+	ed.reloadHistory()
+	ed.history = historyState{prefix: ed.line[:ed.dot], current: len(ed.histories)}
 	if ed.prevHistory() {
 		ed.mode = modeHistory
 	} else {
-		ed.pushTip("no matching history item")
+		ed.pushTip(ed.tr("no matching history item"))
 	}
 	return nil
 }
@@ -288,8 +404,12 @@ func selectHistoryPrev(ed *Editor, k Key) *leReturn {
 	return nil
 }
 
+// selectHistoryNext recalls the next matching entry. Past the newest one, it
+// goes back to insert mode with the line typed before history mode.
 func selectHistoryNext(ed *Editor, k Key) *leReturn {
-	ed.nextHistory()
+	if !ed.nextHistory() {
+		ed.mode = modeInsert
+	}
 	return nil
 }
 
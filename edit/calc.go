@@ -0,0 +1,62 @@
+package edit
+
+import (
+	"strings"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// When the line is an arithmetic expression that eval.Calc understands, like
+// "(1 + 2) * 4", or just a variable, its value is shown as a tip while
+// typing, so that the shell can be used as a calculator without running
+// anything. The calc builtin, bound to Alt-=, replaces the line with it.
+
+// calcLine returns the value of line if it is an arithmetic expression other
+// than a plain number, or a variable, looking up variables with vars.
+func calcLine(line string, vars func() map[string]eval.Value) (string, bool) {
+	if result, err := eval.Calc(line); err == nil {
+		return result, result != strings.TrimSpace(line)
+	}
+	n, err := parse.Parse("<calc>", line)
+	if err != nil || len(n.Nodes) != 1 || len(n.Nodes[0].Nodes) != 1 {
+		return "", false
+	}
+	form := n.Nodes[0].Nodes[0]
+	if form.Args != nil && len(form.Args.Nodes) > 0 {
+		return "", false
+	}
+	name, ok := variableTerm(form.Command)
+	if !ok {
+		return "", false
+	}
+	v, ok := vars()[name]
+	if !ok {
+		return "", false
+	}
+	return v.Repr(), true
+}
+
+// variableTerm returns the name of the variable if term is just one.
+func variableTerm(term *parse.TermNode) (string, bool) {
+	if term == nil || len(term.Nodes) != 1 || term.Nodes[0].Typ != parse.VariableFactor {
+		return "", false
+	}
+	sn, ok := term.Nodes[0].Node.(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return sn.Text, true
+}
+
+// showCalc shows the value of the line as a tip, if it is an arithmetic
+// expression or a variable, unless calc has just shown it.
+func (ed *Editor) showCalc() {
+	if ed.mode != modeInsert || (ed.calcResult != "" && ed.line == ed.calcLine) {
+		return
+	}
+	if value, ok := calcLine(ed.line, ed.ev.Variables); ok {
+		ed.pushStyledTip(styled.Plain("= " + value))
+	}
+}
@@ -0,0 +1,40 @@
+package edit
+
+import (
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+var calcLineTests = []struct {
+	line  string
+	value string
+	ok    bool
+}{
+	{"1 + 2", "3", true},
+	{"(1 + 2) * 4", "12", true},
+	{"1/4", "0.25", true},
+	{"-(3)", "-3", true},
+	{"$x", "2", true},
+	{"$s", "foo", true},
+	{"42", "42", false},
+	{" 42 ", "42", false},
+	{"1 +", "", false},
+	{"$nope", "", false},
+	{"$x y", "", false},
+	{"+ 1 2", "", false},
+	{"echo 1", "", false},
+	{"", "", false},
+}
+
+func TestCalcLine(t *testing.T) {
+	vars := func() map[string]eval.Value {
+		return map[string]eval.Value{"x": eval.NewString("2"), "s": eval.NewString("foo")}
+	}
+	for _, tt := range calcLineTests {
+		value, ok := calcLine(tt.line, vars)
+		if (ok && value != tt.value) || ok != tt.ok {
+			t.Errorf("calcLine(%q) => (%q, %v), want (%q, %v)", tt.line, value, ok, tt.value, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package edit
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CapQueryTimeout is how long the editor waits for the terminal to reply to
+// capability queries.
+const CapQueryTimeout = 50 * time.Millisecond
+
+// Escape sequences to begin and end a synchronized update (private mode 2026).
+// A terminal supporting it holds off repainting between the two, so a frame
+// appears atomically.
+const (
+	beginSyncUpdate = "\033[?2026h"
+	endSyncUpdate   = "\033[?2026l"
+)
+
+// Private modes queried with DECRQM.
+const (
+	modeSyncUpdate = 2026
+)
+
+// capabilities records what the terminal is capable of, and which features of
+// the editor are used with it. Except for dumb and multiplexer, each field is
+// a feature that can be overridden (see features).
+type capabilities struct {
+	// dumb is true when the terminal is not known to understand any escape
+	// sequences. Nothing but plain text, carriage returns and newlines are
+	// sent to such terminals.
+	dumb            bool
+	color           bool
+	trueColor       bool
+	syncUpdate      bool
+	focusReporting  bool
+	bracketedPaste  bool
+	cursorQuery     bool
+	asyncCompletion bool
+	hyperlinks      bool
+	passthrough     bool
+	// Graphics protocols images can be shown with; see image-preview.go.
+	sixel         bool
+	kittyGraphics bool
+	// The multiplexer the editor runs in, if any; see multiplexer.go.
+	multiplexer multiplexer
+}
+
+// noColorTerms lists values of $TERM of terminals that support escape
+// sequences but not colors.
+var noColorTerms = []string{
+	"vt52", "vt100", "vt102", "vt220", "vt320",
+}
+
+// syncUpdateTerms lists prefixes of $TERM of terminals known to support
+// synchronized updates.
+var syncUpdateTerms = []string{
+	"alacritty", "contour", "foot", "wezterm", "xterm-kitty",
+}
+
+// syncUpdateTermPrograms lists values of $TERM_PROGRAM of terminals known to
+// support synchronized updates.
+var syncUpdateTermPrograms = []string{
+	"iTerm.app", "WezTerm", "vscode",
+}
+
+func hasPrefixIn(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hyperlinkTerms lists prefixes of $TERM of terminals known to support OSC 8
+// hyperlinks.
+var hyperlinkTerms = []string{
+	"alacritty", "contour", "foot", "wezterm", "xterm-kitty",
+}
+
+// hyperlinkTermPrograms lists values of $TERM_PROGRAM of terminals known to
+// support OSC 8 hyperlinks.
+var hyperlinkTermPrograms = []string{
+	"iTerm.app", "WezTerm", "vscode",
+}
+
+// kittyGraphicsTerms lists prefixes of $TERM of terminals known to support
+// the kitty graphics protocol.
+var kittyGraphicsTerms = []string{
+	"xterm-ghostty", "xterm-kitty",
+}
+
+// kittyGraphicsTermPrograms lists values of $TERM_PROGRAM of terminals known
+// to support the kitty graphics protocol.
+var kittyGraphicsTermPrograms = []string{
+	"WezTerm", "ghostty",
+}
+
+// minHyperlinkVTE is the first version of libvte, as in $VTE_VERSION, that
+// supports OSC 8 hyperlinks.
+const minHyperlinkVTE = 5000
+
+// capabilitiesFromEnv guesses the capabilities of the terminal from $TERM
+// and friends. The guess is refined with capabilities.update if the terminal
+// replies to queries.
+func capabilitiesFromEnv() capabilities {
+	term := os.Getenv("TERM")
+	if term == "" || term == "dumb" {
+		return capabilities{dumb: true}
+	}
+	caps := capabilities{
+		focusReporting: true, bracketedPaste: true,
+		cursorQuery: true, asyncCompletion: true,
+		multiplexer: multiplexerFromEnv(),
+	}
+	caps.passthrough = caps.multiplexer != noMultiplexer
+	colorterm := os.Getenv("COLORTERM")
+	caps.color = !hasPrefixIn(term, noColorTerms) || colorterm != ""
+	caps.trueColor = colorterm == "truecolor" || colorterm == "24bit"
+	caps.syncUpdate = hasPrefixIn(term, syncUpdateTerms) ||
+		hasPrefixIn(os.Getenv("TERM_PROGRAM"), syncUpdateTermPrograms)
+	vte, _ := strconv.Atoi(os.Getenv("VTE_VERSION"))
+	caps.hyperlinks = hasPrefixIn(term, hyperlinkTerms) ||
+		hasPrefixIn(os.Getenv("TERM_PROGRAM"), hyperlinkTermPrograms) ||
+		vte >= minHyperlinkVTE
+	caps.kittyGraphics = hasPrefixIn(term, kittyGraphicsTerms) ||
+		hasPrefixIn(os.Getenv("TERM_PROGRAM"), kittyGraphicsTermPrograms) ||
+		os.Getenv("KITTY_WINDOW_ID") != ""
+	if caps.multiplexer != noMultiplexer {
+		// Multiplexers don't know where the images placed with the kitty
+		// protocol are, and don't move or erase them with the text
+		caps.kittyGraphics = false
+		// $TERM is that of the multiplexer, and so is $TERM_PROGRAM inside
+		// tmux, but $LC_TERMINAL is passed on from iTerm2 over ssh and into
+		// multiplexers
+		caps.hyperlinks = caps.hyperlinks || os.Getenv("LC_TERMINAL") == "iTerm2"
+	}
+	if caps.multiplexer == screenMultiplexer {
+		// screen draws the frames itself, and doesn't hold them off
+		caps.syncUpdate = false
+	}
+	return caps
+}
+
+// capQueries returns the queries sent to the terminal to query its
+// capabilities, and its background for the theme "auto" (see background.go).
+// DA1 is sent last: since virtually all terminals reply to it and replies
+// come in order, its reply marks the end of all replies.
+func (caps capabilities) capQueries() string {
+	return "\033[?" + strconv.Itoa(modeSyncUpdate) + "$p" +
+		caps.wrapQuery(backgroundQuery) + "\033[c"
+}
+
+// update refines caps with a reply from the terminal. It returns true if the
+// reply is the last one expected.
+func (caps *capabilities) update(rep *termReply) bool {
+	switch rep.typ {
+	case replyDECRPM:
+		// Values 1 and 2 mean set and reset; 0 and 4 mean unrecognized and
+		// permanently reset.
+		if len(rep.params) > 1 && rep.params[0] == modeSyncUpdate {
+			caps.syncUpdate = rep.params[1] == 1 || rep.params[1] == 2
+		}
+		return false
+	case replyDA1:
+		// The first parameter is the conformance level; later parameters
+		// of 4 and 22 mean sixel graphics and ANSI color support.
+		if len(rep.params) > 1 {
+			for _, p := range rep.params[1:] {
+				switch p {
+				case 4:
+					caps.sixel = true
+				case 22:
+					caps.color = true
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// detectCapabilities queries the terminal for its capabilities, falling back
+// to the guess from the environment for queries that are not replied to
+// within CapQueryTimeout. The reader must have been continued.
+func (ed *Editor) detectCapabilities() capabilities {
+	caps := capabilitiesFromEnv()
+	if caps.dumb {
+		return caps
+	}
+	ed.writer.file.WriteString(caps.withOverrides(ed.featureOverrides).capQueries())
+
+	ones := ed.reader.Chan()
+	timeout := time.After(CapQueryTimeout)
+	for {
+		select {
+		case or := <-ones:
+			if or.Reply != nil {
+				ed.updateBackground(or.Reply)
+				if caps.update(or.Reply) {
+					return caps
+				}
+			}
+			// Just discard other reads
+		case <-timeout:
+			return caps
+		}
+	}
+}
+
+// reduceTrueColor replaces 24-bit colors in an SGR attribute string with the
+// nearest colors of the 256-color palette.
+func reduceTrueColor(attr string) string {
+	params := strings.Split(attr, ";")
+	for i := 0; i+4 < len(params); i++ {
+		if (params[i] != "38" && params[i] != "48") || params[i+1] != "2" {
+			continue
+		}
+		var rgb [3]int
+		for j := range rgb {
+			n, err := strconv.Atoi(params[i+2+j])
+			if err != nil || n < 0 || n > 255 {
+				n = 0
+			}
+			rgb[j] = (n*5 + 127) / 255
+		}
+		color := 16 + 36*rgb[0] + 6*rgb[1] + rgb[2]
+		params = append(append(params[:i+1], "5", strconv.Itoa(color)), params[i+5:]...)
+	}
+	return strings.Join(params, ";")
+}
+
+// stripColor removes color parameters from an SGR attribute string, keeping
+// other styling like bold and reverse intact.
+func stripColor(attr string) string {
+	params := strings.Split(attr, ";")
+	kept := params[:0]
+	for i := 0; i < len(params); i++ {
+		n, err := strconv.Atoi(params[i])
+		if err != nil {
+			kept = append(kept, params[i])
+			continue
+		}
+		switch {
+		case n == 38 || n == 48:
+			// Extended color: 38;5;n or 38;2;r;g;b
+			if i+1 < len(params) && params[i+1] == "5" {
+				i += 2
+			} else if i+1 < len(params) && params[i+1] == "2" {
+				i += 4
+			}
+		case 30 <= n && n <= 49, 90 <= n && n <= 97, 100 <= n && n <= 107:
+			// Basic and bright colors
+		default:
+			kept = append(kept, params[i])
+		}
+	}
+	return strings.Join(kept, ";")
+}
@@ -0,0 +1,66 @@
+package edit
+
+import "testing"
+
+var stripColorTests = []struct {
+	in, wanted string
+}{
+	{"", ""},
+	{"1;7;33", "1;7"},
+	{";7", ";7"},
+	{"34;1", "1"},
+	{"38;5;208;1", "1"},
+	{"1;48;2;0;0;255;4", "1;4"},
+	{"91", ""},
+}
+
+func TestStripColor(t *testing.T) {
+	for _, tt := range stripColorTests {
+		if out := stripColor(tt.in); out != tt.wanted {
+			t.Errorf("stripColor(%q) => %q, want %q", tt.in, out, tt.wanted)
+		}
+	}
+}
+
+var capabilitiesUpdateTests = []struct {
+	rep    termReply
+	last   bool
+	wanted capabilities
+}{
+	{termReply{replyDA1, nil}, true, capabilities{}},
+	{termReply{replyDA1, []int{62}}, true, capabilities{}},
+	{termReply{replyDA1, []int{62, 1, 22}}, true, capabilities{color: true}},
+	{termReply{replyDA1, []int{64, 4, 22}}, true, capabilities{color: true, sixel: true}},
+	{termReply{replyDA1, []int{4}}, true, capabilities{}},
+	{termReply{replyDECRPM, nil}, false, capabilities{}},
+	{termReply{replyDECRPM, []int{modeSyncUpdate, 2}}, false, capabilities{syncUpdate: true}},
+	{termReply{replyDECRPM, []int{modeSyncUpdate, 0}}, false, capabilities{}},
+}
+
+func TestCapabilitiesUpdate(t *testing.T) {
+	for _, tt := range capabilitiesUpdateTests {
+		var caps capabilities
+		if last := caps.update(&tt.rep); last != tt.last || caps != tt.wanted {
+			t.Errorf("update(%v) => %v, caps %v, want %v, caps %v", tt.rep, last, caps, tt.last, tt.wanted)
+		}
+	}
+}
+
+var reduceTrueColorTests = []struct {
+	in, wanted string
+}{
+	{"", ""},
+	{"1;33", "1;33"},
+	{"38;5;208", "38;5;208"},
+	{"38;2;255;0;0", "38;5;196"},
+	{"1;48;2;0;0;0;4", "1;48;5;16;4"},
+	{"38;2;255;255;255;48;2;0;0;255", "38;5;231;48;5;21"},
+}
+
+func TestReduceTrueColor(t *testing.T) {
+	for _, tt := range reduceTrueColorTests {
+		if out := reduceTrueColor(tt.in); out != tt.wanted {
+			t.Errorf("reduceTrueColor(%q) => %q, want %q", tt.in, out, tt.wanted)
+		}
+	}
+}
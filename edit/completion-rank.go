@@ -0,0 +1,163 @@
+package edit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Completion candidates are listed in the order they are found unless
+// rankers are chosen with le:completion-sort. Candidates are then sorted by
+// the score of the first ranker, ties by the following ones, and remaining
+// ties alphabetically; groups of candidates stay as they are.
+
+// CompletionRanker scores completion candidates. The candidates are the texts
+// they insert, like file names relative to dir, the current directory; history
+// is from the oldest to the most recent entry. It returns the scores of the
+// candidates, in the same order. Candidates with higher scores come first.
+type CompletionRanker func(history []HistoryEntry, candidates []string, dir string) []float64
+
+// builtinCompletionRankers maps names of the completion rankers every Editor
+// has to the rankers.
+var builtinCompletionRankers = map[string]CompletionRanker{
+	"alpha":    rankAlpha,
+	"mtime":    rankMtime,
+	"frecency": rankDirFrecency,
+}
+
+func init() {
+	addEditorBuiltin("le:completion-sort", builtinCompletionSort)
+}
+
+// AddCompletionRanker adds a completion ranker that can be chosen with
+// le:completion-sort.
+func (ed *Editor) AddCompletionRanker(name string, f CompletionRanker) {
+	if _, ok := ed.completionRankers[name]; ok || name == "source" {
+		panic("completion ranker redefined: " + name)
+	}
+	ed.completionRankers[name] = f
+}
+
+// rankAlpha scores all candidates the same, leaving them sorted
+// alphabetically.
+func rankAlpha(history []HistoryEntry, candidates []string, dir string) []float64 {
+	return make([]float64, len(candidates))
+}
+
+// rankMtime prefers recently modified files.
+func rankMtime(history []HistoryEntry, candidates []string, dir string) []float64 {
+	scores := make([]float64, len(candidates))
+	for i, name := range candidates {
+		if info, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			scores[i] = float64(info.ModTime().UnixNano())
+		}
+	}
+	return scores
+}
+
+// rankDirFrecency prefers directories in or under which lines are accepted
+// often and recently, decaying like the frecency of autosuggestions. Other
+// candidates score 0.
+func rankDirFrecency(history []HistoryEntry, candidates []string, dir string) []float64 {
+	scores := make([]float64, len(candidates))
+	for i, name := range candidates {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		for j, h := range history {
+			if h.Dir == path || strings.HasPrefix(h.Dir, path+"/") {
+				age := float64(len(history) - 1 - j)
+				scores[i] += math.Pow(0.5, age/FrecencyHalfLife)
+			}
+		}
+	}
+	return scores
+}
+
+// sortCandidates sorts candidates with the rankers named in rankers, keeping
+// groups in the order they appear.
+func (o *options) sortCandidates(cands []*candidate, rankers []string, history []HistoryEntry, dir string) {
+	if len(rankers) == 0 {
+		return
+	}
+	texts := make([]string, len(cands))
+	for i, c := range cands {
+		texts[i] = c.text
+	}
+	cs := rankedCandidates{cands, make(map[string]int), make(map[*candidate][]float64)}
+	for _, c := range cands {
+		if _, ok := cs.order[c.group]; !ok {
+			cs.order[c.group] = len(cs.order)
+		}
+	}
+	for _, name := range rankers {
+		for i, score := range o.completionRankers[name](history, texts, dir) {
+			cs.scores[cands[i]] = append(cs.scores[cands[i]], score)
+		}
+	}
+	sort.Stable(cs)
+}
+
+type rankedCandidates struct {
+	cands  []*candidate
+	order  map[string]int
+	scores map[*candidate][]float64
+}
+
+func (cs rankedCandidates) Len() int { return len(cs.cands) }
+func (cs rankedCandidates) Swap(i, j int) {
+	cs.cands[i], cs.cands[j] = cs.cands[j], cs.cands[i]
+}
+func (cs rankedCandidates) Less(i, j int) bool {
+	a, b := cs.cands[i], cs.cands[j]
+	if cs.order[a.group] != cs.order[b.group] {
+		return cs.order[a.group] < cs.order[b.group]
+	}
+	for k, sa := range cs.scores[a] {
+		if sb := cs.scores[b][k]; sa != sb {
+			return sa > sb
+		}
+	}
+	return a.text < b.text
+}
+
+// builtinCompletionSort implements the le:completion-sort builtin. With no
+// arguments, it prints the names of the rankers chosen, or source if there are
+// none, followed by the available ones. With arguments, it chooses the
+// rankers; source alone keeps the order candidates are found in.
+func builtinCompletionSort(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) == 0 {
+		var names []string
+		for name := range ed.completionRankers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		current := "source"
+		if len(ed.completionSort) > 0 {
+			current = strings.Join(ed.completionSort, " ")
+		}
+		fmt.Fprintf(ev.OutFile(), "%s (available: %s)\n",
+			current, strings.Join(names, " "))
+		return ""
+	}
+	if len(args) == 1 && args[0].String() == "source" {
+		ed.completionSort = nil
+		return ""
+	}
+	var names []string
+	for _, arg := range args {
+		name := arg.String()
+		if _, ok := ed.completionRankers[name]; !ok {
+			return fmt.Sprintf("no completion ranker named %s", name)
+		}
+		names = append(names, name)
+	}
+	ed.completionSort = names
+	return ""
+}
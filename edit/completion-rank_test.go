@@ -0,0 +1,68 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortCandidates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"src", "tmp", "doc"} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+	}
+	now := time.Now()
+	for i, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, nil, 0644)
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+	history := []HistoryEntry{
+		{Line: "make", Dir: filepath.Join(dir, "src", "edit")},
+		{Line: "ls", Dir: filepath.Join(dir, "tmp")},
+		{Line: "make", Dir: filepath.Join(dir, "src")},
+	}
+
+	sortTests := []struct {
+		rankers []string
+		texts   string
+		wanted  string
+	}{
+		{nil, "c.txt a.txt b.txt", "c.txt a.txt b.txt"},
+		{[]string{"alpha"}, "c.txt a.txt b.txt", "a.txt b.txt c.txt"},
+		{[]string{"mtime"}, "c.txt a.txt b.txt", "b.txt a.txt c.txt"},
+		{[]string{"frecency"}, "doc tmp src a.txt", "src tmp a.txt doc"},
+		// Groups, here before and after /, are kept
+		{[]string{"alpha"}, "u/b u/a f/src f/doc", "u/a u/b f/doc f/src"},
+	}
+	for _, tt := range sortTests {
+		var cands []*candidate
+		for _, text := range strings.Fields(tt.texts) {
+			c := &candidate{text: text}
+			if i := strings.Index(text, "/"); i != -1 {
+				c.group, c.text = text[:i], text[i+1:]
+			}
+			cands = append(cands, c)
+		}
+		newOptions().sortCandidates(cands, tt.rankers, history, dir)
+		var out []string
+		for _, c := range cands {
+			if c.group != "" {
+				out = append(out, c.group+"/"+c.text)
+			} else {
+				out = append(out, c.text)
+			}
+		}
+		if s := strings.Join(out, " "); s != tt.wanted {
+			t.Errorf("sortCandidates(%q, %v) => %q, want %q", tt.texts, tt.rankers, s, tt.wanted)
+		}
+	}
+}
@@ -3,28 +3,55 @@ package edit
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
 	"github.com/xiaq/elvish/parse"
 )
 
-type tokenPart struct {
-	text      string
-	completed bool
+// The completion style, changed with le:completion-style, is how completion
+// starts: "menu" to show the candidates right away, or "prefix" to first
+// insert the longest common prefix of the candidates like readline, and only
+// show them when there is no common prefix to insert, like on the second Tab.
+//
+// At most as many candidates as the completion limit, changed with
+// le:completion-limit, are shown; the number of the other candidates is shown
+// instead, so that completing in huge directories stays fast.
+
+func init() {
+	addEditorBuiltin("le:completion-style", builtinCompletionStyle)
+	addEditorBuiltin("le:completion-limit", builtinCompletionLimit)
 }
 
 type candidate struct {
-	text  string
-	parts []tokenPart
-	attr  string // Attribute used for preview
+	text    string      // The text to insert
+	parts   styled.Text // Shown in place of the text being completed
+	display styled.Text // Shown in the completion listing
+	// Shown after the candidate in the completion listing, like the size of
+	// a file. Optional.
+	description string
+	// The group of the candidate, like "files"; groups are shown with
+	// headers when there are several. Optional.
+	group string
+	// The URL the candidate in the listing is a hyperlink to, like that of a
+	// file. Optional.
+	link string
+	// The file the candidate names, previewed beside the listing. Optional.
+	file string
 }
 
 func newCandidate() *candidate {
 	return &candidate{}
 }
 
-func (c *candidate) push(tp tokenPart) {
-	c.text += tp.text
-	c.parts = append(c.parts, tp)
+func (c *candidate) push(text, style string) {
+	c.text += text
+	c.parts = append(c.parts, styled.Segment{Text: text, Style: style})
 }
 
 type completion struct {
@@ -32,6 +59,8 @@ type completion struct {
 	typ        parse.ItemType
 	candidates []*candidate
 	current    int
+	// The number of candidates left out because of the completion limit.
+	omitted int
 }
 
 func (c *completion) prev(cycle bool) {
@@ -56,77 +85,383 @@ func (c *completion) next(cycle bool) {
 	}
 }
 
-func findCandidates(p string, all []string) (cands []*candidate) {
+// findCandidates finds the candidates in all prefixed by p. attr is the
+// attribute of the text being completed.
+func (a *attrs) findCandidates(p string, all []string, attr string) (cands []*candidate) {
 	// Prefix match
 	for _, s := range all {
 		if len(s) >= len(p) && s[:len(p)] == p {
 			cand := newCandidate()
-			cand.push(tokenPart{p, false})
-			cand.push(tokenPart{s[len(p):], true})
+			cand.push(p, attr)
+			cand.push(s[len(p):], attr+a.attrForCompleted)
 			cands = append(cands, cand)
 		}
 	}
 	return
 }
 
-func fileNames(dir string) (names []string, err error) {
+// fileNames returns the names of files in dir, and their descriptions.
+// commonPrefix returns the longest common prefix of the texts of candidates,
+// without splitting runes.
+func commonPrefix(cands []*candidate) string {
+	if len(cands) == 0 {
+		return ""
+	}
+	prefix := cands[0].text
+	for _, c := range cands[1:] {
+		i := 0
+		for i < len(prefix) && i < len(c.text) && prefix[i] == c.text[i] {
+			i++
+		}
+		// Back off to the start of a rune
+		for i > 0 && i < len(prefix) && !utf8.RuneStart(prefix[i]) {
+			i--
+		}
+		prefix = prefix[:i]
+	}
+	return prefix
+}
+
+// builtinCompletionStyle implements the le:completion-style builtin. With no
+// arguments, it prints the completion style. With one argument, menu or
+// prefix, it sets the style.
+func builtinCompletionStyle(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.completionStyle)
+		return ""
+	case 1:
+		switch style := args[0].String(); style {
+		case "menu", "prefix":
+			ed.completionStyle = style
+			return ""
+		}
+		return "args error"
+	default:
+		return "args error"
+	}
+}
+
+// builtinCompletionLimit implements the le:completion-limit builtin. With no
+// arguments, it prints the maximum number of candidates shown. With one
+// argument, a positive number, it sets it.
+func builtinCompletionLimit(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.completionLimit)
+		return ""
+	case 1:
+		n, err := strconv.Atoi(args[0].String())
+		if err != nil || n <= 0 {
+			return "args error"
+		}
+		ed.completionLimit = n
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// groupCandidates sorts candidates so that each group is contiguous, with
+// groups in the order they first appear and candidates in each group in their
+// original order.
+func groupCandidates(cands []*candidate) {
+	order := make(map[string]int)
+	for _, c := range cands {
+		if _, ok := order[c.group]; !ok {
+			order[c.group] = len(order)
+		}
+	}
+	sort.Stable(candidatesByGroup{cands, order})
+}
+
+type candidatesByGroup struct {
+	cands []*candidate
+	order map[string]int
+}
+
+func (cg candidatesByGroup) Len() int { return len(cg.cands) }
+func (cg candidatesByGroup) Swap(i, j int) {
+	cg.cands[i], cg.cands[j] = cg.cands[j], cg.cands[i]
+}
+func (cg candidatesByGroup) Less(i, j int) bool {
+	return cg.order[cg.cands[i].group] < cg.order[cg.cands[j].group]
+}
+
+func fileNames(dir string) (names []string, descs map[string]string, err error) {
 	infos, e := ioutil.ReadDir(".")
 	if e != nil {
 		err = e
 		return
 	}
+	descs = make(map[string]string)
 	for _, info := range infos {
 		names = append(names, info.Name())
+		descs[info.Name()] = fileDescription(info)
 	}
 	return
 }
 
+// fileDescription describes a file for the completion listing: directories and
+// symlinks as such, and other files by their sizes. Descriptions are
+// translated when shown.
+func fileDescription(info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	}
+	return humanSize(info.Size())
+}
+
+// humanSize formats a size in bytes like ls -h.
+func humanSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	for _, unit := range "KMGTP" {
+		f /= 1024
+		if f < 1024 || unit == 'P' {
+			if f < 10 {
+				return fmt.Sprintf("%.1f%c", f, unit)
+			}
+			return fmt.Sprintf("%.0f%c", f, unit)
+		}
+	}
+	panic("unreachable")
+}
+
 func startCompletion(ed *Editor, k Key) *leReturn {
 	c := &completion{}
+	// A $ alone is not parsed as a variable yet
+	if strings.HasSuffix(ed.line[:ed.dot], "$") {
+		ed.completeVariable(c, ed.dot-1, "")
+		return nil
+	}
 	ctx, err := parse.Complete("<completion>", ed.line[:ed.dot])
 	if err != nil {
-		ed.pushTip("parser error")
+		ed.pushTip(ed.tr("parser error"))
+		return nil
+	}
+	if f := ctx.ThisFactor; f != nil && f.Typ == parse.VariableFactor {
+		ed.completeVariable(c, int(f.Pos), f.Node.(*parse.StringNode).Text)
 		return nil
 	}
 	pctx := ctx.EvalPlain()
 	if pctx == nil {
-		ed.pushTip("context not plain")
+		ed.pushTip(ed.tr("context not plain"))
 		return nil
 	}
 	switch pctx.Typ {
 	case parse.CommandContext:
 		// BUG(xiaq): When completing, CommandContext is not supported
-		ed.pushTip("command context not yet supported :(")
-	case parse.ArgContext:
-		// BUG(xiaq): When completing, ArgContext is treated like RedirFilenameContext
-		fallthrough
-	case parse.RedirFilenameContext:
+		ed.pushTip(ed.tr("command context not yet supported :("))
+	case parse.ArgContext, parse.RedirFilenameContext:
 		// BUG(xiaq): When completing, only the case of ctx.ThisFactor.Typ == StringFactor is supported
 		if pctx.ThisFactor.Typ != parse.StringFactor {
-			ed.pushTip("only StringFactor is supported :(")
+			ed.pushTip(ed.tr("only StringFactor is supported :("))
 			return nil
 		}
 		pattern := pctx.PrevFactors + pctx.ThisFactor.Node.(*parse.StringNode).Text
-		names, err := fileNames(".")
-		if err != nil {
-			ed.pushTip(err.Error())
-			return nil
-		}
 		c.start = int(ctx.PrevFactors.Pos)
 		c.end = ed.dot
 		// BUG(xiaq) When completing, completion.typ is always ItemBare
 		c.typ = parse.ItemBare
-		c.candidates = findCandidates(pattern, names)
-		if len(c.candidates) > 0 {
-			// XXX assumes filename candidate
-			for _, c := range c.candidates {
-				c.attr = defaultLsColor.determineAttr(c.text)
+		// Users after ~, and hosts after @
+		if !strings.Contains(pattern, "/") {
+			if strings.HasPrefix(pattern, "~") {
+				ed.completeUser(c, pattern)
+				return nil
+			}
+			if i := strings.LastIndex(pattern, "@"); i != -1 {
+				ed.completeHost(c, pattern, i)
+				return nil
 			}
-			ed.completion = c
-			ed.mode = modeCompletion
-		} else {
-			ed.pushTip(fmt.Sprintf("No completion for %s", pattern))
 		}
+		// The first argument of with-env is a profile name
+		if pctx.Typ == parse.ArgContext &&
+			pctx.CommandTerm == "with-env" && len(pctx.PrevTerms) == 0 {
+			ed.applyCompletion(&completionResult{
+				ed.generation, c, pattern, nil, ed.ev.EnvProfileNames(), nil, true, nil, ""})
+			return nil
+		}
+		// Values used before for the argument come first: the first
+		// argument, or the value of a flag
+		var used []string
+		if pctx.Typ == parse.ArgContext {
+			if n := len(pctx.PrevTerms); n == 0 {
+				used = ed.args.values(pctx.CommandTerm, "")
+			} else if flag := pctx.PrevTerms[n-1]; strings.HasPrefix(flag, "-") {
+				used = ed.args.values(pctx.CommandTerm, flag)
+			}
+		}
+		// Flags of external commands are found from their --help. The
+		// command is found like Enter would find it, which in restricted
+		// mode rejects commands with slashes.
+		command := ""
+		if pctx.Typ == parse.ArgContext && strings.HasPrefix(pattern, "-") {
+			kind, path, err := ed.ev.ResolveCommand(pctx.CommandTerm)
+			if err == nil && kind == eval.CommandExternal {
+				command = path
+			}
+		}
+		// Bash, prepared to complete the words of the command
+		var bash func() ([]string, error)
+		if pctx.Typ == parse.ArgContext && ed.bashCompletionScript != "" && !ed.ev.Restricted() {
+			words := append(append([]string{pctx.CommandTerm}, pctx.PrevTerms...), pattern)
+			bash, _ = bashCompleter(ed.ev, ed.bashCompletionScript, words)
+		}
+		// BUG(xiaq): When completing, other arguments are treated like
+		// filenames in redirections
+		//
+		// Reading a directory or running --help can be slow, so it is done
+		// in the background; the result is dropped if the line has changed
+		// when it arrives.
+		gen := ed.generation
+		complete := func() *completionResult {
+			defer ed.metrics.start("completion")()
+			return argCompletion(ed.ev, ed.helpCache, gen, c, pattern, used, command, bash)
+		}
+		if !ed.writer.caps.asyncCompletion {
+			ed.applyCompletion(complete())
+			return nil
+		}
+		go func() {
+			res := complete()
+			ed.post(func() { ed.applyCompletion(res) })
+		}()
 	}
 	return nil
 }
+
+// argCompletion finds the names for completing an argument: the completions
+// found by bash if it is not nil, the flags of the external command at path
+// command if it is not empty, and file names if neither is found. ev decides
+// whether commands may be run, and their flags are remembered in hc.
+func argCompletion(ev *eval.Evaluator, hc *helpCache, gen int, c *completion, pattern string, used []string, command string, bash func() ([]string, error)) *completionResult {
+	if bash != nil {
+		names, err := bash()
+		if err == nil && len(names) > 0 {
+			return &completionResult{gen, c, pattern, used, names, nil, false, nil, "bash completion"}
+		}
+	}
+	if command != "" {
+		if flags, err := hc.helpFlags(ev, command); err == nil && len(flags) > 0 {
+			names := make([]string, 0, len(flags))
+			for flag := range flags {
+				names = append(names, flag)
+			}
+			sort.Strings(names)
+			return &completionResult{gen, c, pattern, used, names, nil, false, flags, "options"}
+		}
+	}
+	names, descs, err := fileNames(".")
+	return &completionResult{gen, c, pattern, used, names, err, false, descs, ""}
+}
+
+// completionResult is the names found for a completion started when the
+// buffer had the given generation.
+type completionResult struct {
+	generation int
+	c          *completion
+	pattern    string
+	// Values used before, from the history, and other names.
+	used, names []string
+	err         error
+	// Whether the names are profile names instead of file names.
+	profile bool
+	// Descriptions of the names, if any.
+	descriptions map[string]string
+	// The group of the names other than profile names, or "" for files. It
+	// is translated when the candidates are shown, since results may be
+	// found in the background.
+	group string
+}
+
+// applyCompletion enters completion mode with the candidates in res, unless
+// the buffer has changed since the completion was started.
+func (ed *Editor) applyCompletion(res *completionResult) {
+	if res.generation != ed.generation {
+		return
+	}
+	if res.err != nil {
+		log.Warn("completion failed", "pattern", res.pattern, "err", res.err)
+		ed.pushTip(res.err.Error())
+		return
+	}
+	c := res.c
+	used := make(map[string]bool)
+	names := res.used
+	for _, name := range res.used {
+		used[name] = true
+	}
+	for _, name := range res.names {
+		if !used[name] {
+			names = append(names, name)
+		}
+	}
+	c.candidates = ed.findCandidates(res.pattern, names, ed.attrForType[c.typ])
+	if len(c.candidates) == 0 {
+		ed.pushStyledTip(styled.Plain("No completion for ").Concat(
+			styled.New(res.pattern, ed.attrForTip+ed.attrForCompleted)))
+		return
+	}
+	for _, c := range c.candidates {
+		switch {
+		case res.profile:
+			c.group = ed.tr("profiles")
+		case used[c.text]:
+			c.group = ed.tr("used before")
+		case res.group != "":
+			c.group = ed.tr(res.group)
+		default:
+			c.group = ed.tr("files")
+		}
+	}
+	// Only the candidates shown are styled, since that needs a stat each
+	ed.showCompletion(c, res.pattern, func(c *candidate) {
+		switch {
+		case res.profile:
+			c.display = styled.Plain(c.text)
+		case used[c.text], res.group != "":
+			c.display = styled.Plain(c.text)
+			c.description = res.descriptions[c.text]
+		default:
+			c.display = styled.New(c.text, defaultLsColor.determineAttr(c.text))
+			c.description = ed.tr(res.descriptions[c.text])
+			c.link = fileURL(c.text)
+			c.file = c.text
+		}
+	})
+}
+
+// showCompletion enters completion mode with the candidates of c, found for
+// pattern, after grouping and sorting them. The candidates shown are styled
+// with style. In the prefix completion style, the common prefix of the
+// candidates is inserted instead if it is longer than pattern.
+func (ed *Editor) showCompletion(c *completion, pattern string, style func(*candidate)) {
+	groupCandidates(c.candidates)
+	if len(ed.completionSort) > 0 {
+		dir, _ := os.Getwd()
+		ed.sortCandidates(c.candidates, ed.completionSort, ed.histories, dir)
+	}
+	if ed.completionStyle == "prefix" {
+		prefix := commonPrefix(c.candidates)
+		if len(prefix) > len(pattern) && strings.HasPrefix(prefix, pattern) {
+			ed.line = ed.line[:c.start] + prefix + ed.line[c.end:]
+			ed.dot += len(prefix) - (c.end - c.start)
+			return
+		}
+	}
+	if len(c.candidates) > ed.completionLimit {
+		c.omitted = len(c.candidates) - ed.completionLimit
+		c.candidates = c.candidates[:ed.completionLimit]
+	}
+	for _, cand := range c.candidates {
+		style(cand)
+	}
+	ed.completion = c
+	ed.mode = modeCompletion
+}
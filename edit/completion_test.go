@@ -0,0 +1,87 @@
+package edit
+
+import "testing"
+
+var humanSizeTests = []struct {
+	n      int64
+	wanted string
+}{
+	{0, "0B"},
+	{1023, "1023B"},
+	{1536, "1.5K"},
+	{200 * 1024, "200K"},
+	{3 << 30, "3.0G"},
+}
+
+func TestHumanSize(t *testing.T) {
+	for _, tt := range humanSizeTests {
+		if out := humanSize(tt.n); out != tt.wanted {
+			t.Errorf("humanSize(%d) => %q, want %q", tt.n, out, tt.wanted)
+		}
+	}
+}
+
+func TestGroupCandidates(t *testing.T) {
+	var cands []*candidate
+	for _, s := range []string{"a/x", "b/y", "a/z", "c/w", "b/v"} {
+		cands = append(cands, &candidate{text: s[2:], group: s[:1]})
+	}
+	groupCandidates(cands)
+	out := ""
+	for _, c := range cands {
+		out += c.group + "/" + c.text + " "
+	}
+	if wanted := "a/x a/z b/y b/v c/w "; out != wanted {
+		t.Errorf("groupCandidates => %q, want %q", out, wanted)
+	}
+}
+
+var commonPrefixTests = []struct {
+	texts  []string
+	wanted string
+}{
+	{[]string{}, ""},
+	{[]string{"foo"}, "foo"},
+	{[]string{"foobar", "foobaz", "foo"}, "foo"},
+	{[]string{"fizz", "fuzz"}, "f"},
+	{[]string{"好好", "好的"}, "好"},
+	{[]string{"é", "è"}, ""},
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, tt := range commonPrefixTests {
+		var cands []*candidate
+		for _, text := range tt.texts {
+			cands = append(cands, &candidate{text: text})
+		}
+		if out := commonPrefix(cands); out != tt.wanted {
+			t.Errorf("commonPrefix(%q) => %q, want %q", tt.texts, out, tt.wanted)
+		}
+	}
+}
+
+func TestPrefixCompletionStyle(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.completionStyle = "prefix"
+	ed.line, ed.dot = "ls f", 4
+	names := []string{"foobar", "foobaz"}
+	ed.applyCompletion(&completionResult{0, &completion{start: 3, end: 4}, "f", nil, names, nil, false, nil, ""})
+	if ed.line != "ls fooba" || ed.dot != 8 || ed.mode != modeInsert {
+		t.Errorf("first completion => %q, dot %d, mode %d, want %q, dot 8, insert mode", ed.line, ed.dot, ed.mode, "ls fooba")
+	}
+	ed.applyCompletion(&completionResult{0, &completion{start: 3, end: 8}, "fooba", nil, names, nil, false, nil, ""})
+	if ed.mode != modeCompletion {
+		t.Errorf("second completion => mode %d, want completion mode", ed.mode)
+	}
+}
+
+func TestCompletionLimit(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.completionLimit = 2
+	ed.line, ed.dot = "ls f", 4
+	names := []string{"f1", "f2", "f3", "f4"}
+	ed.applyCompletion(&completionResult{0, &completion{start: 3, end: 4}, "f", nil, names, nil, false, nil, ""})
+	if c := ed.completion; c == nil || len(c.candidates) != 2 || c.omitted != 2 {
+		t.Errorf("completion with limit 2 => %v, want 2 candidates and 2 omitted", c)
+	}
+}
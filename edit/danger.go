@@ -0,0 +1,186 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// Before an accepted line is returned to be run, it is checked for dangerous
+// commands, like "rm -rf /". If one is found, it is highlighted, and the line
+// is only run if the user answers y to a question saying why it is dangerous.
+//
+// Go programs add checks with AddDangerCheck. In elvish, le:danger adds a
+// pattern of words. A command matches it if its name is the first word, and
+// the other words are all among its arguments, in any order; so the pattern
+// "git push --force" matches "git push origin main --force". Only the commands
+// of the line itself are checked, not those in output captures or closures.
+
+// DangerCheck checks a command of the line, whose parsed form is given, for
+// danger. It returns a message saying why the command is dangerous, or "" if
+// it is not.
+type DangerCheck func(line string, form *parse.FormNode) string
+
+// danger is a dangerous command found in the line, with the range of the line
+// it takes.
+type danger struct {
+	start, end int
+	reason     string
+}
+
+// defaultDangerPatterns are the patterns of dangerous commands an Editor
+// starts with.
+var defaultDangerPatterns = []string{"rm -rf /", "rm -rf /*", "git push --force", "git push -f"}
+
+func init() {
+	addEditorBuiltin("le:danger", builtinDanger)
+	addEditorBuiltin("le:undanger", builtinUndanger)
+}
+
+// AddDangerCheck adds a check for dangerous commands.
+func (ed *Editor) AddDangerCheck(f DangerCheck) {
+	ed.dangerChecks = append(ed.dangerChecks, f)
+}
+
+// formWords returns the words of form that are literal strings, with the
+// ranges of the line they take. Other words are "", with empty ranges.
+func formWords(form *parse.FormNode) ([]string, [][2]int) {
+	terms := []*parse.TermNode{form.Command}
+	if form.Args != nil {
+		terms = append(terms, form.Args.Nodes...)
+	}
+	words := make([]string, len(terms))
+	ranges := make([][2]int, len(terms))
+	for i, term := range terms {
+		if word, ok := literalTerm(term); ok {
+			end := int(term.Pos)
+			for _, factor := range term.Nodes {
+				end += len(factor.Node.(*parse.StringNode).Quoted)
+			}
+			words[i] = word
+			ranges[i] = [2]int{int(term.Pos), end}
+		}
+	}
+	return words, ranges
+}
+
+// matchDangerPattern returns the range of the line taken by form if it matches
+// pattern, up to the end of the last word matched.
+func matchDangerPattern(pattern string, form *parse.FormNode) (int, int, bool) {
+	words, ranges := formWords(form)
+	pwords := strings.Fields(pattern)
+	if len(pwords) == 0 || words[0] != pwords[0] {
+		return 0, 0, false
+	}
+	end := ranges[0][1]
+	for _, pw := range pwords[1:] {
+		found := false
+		for i := 1; i < len(words); i++ {
+			if words[i] == pw {
+				found = true
+				if end < ranges[i][1] {
+					end = ranges[i][1]
+				}
+				break
+			}
+		}
+		if !found {
+			return 0, 0, false
+		}
+	}
+	return ranges[0][0], end, true
+}
+
+// findDanger returns the first dangerous command in line, if any.
+func (o *options) findDanger(line string) (danger, bool) {
+	if len(o.dangerChecks) == 0 && len(o.dangerPatterns) == 0 {
+		return danger{}, false
+	}
+	n, err := parse.Parse("<danger>", line)
+	if err != nil {
+		return danger{}, false
+	}
+	for _, p := range n.Nodes {
+		for _, form := range p.Nodes {
+			if form.Command == nil {
+				continue
+			}
+			for _, pattern := range o.dangerPatterns {
+				if start, end, ok := matchDangerPattern(pattern, form); ok {
+					return danger{start, end, o.trf("%s is dangerous", pattern)}, true
+				}
+			}
+			for _, f := range o.dangerChecks {
+				if reason := f(line, form); reason != "" {
+					start := int(form.Pos)
+					_, ranges := formWords(form)
+					end := start
+					for _, r := range ranges {
+						if end < r[1] {
+							end = r[1]
+						}
+					}
+					if end == start {
+						end = len(line)
+					}
+					return danger{start, end, reason}, true
+				}
+			}
+		}
+	}
+	return danger{}, false
+}
+
+// confirmDanger asks whether to run the line with the dangerous command d,
+// highlighting it. ret is returned, ending ReadLine, if the answer is yes.
+func (ed *Editor) confirmDanger(d danger, ret *leReturn) {
+	ed.confirm(d.reason+"; "+ed.tr("run anyway?"), func(*Editor) {})
+	mb := ed.minibuffer
+	mb.matchStart, mb.matchEnd = d.start, d.end
+	mb.yesReturn = ret
+}
+
+// builtinDanger implements the le:danger builtin. With no arguments, it prints
+// the patterns of dangerous commands, one per line. With one, it adds the
+// pattern.
+func builtinDanger(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		for _, pattern := range ed.dangerPatterns {
+			fmt.Fprintln(ev.OutFile(), pattern)
+		}
+		return ""
+	case 1:
+		pattern := strings.Join(strings.Fields(args[0].String()), " ")
+		if pattern == "" {
+			return "empty pattern"
+		}
+		for _, p := range ed.dangerPatterns {
+			if p == pattern {
+				return ""
+			}
+		}
+		ed.dangerPatterns = append(ed.dangerPatterns, pattern)
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinUndanger implements the le:undanger builtin. It removes a pattern of
+// dangerous commands.
+func builtinUndanger(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 1 {
+		return "args error"
+	}
+	pattern := strings.Join(strings.Fields(args[0].String()), " ")
+	for i, p := range ed.dangerPatterns {
+		if p == pattern {
+			ed.dangerPatterns = append(ed.dangerPatterns[:i:i], ed.dangerPatterns[i+1:]...)
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s is not a pattern of dangerous commands", pattern)
+}
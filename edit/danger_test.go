@@ -0,0 +1,55 @@
+package edit
+
+import (
+	"testing"
+
+	"github.com/xiaq/elvish/parse"
+)
+
+var findDangerTests = []struct {
+	line   string
+	danger danger
+	ok     bool
+}{
+	{"rm -rf /", danger{0, 8, "rm -rf / is dangerous"}, true},
+	{"echo x; rm / -rf tmp", danger{8, 16, "rm -rf / is dangerous"}, true},
+	{"git push origin main --force", danger{0, 28, "git push --force is dangerous"}, true},
+	{"git push `-f`", danger{0, 13, "git push -f is dangerous"}, true},
+	{"rm -rf /tmp/x", danger{}, false},
+	{"echo rm -rf /", danger{}, false},
+	{"git push", danger{}, false},
+	{"rm -rf $dir", danger{}, false},
+	{"sudo reboot", danger{0, 11, "reboots"}, true},
+}
+
+func TestFindDanger(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.AddDangerCheck(func(line string, form *parse.FormNode) string {
+		if words, _ := formWords(form); len(words) == 2 && words[1] == "reboot" {
+			return "reboots"
+		}
+		return ""
+	})
+	for _, tt := range findDangerTests {
+		d, ok := ed.findDanger(tt.line)
+		if d != tt.danger || ok != tt.ok {
+			t.Errorf("findDanger(%q) => (%v, %v), want (%v, %v)", tt.line, d, ok, tt.danger, tt.ok)
+		}
+	}
+}
+
+func TestConfirmDanger(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.line, ed.dot = "rm -rf /", 8
+	if ret := ed.handleRead(keyRead(Key{Enter, 0})); ret != nil || ed.mode != modeMinibuffer {
+		t.Fatalf("Enter on a dangerous line => %v, mode %d, want a question", ret, ed.mode)
+	}
+	if ret := ed.handleRead(keyRead(Key{'n', 0})); ret != nil || ed.mode != modeInsert {
+		t.Errorf("answering n => %v, mode %d, want nothing run", ret, ed.mode)
+	}
+	ed.handleRead(keyRead(Key{Enter, 0}))
+	ret := ed.handleRead(keyRead(Key{'y', 0}))
+	if ret == nil || ret.Line != "rm -rf /" {
+		t.Errorf("answering y => %v, want the line returned", ret)
+	}
+}
@@ -0,0 +1,60 @@
+package edit
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xiaq/elvish/edit/tty"
+)
+
+// The editor can read keys from and draw to /dev/tty, the controlling
+// terminal of the process, instead of the files it is given, so that lines
+// can still be edited when stdin or stdout is redirected, like in
+// "elvish | tee log".
+
+// TTYMode is how the editor chooses the terminal it uses.
+type TTYMode int
+
+const (
+	// TTYAuto uses the files given if they are both terminals, and /dev/tty
+	// otherwise.
+	TTYAuto TTYMode = iota
+	// TTYAlways always uses /dev/tty.
+	TTYAlways
+	// TTYNever always uses the files given.
+	TTYNever
+)
+
+var ttyModeNames = []string{"auto", "always", "never"}
+
+func (m TTYMode) String() string {
+	if m < 0 || int(m) >= len(ttyModeNames) {
+		return fmt.Sprintf("TTYMode(%d)", int(m))
+	}
+	return ttyModeNames[m]
+}
+
+// ParseTTYMode parses the name of a TTYMode: auto, always or never.
+func ParseTTYMode(s string) (TTYMode, error) {
+	for i, name := range ttyModeNames {
+		if s == name {
+			return TTYMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("bad tty mode %q, want auto, always or never", s)
+}
+
+// OpenTerminal returns the files to read keys from and draw to, given in and
+// out and the mode. When /dev/tty is used, it is opened and also returned as
+// ttyFile, for the caller to close when done.
+func OpenTerminal(in, out *os.File, mode TTYMode) (input, output, ttyFile *os.File, err error) {
+	if mode == TTYNever || (mode == TTYAuto &&
+		tty.IsTerminal(int(in.Fd())) && tty.IsTerminal(int(out.Fd()))) {
+		return in, out, nil, nil
+	}
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return f, f, f, nil
+}
@@ -0,0 +1,26 @@
+package edit
+
+import "testing"
+
+var parseTTYModeTests = []struct {
+	s    string
+	mode TTYMode
+	ok   bool
+}{
+	{"auto", TTYAuto, true},
+	{"always", TTYAlways, true},
+	{"never", TTYNever, true},
+	{"sometimes", 0, false},
+}
+
+func TestParseTTYMode(t *testing.T) {
+	for _, tt := range parseTTYModeTests {
+		mode, err := ParseTTYMode(tt.s)
+		if mode != tt.mode || (err == nil) != tt.ok {
+			t.Errorf("ParseTTYMode(%q) => (%v, %v), want (%v, ok %v)", tt.s, mode, err, tt.mode, tt.ok)
+		}
+		if tt.ok && mode.String() != tt.s {
+			t.Errorf("TTYMode(%d).String() => %q, want %q", int(mode), mode.String(), tt.s)
+		}
+	}
+}
@@ -2,22 +2,41 @@
 package edit
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/edit/terminfo"
 	"github.com/xiaq/elvish/edit/tty"
 	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/logger"
 	"github.com/xiaq/elvish/parse"
+	"github.com/xiaq/elvish/util"
 )
 
+var log = logger.New("edit")
+
 const (
 	CPRWaitTimeout = 10 * time.Millisecond
+	// RefreshDebounce is how long the editor may wait for more input before
+	// refreshing, when input comes in bursts.
+	RefreshDebounce = 2 * time.Millisecond
+	// MaxCoalescedReads and MaxRefreshDelay bound how many reads and how much
+	// time a single refresh can be put off for.
+	MaxCoalescedReads = 256
+	MaxRefreshDelay   = 50 * time.Millisecond
 )
 
-var LackEOL = "\033[7m\u23ce\033[m\n"
+// defaultEOLMarker is shown, in the eol-marker style, after the output of a
+// command when it does not end with a newline, unless changed with
+// le:eol-marker.
+const defaultEOLMarker = "\u23ce"
 
 type bufferMode int
 
@@ -27,35 +46,140 @@ const (
 	modeCompletion
 	modeNavigation
 	modeHistory
+	modeSnippet
+	modePaste
+	modeViPending
+	modeVisual
+	modeLiteral
+	modeMinibuffer
+	modePalette
+	modeHistoryListing
 )
 
 type editorState struct {
 	// States used during ReadLine. Reset at the beginning of ReadLine.
-	savedTermios          *tty.Termios
-	tokens                []parse.Item
-	prompt, rprompt, line string
-	dot                   int
-	tips                  []string
-	mode                  bufferMode
-	completion            *completion
-	completionLines       int
-	navigation            *navigation
-	history               historyState
+	savedTermios    *tty.Termios
+	tokens          []parse.Item
+	prompt, rprompt styled.Text
+	line            string
+	dot             int
+	tips            []styled.Text
+	mode            bufferMode
+	completion      *completion
+	completionLines int
+	navigation      *navigation
+	history         historyState
+	snippet         *snippetListing
+	paste           *pasteState
+	// Placeholders of the last inserted snippet not yet jumped to.
+	placeholders []string
+	// The current field of the last inserted snippet, the fields not yet
+	// jumped to, and the line their ranges are for.
+	field     *snippetField
+	fields    []snippetField
+	fieldLine string
+	// The result of the last calc, and the line it was calculated from.
+	calcResult, calcLine string
+	// The rest of the line suggested from the history. Updated by
+	// updateSuggestion.
+	suggestion string
+	// The first parse or compile error in the line, if any. Updated by
+	// checkLine.
+	lineError *util.ContextualError
+	// The keys of the vi command being typed in vi-pending mode.
+	viKeys string
+	// The selected range of the line, if any.
+	selection *selection
+	literal   *literalState
+	// Bindings or keys that can follow shown below the line until the next
+	// key.
+	hints          []string
+	minibuffer     *minibuffer
+	palette        *palette
+	historyListing *historyListing
+	// The text being composed with an input method, set with SetPreedit.
+	preedit preedit
+	// Messages of Notify shown above the prompt.
+	messages []string
+	// What the line printed when run in instant mode.
+	instant []string
+	// The preview of the selected file, if any; see file-preview.go.
+	filePreview *filePreview
 }
 
 type historyState struct {
 	current int
 	prefix  string
+	// The current directory and its project root, and a cache of project
+	// roots of directories in the history, used by inHistoryScope.
+	wd, root string
+	roots    map[string]string
 }
 
 // Editor keeps the status of the line editor.
 type Editor struct {
+	// The terminal keys are read from. The writer has the one drawn to.
 	file      *os.File
 	writer    *writer
 	reader    *Reader
 	ev        *eval.Evaluator
 	sigs      <-chan os.Signal
-	histories []string
+	histories []HistoryEntry
+	// The file the history is appended to, if any, how much of it has been
+	// read, and whether the last entry of histories is a line still running,
+	// to be appended to it when it finishes. historyInfo identifies the file
+	// read, to tell when it has been replaced.
+	historyFile   string
+	historyOffset int64
+	historyInfo   os.FileInfo
+	running       bool
+	// The arguments in histories.
+	args argIndex
+	// The flags found in the --help of commands, for completion.
+	helpCache *helpCache
+	// Whether the terminal has been queried for its capabilities, and the
+	// capabilities found, before features are overridden.
+	capsDetected bool
+	detectedCaps capabilities
+	// Keys read while waiting for replies of the terminal, to be handled
+	// before reading more.
+	typeahead []OneRead
+	// Whether the terminal has reported losing focus, and not regaining it
+	// since. Focus is only reported during ReadLine.
+	unfocused bool
+	// The generation of the buffer, bumped whenever the line, the dot or the
+	// mode changes. Results of completions running in the background are only
+	// applied if the generation has not changed.
+	generation int
+	// The registers of vi commands.
+	registers map[rune]viRegister
+	// The prompt functions set with SetPrompts.
+	promptFn, rpromptFn func() styled.Text
+	// Whether SIGINT has been received during ReadLine.
+	interrupted bool
+	// /dev/tty, if the editor opened it to use as the terminal.
+	ttyFile *os.File
+	// Functions posted by other goroutines, and a channel notified to run
+	// them and redraw; see post.
+	asyncMutex sync.Mutex
+	posted     []func()
+	redraws    chan struct{}
+	// The last messages of Notify, and when to stop showing them.
+	messageLog      []string
+	messagesTimeout <-chan time.Time
+	// The line run in instant mode, when to run it, and how to kill it.
+	instantLine   string
+	instantTimer  <-chan time.Time
+	instantCancel context.CancelFunc
+	// Hooks added by Go programs, and errors of hooks to be shown at the next
+	// prompt.
+	beforeReadline []func()
+	onAccept       []func(string) string
+	afterReadline  []func(string)
+	hookErrors     []string
+	// How long things took; see le:metrics.
+	metrics metrics
+	*options
 	editorState
 }
 
@@ -68,12 +192,32 @@ type LineRead struct {
 }
 
 func (ed *Editor) appendHistory(line string) {
-	ed.histories = append(ed.histories, line)
+	if ed.running && ed.historyFile != "" {
+		// The status of the last line was never reported; write it without
+		// one
+		ed.writeHistoryEntries(ed.histories[len(ed.histories)-1])
+	}
+	ed.running = false
+	if ed.ignoredInHistory(line) {
+		return
+	}
+	histories, ok := ed.dedupHistory(ed.histories, line)
+	if !ok {
+		return
+	}
+	dir, _ := os.Getwd()
+	ed.histories = append(histories,
+		HistoryEntry{Line: line, Dir: dir, Start: time.Now(), local: true})
+	ed.running = true
+	if ed.args == nil {
+		ed.args = make(argIndex)
+	}
+	ed.args.add(line)
 }
 
 func (ed *Editor) prevHistory() bool {
 	for i := ed.history.current - 1; i >= 0; i-- {
-		if strings.HasPrefix(ed.histories[i], ed.history.prefix) {
+		if strings.HasPrefix(ed.histories[i].Line, ed.history.prefix) && ed.inHistoryScope(i) {
 			ed.history.current = i
 			return true
 		}
@@ -83,7 +227,7 @@ func (ed *Editor) prevHistory() bool {
 
 func (ed *Editor) nextHistory() bool {
 	for i := ed.history.current + 1; i < len(ed.histories); i++ {
-		if strings.HasPrefix(ed.histories[i], ed.history.prefix) {
+		if strings.HasPrefix(ed.histories[i].Line, ed.history.prefix) && ed.inHistoryScope(i) {
 			ed.history.current = i
 			return true
 		}
@@ -91,21 +235,102 @@ func (ed *Editor) nextHistory() bool {
 	return false
 }
 
-// NewEditor creates an Editor.
+// NewEditor creates an Editor on the terminal file. Signals received by the
+// program are passed through sigs. See New for more options.
 func NewEditor(file *os.File, ev *eval.Evaluator, sigs <-chan os.Signal) *Editor {
-	return &Editor{
-		file:   file,
-		writer: newWriter(file),
-		reader: NewReader(file),
-		ev:     ev,
-		sigs:   sigs,
+	return newEditor(file, file, ev, sigs)
+}
+
+func newEditor(in, out *os.File, ev *eval.Evaluator, sigs <-chan os.Signal) *Editor {
+	o := newOptions()
+	ed := &Editor{
+		file:    in,
+		writer:  newWriter(out, o),
+		reader:  NewReader(in),
+		ev:      ev,
+		sigs:    sigs,
+		options: o,
+
+		helpCache: newHelpCache(),
+		redraws:   make(chan struct{}, 1),
+	}
+	ev.SetEditor(ed)
+	return ed
+}
+
+// editorOf returns the Editor using ev, or nil if there is none, like when
+// elvish runs a script.
+func editorOf(ev *eval.Evaluator) *Editor {
+	ed, _ := ev.Editor().(*Editor)
+	return ed
+}
+
+// addEditorBuiltin adds a builtin function of elvish that operates on the
+// Editor it is called from, like le:bind. It fails if there is none.
+func addEditorBuiltin(name string, f func(ed *Editor, ev *eval.Evaluator, args []eval.Value) string) {
+	eval.AddPrintingBuiltinFunc(name, func(ev *eval.Evaluator, args []eval.Value) string {
+		ed := editorOf(ev)
+		if ed == nil {
+			return "no editor"
+		}
+		return f(ed, ev, args)
+	})
+}
+
+// SetHorizontalScroll sets whether long lines are scrolled horizontally
+// within one terminal row instead of being soft-wrapped. This is useful on
+// terminals that mangle redraws of wrapped lines.
+func (ed *Editor) SetHorizontalScroll(b bool) {
+	ed.writer.horizontalScroll = b
+}
+
+// SetEscape sets how an Escape not followed by a function key sequence is
+// read; see (*Reader).SetEscape.
+func (ed *Editor) SetEscape(timeout time.Duration, meta bool) {
+	ed.reader.SetEscape(timeout, meta)
+}
+
+// Close releases the terminal input, stopping the goroutines reading it, and
+// closes /dev/tty if the editor opened it. The Editor cannot be used
+// afterwards.
+func (ed *Editor) Close() error {
+	err := ed.reader.Close()
+	if ed.ttyFile != nil {
+		if cerr := ed.ttyFile.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Focused returns false if the terminal window was last reported to have lost
+// focus. Focus is only reported during ReadLine, and only by terminals that
+// support focus reporting; the window is assumed to be focused when nothing is
+// known.
+func (ed *Editor) Focused() bool {
+	return !ed.unfocused
+}
+
+// UseTerminfo makes the editor generate escape sequences for cursor motions
+// and erases from the terminfo entry for $TERM, instead of the hardcoded xterm
+// sequences.
+func (ed *Editor) UseTerminfo() error {
+	ti, err := terminfo.Load(os.Getenv("TERM"))
+	if err != nil {
+		return err
 	}
+	ed.writer.esc = terminfoEscapes{ti}
+	return nil
 }
 
 func (ed *Editor) beep() {
 }
 
 func (ed *Editor) pushTip(more string) {
+	ed.pushStyledTip(styled.Plain(more))
+}
+
+func (ed *Editor) pushStyledTip(more styled.Text) {
 	ed.tips = append(ed.tips, more)
 }
 
@@ -118,36 +343,83 @@ func (ed *Editor) refresh() error {
 			ed.tokens = append(ed.tokens, token)
 		}
 	}
-	return ed.writer.refresh(&ed.editorState, ed.histories)
+	err := ed.writer.refresh(&ed.editorState, ed.histories)
+	ed.loadNavImage()
+	return err
 }
 
-// TODO Allow modifiable keybindings.
-var keyBindings = map[bufferMode]map[Key]string{
+// checkLine parses and compiles the line, to show the first error in it. An
+// error at the end of the line, which is usually just incomplete, is not
+// shown.
+func (ed *Editor) checkLine() {
+	ed.lineError = nil
+	err := ed.ev.Check("<interactive code>", ed.line)
+	if e, ok := err.(*util.ContextualError); ok && e.Pos() < len(ed.line) {
+		ed.lineError = e
+	}
+}
+
+// defaultKeyBindings are the key bindings editors start with. Each Editor has
+// a copy, which le:bind changes.
+var defaultKeyBindings = map[bufferMode]map[Key]string{
 	modeCommand: map[Key]string{
 		Key{'i', 0}:    "start-insert",
 		Key{'h', 0}:    "move-dot-left",
 		Key{'l', 0}:    "move-dot-right",
 		Key{'D', 0}:    "kill-line-right",
+		Key{'b', 0}:    "move-dot-left-word",
+		Key{'w', 0}:    "move-dot-right-word",
+		Key{'B', 0}:    "move-dot-left-big-word",
+		Key{'W', 0}:    "move-dot-right-big-word",
+		Key{'"', 0}:    "vi-command-key",
+		Key{'d', 0}:    "vi-command-key",
+		Key{'y', 0}:    "vi-command-key",
+		Key{'c', 0}:    "vi-command-key",
+		Key{'p', 0}:    "vi-command-key",
+		Key{'P', 0}:    "vi-command-key",
+		Key{'v', 0}:    "start-visual",
+		Key{F1, 0}:     "show-bindings",
+		Key{':', 0}:    "start-palette",
 		DefaultBinding: "default-command",
 	},
 	modeInsert: map[Key]string{
 		Key{'[', Ctrl}:    "start-command",
 		Key{'U', Ctrl}:    "kill-line-left",
 		Key{'K', Ctrl}:    "kill-line-right",
-		Key{'W', Ctrl}:    "kill-word-left",
+		Key{'W', Ctrl}:    "kill-big-word-left",
+		Key{'b', Alt}:     "move-dot-left-word",
+		Key{'f', Alt}:     "move-dot-right-word",
+		Key{'d', Alt}:     "kill-word-right",
+		Key{'V', Ctrl}:    "start-literal",
+		Key{'k', Alt}:     "start-digraph",
 		Key{Backspace, 0}: "kill-rune-left",
 		Key{Delete, 0}:    "kill-rune-right",
 		Key{Left, 0}:      "move-dot-left",
-		Key{Right, 0}:     "move-dot-right",
-		Key{Up, 0}:        "move-dot-up",
+		Key{Right, 0}:     "accept-suggestion-or-move-dot-right",
+		Key{Up, 0}:        "move-dot-up-or-start-history",
 		Key{Down, 0}:      "move-dot-down",
 		Key{Enter, Alt}:   "insert-key",
 		Key{Enter, 0}:     "return-line",
 		Key{'D', Ctrl}:    "return-eof",
-		Key{Tab, 0}:       "start-completion",
+		Key{Tab, 0}:       "next-placeholder-or-complete",
+		Key{'s', Alt}:     "start-snippet",
+		Key{'=', Alt}:     "calc",
 		Key{PageUp, 0}:    "start-history",
 		Key{'N', Ctrl}:    "start-navigation",
+		Key{F1, 0}:        "show-bindings",
+		Key{'R', Ctrl}:    "search-history",
+		Key{'q', Alt}:     "query-replace",
+		Key{'x', Alt}:     "start-palette",
+		Key{'h', Alt}:     "toggle-dir-history",
+		Key{'r', Alt}:     "start-history-listing",
 		DefaultBinding:    "default-insert",
+
+		Key{Backspace, Alt}: "kill-word-left",
+
+		Key{Left, Shift}:         "extend-selection-left",
+		Key{Right, Shift}:        "extend-selection-right",
+		Key{Left, Shift | Ctrl}:  "extend-selection-left-word",
+		Key{Right, Shift | Ctrl}: "extend-selection-right-word",
 	},
 	modeCompletion: map[Key]string{
 		Key{'[', Ctrl}: "cancel-completion",
@@ -163,21 +435,88 @@ var keyBindings = map[bufferMode]map[Key]string{
 		Key{Down, 0}:   "select-nav-down",
 		Key{Left, 0}:   "ascend-nav",
 		Key{Right, 0}:  "descend-nav",
+		Key{'R', Ctrl}: "rename-nav",
+		Key{Delete, 0}: "remove-nav",
 		DefaultBinding: "default-navigation",
 	},
 	modeHistory: map[Key]string{
 		Key{'[', Ctrl}:   "start-insert",
 		Key{PageUp, 0}:   "select-history-prev",
 		Key{PageDown, 0}: "select-history-next",
+		Key{Up, 0}:       "select-history-prev",
+		Key{Down, 0}:     "select-history-next",
+		Key{'h', Alt}:    "toggle-dir-history",
 		DefaultBinding:   "default-history",
 	},
+	modeSnippet: map[Key]string{
+		Key{'[', Ctrl}: "cancel-snippet",
+		Key{Up, 0}:     "select-snippet-up",
+		Key{Down, 0}:   "select-snippet-down",
+		Key{Enter, 0}:  "accept-snippet",
+		DefaultBinding: "default-snippet",
+	},
+	modePaste: map[Key]string{
+		Key{'[', Ctrl}: "cancel-paste",
+		Key{Enter, 0}:  "accept-paste",
+		DefaultBinding: "default-paste",
+	},
+	modeViPending: map[Key]string{
+		Key{'[', Ctrl}: "cancel-vi-command",
+		DefaultBinding: "vi-command-key",
+	},
+	modeVisual: map[Key]string{
+		Key{'[', Ctrl}: "cancel-visual",
+		Key{'v', 0}:    "cancel-visual",
+		Key{'h', 0}:    "move-dot-left",
+		Key{'l', 0}:    "move-dot-right",
+		Key{Left, 0}:   "move-dot-left",
+		Key{Right, 0}:  "move-dot-right",
+		Key{'b', 0}:    "move-dot-left-word",
+		Key{'w', 0}:    "move-dot-right-word",
+		Key{'B', 0}:    "move-dot-left-big-word",
+		Key{'W', 0}:    "move-dot-right-big-word",
+		Key{'d', 0}:    "visual-delete",
+		Key{'x', 0}:    "visual-delete",
+		Key{'y', 0}:    "visual-yank",
+		Key{'c', 0}:    "visual-change",
+		DefaultBinding: "default-visual",
+	},
+	modeLiteral: map[Key]string{
+		DefaultBinding: "literal-key",
+	},
+	modeMinibuffer: map[Key]string{
+		Key{'[', Ctrl}:    "cancel-minibuffer",
+		Key{Enter, 0}:     "accept-minibuffer",
+		Key{Backspace, 0}: "minibuffer-kill-rune-left",
+		Key{'U', Ctrl}:    "minibuffer-kill-line-left",
+		Key{Left, 0}:      "minibuffer-move-dot-left",
+		Key{Right, 0}:     "minibuffer-move-dot-right",
+		DefaultBinding:    "default-minibuffer",
+	},
+	modePalette: map[Key]string{
+		Key{'[', Ctrl}:    "cancel-palette",
+		Key{Up, 0}:        "select-palette-up",
+		Key{Down, 0}:      "select-palette-down",
+		Key{Backspace, 0}: "kill-palette-rune-left",
+		Key{Enter, 0}:     "accept-palette",
+		DefaultBinding:    "default-palette",
+	},
+	modeHistoryListing: map[Key]string{
+		Key{'[', Ctrl}:    "cancel-history-listing",
+		Key{Up, 0}:        "select-history-listing-up",
+		Key{Down, 0}:      "select-history-listing-down",
+		Key{Tab, 0}:       "toggle-history-listing",
+		Key{Backspace, 0}: "kill-history-listing-rune-left",
+		Key{Enter, 0}:     "accept-history-listing",
+		DefaultBinding:    "default-history-listing",
+	},
 }
 
 func init() {
-	for _, kb := range keyBindings {
+	for _, kb := range defaultKeyBindings {
 		for _, name := range kb {
 			if leBuiltins[name] == nil {
-				panic("bad keyBindings table: no editor builtin named " + name)
+				panic("bad defaultKeyBindings table: no editor builtin named " + name)
 			}
 		}
 	}
@@ -197,11 +536,14 @@ func (ed *Editor) acceptCompletion() {
 
 // acceptHistory accepts currently history.
 func (ed *Editor) acceptHistory() {
-	ed.line = ed.histories[ed.history.current]
+	ed.line = ed.histories[ed.history.current].Line
 	ed.dot = len(ed.line)
 }
 
-func SetupTerminal(file *os.File) (*tty.Termios, error) {
+// SetupTerminal puts the terminal in the mode the editor reads keys in,
+// keeping flow control if flowControl is true, and returns the attributes to
+// restore with CleanupTerminal.
+func SetupTerminal(file *os.File, flowControl bool) (*tty.Termios, error) {
 	fd := int(file.Fd())
 	term, err := tty.NewTermiosFromFd(fd)
 	if err != nil {
@@ -212,6 +554,7 @@ func SetupTerminal(file *os.File) (*tty.Termios, error) {
 
 	term.SetIcanon(false)
 	term.SetEcho(false)
+	term.SetIxon(flowControl)
 	term.SetMin(1)
 	term.SetTime(0)
 
@@ -220,9 +563,6 @@ func SetupTerminal(file *os.File) (*tty.Termios, error) {
 		return nil, fmt.Errorf("can't set up terminal attribute: %s", err)
 	}
 
-	// Set autowrap off
-	file.WriteString("\033[?7l")
-
 	err = tty.FlushInput(fd)
 	if err != nil {
 		return nil, fmt.Errorf("can't flush input: %s", err)
@@ -232,154 +572,420 @@ func SetupTerminal(file *os.File) (*tty.Termios, error) {
 }
 
 func CleanupTerminal(file *os.File, savedTermios *tty.Termios) error {
-	// Set autowrap on
-	file.WriteString("\033[?7h")
 	fd := int(file.Fd())
 	return savedTermios.ApplyToFd(fd)
 }
 
 // startsReadLine prepares the terminal for the editor.
 func (ed *Editor) startReadLine() error {
-	savedTermios, err := SetupTerminal(ed.file)
+	savedTermios, err := SetupTerminal(ed.file, ed.flowControl)
 	if err != nil {
 		return err
 	}
 	ed.savedTermios = savedTermios
 
-	// Query cursor location
-	ed.file.WriteString("\033[6n")
-
 	ed.reader.Continue()
-	ones := ed.reader.Chan()
 
-	cpr := InvalidPos
-FindCPR:
+	if !ed.capsDetected {
+		ed.detectedCaps = ed.detectCapabilities()
+		ed.capsDetected = true
+	}
+	ed.writer.caps = ed.detectedCaps.withOverrides(ed.featureOverrides)
+	if ed.writer.caps.dumb {
+		// Don't send anything that the terminal does not understand, and
+		// render without escape sequences
+		return nil
+	}
+
+	// Mark missing newlines while autowrap is still on, which eolMarker
+	// relies on when the cursor position is unknown
+	col := -1
+	if ed.writer.caps.cursorQuery {
+		if cursor, err := ed.cursorPos(); err == nil {
+			col = cursor.col
+		}
+	}
+	out := ed.writer.file
+	width, _, _ := tty.Size(int(out.Fd()))
+	out.WriteString(ed.eolMarker(col, width))
+
+	// Set autowrap off, and focus reporting and bracketed paste on
+	out.WriteString("\033[?7l" + ed.privateModes("h"))
+
+	return nil
+}
+
+// privateModes returns the sequences that set (with "h") or reset (with "l")
+// the private modes of focus reporting and bracketed paste, when the features
+// are on.
+func (ed *Editor) privateModes(hl string) string {
+	s := ""
+	if ed.writer.caps.focusReporting {
+		s += "\033[?1004" + hl
+	}
+	if ed.writer.caps.bracketedPaste {
+		s += "\033[?2004" + hl
+	}
+	return s
+}
+
+// eolMarker returns what to write before the prompt so that it starts at
+// column 0, on the line after the output of the last command, given the
+// column of the cursor and the width of the terminal. If the cursor is not at
+// column 0, the output does not end with a newline, and the marker is written
+// before a newline.
+//
+// When the column is unknown (-1), the marker is padded with spaces to the
+// full width, like PROMPT_SP in zsh. If the cursor was at column 0, the line
+// is filled exactly, and the marker is erased after a carriage return;
+// otherwise autowrap moves the padding to the next line, and only the padding
+// is erased.
+func (o *options) eolMarker(col, width int) string {
+	marker := o.eolMarkerText
+	if !o.noStyle && o.attrForEOLMarker != "" {
+		marker = "\033[" + o.attrForEOLMarker + "m" + marker + "\033[m"
+	}
+	switch {
+	case col == 0:
+		return ""
+	case col > 0:
+		return marker + "\n"
+	}
+	padding := width - o.wcWidths(o.eolMarkerText)
+	if padding < 0 {
+		// Too narrow to pad; just rewind to column 0
+		return "\r"
+	}
+	return marker + strings.Repeat(" ", padding) + "\r\033[K"
+}
+
+// cursorPos queries the terminal for the position of the cursor, with line
+// and column numbers starting from 0. It waits up to CPRTimeout for the
+// report. Keys read in the meantime are kept in typeahead, to be handled by
+// ReadLine; other reads are discarded.
+func (ed *Editor) cursorPos() (pos, error) {
+	if err := ed.writer.queryCursor(); err != nil {
+		return InvalidPos, err
+	}
+	ones := ed.reader.Chan()
+	timeout := time.After(CPRTimeout)
 	for {
 		select {
 		case or := <-ones:
 			if or.CPR != InvalidPos {
-				cpr = or.CPR
-				break FindCPR
-			} else {
-				// Just discard
+				return pos{or.CPR.line - 1, or.CPR.col - 1}, nil
 			}
-		case <-time.After(CPRTimeout):
-			break FindCPR
+			if or.Err == nil && or.Reply == nil {
+				ed.typeahead = append(ed.typeahead, or)
+			}
+		case <-timeout:
+			return InvalidPos, ErrTimeout
 		}
 	}
-
-	if cpr == InvalidPos {
-		// Unable to get CPR, just rewind to column 1
-		ed.file.WriteString("\r")
-	} else if cpr.col != 1 {
-		// BUG(xiaq) startReadline assumes that column number starts from 0
-		ed.file.WriteString(LackEOL)
-	}
-
-	return nil
 }
 
 // finishReadLine puts the terminal in a state suitable for other programs to
 // use.
 func (ed *Editor) finishReadLine(lr *LineRead) {
-	if lr.EOF == false && lr.Err == nil && lr.Line != "" {
-		ed.appendHistory(lr.Line)
-	}
-
 	ed.reader.Stop()
 
 	ed.mode = modeInsert
 	ed.tips = nil
 	ed.completion = nil
 	ed.navigation = nil
+	ed.snippet = nil
+	ed.paste = nil
+	ed.viKeys = ""
+	ed.selection = nil
+	ed.literal = nil
+	ed.hints = nil
+	ed.clearPreedit()
+	ed.clearMessages()
+	ed.stopInstant()
+	ed.minibuffer = nil
+	ed.palette = nil
+	ed.historyListing = nil
+	ed.filePreview = nil
+	ed.lineError = nil
+	ed.suggestion = ""
 	ed.dot = len(ed.line)
 	// TODO Perhaps make it optional to NOT clear the rprompt
-	ed.rprompt = ""
+	ed.rprompt = nil
 	ed.refresh() // XXX(xiaq): Ignore possible error
-	ed.file.WriteString("\n")
+	ed.writer.file.WriteString("\n")
 
+	if !ed.writer.caps.dumb {
+		// Set autowrap on, and focus reporting and bracketed paste off
+		ed.writer.file.WriteString("\033[?7h" + ed.privateModes("l"))
+	}
 	err := CleanupTerminal(ed.file, ed.savedTermios)
 
 	if err != nil {
+		log.Error("cannot restore terminal", "err", err)
 		// BUG(xiaq): Error in Editor.finishReadLine may override earlier error
 		*lr = LineRead{Err: fmt.Errorf("can't restore terminal attribute: %s", err)}
 	}
 	ed.savedTermios = nil
+
+	log.Debug("finished reading line", "line", lr.Line, "eof", lr.EOF, "err", lr.Err)
+	// Hooks may run commands, so they are run with the terminal restored
+	if lr.EOF == false && lr.Err == nil {
+		lr.Line = ed.runAcceptHooks(lr.Line)
+		if lr.Line != "" {
+			ed.appendHistory(lr.Line)
+		}
+	}
 }
 
-// ReadLine reads a line interactively.
-// TODO(xiaq): ReadLine currently handles SIGINT and SIGWINCH and swallows all
+// readLine implements ReadLine.
+// TODO(xiaq): readLine currently handles SIGINT and SIGWINCH and swallows all
 // other signals.
-func (ed *Editor) ReadLine(prompt, rprompt func() string) (lr LineRead) {
+func (ed *Editor) readLine(ctx context.Context) (lr LineRead) {
 	ed.editorState = editorState{}
+	ed.generation++
 	ed.writer.oldBuf.cells = nil
+	ed.interrupted = false
+	ed.reloadHistory()
+	ed.runBeforeReadline()
+	ed.runPosted()
 	ones := ed.reader.Chan()
 
+	if ctx.Err() != nil {
+		return LineRead{Err: ErrCanceled}
+	}
 	err := ed.startReadLine()
 	if err != nil {
+		log.Error("cannot start reading line", "err", err)
 		return LineRead{Err: err}
 	}
+	if log.Enabled(logger.Debug) {
+		log.Debug("started reading line", "caps", fmt.Sprintf("%+v", ed.writer.caps))
+	}
 	defer ed.finishReadLine(&lr)
+	if _, _, ok := tty.Size(int(ed.writer.file.Fd())); !ok {
+		defer ed.pollSize()()
+	}
 
-MainLoop:
-	for {
-		ed.prompt = prompt()
-		ed.rprompt = rprompt()
-		err := ed.refresh()
-		if err != nil {
-			return LineRead{Err: err}
+	// A read received while coalescing refreshes, handled before reading
+	// more.
+	var pending *OneRead
+	var sched refreshScheduler
+	// When the first of the reads not drawn yet was handled.
+	var readTime time.Time
+
+	for _, or := range ed.typeahead {
+		if ret := ed.handleRead(or); ret != nil {
+			ed.typeahead = nil
+			return *ret
 		}
+	}
+	ed.typeahead = nil
 
-		ed.tips = nil
-
-		select {
-		case sig := <-ed.sigs:
-			// TODO(xiaq): Maybe support customizable handling of signals
-			switch sig {
-			case syscall.SIGINT:
-				// Start over
-				ed.editorState = editorState{savedTermios: ed.savedTermios}
-				goto MainLoop
-			case syscall.SIGWINCH:
-				continue MainLoop
+	for {
+		if ed.interrupted {
+			return LineRead{Err: ErrInterrupted}
+		}
+		if pending == nil {
+			// Prompts may be expensive to compute; don't update them while
+			// nobody is looking
+			if !ed.unfocused || ed.prompt == nil {
+				stop := ed.metrics.start("prompt")
+				ed.prompt = ed.promptFn()
+				ed.rprompt = ed.rpromptFn()
+				stop()
 			}
-		case or := <-ones:
-			// Alert about error
-			err := or.Err
+			ed.checkLine()
+			ed.updateSuggestion()
+			ed.showCalc()
+			ed.scheduleInstant()
+			ed.updateFilePreview()
+			stop := ed.metrics.start("refresh")
+			err := ed.refresh()
 			if err != nil {
-				ed.pushTip(err.Error())
-				continue
+				return LineRead{Err: err}
 			}
-
-			// Ignore bogus CPR
-			if or.CPR != InvalidPos {
-				continue
+			stop()
+			if !readTime.IsZero() {
+				ed.metrics.add("key-to-render", time.Since(readTime))
+				readTime = time.Time{}
 			}
 
-			k := or.Key
-		lookupKey:
-			keyBinding, ok := keyBindings[ed.mode]
-			if !ok {
-				ed.pushTip("No binding for current mode")
-				continue
-			}
+			ed.tips = nil
+			sched.batchSize = 0
+		}
 
-			name, bound := keyBinding[k]
-			if !bound {
-				name = keyBinding[DefaultBinding]
-			}
-			ret := leBuiltins[name](ed, k)
-			if ret == nil {
+		var or OneRead
+		if pending != nil {
+			or, pending = *pending, nil
+		} else {
+			select {
+			case sig := <-ed.sigs:
+				ed.handleSignal(sig)
 				continue
-			}
-			switch ret.action {
-			case noAction:
+			case <-ed.redraws:
+				ed.runPosted()
+				continue
+			case <-ed.messagesTimeout:
+				ed.clearMessages()
+				continue
+			case <-ed.instantTimer:
+				ed.startInstant()
 				continue
-			case reprocessKey:
-				goto lookupKey
-			case exitReadLine:
-				return ret.readLineReturn
+			case <-ctx.Done():
+				return LineRead{Err: ErrCanceled}
+			case or = <-ones:
 			}
 		}
+
+		if readTime.IsZero() && (or.Key != ZeroKey || or.Paste != nil) {
+			readTime = time.Now()
+		}
+		if ret := ed.handleRead(or); ret != nil {
+			return *ret
+		}
+		pending = ed.coalesce(ones, &sched)
+	}
+}
+
+// handleSignal handles a signal received during ReadLine.
+func (ed *Editor) handleSignal(sig os.Signal) {
+	// TODO(xiaq): Maybe support customizable handling of signals
+	switch sig {
+	case syscall.SIGINT:
+		ed.interrupted = true
+	case syscall.SIGWINCH:
+		ed.queryBackground()
+	}
+}
+
+// handleRead handles one read from the terminal. It returns a non-nil
+// *LineRead when ReadLine should return.
+func (ed *Editor) handleRead(or OneRead) *LineRead {
+	// Alert about error
+	err := or.Err
+	if err == io.EOF {
+		// The terminal is gone
+		return &LineRead{EOF: true}
+	} else if err != nil {
+		ed.pushTip(err.Error())
+		return nil
+	}
+
+	if or.Focus != NoFocusEvent {
+		ed.unfocused = or.Focus == FocusOut
+		if or.Focus == FocusIn {
+			ed.queryBackground()
+		}
+		return nil
+	}
+
+	// Replies to queries of the background may change the theme; ignore
+	// bogus CPR and other late replies to queries
+	if or.Reply != nil {
+		ed.updateBackground(or.Reply)
+		return nil
+	} else if or.CPR != InvalidPos {
+		return nil
+	}
+	// Focus changes while ReadLine is not running are not reported, but keys
+	// are only typed into focused windows
+	ed.unfocused = false
+	ed.hints = nil
+	ed.clearMessages()
+
+	line, dot, mode := ed.line, ed.dot, ed.mode
+	defer func() {
+		if ed.line != line || ed.dot != dot || ed.mode != mode {
+			ed.generation++
+		}
+		ed.adjustFields()
+	}()
+
+	ed.recordInput(or)
+	if or.Paste != nil {
+		ed.handlePaste(or.Paste.Text)
+		return nil
+	}
+
+	k := or.Key
+lookupKey:
+	keyBinding, ok := ed.keyBindings[ed.mode]
+	if !ok {
+		ed.pushTip(ed.tr("No binding for current mode"))
+		return nil
+	}
+
+	name, bound := keyBinding[k]
+	if !bound {
+		name = keyBinding[DefaultBinding]
+	}
+	if ed.mode == modeInsert && !isSelectionBuiltin(name) {
+		ed.selection = nil
+	}
+	if ed.mode == modeInsert && ed.clearFreshField(name, k) {
+		return nil
+	}
+	ret := leBuiltins[name](ed, k)
+	if ret == nil {
+		return nil
+	}
+	switch ret.action {
+	case reprocessKey:
+		goto lookupKey
+	case exitReadLine:
+		return &ret.readLineReturn
+	}
+	return nil
+}
+
+// refreshScheduler keeps the state of coalesce.
+type refreshScheduler struct {
+	lastRead time.Time
+	// When the first read after the last refresh was handled, and how many
+	// reads have been handled since.
+	batchStart time.Time
+	batchSize  int
+}
+
+// coalesce decides whether the refresh after a read can be merged with that
+// of the next read, so that key repeats and pastes do not cause one refresh
+// per key. A read that is already available is always taken; if the last read
+// came in less than RefreshDebounce ago, coalesce also waits up to
+// RefreshDebounce for the next one. Isolated keystrokes are never delayed, and
+// a long burst still gets a refresh every MaxCoalescedReads reads or
+// MaxRefreshDelay. A pending signal is handled right away and also forces a
+// refresh. It returns the next read, or nil if the editor should refresh now.
+func (ed *Editor) coalesce(ones <-chan OneRead, s *refreshScheduler) *OneRead {
+	now := time.Now()
+	burst := now.Sub(s.lastRead) < RefreshDebounce
+	s.lastRead = now
+	if s.batchSize == 0 {
+		s.batchStart = now
+	}
+	s.batchSize++
+	if s.batchSize >= MaxCoalescedReads || now.Sub(s.batchStart) >= MaxRefreshDelay {
+		return nil
+	}
+
+	if !burst {
+		// Only take what is already available
+		select {
+		case sig := <-ed.sigs:
+			ed.handleSignal(sig)
+			return nil
+		case or := <-ones:
+			return &or
+		default:
+			return nil
+		}
+	}
+	select {
+	case sig := <-ed.sigs:
+		ed.handleSignal(sig)
+		return nil
+	case or := <-ones:
+		return &or
+	case <-time.After(RefreshDebounce):
+		return nil
 	}
 }
@@ -0,0 +1,318 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/edit/tty"
+	"github.com/xiaq/elvish/eval"
+)
+
+// openPty opens a pseudo terminal of the given size, returning the master and
+// the slave.
+func openPty(rows, cols uint16) (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	var unlock int32
+	var n uint32
+	if err := tty.Ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := tty.Ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	// Open the slave with syscall.Open instead of os.OpenFile, so that Go
+	// doesn't regard it as pollable and it behaves like a real terminal on
+	// stdin.
+	sfd, err := syscall.Open("/dev/pts/"+strconv.Itoa(int(n)), syscall.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave := os.NewFile(uintptr(sfd), "/dev/pts/"+strconv.Itoa(int(n)))
+	ws := tty.Winsize{Row: rows, Col: cols}
+	if err := tty.Ioctl(int(slave.Fd()), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+const benchPrompt = "bench> "
+
+// BenchmarkReadLineThroughput measures how fast keys typed in a burst, like a
+// paste or a key repeat, are processed, with one key per iteration.
+func BenchmarkReadLineThroughput(b *testing.B) {
+	master, slave, err := openPty(24, 80)
+	if err != nil {
+		b.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	ed := NewEditor(slave, eval.NewEvaluator(), make(chan os.Signal))
+	prompt := func() styled.Text { return styled.Plain(benchPrompt) }
+	ed.SetPrompts(prompt, prompt)
+
+	// Only start typing after the prompt is first drawn; the editor flushes
+	// pending input when setting up the terminal before that.
+	started := make(chan struct{})
+	go func() {
+		var seen []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			seen = append(seen, buf[:n]...)
+			if bytes.Contains(seen, []byte(benchPrompt)) {
+				break
+			}
+		}
+		close(started)
+		io.Copy(ioutil.Discard, master)
+	}()
+	go func() {
+		<-started
+		keys := make([]byte, b.N+1)
+		for i := 0; i < b.N; i++ {
+			// Alternate between a letter and a backspace, so that the line
+			// stays short.
+			if i%2 == 0 {
+				keys[i] = 'a'
+			} else {
+				keys[i] = Backspace
+			}
+		}
+		keys[b.N] = Enter
+		master.Write(keys)
+	}()
+
+	b.ResetTimer()
+	start := time.Now()
+	_, err = ed.ReadLine(context.Background())
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "keys/s")
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+var readLineTests = []struct {
+	input string
+	line  string
+	err   error
+}{
+	{"echo hi\n", "echo hi", nil},
+	{"\x04", "", io.EOF},
+	{"", "", ErrCanceled},
+}
+
+func TestReadLine(t *testing.T) {
+	master, slave, err := openPty(24, 80)
+	if err != nil {
+		t.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	go io.Copy(ioutil.Discard, master)
+
+	ed, err := New(slave, slave, eval.NewEvaluator(), Config{Signals: make(chan os.Signal)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range readLineTests {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// Input sent before the terminal is set up is flushed
+			time.Sleep(100 * time.Millisecond)
+			if tt.input == "" {
+				cancel()
+			} else {
+				master.Write([]byte(tt.input))
+			}
+		}()
+		line, err := ed.ReadLine(ctx)
+		cancel()
+		if line != tt.line || err != tt.err {
+			t.Errorf("ReadLine with input %q => (%q, %v), want (%q, %v)",
+				tt.input, line, err, tt.line, tt.err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ed.ReadLine(ctx); err != ErrCanceled {
+		t.Errorf("ReadLine with canceled context => %v, want %v", err, ErrCanceled)
+	}
+}
+
+func TestOpenTerminal(t *testing.T) {
+	master, slave, err := openPty(24, 80)
+	if err != nil {
+		t.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Terminals are used as they are
+	in, out, f, err := OpenTerminal(slave, slave, TTYAuto)
+	if in != slave || out != slave || f != nil || err != nil {
+		t.Errorf("OpenTerminal(pty, pty, auto) => (%v, %v, %v, %v), want the pty", in, out, f, err)
+	}
+	// So are other files, if /dev/tty is never used
+	in, out, f, err = OpenTerminal(r, w, TTYNever)
+	if in != r || out != w || f != nil || err != nil {
+		t.Errorf("OpenTerminal(pipe, pipe, never) => (%v, %v, %v, %v), want the pipe", in, out, f, err)
+	}
+}
+
+// TestConcurrentNotify types a line while other goroutines show messages and
+// preedit strings. It is most useful with -race.
+func TestConcurrentNotify(t *testing.T) {
+	master, slave, err := openPty(24, 80)
+	if err != nil {
+		t.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	go io.Copy(ioutil.Discard, master)
+
+	ed, err := New(slave, slave, eval.NewEvaluator(), Config{Signals: make(chan os.Signal)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			for j := 0; j < n; j++ {
+				switch i {
+				case 0:
+					ed.Notify(strconv.Itoa(j))
+				case 1:
+					ed.SetPreedit("你好", j%7)
+				case 2:
+					ed.Redraw()
+				}
+				time.Sleep(time.Millisecond)
+			}
+			done <- struct{}{}
+		}(i)
+	}
+	go func() {
+		// Input sent before the terminal is set up is flushed
+		time.Sleep(100 * time.Millisecond)
+		for _, r := range "echo hi" {
+			master.Write([]byte(string(r)))
+			time.Sleep(5 * time.Millisecond)
+		}
+		for i := 0; i < 3; i++ {
+			<-done
+		}
+		master.Write([]byte("\n"))
+	}()
+	line, err := ed.ReadLine(context.Background())
+	if line != "echo hi" || err != nil {
+		t.Errorf("ReadLine with concurrent notifications => (%q, %v), want (%q, nil)", line, err, "echo hi")
+	}
+
+	// Messages posted after the line was read are left for the next ReadLine
+	ed.runPosted()
+	if len(ed.messageLog) != n {
+		t.Errorf("%d messages shown, want %d", len(ed.messageLog), n)
+	}
+}
+
+func TestSetupTerminalFlowControl(t *testing.T) {
+	master, slave, err := openPty(24, 80)
+	if err != nil {
+		t.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	ixon := func() bool {
+		term, err := tty.NewTermiosFromFd(int(slave.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return term.Iflag&syscall.IXON != 0
+	}
+	if !ixon() {
+		t.Skip("flow control is off on the pty")
+	}
+	for _, on := range []bool{false, true} {
+		saved, err := SetupTerminal(slave, on)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ixon(); got != on {
+			t.Errorf("flow control with le:flow-control %s => %v, want %v", onOff(on), got, on)
+		}
+		CleanupTerminal(slave, saved)
+		if !ixon() {
+			t.Errorf("flow control not restored after le:flow-control %s", onOff(on))
+		}
+	}
+}
+
+func TestReadLineUnknownSize(t *testing.T) {
+	master, slave, err := openPty(0, 0)
+	if err != nil {
+		t.Skip("cannot open pty:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	go io.Copy(ioutil.Discard, master)
+
+	ed, err := New(slave, slave, eval.NewEvaluator(), Config{Signals: make(chan os.Signal)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec bytes.Buffer
+	ed.Record(&rec)
+	go func() {
+		// Input sent before the terminal is set up is flushed
+		time.Sleep(100 * time.Millisecond)
+		master.Write([]byte("echo hi"))
+		time.Sleep(100 * time.Millisecond)
+		// The size is set after the line is drawn, without SIGWINCH
+		ws := tty.Winsize{Row: 30, Col: 100}
+		tty.Ioctl(int(slave.Fd()), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+		time.Sleep(SizePollInterval + 100*time.Millisecond)
+		master.Write([]byte("\n"))
+	}()
+	line, err := ed.ReadLine(context.Background())
+	if line != "echo hi" || err != nil {
+		t.Errorf("ReadLine on a pty of size 0x0 => (%q, %v), want (%q, nil)", line, err, "echo hi")
+	}
+	// The new size is drawn before Enter is read
+	resize := bytes.Index(rec.Bytes(), []byte(`"r","100x30"`))
+	enter := bytes.Index(rec.Bytes(), []byte(`"i","Enter"`))
+	if resize == -1 || enter == -1 || resize > enter {
+		t.Errorf("size of the pty not polled, recorded %s", rec.String())
+	}
+}
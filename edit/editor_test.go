@@ -0,0 +1,147 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+func TestCursorPos(t *testing.T) {
+	f, err := ioutil.TempFile("", "elvish-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ones := make(chan OneRead, 4)
+	o := newOptions()
+	ed := &Editor{writer: newWriter(f, o), reader: &Reader{ones: ones}, options: o}
+	ones <- keyRead(Key{'a', 0})
+	ones <- OneRead{Reply: &termReply{replyDA1, nil}, CPR: InvalidPos}
+	ones <- OneRead{CPR: pos{3, 5}}
+	if p, err := ed.cursorPos(); p != (pos{2, 4}) || err != nil {
+		t.Errorf("cursorPos() => (%v, %v), want ({2 4}, nil)", p, err)
+	}
+	if len(ed.typeahead) != 1 || ed.typeahead[0].Key != (Key{'a', 0}) {
+		t.Errorf("cursorPos() left typeahead %v, want [a]", ed.typeahead)
+	}
+	if out, _ := ioutil.ReadFile(f.Name()); string(out) != "\033[6n" {
+		t.Errorf("cursorPos() wrote %q, want %q", out, "\033[6n")
+	}
+
+	if _, err := ed.cursorPos(); err != ErrTimeout {
+		t.Errorf("cursorPos() without reply => error %v, want ErrTimeout", err)
+	}
+}
+
+var eolMarkerTests = []struct {
+	col, width int
+	want       string
+}{
+	{0, 10, ""},
+	{3, 10, "\033[7m⏎\033[m\n"},
+	{-1, 10, "\033[7m⏎\033[m         \r\033[K"},
+	{-1, 1, "\033[7m⏎\033[m\r\033[K"},
+	{-1, 0, "\r"},
+}
+
+func TestEOLMarker(t *testing.T) {
+	o := newOptions()
+	o.noStyle = false
+	for _, tt := range eolMarkerTests {
+		if out := o.eolMarker(tt.col, tt.width); out != tt.want {
+			t.Errorf("eolMarker(%v, %v) => %q, want %q", tt.col, tt.width, out, tt.want)
+		}
+	}
+}
+
+func TestFocus(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.handleRead(OneRead{CPR: InvalidPos, Focus: FocusOut})
+	if ed.Focused() {
+		t.Errorf("Focused() after FocusOut => true, want false")
+	}
+	ed.handleRead(keyRead(Key{'a', 0}))
+	if !ed.Focused() {
+		t.Errorf("Focused() after a key => false, want true")
+	}
+	ed.handleRead(OneRead{CPR: InvalidPos, Focus: FocusOut})
+	ed.handleRead(OneRead{CPR: InvalidPos, Focus: FocusIn})
+	if !ed.Focused() {
+		t.Errorf("Focused() after FocusIn => false, want true")
+	}
+}
+
+func TestStaleCompletion(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.line, ed.dot = "ls f", 4
+	c := &completion{start: 3, end: 4}
+	ed.handleRead(keyRead(Key{'o', 0}))
+	ed.applyCompletion(&completionResult{0, c, "f", nil, []string{"foo"}, nil, false, nil, ""})
+	if ed.mode != modeInsert {
+		t.Errorf("stale completion result applied")
+	}
+	ed.applyCompletion(&completionResult{ed.generation, c, "fo", nil, []string{"foo"}, nil, false, nil, ""})
+	if ed.mode != modeCompletion || len(ed.completion.candidates) != 1 {
+		t.Errorf("current completion result not applied")
+	}
+}
+
+func TestPrefixHistory(t *testing.T) {
+	ed := &Editor{options: newOptions(), histories: []HistoryEntry{
+		{Line: "make test"}, {Line: "ls"}, {Line: "make install"}}}
+	ed.line, ed.dot = "make", 4
+	moveDotUpOrStartHistory(ed, Key{Up, 0})
+	first := ed.histories[ed.history.current].Line
+	selectHistoryPrev(ed, Key{Up, 0})
+	second := ed.histories[ed.history.current].Line
+	if ed.mode != modeHistory || first != "make install" || second != "make test" {
+		t.Errorf("Up recalls %q, %q, want \"make install\", \"make test\"", first, second)
+	}
+	// Past the newest entry, the typed line comes back
+	selectHistoryNext(ed, Key{Down, 0})
+	selectHistoryNext(ed, Key{Down, 0})
+	if ed.mode != modeInsert || ed.line != "make" {
+		t.Errorf("Down past the newest entry => mode %v, line %q, want insert mode with \"make\"",
+			ed.mode, ed.line)
+	}
+}
+
+func TestEditorBuiltins(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	var eds [2]*Editor
+	for i := range eds {
+		ev := eval.NewEvaluator()
+		ev.SetPorts(devNull, devNull, devNull)
+		ev.SetStatusCallback(nil)
+		eds[i] = &Editor{options: newOptions(), ev: ev}
+		ev.SetEditor(eds[i])
+	}
+	// The forms of pipelines are evaluated with copies of the evaluator,
+	// which share its editor
+	eds[0].ev.EvalSource("<test>", "le:history-scope project | le:snippet s \"echo {x}\"")
+	if st := eds[0].ev.Status(); st != eval.ExitOK {
+		t.Fatalf("le:snippet and le:history-scope => status %v, want %v", st, eval.ExitOK)
+	}
+	if eds[0].snippets["s"] != "echo {x}" || eds[0].historyScope != "project" {
+		t.Errorf("le: builtins didn't change the options of their editor")
+	}
+	if len(eds[1].snippets) != 0 || eds[1].historyScope != "global" {
+		t.Errorf("le: builtins changed the options of another editor")
+	}
+
+	ev := eval.NewEvaluator()
+	ev.SetPorts(devNull, devNull, devNull)
+	ev.SetStatusCallback(nil)
+	ev.EvalSource("<test>", "le:snippet s \"echo {x}\"")
+	if st := ev.Status(); st == eval.ExitOK {
+		t.Errorf("le:snippet without an editor => status %v, want failure", st)
+	}
+}
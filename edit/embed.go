@@ -0,0 +1,149 @@
+package edit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
+)
+
+// Go programs can embed the line editor to read lines from a terminal:
+//
+//	ed, err := edit.New(os.Stdin, os.Stdout, eval.NewEvaluator(), edit.Config{
+//		Prompt: func() styled.Text { return styled.Plain("> ") },
+//	})
+//	for {
+//		line, err := ed.ReadLine(context.Background())
+//		if err == io.EOF {
+//			break
+//		} else if err == edit.ErrInterrupted {
+//			continue
+//		}
+//		...
+//	}
+//	ed.Close()
+//
+// The state of the editor, like the history and the registers, is kept in the
+// Editor, and so are its options, like key bindings and the theme, so that the
+// editors of a program don't affect each other. Go programs set them with the
+// methods of Editor, and elvish code with le: builtins, which act on the
+// editor using the evaluator they are called from.
+
+// ErrInterrupted is returned by ReadLine when it is interrupted by SIGINT,
+// which Ctrl-C sends.
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrCanceled is returned by ReadLine when its context is done, by being
+// canceled or reaching its deadline. The terminal is restored before
+// ReadLine returns, so a program can cancel a prompt from another goroutine,
+// or from its own signal handler, and go on writing to the terminal.
+var ErrCanceled = errors.New("canceled")
+
+// Config configures an Editor created with New. The zero value is usable.
+type Config struct {
+	// The prompt and the right prompt, computed before each redraw. Nil ones
+	// are empty.
+	Prompt, RPrompt func() styled.Text
+	// Signals received by the program. SIGINT interrupts ReadLine, and
+	// SIGWINCH makes it redraw. If nil, ReadLine is notified of them itself
+	// while it runs.
+	Signals <-chan os.Signal
+	// Whether long lines are scrolled horizontally; see SetHorizontalScroll.
+	HorizontalScroll bool
+	// How long to wait for a key after Escape before reading it alone, or 0
+	// for EscTimeout; see (*Reader).SetEscape.
+	EscTimeout time.Duration
+	// Whether Escape is read immediately instead of as a prefix of Alt- keys.
+	EscImmediate bool
+	// Whether to use the terminfo entry of $TERM; see UseTerminfo.
+	Terminfo bool
+	// The history file, if any; see LoadHistory.
+	HistoryFile string
+	// Whether to use /dev/tty instead of in and out; see OpenTerminal. It is
+	// closed by Close.
+	TTY TTYMode
+	// Names of files or directories marking the roots of projects, for
+	// history recall scoped to projects; see le:history-scope. If nil, they
+	// are .git and .elvish-project.
+	ProjectMarkers []string
+}
+
+// New creates an Editor reading keys from in and drawing to out, which are
+// usually the same terminal. The le: builtins called from ev, and from
+// evaluators copied from it, act on the Editor.
+func New(in, out *os.File, ev *eval.Evaluator, cfg Config) (*Editor, error) {
+	in, out, ttyFile, err := OpenTerminal(in, out, cfg.TTY)
+	if err != nil {
+		return nil, err
+	}
+	ed := newEditor(in, out, ev, cfg.Signals)
+	ed.ttyFile = ttyFile
+	ed.SetPrompts(cfg.Prompt, cfg.RPrompt)
+	if cfg.ProjectMarkers != nil {
+		ed.projectMarkers = cfg.ProjectMarkers
+	}
+	ed.SetHorizontalScroll(cfg.HorizontalScroll)
+	escTimeout := cfg.EscTimeout
+	if escTimeout == 0 {
+		escTimeout = EscTimeout
+	}
+	ed.SetEscape(escTimeout, !cfg.EscImmediate)
+	if cfg.Terminfo {
+		if err := ed.UseTerminfo(); err != nil {
+			ed.Close()
+			return nil, err
+		}
+	}
+	if cfg.HistoryFile != "" {
+		if err := ed.LoadHistory(cfg.HistoryFile); err != nil {
+			ed.Close()
+			return nil, err
+		}
+	}
+	return ed, nil
+}
+
+// SetPrompts sets the prompt and the right prompt. Nil ones are empty.
+func (ed *Editor) SetPrompts(prompt, rprompt func() styled.Text) {
+	empty := func() styled.Text { return styled.Text{} }
+	if prompt == nil {
+		prompt = empty
+	}
+	if rprompt == nil {
+		rprompt = empty
+	}
+	ed.promptFn, ed.rpromptFn = prompt, rprompt
+}
+
+// ReadLine reads a line interactively. It returns io.EOF when the terminal is
+// gone or Ctrl-D is pressed on an empty line, ErrInterrupted on SIGINT, and
+// ErrCanceled when ctx is done, also if it is done before ReadLine is
+// called.
+func (ed *Editor) ReadLine(ctx context.Context) (string, error) {
+	if ed.promptFn == nil {
+		ed.SetPrompts(nil, nil)
+	}
+	if ed.sigs == nil {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGWINCH)
+		ed.sigs = sigs
+		defer func() {
+			signal.Stop(sigs)
+			ed.sigs = nil
+		}()
+	}
+	lr := ed.readLine(ctx)
+	switch {
+	case lr.EOF:
+		return "", io.EOF
+	case lr.Err != nil:
+		return "", lr.Err
+	}
+	return lr.Line, nil
+}
@@ -0,0 +1,128 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaq/elvish/edit/terminfo"
+)
+
+// escapes generates the escape sequences for cursor motions, erases and
+// hyperlinks used by the writer. Columns are counted from 0. SGR attributes
+// are always written as is.
+type escapes interface {
+	up(n int) string
+	down(n int) string
+	right(n int) string
+	column(col int) string
+	eraseLine() string
+	eraseDown() string
+	eraseChars(n int) string
+	resetAttr() string
+	hideCursor() string
+	showCursor() string
+	// hyperlink starts a hyperlink to link, or ends the current one if link
+	// is empty.
+	hyperlink(link string) string
+}
+
+// xtermEscapes hardcodes the sequences understood by xterm and virtually all
+// other terminal emulators. It is the default.
+type xtermEscapes struct{}
+
+func (xtermEscapes) up(n int) string         { return fmt.Sprintf("\033[%dA", n) }
+func (xtermEscapes) down(n int) string       { return fmt.Sprintf("\033[%dB", n) }
+func (xtermEscapes) right(n int) string      { return fmt.Sprintf("\033[%dC", n) }
+func (xtermEscapes) column(col int) string   { return fmt.Sprintf("\033[%dG", col+1) }
+func (xtermEscapes) eraseLine() string       { return "\033[K" }
+func (xtermEscapes) eraseDown() string       { return "\033[J" }
+func (xtermEscapes) eraseChars(n int) string { return fmt.Sprintf("\033[%dX", n) }
+func (xtermEscapes) resetAttr() string       { return "\033[m" }
+func (xtermEscapes) hideCursor() string      { return "\033[?25l" }
+func (xtermEscapes) showCursor() string      { return "\033[?25h" }
+func (xtermEscapes) hyperlink(link string) string {
+	return "\033]8;;" + link + "\033\\"
+}
+
+// terminfoEscapes generates sequences from a terminfo entry. When a
+// parameterized capability is absent, its non-parameterized counterpart is
+// repeated; when both are absent, the xterm sequence is used. Hiding and
+// showing the cursor are merely cosmetic, so they are skipped when the entry
+// lacks them.
+type terminfoEscapes struct {
+	ti *terminfo.Terminfo
+}
+
+func (te terminfoEscapes) parm(parm, single, n int, fallback string) string {
+	if s := te.ti.String(parm); s != "" {
+		return terminfo.Expand(s, n)
+	}
+	if s := te.ti.String(single); s != "" {
+		return strings.Repeat(s, n)
+	}
+	return fallback
+}
+
+func (te terminfoEscapes) str(i int, fallback string) string {
+	if s := te.ti.String(i); s != "" {
+		return s
+	}
+	return fallback
+}
+
+func (te terminfoEscapes) up(n int) string {
+	return te.parm(terminfo.ParmUpCursor, terminfo.CursorUp, n, xtermEscapes{}.up(n))
+}
+
+func (te terminfoEscapes) down(n int) string {
+	return te.parm(terminfo.ParmDownCursor, terminfo.CursorDown, n, xtermEscapes{}.down(n))
+}
+
+func (te terminfoEscapes) right(n int) string {
+	return te.parm(terminfo.ParmRightCursor, terminfo.CursorRight, n, xtermEscapes{}.right(n))
+}
+
+func (te terminfoEscapes) column(col int) string {
+	if s := te.ti.String(terminfo.ColumnAddress); s != "" {
+		return terminfo.Expand(s, col)
+	}
+	cr := te.str(terminfo.CarriageReturn, "\r")
+	if col == 0 {
+		return cr
+	}
+	return cr + te.right(col)
+}
+
+func (te terminfoEscapes) eraseLine() string {
+	return te.str(terminfo.ClrEol, xtermEscapes{}.eraseLine())
+}
+
+func (te terminfoEscapes) eraseDown() string {
+	return te.str(terminfo.ClrEos, xtermEscapes{}.eraseDown())
+}
+
+func (te terminfoEscapes) eraseChars(n int) string {
+	if s := te.ti.String(terminfo.EraseChars); s != "" {
+		return terminfo.Expand(s, n)
+	}
+	// Overwrite with spaces and move back
+	left := te.str(terminfo.CursorLeft, "\b")
+	return strings.Repeat(" ", n) + strings.Repeat(left, n)
+}
+
+func (te terminfoEscapes) resetAttr() string {
+	return te.str(terminfo.ExitAttributeMode, xtermEscapes{}.resetAttr())
+}
+
+// hyperlink always uses OSC 8, which terminfo has no capability for.
+func (te terminfoEscapes) hyperlink(link string) string {
+	return xtermEscapes{}.hyperlink(link)
+}
+
+func (te terminfoEscapes) hideCursor() string {
+	return te.str(terminfo.CursorInvisible, "")
+}
+
+func (te terminfoEscapes) showCursor() string {
+	return te.str(terminfo.CursorNormal, "")
+}
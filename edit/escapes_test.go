@@ -0,0 +1,17 @@
+package edit
+
+import (
+	"testing"
+
+	"github.com/xiaq/elvish/edit/terminfo"
+)
+
+func TestTerminfoEscapesWithoutCursorVisibility(t *testing.T) {
+	te := terminfoEscapes{&terminfo.Terminfo{}}
+	if s := te.hideCursor(); s != "" {
+		t.Errorf("hideCursor() => %q, want \"\"", s)
+	}
+	if s := te.showCursor(); s != "" {
+		t.Errorf("showCursor() => %q, want \"\"", s)
+	}
+}
@@ -0,0 +1,140 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Whether a feature is used is detected from the environment and the replies
+// of the terminal, unless it is overridden, either in $ELVISH_FEATURES or with
+// le:feature. Overrides take effect from the next line read; they are the way
+// to work around terminals that misbehave with some feature.
+//
+// $ELVISH_FEATURES is a comma-separated list of name=on or name=off, read
+// when the editor starts, e.g. ELVISH_FEATURES=bracketed-paste=off.
+
+// features lists the features that can be overridden, with the fields of
+// capabilities holding them.
+var features = []struct {
+	name  string
+	field func(caps *capabilities) *bool
+}{
+	{"color", func(caps *capabilities) *bool { return &caps.color }},
+	{"true-color", func(caps *capabilities) *bool { return &caps.trueColor }},
+	{"sync-update", func(caps *capabilities) *bool { return &caps.syncUpdate }},
+	{"focus-reporting", func(caps *capabilities) *bool { return &caps.focusReporting }},
+	{"bracketed-paste", func(caps *capabilities) *bool { return &caps.bracketedPaste }},
+	{"cursor-query", func(caps *capabilities) *bool { return &caps.cursorQuery }},
+	{"async-completion", func(caps *capabilities) *bool { return &caps.asyncCompletion }},
+	{"hyperlinks", func(caps *capabilities) *bool { return &caps.hyperlinks }},
+	{"passthrough", func(caps *capabilities) *bool { return &caps.passthrough }},
+	{"sixel", func(caps *capabilities) *bool { return &caps.sixel }},
+	{"kitty-graphics", func(caps *capabilities) *bool { return &caps.kittyGraphics }},
+}
+
+func init() {
+	addEditorBuiltin("le:feature", builtinFeature)
+}
+
+func isFeature(name string) bool {
+	for _, f := range features {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFeatureOverrides parses the value of $ELVISH_FEATURES. Malformed items
+// and unknown features are ignored.
+func parseFeatureOverrides(s string) map[string]bool {
+	overrides := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		i := strings.IndexByte(item, '=')
+		if i == -1 || !isFeature(item[:i]) {
+			continue
+		}
+		switch item[i+1:] {
+		case "on":
+			overrides[item[:i]] = true
+		case "off":
+			overrides[item[:i]] = false
+		}
+	}
+	return overrides
+}
+
+// withOverrides returns caps with the features in overrides overridden. A
+// dumb terminal stays dumb, and nothing is turned on for it.
+func (caps capabilities) withOverrides(overrides map[string]bool) capabilities {
+	if caps.dumb {
+		return caps
+	}
+	for _, f := range features {
+		if on, ok := overrides[f.name]; ok {
+			*f.field(&caps) = on
+		}
+	}
+	return caps
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// builtinFeature implements the le:feature builtin. With no arguments, it
+// prints each feature with whether it is on and whether that is detected or
+// overridden, for diagnosing terminal problems. With one argument, it prints
+// on or off for the named feature, so that scripts can query it. With two, it
+// overrides the feature with on or off, or removes the override with auto.
+func builtinFeature(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	detected := ed.detectedCaps
+	if !ed.capsDetected {
+		detected = capabilitiesFromEnv()
+	}
+	caps := detected.withOverrides(ed.featureOverrides)
+	switch len(args) {
+	case 0:
+		out := ev.OutFile()
+		fmt.Fprintf(out, "dumb terminal: %s\n", onOff(caps.dumb))
+		for _, f := range features {
+			source := "detected"
+			if _, ok := ed.featureOverrides[f.name]; ok {
+				source = "overridden"
+			}
+			fmt.Fprintf(out, "%-18s%-4s%s\n", f.name, onOff(*f.field(&caps)), source)
+		}
+		return ""
+	case 1, 2:
+		name := args[0].String()
+		if !isFeature(name) {
+			return fmt.Sprintf("no feature named %s", name)
+		}
+		if len(args) == 1 {
+			for _, f := range features {
+				if f.name == name {
+					fmt.Fprintln(ev.OutFile(), onOff(*f.field(&caps)))
+				}
+			}
+			return ""
+		}
+		switch args[1].String() {
+		case "on":
+			ed.featureOverrides[name] = true
+		case "off":
+			ed.featureOverrides[name] = false
+		case "auto":
+			delete(ed.featureOverrides, name)
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
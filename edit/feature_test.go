@@ -0,0 +1,45 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var parseFeatureOverridesTests = []struct {
+	in     string
+	wanted map[string]bool
+}{
+	{"", map[string]bool{}},
+	{"bracketed-paste=off", map[string]bool{"bracketed-paste": false}},
+	{"color=off,true-color=on", map[string]bool{"color": false, "true-color": true}},
+	{"no-such=off,sync-update=maybe,focus-reporting", map[string]bool{}},
+}
+
+func TestParseFeatureOverrides(t *testing.T) {
+	for _, tt := range parseFeatureOverridesTests {
+		if out := parseFeatureOverrides(tt.in); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("parseFeatureOverrides(%q) => %v, want %v", tt.in, out, tt.wanted)
+		}
+	}
+}
+
+var withOverridesTests = []struct {
+	caps      capabilities
+	overrides map[string]bool
+	wanted    capabilities
+}{
+	{capabilities{color: true}, nil, capabilities{color: true}},
+	{capabilities{color: true, bracketedPaste: true},
+		map[string]bool{"bracketed-paste": false, "sync-update": true},
+		capabilities{color: true, syncUpdate: true}},
+	{capabilities{dumb: true}, map[string]bool{"color": true},
+		capabilities{dumb: true}},
+}
+
+func TestWithOverrides(t *testing.T) {
+	for _, tt := range withOverridesTests {
+		if out := tt.caps.withOverrides(tt.overrides); out != tt.wanted {
+			t.Errorf("%v.withOverrides(%v) => %v, want %v", tt.caps, tt.overrides, out, tt.wanted)
+		}
+	}
+}
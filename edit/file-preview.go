@@ -0,0 +1,380 @@
+package edit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// The first lines of the file under the selected completion candidate, or
+// selected in navigation mode, are shown in a preview beside the listing,
+// with comments, strings, keywords and numbers colored for files with known
+// extensions. Binary files are only marked as such. Files are read in the
+// background when they are selected, so that moving through a listing stays
+// responsive; the preview is blank until the file is read.
+//
+// The tokens are colored with the styles of syntax highlighting: comments and
+// strings like those in elvish code, keywords like brackets and numbers like
+// variables.
+
+const (
+	// FilePreviewLines is the maximum number of lines of a file shown.
+	FilePreviewLines = 50
+	// filePreviewBytes is the maximum number of bytes of a file read.
+	filePreviewBytes = 64 << 10
+	// filePreviewMinWidth is the minimum width of the terminal for previews
+	// to be shown beside the completion listing.
+	filePreviewMinWidth = 60
+	// filePreviewMinLines is the minimum number of lines of previews beside
+	// the completion listing, when there are enough lines.
+	filePreviewMinLines = 5
+)
+
+func init() {
+	addEditorBuiltin("le:file-preview", builtinFilePreview)
+}
+
+// tokenKind is the kind of a token in a previewed file.
+type tokenKind int
+
+// Possible values for tokenKind.
+const (
+	plainToken tokenKind = iota
+	commentToken
+	stringToken
+	keywordToken
+	numberToken
+)
+
+// tokenAttr returns the attribute tokens of kind are colored with.
+func (a *attrs) tokenAttr(kind tokenKind) string {
+	switch kind {
+	case commentToken:
+		return a.attrForType[parse.ItemSpace]
+	case stringToken:
+		return a.attrForType[parse.ItemDoubleQuoted]
+	case keywordToken:
+		return a.attrForType[parse.ItemLBrace]
+	case numberToken:
+		return a.attrForType[parse.ItemDollar]
+	default:
+		return ""
+	}
+}
+
+// previewToken is a piece of a line of a previewed file.
+type previewToken struct {
+	text string
+	kind tokenKind
+}
+
+// filePreview is the preview of a file. It is filled in when the file has
+// been read.
+type filePreview struct {
+	path    string
+	modTime time.Time
+	loaded  bool
+	binary  bool
+	err     error
+	lines   [][]previewToken
+}
+
+// syntax is how the tokens of files of a language are told apart.
+type syntax struct {
+	lineComments []string
+	// The delimiters of block comments, if any.
+	blockStart, blockEnd string
+	quotes               string
+	keywords             map[string]bool
+}
+
+func keywords(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cSyntax = &syntax{[]string{"//"}, "/*", "*/", "\"'", keywords(
+		"break case char const continue default do double else enum extern float for goto if int long return short signed sizeof static struct switch typedef union unsigned void while")}
+	goSyntax = &syntax{[]string{"//"}, "/*", "*/", "\"'`", keywords(
+		"break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var")}
+	jsSyntax = &syntax{[]string{"//"}, "/*", "*/", "\"'`", keywords(
+		"async await break case catch class const continue default delete do else export extends finally for function if import in instanceof let new return switch this throw try typeof var while yield")}
+	rustSyntax = &syntax{[]string{"//"}, "/*", "*/", "\"", keywords(
+		"as break const continue crate else enum fn for if impl in let loop match mod move mut pub ref return self struct trait type unsafe use where while")}
+	pythonSyntax = &syntax{[]string{"#"}, "", "", "\"'", keywords(
+		"and as assert async await break class continue def del elif else except finally for from global if import in is lambda not or pass raise return try while with yield")}
+	shSyntax = &syntax{[]string{"#"}, "", "", "\"'", keywords(
+		"case do done elif else esac fi for function if in then until while")}
+	elvishSyntax = &syntax{[]string{"#"}, "", "", "\"'`", keywords(
+		"del else except finally fn for if try use var while")}
+	configSyntax = &syntax{[]string{"#"}, "", "", "\"'", keywords("false true")}
+	jsonSyntax   = &syntax{nil, "", "", "\"", keywords("false null true")}
+)
+
+// syntaxes maps extensions of files to their syntax.
+var syntaxes = map[string]*syntax{
+	".c": cSyntax, ".h": cSyntax, ".cc": cSyntax, ".cpp": cSyntax,
+	".go": goSyntax, ".js": jsSyntax, ".ts": jsSyntax, ".rs": rustSyntax,
+	".py": pythonSyntax, ".sh": shSyntax, ".bash": shSyntax, ".zsh": shSyntax,
+	".elv": elvishSyntax, ".toml": configSyntax, ".yaml": configSyntax,
+	".yml": configSyntax, ".json": jsonSyntax,
+}
+
+// readFilePreview reads the first lines of the file at path, at most maxLines
+// of them, with tokens colored by the syntax for its extension if it is
+// known. A file is binary if it has a NUL byte or is not valid UTF-8.
+func readFilePreview(path string, maxLines int) (lines [][]previewToken, binary bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	data := make([]byte, filePreviewBytes)
+	n, err := io.ReadFull(f, data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	} else if err == nil {
+		// The last line may be cut, maybe in the middle of a rune
+		n = bytes.LastIndexByte(data[:n], '\n') + 1
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	data = data[:n]
+	if bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data) {
+		return nil, true, nil
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil, false, nil
+	}
+	rawLines := strings.SplitN(text, "\n", maxLines+1)
+	if len(rawLines) > maxLines {
+		rawLines = rawLines[:maxLines]
+	}
+	syn := syntaxes[strings.ToLower(filepath.Ext(path))]
+	inBlock := false
+	for _, line := range rawLines {
+		line = sanitizePreviewLine(line)
+		var tokens []previewToken
+		if syn == nil {
+			tokens = []previewToken{{line, plainToken}}
+		} else {
+			tokens, inBlock = syn.tokenize(line, inBlock)
+		}
+		lines = append(lines, tokens)
+	}
+	return lines, false, nil
+}
+
+// sanitizePreviewLine expands tabs to 4 spaces and drops other control
+// characters, so that they don't mess up the terminal.
+func sanitizePreviewLine(line string) string {
+	line = strings.Replace(line, "\t", "    ", -1)
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// tokenize splits line into tokens. inBlock is whether the line starts in a
+// block comment; whether the next line does is returned.
+func (syn *syntax) tokenize(line string, inBlock bool) ([]previewToken, bool) {
+	var tokens []previewToken
+	add := func(text string, kind tokenKind) {
+		if n := len(tokens); n > 0 && tokens[n-1].kind == kind {
+			tokens[n-1].text += text
+		} else {
+			tokens = append(tokens, previewToken{text, kind})
+		}
+	}
+	for i := 0; i < len(line); {
+		rest := line[i:]
+		if inBlock {
+			end := strings.Index(rest, syn.blockEnd)
+			if end == -1 {
+				add(rest, commentToken)
+				break
+			}
+			add(rest[:end+len(syn.blockEnd)], commentToken)
+			i += end + len(syn.blockEnd)
+			inBlock = false
+			continue
+		}
+		if syn.blockStart != "" && strings.HasPrefix(rest, syn.blockStart) {
+			add(syn.blockStart, commentToken)
+			i += len(syn.blockStart)
+			inBlock = true
+			continue
+		}
+		if syn.isLineComment(rest) {
+			add(rest, commentToken)
+			break
+		}
+		c := line[i]
+		switch {
+		case strings.IndexByte(syn.quotes, c) != -1:
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			if j < len(line) {
+				j++
+			} else {
+				j = len(line)
+			}
+			add(line[i:j], stringToken)
+			i = j
+		case isWordByte(c):
+			j := i + 1
+			for j < len(line) && isWordByte(line[j]) {
+				j++
+			}
+			word := line[i:j]
+			kind := plainToken
+			if '0' <= c && c <= '9' {
+				kind = numberToken
+			} else if syn.keywords[word] {
+				kind = keywordToken
+			}
+			add(word, kind)
+			i = j
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			add(rest[:size], plainToken)
+			i += size
+		}
+	}
+	return tokens, inBlock
+}
+
+func (syn *syntax) isLineComment(s string) bool {
+	for _, prefix := range syn.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewedFile returns the name of the file to preview: the one selected in
+// navigation mode, unless it is a directory or an image shown as such, or
+// the file under the current completion candidate.
+func (ed *Editor) previewedFile() string {
+	switch {
+	case ed.navigation != nil:
+		nav := ed.navigation
+		if nav.dirPreview != nil || nav.image != nil && ed.writer != nil && ed.writer.caps.graphics() != noGraphics {
+			return ""
+		}
+		return nav.current.selectedName()
+	case ed.mode == modeCompletion && ed.completion != nil:
+		c := ed.completion
+		if c.current < 0 || c.current >= len(c.candidates) {
+			return ""
+		}
+		return c.candidates[c.current].file
+	default:
+		return ""
+	}
+}
+
+// updateFilePreview starts reading the file to preview in the background, if
+// it is not the one previewed, or if it has changed since.
+func (ed *Editor) updateFilePreview() {
+	name := ""
+	if ed.filePreviewMode {
+		name = ed.previewedFile()
+	}
+	if name == "" {
+		ed.filePreview = nil
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		ed.filePreview = nil
+		return
+	}
+	path, _ := filepath.Abs(name)
+	if fp := ed.filePreview; fp != nil && fp.path == path && fp.modTime.Equal(fi.ModTime()) {
+		return
+	}
+	fp := &filePreview{path: path, modTime: fi.ModTime()}
+	ed.filePreview = fp
+	go func() {
+		lines, binary, err := readFilePreview(path, FilePreviewLines)
+		ed.post(func() {
+			fp.loaded, fp.lines, fp.binary, fp.err = true, lines, binary, err
+		})
+	}()
+}
+
+// renderFilePreview renders the first h lines of the preview fp in a buffer
+// of width w. Lines too long are cut.
+func (o *options) renderFilePreview(fp *filePreview, w, h int) *buffer {
+	b := o.newBuffer(w)
+	switch {
+	case !fp.loaded:
+	case fp.err != nil:
+		b.writes(o.trimWcWidth(fp.err.Error(), w), o.attrForDescription)
+	case fp.binary:
+		b.writes(o.trimWcWidth(o.tr("binary file"), w), o.attrForDescription)
+	default:
+		for i := 0; i < len(fp.lines) && i < h; i++ {
+			if i > 0 {
+				b.newline()
+			}
+			var t styled.Text
+			for _, token := range fp.lines[i] {
+				t = append(t, styled.Segment{Text: token.text, Style: o.tokenAttr(token.kind)})
+			}
+			b.writeStyled(o.trimStyledWcWidth(t, w), "")
+		}
+	}
+	return b
+}
+
+// builtinFilePreview implements the le:file-preview builtin. With no
+// arguments, it prints whether previews of files are shown. With one, on or
+// off, it turns them on or off.
+func builtinFilePreview(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.filePreviewMode))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.filePreviewMode = true
+		case "off":
+			ed.filePreviewMode = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
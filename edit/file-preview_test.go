@@ -0,0 +1,133 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var tokenizeTests = []struct {
+	syn     *syntax
+	line    string
+	inBlock bool
+	tokens  []previewToken
+	after   bool
+}{
+	{goSyntax, "func f() int { return 42 } // answer", false, []previewToken{
+		{"func", keywordToken}, {" f() int { ", plainToken},
+		{"return", keywordToken}, {" ", plainToken},
+		{"42", numberToken}, {" } ", plainToken}, {"// answer", commentToken},
+	}, false},
+	{goSyntax, `s := "a \" b" + x`, false, []previewToken{
+		{"s := ", plainToken}, {`"a \" b"`, stringToken}, {" + x", plainToken},
+	}, false},
+	{goSyntax, "x /* a", false, []previewToken{
+		{"x ", plainToken}, {"/* a", commentToken},
+	}, true},
+	{goSyntax, "b */ y", true, []previewToken{
+		{"b */", commentToken}, {" y", plainToken},
+	}, false},
+	{pythonSyntax, "'unterminated", false, []previewToken{
+		{"'unterminated", stringToken},
+	}, false},
+	{shSyntax, "if é; then # c", false, []previewToken{
+		{"if", keywordToken}, {" é; ", plainToken}, {"then", keywordToken},
+		{" ", plainToken}, {"# c", commentToken},
+	}, false},
+}
+
+func TestTokenize(t *testing.T) {
+	for _, tt := range tokenizeTests {
+		tokens, after := tt.syn.tokenize(tt.line, tt.inBlock)
+		if !reflect.DeepEqual(tokens, tt.tokens) || after != tt.after {
+			t.Errorf("tokenize(%q, %v) => (%v, %v), want (%v, %v)",
+				tt.line, tt.inBlock, tokens, after, tt.tokens, tt.after)
+		}
+	}
+}
+
+func TestReadFilePreview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Got error when writing %s: %v", name, err)
+		}
+		return path
+	}
+
+	lines, binary, err := readFilePreview(write("a.txt", "a\tb\033\nc\nd\ne\n"), 3)
+	wanted := [][]previewToken{{{"a    b", plainToken}}, {{"c", plainToken}}, {{"d", plainToken}}}
+	if !reflect.DeepEqual(lines, wanted) || binary || err != nil {
+		t.Errorf("readFilePreview(a.txt) => (%v, %v, %v), want (%v, false, nil)", lines, binary, err, wanted)
+	}
+	lines, _, _ = readFilePreview(write("a.go", "package a\n"), 3)
+	wanted = [][]previewToken{{{"package", keywordToken}, {" a", plainToken}}}
+	if !reflect.DeepEqual(lines, wanted) {
+		t.Errorf("readFilePreview(a.go) => %v, want %v", lines, wanted)
+	}
+	for _, content := range []string{"\x7fELF\x00\x01", "\xff\xfe"} {
+		if _, binary, _ := readFilePreview(write("bin", content), 3); !binary {
+			t.Errorf("readFilePreview(%q) => not binary", content)
+		}
+	}
+	// A rune cut at the end of what is read doesn't make a file binary
+	long := strings.Repeat("é", filePreviewBytes)
+	if lines, binary, _ := readFilePreview(write("long", "a\n"+long), 3); binary || len(lines) != 1 {
+		t.Errorf("readFilePreview(long) => (%d lines, %v), want (1 line, false)", len(lines), binary)
+	}
+	if _, _, err := readFilePreview(filepath.Join(dir, "nonexistent"), 3); err == nil {
+		t.Errorf("readFilePreview(nonexistent) => no error")
+	}
+}
+
+func TestUpdateFilePreview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	ioutil.WriteFile("a.py", []byte("import os\n"), 0644)
+	os.Mkdir("d", 0755)
+
+	ed := &Editor{options: newOptions(), redraws: make(chan struct{}, 1)}
+	ed.mode = modeCompletion
+	ed.completion = &completion{candidates: []*candidate{{text: "a.py", file: "a.py"}, {text: "d", file: "d"}}}
+	ed.updateFilePreview()
+	fp := ed.filePreview
+	if fp == nil || fp.loaded {
+		t.Fatalf("updateFilePreview => preview %v, want one being loaded", fp)
+	}
+	<-ed.redraws
+	ed.runPosted()
+	wanted := [][]previewToken{{{"import", keywordToken}, {" os", plainToken}}}
+	if !fp.loaded || !reflect.DeepEqual(fp.lines, wanted) {
+		t.Errorf("preview after loading => %v, lines %v, want loaded, lines %v", fp.loaded, fp.lines, wanted)
+	}
+	ed.updateFilePreview()
+	if ed.filePreview != fp {
+		t.Errorf("updateFilePreview of the same file => new preview")
+	}
+
+	// Directories are not previewed
+	ed.completion.current = 1
+	if ed.updateFilePreview(); ed.filePreview != nil {
+		t.Errorf("updateFilePreview of a directory => preview %v, want none", ed.filePreview)
+	}
+
+	ed.filePreviewMode = false
+	ed.completion.current = 0
+	if ed.updateFilePreview(); ed.filePreview != nil {
+		t.Errorf("updateFilePreview with le:file-preview off => preview %v, want none", ed.filePreview)
+	}
+}
@@ -0,0 +1,47 @@
+package edit
+
+import (
+	"fmt"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Terminals usually stop output on Ctrl-S and restart it on Ctrl-Q, for flow
+// control on serial lines, which looks like a frozen terminal to users who
+// press Ctrl-S by accident, or expect it to search. The editor turns flow
+// control off while reading a line, so that Ctrl-S and Ctrl-Q are read as
+// keys and can be bound; the setting of the terminal is restored for other
+// programs. le:flow-control on keeps flow control, for real serial lines.
+
+func init() {
+	addEditorBuiltin("le:flow-control", builtinFlowControl)
+}
+
+// SetFlowControl sets whether Ctrl-S and Ctrl-Q stop and restart output while
+// reading a line, instead of being read as keys.
+func (ed *Editor) SetFlowControl(on bool) {
+	ed.flowControl = on
+}
+
+// builtinFlowControl implements the le:flow-control builtin. With no
+// arguments, it prints whether flow control is kept. With one, on or off, it
+// keeps flow control or turns it off.
+func builtinFlowControl(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.flowControl))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.flowControl = true
+		case "off":
+			ed.flowControl = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
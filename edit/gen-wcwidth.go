@@ -0,0 +1,188 @@
+//go:build ignore
+// +build ignore
+
+// gen-wcwidth generates the tables of wcwidth-table.go from UnicodeData.txt
+// and EastAsianWidth.txt of the Unicode Character Database, following the
+// rules of wcwidth.c by Markus Kuhn: nonspacing and enclosing marks, format
+// characters except the soft hyphen, and the Hangul medial vowels and final
+// consonants have width 0, and wide and fullwidth characters width 2.
+//
+// The files are downloaded from -ucd, or read from it if it is a directory.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ucd = flag.String("ucd", "https://www.unicode.org/Public/UCD/latest/ucd", "URL or directory of the Unicode Character Database")
+	out = flag.String("o", "wcwidth-table.go", "file to write")
+)
+
+const maxRune = 0x10FFFF
+
+func open(name string) (io.ReadCloser, error) {
+	if info, err := os.Stat(*ucd); err == nil && info.IsDir() {
+		return os.Open(path.Join(*ucd, name))
+	}
+	resp, err := http.Get(*ucd + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// eachLine calls f with the fields, separated by ";" and trimmed, of each
+// line of the named file that is not empty after removing comments. It
+// returns the first comment line, which names the version of the file.
+func eachLine(name string, f func(fields []string)) string {
+	rc, err := open(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rc.Close()
+	first := ""
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			if first == "" && i == 0 {
+				first = strings.TrimSpace(line[1:])
+			}
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		f(fields)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return first
+}
+
+func parseCode(s string) rune {
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || n > maxRune {
+		log.Fatalf("bad code point %q", s)
+	}
+	return rune(n)
+}
+
+// parseRange parses a code point or a range like 3400..4DBF.
+func parseRange(s string) (rune, rune) {
+	if i := strings.Index(s, ".."); i != -1 {
+		return parseCode(s[:i]), parseCode(s[i+2:])
+	}
+	r := parseCode(s)
+	return r, r
+}
+
+func set(table []bool, from, to rune) {
+	for r := from; r <= to; r++ {
+		table[r] = true
+	}
+}
+
+// ranges returns the ranges of runes set in table.
+func ranges(table []bool) [][2]rune {
+	var rs [][2]rune
+	for r := rune(0); r <= maxRune; r++ {
+		if !table[r] {
+			continue
+		}
+		from := r
+		for r < maxRune && table[r+1] {
+			r++
+		}
+		rs = append(rs, [2]rune{from, r})
+	}
+	return rs
+}
+
+func writeTable(b *bytes.Buffer, name, doc string, rs [][2]rune) {
+	fmt.Fprintf(b, "\n// %s\nvar %s = [][2]rune{\n", doc, name)
+	for i, r := range rs {
+		fmt.Fprintf(b, "{0x%04X, 0x%04X},", r[0], r[1])
+		if i%3 == 2 || i == len(rs)-1 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(" ")
+		}
+	}
+	b.WriteString("}\n")
+}
+
+func main() {
+	flag.Parse()
+
+	combining := make([]bool, maxRune+1)
+	var first rune
+	eachLine("UnicodeData.txt", func(fields []string) {
+		if len(fields) < 3 {
+			log.Fatalf("bad line of UnicodeData.txt: %q", fields)
+		}
+		r := parseCode(fields[0])
+		from := r
+		// Large ranges are given by their first and last code points
+		if strings.HasSuffix(fields[1], ", First>") {
+			first = r
+			return
+		} else if strings.HasSuffix(fields[1], ", Last>") {
+			from = first
+		}
+		switch fields[2] {
+		case "Mn", "Me", "Cf":
+			set(combining, from, r)
+		}
+	})
+	combining[0x00AD] = false
+	set(combining, 0x1160, 0x11FF)
+
+	wide := make([]bool, maxRune+1)
+	version := eachLine("EastAsianWidth.txt", func(fields []string) {
+		if len(fields) < 2 {
+			log.Fatalf("bad line of EastAsianWidth.txt: %q", fields)
+		}
+		if fields[1] == "W" || fields[1] == "F" {
+			from, to := parseRange(fields[0])
+			set(wide, from, to)
+		}
+	})
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "// Code generated by gen-wcwidth.go from %s; DO NOT EDIT.\n\npackage edit\n", version)
+	writeTable(b, "combining", `combining are the ranges of runes of width 0: nonspacing and enclosing
+// marks, format characters except the soft hyphen, and the Hangul medial
+// vowels and final consonants.`, ranges(combining))
+	writeTable(b, "wide", `wide are the ranges of runes of width 2: wide and fullwidth characters in
+// East Asian scripts.`, ranges(wide))
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,345 @@
+package edit
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/util"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// newFixture makes an editorState with the given line, with the dot at the
+// end, and tokens from the highlighter.
+func newFixture(prompt, line string) *editorState {
+	bs := &editorState{prompt: styled.Plain(prompt), line: line, dot: len(line)}
+	for token := range Highlight("<fixture>", line, eval.NewEvaluator()) {
+		bs.tokens = append(bs.tokens, token)
+	}
+	return bs
+}
+
+// goldenOptions are the options goldenTests are rendered with.
+var goldenOptions = func() *options {
+	o := newOptions()
+	o.noStyle = false
+	o.snippets = map[string]string{"ff": "ffmpeg -i {input} {output}", "tarx": "tar xf {file}"}
+	return o
+}()
+
+var goldenTests = []struct {
+	name          string
+	width, height int
+	hscroll       bool
+	// The states rendered in sequence. Only the screen after rendering the
+	// last one is compared, so that incremental updates are covered too.
+	states []*editorState
+}{
+	{"simple", 30, 5, false, []*editorState{
+		newFixture("~> ", "echo hello"),
+	}},
+	{"rprompt", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "ls")
+			bs.rprompt = styled.Plain("user@host")
+			return bs
+		}(),
+	}},
+	{"wrap", 16, 5, false, []*editorState{
+		newFixture("~> ", "echo the quick brown fox"),
+	}},
+	{"shrink", 16, 5, false, []*editorState{
+		newFixture("~> ", "echo the quick brown fox"),
+		newFixture("~> ", "echo"),
+	}},
+	{"edit-middle", 30, 5, false, []*editorState{
+		newFixture("~> ", "echo hello"),
+		func() *editorState {
+			bs := newFixture("~> ", "echo jello")
+			bs.dot = 6
+			return bs
+		}(),
+	}},
+	{"mode-and-tips", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo")
+			bs.mode = modeCommand
+			bs.tips = []styled.Text{styled.Plain("a tip"), styled.Plain("another")}
+			return bs
+		}(),
+	}},
+	{"viewport", 10, 3, false, []*editorState{
+		newFixture("> ", "echo one two three four five"),
+	}},
+	{"hscroll", 12, 3, true, []*editorState{
+		newFixture("> ", "echo one two three four five"),
+	}},
+	{"completion", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "ls f")
+			bs.mode = modeCompletion
+			bs.completion = &completion{start: 3, end: 4, current: 1,
+				candidates: goldenOptions.findCandidates("f", []string{"foo", "bar", "fizz", "fuzz"}, "")}
+			for _, c := range bs.completion.candidates {
+				c.display = styled.Plain(c.text)
+			}
+			return bs
+		}(),
+	}},
+	{"completion-descriptions", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "ls f")
+			bs.mode = modeCompletion
+			bs.completion = &completion{start: 3, end: 4, current: 1,
+				candidates: goldenOptions.findCandidates("f", []string{"foo", "fizz", "fuzz"}, "")}
+			descs := []string{"directory", "1.5K", "a description too long to fit"}
+			for i, c := range bs.completion.candidates {
+				c.display = styled.Plain(c.text)
+				c.description = descs[i]
+			}
+			return bs
+		}(),
+	}},
+	{"completion-groups", 30, 7, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "ls f")
+			bs.mode = modeCompletion
+			bs.completion = &completion{start: 3, end: 4, current: 2,
+				candidates: goldenOptions.findCandidates("f", []string{"foo", "fizz", "fuzz"}, "")}
+			groups := []string{"used before", "files", "files"}
+			for i, c := range bs.completion.candidates {
+				c.display = styled.Plain(c.text)
+				c.group = groups[i]
+			}
+			return bs
+		}(),
+	}},
+	{"completion-file-preview", 60, 8, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "cat f")
+			bs.mode = modeCompletion
+			bs.completion = &completion{start: 4, end: 5, current: 1,
+				candidates: goldenOptions.findCandidates("f", []string{"foo.go", "fizz.go", "fuzz"}, "")}
+			for _, c := range bs.completion.candidates {
+				c.display = styled.Plain(c.text)
+			}
+			bs.filePreview = &filePreview{loaded: true, lines: [][]previewToken{
+				{{"package", keywordToken}, {" main ", plainToken}, {"// fizz", commentToken}},
+				{},
+				{{"const", keywordToken}, {" n = ", plainToken}, {"15", numberToken}},
+				{{"var", keywordToken}, {" s = ", plainToken}, {`"a string too long to fit beside the listing"`, stringToken}},
+			}}
+			return bs
+		}(),
+	}},
+	{"completion-omitted", 30, 4, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "ls f")
+			bs.mode = modeCompletion
+			bs.completion = &completion{start: 3, end: 4, current: 0, omitted: 1234,
+				candidates: goldenOptions.findCandidates("f", []string{"foo", "fizz", "fuzz"}, "")}
+			for _, c := range bs.completion.candidates {
+				c.display = styled.Plain(c.text)
+			}
+			return bs
+		}(),
+	}},
+	{"snippet", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "")
+			bs.mode = modeSnippet
+			bs.snippet = &snippetListing{[]string{"ff", "tarx"}, 1}
+			return bs
+		}(),
+	}},
+	{"line-error", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo $nosuch x")
+			err := eval.NewEvaluator().Check("<fixture>", bs.line)
+			bs.lineError, _ = err.(*util.ContextualError)
+			return bs
+		}(),
+	}},
+	{"suggestion", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo")
+			bs.suggestion = " hello"
+			return bs
+		}(),
+	}},
+	{"paste", 40, 6, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "")
+			bs.mode = modePaste
+			bs.paste = &pasteState{"echo one\n\techo two\necho three", 1}
+			return bs
+		}(),
+	}},
+	{"selection", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo foo bar")
+			bs.selection = &selection{anchor: 5}
+			bs.dot = 8
+			return bs
+		}(),
+	}},
+	{"vi-hints", 40, 6, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo foo")
+			bs.mode = modeViPending
+			bs.viKeys = "d"
+			bs.hints = goldenOptions.viPendingHints(bs.viKeys)
+			return bs
+		}(),
+	}},
+	{"minibuffer", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo")
+			bs.mode = modeMinibuffer
+			bs.minibuffer = &minibuffer{prompt: "Search history:", text: "foo", dot: 1}
+			return bs
+		}(),
+	}},
+	{"palette", 40, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "echo")
+			bs.mode = modePalette
+			bs.palette = &palette{mode: modeInsert}
+			bs.palette.setFilter(goldenOptions, "kill-li")
+			return bs
+		}(),
+	}},
+	{"history-listing", 50, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~> ", "")
+			bs.mode = modeHistoryListing
+			bs.historyListing = &historyListing{
+				filter: "mk",
+				entries: []HistoryEntry{
+					{Line: "make test", Start: time.Date(2016, 1, 2, 15, 4, 0, 0, time.UTC)},
+					{Line: "make"}},
+				current:  1,
+				selected: []string{"make test"},
+			}
+			return bs
+		}(),
+	}},
+	{"wide", 12, 3, false, []*editorState{
+		newFixture("> ", "echo 好好好好好"),
+	}},
+	{"narrow", 8, 3, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("~/src/elvish> ", "echo hi")
+			bs.rprompt = styled.Plain("user@host")
+			return bs
+		}(),
+	}},
+	{"preedit", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("> ", "echo ")
+			bs.preedit = preedit{"你好", len("你")}
+			return bs
+		}(),
+	}},
+	{"messages", 30, 5, false, []*editorState{
+		newMessagesFixture(),
+	}},
+	{"messages-dropped", 30, 4, false, []*editorState{
+		newMessagesFixture(),
+	}},
+	{"snippet-fields", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("> ", "each gh list; ")
+			bs.dot = len("each gh list")
+			bs.field = &snippetField{2, len("each gh "), len("each gh list"), true}
+			bs.fields = []snippetField{{0, len("each gh list; "), len("each gh list; "), true}}
+			return bs
+		}(),
+	}},
+	{"query-replace", 40, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("> ", "cp a.txt a.bak")
+			bs.dot = len("cp a.txt ")
+			bs.mode = modeMinibuffer
+			bs.minibuffer = &minibuffer{prompt: "Replace with b? (y/n/a/q)", choices: "ynaq",
+				matchStart: len("cp a.txt "), matchEnd: len("cp a.txt a")}
+			return bs
+		}(),
+	}},
+	{"instant", 30, 5, false, []*editorState{
+		func() *editorState {
+			bs := newFixture("> ", "ls")
+			bs.instant = []string{"a.go", "b\tc.go", "d.go", "e.go", "f.go"}
+			return bs
+		}(),
+	}},
+}
+
+func newMessagesFixture() *editorState {
+	bs := newFixture("> ", "echo")
+	bs.messages = []string{"job 1 done", "a message too long to fit in one line"}
+	bs.tips = []styled.Text{styled.Plain("tip")}
+	bs.mode = modeCommand
+	return bs
+}
+
+func TestGolden(t *testing.T) {
+	for _, tt := range goldenTests {
+		screen, err := renderToVT(tt.width, tt.height, tt.hscroll, tt.states)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		golden := filepath.Join("testdata", tt.name+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, []byte(screen), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		wanted, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if screen != string(wanted) {
+			t.Errorf("%s: screen is\n%s\nwant\n%s", tt.name, screen, wanted)
+		}
+	}
+}
+
+// renderToVT renders states in sequence with a writer, and returns the
+// screenshot of a virtual terminal fed with what the writer writes.
+func renderToVT(width, height int, hscroll bool, states []*editorState) (string, error) {
+	f, err := ioutil.TempFile("", "elvish-test")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newWriter(f, goldenOptions)
+	w.caps = capabilities{color: true}
+	w.horizontalScroll = hscroll
+	for _, bs := range states {
+		if err := w.redraw(bs, nil, width, height); err != nil {
+			return "", err
+		}
+	}
+
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	t := newVT(width, height)
+	if _, err := t.Write(out); err != nil {
+		return "", err
+	}
+	return t.screenshot(), nil
+}
@@ -0,0 +1,251 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/sys"
+)
+
+// Flags of external commands are completed from what they print with --help,
+// when the word being completed starts with -. The command is found like the
+// command of a form, and is only run if the exec filter of the evaluator
+// allows it, so that completion doesn't run commands the user may not. It is
+// run in a sandbox:
+//
+//	- in a session of its own, without a controlling terminal or input;
+//	- in an empty temporary directory, removed afterwards;
+//	- with none of the environment of the shell, only helpEnv;
+//	- with the resource limits of helpLimits, set as soon as it has started,
+//	  which notably keep it from writing to files.
+//
+// It is killed, with any process it has started, after HelpTimeout. What it
+// prints is parsed for lines like
+//
+//	  -f, --flag=VALUE  Description
+//
+// and the result is cached for the SHA-256 hash of the file of the command,
+// so that it is not run again unless the file changes. Hashes are remembered
+// for the path, size and modification time of files, so that files are only
+// hashed again when they seem to have changed.
+
+// HelpTimeout is how long a command is given to print its --help.
+const HelpTimeout = time.Second
+
+// maxHelpSize is the maximum number of bytes of --help output read.
+const maxHelpSize = 1 << 20
+
+// waitDelay is how long to wait for the output of commands run by the editor
+// to be closed after they have exited or been killed, since processes they
+// started may keep it open.
+const waitDelay = 100 * time.Millisecond
+
+// helpEnv is the environment commands are run with for their --help, which
+// keeps them from paging or coloring their output.
+var helpEnv = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "PAGER=cat", "MANPAGER=cat", "NO_COLOR=1", "TERM=dumb"}
+
+// helpLimits are the resource limits of commands run for their --help.
+var helpLimits = []struct {
+	resource int
+	limit    uint64
+}{
+	{syscall.RLIMIT_FSIZE, 0},
+	{syscall.RLIMIT_CORE, 0},
+	{syscall.RLIMIT_CPU, 2},
+	{syscall.RLIMIT_AS, 4 << 30},
+}
+
+// helpCache remembers the flags found in the --help of commands. Each Editor
+// has its own; completions found in the background use it concurrently.
+type helpCache struct {
+	mutex sync.Mutex
+	// Maps hashes of files of commands to the flags found, with
+	// descriptions.
+	flags map[[sha256.Size]byte]map[string]string
+	// Maps keys from fileKey to hashes of files.
+	hashes map[string][sha256.Size]byte
+}
+
+func newHelpCache() *helpCache {
+	return &helpCache{flags: make(map[[sha256.Size]byte]map[string]string),
+		hashes: make(map[string][sha256.Size]byte)}
+}
+
+// flagPattern matches a flag in a list of flags, with its argument if any.
+var flagPattern = regexp.MustCompile(`^(--?[A-Za-z0-9?][A-Za-z0-9_-]*)(?:\[?[= ][^ ,]*\]?)?$`)
+
+// parseHelp finds the flags listed in the output of --help, and their
+// descriptions. Lines listing flags start with spaces and a -, and their
+// descriptions follow after two spaces or a tab, or are on the next line.
+func parseHelp(help string) map[string]string {
+	flags := make(map[string]string)
+	lines := strings.Split(help, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == line || !strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		list, desc := trimmed, ""
+		if j := strings.Index(trimmed, "  "); j != -1 {
+			list, desc = trimmed[:j], trimmed[j:]
+		}
+		if j := strings.IndexRune(list, '\t'); j != -1 {
+			list, desc = list[:j], list[j:]+desc
+		}
+		desc = strings.TrimSpace(desc)
+		if desc == "" && i+1 < len(lines) {
+			next := strings.TrimSpace(lines[i+1])
+			if !strings.HasPrefix(next, "-") {
+				desc = next
+			}
+		}
+		for _, item := range strings.Split(list, ", ") {
+			m := flagPattern.FindStringSubmatch(strings.TrimSpace(item))
+			if m == nil {
+				continue
+			}
+			if _, ok := flags[m[1]]; !ok {
+				flags[m[1]] = desc
+			}
+		}
+	}
+	return flags
+}
+
+// fileKey returns a key that changes when the file at path seems to.
+func fileKey(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", path, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// fileHash returns the SHA-256 hash of the file at path, remembered in hc.
+func (hc *helpCache) fileHash(path string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	key, err := fileKey(path)
+	if err != nil {
+		return hash, err
+	}
+	hc.mutex.Lock()
+	hash, ok := hc.hashes[key]
+	hc.mutex.Unlock()
+	if ok {
+		return hash, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return hash, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return hash, err
+	}
+	copy(hash[:], h.Sum(nil))
+	hc.mutex.Lock()
+	hc.hashes[key] = hash
+	hc.mutex.Unlock()
+	return hash, nil
+}
+
+// killGroupOnCancel makes cmd, made with exec.CommandContext, run in a
+// process group of its own, which is killed as a whole when the context is
+// done, and keeps Wait from waiting long for the output after cmd is done.
+func killGroupOnCancel(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+}
+
+// runHelp runs the command at path with --help in the sandbox, with dir as
+// its working directory, and returns what it prints on both the standard
+// output and error.
+func runHelp(path, dir string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HelpTimeout)
+	defer cancel()
+	var out limitedBuffer
+	cmd := exec.CommandContext(ctx, path, "--help")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Dir = dir
+	cmd.Env = append(append([]string(nil), helpEnv...), "HOME="+dir)
+	// A new session has no controlling terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	killGroupOnCancel(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	for _, l := range helpLimits {
+		sys.Prlimit(cmd.Process.Pid, l.resource, &syscall.Rlimit{Cur: l.limit, Max: l.limit})
+	}
+	// Many commands exit with non-zero statuses after printing --help
+	cmd.Wait()
+	// Processes it has left running are not needed any longer
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	return out.String(), nil
+}
+
+// limitedBuffer is a bytes.Buffer that drops what is written after
+// maxHelpSize bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := maxHelpSize - b.Len(); len(p) > n {
+		b.Buffer.Write(p[:n])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// helpFlags returns the flags of the external command at path, with their
+// descriptions, from its --help, remembered in hc. ev decides whether the
+// command may be run.
+func (hc *helpCache) helpFlags(ev *eval.Evaluator, path string) (map[string]string, error) {
+	hash, err := hc.fileHash(path)
+	if err != nil {
+		return nil, err
+	}
+	hc.mutex.Lock()
+	flags, ok := hc.flags[hash]
+	hc.mutex.Unlock()
+	if ok {
+		return flags, nil
+	}
+	dir, err := ioutil.TempDir("", "elvish-help.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := ev.CheckExec(path, []string{path, "--help"}, dir); err != nil {
+		return nil, err
+	}
+	help, err := runHelp(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	flags = parseHelp(help)
+	hc.mutex.Lock()
+	hc.flags[hash] = flags
+	hc.mutex.Unlock()
+	return flags, nil
+}
@@ -0,0 +1,110 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+var parseHelpTests = []struct {
+	help   string
+	wanted map[string]string
+}{
+	{"Usage: ls [OPTION]... [FILE]...\n" +
+		"  -a, --all                  do not ignore entries starting with .\n" +
+		"      --color[=WHEN]         colorize the output\n" +
+		"  -I, --ignore=PATTERN       do not list implied entries matching PATTERN\n" +
+		"      --very-long-option-name\n" +
+		"                             described on the next line\n",
+		map[string]string{
+			"-a": "do not ignore entries starting with .", "--all": "do not ignore entries starting with .",
+			"--color": "colorize the output",
+			"-I":      "do not list implied entries matching PATTERN", "--ignore": "do not list implied entries matching PATTERN",
+			"--very-long-option-name": "described on the next line",
+		}},
+	{"Usage of prog:\n  -v\tbe verbose\n  -n int\tnumber of times\n",
+		map[string]string{"-v": "be verbose", "-n": "number of times"}},
+	{"-x at the start of a line is not a flag\n", map[string]string{}},
+}
+
+func TestParseHelp(t *testing.T) {
+	for _, tt := range parseHelpTests {
+		if out := parseHelp(tt.help); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("parseHelp(%q) => %v, want %v", tt.help, out, tt.wanted)
+		}
+	}
+}
+
+func TestHelpFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	os.Mkdir(runs, 0755)
+	os.Setenv("ELVISH_HELP_TEST", "set")
+	defer os.Unsetenv("ELVISH_HELP_TEST")
+	// The command counts how many times it is run, and tells where it runs
+	// and whether it has the environment of the shell
+	script := "#!/bin/sh\nmkdir " + runs + "/$$\n" +
+		"echo '  -q, --quiet  say less'\n" +
+		"echo \"  -d, --dir  in $PWD\"\n" +
+		"echo \"  -e, --env  ${ELVISH_HELP_TEST-unset}\"\nexit 1\n"
+	cmd := filepath.Join(dir, "cmd")
+	if err := ioutil.WriteFile(cmd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := eval.NewEvaluator()
+	hc := newHelpCache()
+	for i := 0; i < 2; i++ {
+		flags, err := hc.helpFlags(ev, cmd)
+		if err != nil || flags["-q"] != "say less" || flags["--env"] != "unset" ||
+			!strings.HasPrefix(flags["--dir"], "in "+os.TempDir()) || flags["--dir"] == "in "+dir {
+			t.Errorf("helpFlags => (%v, %v), want -q, --env unset and --dir in a temporary directory", flags, err)
+		}
+	}
+	if entries, _ := ioutil.ReadDir(runs); len(entries) != 1 {
+		t.Errorf("command run %d times, want once", len(entries))
+	}
+
+	// Commands vetoed by the exec filter are not run
+	ev.SetExecFilter(eval.WhitelistExecFilter(nil))
+	ioutil.WriteFile(cmd, []byte(script+"# changed\n"), 0755)
+	if flags, err := hc.helpFlags(ev, cmd); err == nil {
+		t.Errorf("helpFlags with a filter vetoing the command => (%v, nil), want error", flags)
+	}
+	if entries, _ := ioutil.ReadDir(runs); len(entries) != 1 {
+		t.Errorf("command vetoed by the filter run")
+	}
+}
+
+func TestHelpFlagsTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The command never exits, and starts a process that keeps the output
+	// open
+	script := "#!/bin/sh\nsleep 30 &\necho '  -q  quiet'\nexec sleep 30\n"
+	cmd := filepath.Join(dir, "cmd")
+	if err := ioutil.WriteFile(cmd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	flags, _ := newHelpCache().helpFlags(eval.NewEvaluator(), cmd)
+	if d := time.Since(start); d > HelpTimeout+5*waitDelay {
+		t.Errorf("helpFlags of a command that doesn't exit took %v", d)
+	}
+	if flags["-q"] != "quiet" {
+		t.Errorf("helpFlags of a command that doesn't exit => %v, want -q", flags)
+	}
+}
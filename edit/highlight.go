@@ -52,9 +52,7 @@ func (hl *Highlighter) command(token parse.Item) {
 	}
 	if token.Typ == parse.ItemBare {
 		// Check validity of command
-		// XXX Disabled until Compiler implements it
-		if true {
-			// if _, _, err := hl.ev.ResolveCommand(token.Val); err == nil {
+		if _, _, err := hl.ev.ResolveCommand(token.Val); err == nil {
 			token.Typ = ItemValidCommand
 		} else {
 			token.Typ = ItemInvalidCommand
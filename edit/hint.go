@@ -0,0 +1,76 @@
+package edit
+
+import (
+	"sort"
+)
+
+// Hints are shown below the line, in columns like completion candidates, until
+// the next key. F1 shows the bindings of the current mode, and after a prefix
+// of a vi command, the keys that can follow are shown.
+
+// bindingHints returns the bindings of mode, with keys sorted.
+func (o *options) bindingHints(mode bufferMode) []string {
+	kb := o.keyBindings[mode]
+	keys := make([]Key, 0, len(kb))
+	for k := range kb {
+		if k != DefaultBinding {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(keySlice(keys))
+
+	hints := make([]string, len(keys))
+	for i, k := range keys {
+		hints[i] = k.String() + " " + kb[k]
+	}
+	return hints
+}
+
+func showBindings(ed *Editor, k Key) *leReturn {
+	ed.hints = ed.bindingHints(ed.mode)
+	return nil
+}
+
+// viHintKeys are the keys tried by viPendingHints, in the order shown.
+const viHintKeys = "dycpPiaw\"'`()b[]{}B"
+
+// viKeyDescriptions describes the keys of vi commands in viHintKeys.
+var viKeyDescriptions = map[rune]string{
+	'd': "delete", 'y': "yank", 'c': "change",
+	'p': "put after", 'P': "put before",
+	'i': "inner", 'a': "around",
+	'w': "word", '"': "\"string\"", '\'': "'string'", '`': "`string`",
+	'(': "(block)", ')': "(block)", 'b': "(block)",
+	'[': "[block]", ']': "[block]",
+	'{': "{block}", '}': "{block}", 'B': "{block}",
+}
+
+// viPendingHints returns the keys that can follow keys in a vi command, with
+// what they do. Keys that leave the command incomplete are marked with "…".
+func (o *options) viPendingHints(keys string) []string {
+	if keys == "\"" {
+		return []string{
+			"a-z " + o.tr("register"),
+			"A-Z " + o.tr("append to register"),
+			"\" " + o.tr("unnamed register"),
+		}
+	}
+	cmd, _, _ := parseViCommand(keys)
+	var hints []string
+	for _, r := range viHintKeys {
+		_, complete, err := parseViCommand(keys + string(r))
+		if err != nil {
+			continue
+		}
+		hint := string(r) + " " + o.tr(viKeyDescriptions[r])
+		if complete && r == cmd.op {
+			// dd, yy and cc
+			hint = string(r) + " " + o.tr("line")
+		}
+		if !complete {
+			hint += "…"
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
@@ -0,0 +1,38 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var viPendingHintsTests = []struct {
+	keys   string
+	wanted []string
+}{
+	{"\"", []string{"a-z register", "A-Z append to register", "\" unnamed register"}},
+	{"\"a", []string{"d delete…", "y yank…", "c change…", "p put after", "P put before"}},
+	{"d", []string{"d line", "i inner…", "a around…"}},
+	{"yi", []string{"w word", "\" \"string\"", "' 'string'", "` `string`",
+		"( (block)", ") (block)", "b (block)", "[ [block]", "] [block]",
+		"{ {block}", "} {block}", "B {block}"}},
+}
+
+func TestViPendingHints(t *testing.T) {
+	for _, tt := range viPendingHintsTests {
+		if out := newOptions().viPendingHints(tt.keys); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("viPendingHints(%q) => %q, want %q", tt.keys, out, tt.wanted)
+		}
+	}
+}
+
+func TestHintsLastOneKey(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.handleRead(keyRead(Key{F1, 0}))
+	if len(ed.hints) == 0 || ed.hints[0] != "Alt-= calc" {
+		t.Errorf("F1 => hints %q, want bindings of insert mode", ed.hints)
+	}
+	ed.handleRead(keyRead(Key{'a', 0}))
+	if ed.hints != nil {
+		t.Errorf("F1 a => hints %q, want none", ed.hints)
+	}
+}
@@ -0,0 +1,226 @@
+package edit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// The history is kept in a file with one entry per line, each a JSON object
+// with the fields of HistoryEntry, so that entries can span multiple lines
+// and carry when, where and how they ran. Files of the older plain format,
+// with just the text of one entry per line, are still read, and rewritten in
+// the structured format when loaded. Entries are appended to the file when
+// they finish running, so the file of multiple elvish processes interleaves
+// their entries; see history-share.go for how they see those of each other.
+//
+// The file is migrated by writing a new file and renaming it over the old
+// one, with the old one locked exclusively, so that it is never left half
+// written. Processes that have opened the old file check once they have
+// locked it that it is still the one at the path, and open the new one
+// otherwise; see openHistoryFile.
+
+// LoadHistory reads the history from the named file, and appends lines
+// accepted from now on to it. A nonexistent file is taken as empty.
+func (ed *Editor) LoadHistory(fname string) error {
+	ed.historyFile = fname
+	for {
+		f, err := ed.openHistoryFile(os.O_RDONLY, syscall.LOCK_SH)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		loaded, err := ed.loadHistoryFile(f)
+		f.Close()
+		if loaded || err != nil {
+			return err
+		}
+	}
+}
+
+// loadHistoryFile reads the history from f, the history file locked shared,
+// migrating it if it has entries of the plain format. It returns false if f
+// has been replaced before it could be migrated, to be loaded again.
+func (ed *Editor) loadHistoryFile(f *os.File) (bool, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+	entries, plain := parseHistory(data)
+	if plain {
+		// Other processes may lock the file while the lock is upgraded, so
+		// it is read again once it is held
+		if err := lockFile(f, syscall.LOCK_EX); err != nil {
+			return false, err
+		}
+		if current, err := isHistoryFile(f, ed.historyFile); err != nil || !current {
+			return false, err
+		}
+		if data, err = ioutil.ReadAll(io.NewSectionReader(f, 0, 1<<62)); err != nil {
+			return false, err
+		}
+		entries, plain = parseHistory(data)
+	}
+	if ed.args == nil {
+		ed.args = make(argIndex)
+	}
+	for _, e := range entries {
+		ed.args.add(e.Line)
+	}
+	ed.histories = append(entries, ed.histories...)
+	if plain {
+		return true, ed.migrateHistoryFile(entries)
+	}
+	ed.historyOffset = int64(len(data))
+	ed.historyInfo, err = f.Stat()
+	return true, err
+}
+
+// openHistoryFile opens the history file with flag, and locks it with how,
+// one of syscall.LOCK_SH and syscall.LOCK_EX. If the file is replaced while
+// waiting for the lock, the new one is opened instead.
+func (ed *Editor) openHistoryFile(flag, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(ed.historyFile, flag, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f, how); err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := isHistoryFile(f, ed.historyFile)
+		if err != nil && !os.IsNotExist(err) {
+			f.Close()
+			return nil, err
+		} else if current {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// isHistoryFile returns whether f is still the file at fname, by comparing
+// their devices and inodes.
+func isHistoryFile(f *os.File, fname string) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pathInfo, err := os.Stat(fname)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(info, pathInfo), nil
+}
+
+// parseHistory parses the content of a history file, and returns its entries,
+// sorted by when they started, and whether any of them is of the plain format.
+// Entries without a start time, like those of the plain format, come first.
+func parseHistory(data []byte) ([]HistoryEntry, bool) {
+	var entries []HistoryEntry
+	plain := false
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal(line, &e); err != nil || e.Line == "" {
+			e = HistoryEntry{Line: string(line)}
+			plain = true
+		}
+		entries = append(entries, e)
+	}
+	sort.Stable(historyByStart(entries))
+	return entries, plain
+}
+
+// historyByStart sorts entries by their start time.
+type historyByStart []HistoryEntry
+
+func (hs historyByStart) Len() int           { return len(hs) }
+func (hs historyByStart) Less(i, j int) bool { return hs[i].Start.Before(hs[j].Start) }
+func (hs historyByStart) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+
+// formatHistory formats entries in the structured format.
+func formatHistory(entries []HistoryEntry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// migrateHistoryFile replaces the history file, which must be locked
+// exclusively, with entries in the structured format.
+func (ed *Editor) migrateHistoryFile(entries []HistoryEntry) error {
+	data, err := formatHistory(entries)
+	if err != nil {
+		return err
+	}
+	tmp := ed.historyFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ed.historyFile); err != nil {
+		return err
+	}
+	ed.historyOffset = int64(len(data))
+	ed.historyInfo = info
+	return nil
+}
+
+// writeHistoryEntries appends entries to the history file, after reading the
+// entries other processes have appended since it was last read.
+func (ed *Editor) writeHistoryEntries(entries ...HistoryEntry) error {
+	data, err := formatHistory(entries)
+	if err != nil {
+		return err
+	}
+	f, err := ed.openHistoryFile(os.O_RDWR|os.O_APPEND|os.O_CREATE, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := ed.readNewHistory(f); err != nil {
+		return err
+	}
+	n, err := f.Write(data)
+	ed.historyOffset += int64(n)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// CommandFinished records how long the line last returned by ReadLine ran and
+// its exit status, and appends its entry to the history file. It does nothing
+// if the line was kept out of the history.
+func (ed *Editor) CommandFinished(status int) error {
+	if !ed.running {
+		return nil
+	}
+	ed.running = false
+	e := &ed.histories[len(ed.histories)-1]
+	e.Duration = time.Since(e.Start)
+	e.Status = status
+	if ed.historyFile == "" {
+		return nil
+	}
+	return ed.writeHistoryEntries(*e)
+}
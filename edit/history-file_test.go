@@ -0,0 +1,72 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var parseHistoryTests = []struct {
+	data  string
+	lines []string
+	plain bool
+}{
+	{"", nil, false},
+	{"ls\n\nmake test\n", []string{"ls", "make test"}, true},
+	{`{"line":"echo a\nb","dir":"/tmp","status":1}` + "\n", []string{"echo a\nb"}, false},
+	{`{"line":"ls"}` + "\n{ put x }\n", []string{"ls", "{ put x }"}, true},
+}
+
+func TestParseHistory(t *testing.T) {
+	for _, tt := range parseHistoryTests {
+		entries, plain := parseHistory([]byte(tt.data))
+		var lines []string
+		for _, e := range entries {
+			lines = append(lines, e.Line)
+		}
+		if strings.Join(lines, "|") != strings.Join(tt.lines, "|") || plain != tt.plain {
+			t.Errorf("parseHistory(%q) => %q, %v, want %q, %v", tt.data, lines, plain, tt.lines, tt.plain)
+		}
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "history")
+	ioutil.WriteFile(fname, []byte("ls\nmake\n"), 0600)
+
+	ed := &Editor{options: newOptions()}
+	if err := ed.LoadHistory(fname); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(fname); !strings.HasPrefix(string(data), `{"line":"ls"`) {
+		t.Errorf("history file of the plain format not migrated, got %q", data)
+	}
+	ed.appendHistory("false")
+	if err := ed.CommandFinished(1); err != nil {
+		t.Fatal(err)
+	}
+	// Lines kept out of the history are not written
+	if err := ed.CommandFinished(2); err != nil {
+		t.Fatal(err)
+	}
+
+	ed = &Editor{options: newOptions()}
+	if err := ed.LoadHistory(fname); err != nil {
+		t.Fatal(err)
+	}
+	if len(ed.histories) != 3 {
+		t.Fatalf("loaded %d entries, want 3", len(ed.histories))
+	}
+	e := ed.histories[2]
+	wd, _ := os.Getwd()
+	if e.Line != "false" || e.Dir != wd || e.Start.IsZero() || e.Status != 1 {
+		t.Errorf("loaded %v, want false run in %s with status 1", e, wd)
+	}
+}
@@ -0,0 +1,238 @@
+package edit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// The history of bash, zsh and fish can be imported with le:history-import,
+// so that switching to elvish doesn't lose it. Timestamps are kept where the
+// other shell records them: bash does with $HISTTIMEFORMAT set, zsh with
+// EXTENDED_HISTORY, and fish always. Entries already in the history, with
+// the same line and start time, are not imported again.
+
+// historyImporters parses the history files of other shells.
+var historyImporters = map[string]func(string) []HistoryEntry{
+	"bash": parseBashHistory,
+	"zsh":  parseZshHistory,
+	"fish": parseFishHistory,
+}
+
+func init() {
+	addEditorBuiltin("le:history-import", builtinHistoryImport)
+}
+
+// shellHistoryFile returns where shell keeps its history by default.
+func shellHistoryFile(shell string) string {
+	home := os.Getenv("HOME")
+	switch shell {
+	case "bash":
+		return path.Join(home, ".bash_history")
+	case "zsh":
+		return path.Join(home, ".zsh_history")
+	default:
+		data := os.Getenv("XDG_DATA_HOME")
+		if data == "" {
+			data = path.Join(home, ".local", "share")
+		}
+		return path.Join(data, "fish", "fish_history")
+	}
+}
+
+// parseUnix parses a timestamp in seconds since the epoch.
+func parseUnix(s string) (time.Time, bool) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// parseBashHistory parses a history file of bash, with one entry per line.
+// With $HISTTIMEFORMAT set, entries are preceded by # and their timestamp.
+func parseBashHistory(data string) []HistoryEntry {
+	var entries []HistoryEntry
+	var start time.Time
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "#") {
+			if t, ok := parseUnix(line[1:]); ok {
+				start = t
+				continue
+			}
+		}
+		if line != "" {
+			entries = append(entries, HistoryEntry{Line: line, Start: start})
+		}
+		start = time.Time{}
+	}
+	return entries
+}
+
+// unmetafyZsh undoes the metafication of bytes zsh does in history files,
+// where some bytes are written as 0x83 followed by the byte xor 0x20.
+func unmetafyZsh(s string) string {
+	if strings.IndexByte(s, 0x83) == -1 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x83 && i+1 < len(s) {
+			i++
+			b = append(b, s[i]^0x20)
+		} else {
+			b = append(b, s[i])
+		}
+	}
+	return string(b)
+}
+
+// parseZshHistory parses a history file of zsh. Entries of the extended
+// format look like ": <start>:<seconds run>;<line>". Lines ending with a
+// backslash are continued on the next line.
+func parseZshHistory(data string) []HistoryEntry {
+	var entries []HistoryEntry
+	lines := strings.Split(unmetafyZsh(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + "\n" + lines[i]
+		}
+		var e HistoryEntry
+		if semi := strings.IndexByte(line, ';'); strings.HasPrefix(line, ": ") && semi != -1 {
+			fields := strings.SplitN(line[2:semi], ":", 2)
+			if t, ok := parseUnix(fields[0]); ok && len(fields) == 2 {
+				e.Start = t
+				if d, err := strconv.Atoi(fields[1]); err == nil {
+					e.Duration = time.Duration(d) * time.Second
+				}
+				line = line[semi+1:]
+			}
+		}
+		if line != "" {
+			e.Line = line
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// parseFishHistory parses a history file of fish, a subset of YAML with
+// entries like "- cmd: <line>" followed by "  when: <start>". Newlines and
+// backslashes in lines are escaped as \n and \\.
+func parseFishHistory(data string) []HistoryEntry {
+	var entries []HistoryEntry
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "- cmd: ") {
+			entries = append(entries, HistoryEntry{Line: unescapeFish(line[7:])})
+		} else if strings.HasPrefix(line, "  when: ") && len(entries) > 0 {
+			if t, ok := parseUnix(line[8:]); ok {
+				entries[len(entries)-1].Start = t
+			}
+		}
+	}
+	return entries
+}
+
+func unescapeFish(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				b = append(b, '\n')
+			case '\\':
+				b = append(b, '\\')
+			default:
+				b = append(b, '\\', s[i])
+			}
+		} else {
+			b = append(b, s[i])
+		}
+	}
+	return string(b)
+}
+
+// importHistory adds entries not in the history yet to it, in the order they
+// started, and to the history file. It returns how many are added.
+func (ed *Editor) importHistory(entries []HistoryEntry) (int, error) {
+	type key struct {
+		line  string
+		start int64
+	}
+	seen := make(map[key]bool)
+	for _, e := range ed.histories {
+		seen[key{e.Line, e.Start.Unix()}] = true
+	}
+	var imported []HistoryEntry
+	for _, e := range entries {
+		k := key{e.Line, e.Start.Unix()}
+		if !seen[k] {
+			seen[k] = true
+			imported = append(imported, e)
+		}
+	}
+	if len(imported) == 0 {
+		return 0, nil
+	}
+
+	// Entries of this session stay at the end
+	var others, local []HistoryEntry
+	for _, e := range ed.histories {
+		if e.local {
+			local = append(local, e)
+		} else {
+			others = append(others, e)
+		}
+	}
+	others = append(others, imported...)
+	sort.Stable(historyByStart(others))
+	ed.histories = append(others, local...)
+	if ed.args == nil {
+		ed.args = make(argIndex)
+	}
+	for _, e := range imported {
+		ed.args.add(e.Line)
+	}
+	if ed.historyFile == "" {
+		return len(imported), nil
+	}
+	return len(imported), ed.writeHistoryEntries(imported...)
+}
+
+// builtinHistoryImport implements the le:history-import builtin. It takes the
+// name of a shell, bash, zsh or fish, and optionally the history file to
+// import, which defaults to where the shell keeps it.
+func builtinHistoryImport(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 1 && len(args) != 2 {
+		return "args error"
+	}
+	shell := args[0].String()
+	parse, ok := historyImporters[shell]
+	if !ok {
+		return fmt.Sprintf("cannot import history of %s", shell)
+	}
+	fname := shellHistoryFile(shell)
+	if len(args) == 2 {
+		fname = args[1].String()
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err.Error()
+	}
+	n, err := ed.importHistory(parse(string(data)))
+	if err != nil {
+		return err.Error()
+	}
+	fmt.Fprintf(ev.OutFile(), "imported %d entries from %s\n", n, fname)
+	return ""
+}
@@ -0,0 +1,46 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var historyImportTests = []struct {
+	shell string
+	data  string
+	want  []HistoryEntry
+}{
+	{"bash", "ls\n#1500000000\nmake\n#not a timestamp\n", []HistoryEntry{
+		{Line: "ls"}, {Line: "make", Start: time.Unix(1500000000, 0)},
+		{Line: "#not a timestamp"}}},
+	{"zsh", ": 1500000000:3;make test\n: 1500000010:0;echo a\\\nb\nls\n", []HistoryEntry{
+		{Line: "make test", Start: time.Unix(1500000000, 0), Duration: 3 * time.Second},
+		{Line: "echo a\nb", Start: time.Unix(1500000010, 0)}, {Line: "ls"}}},
+	{"zsh", "echo \x83\xa2\n", []HistoryEntry{{Line: "echo \x82"}}},
+	{"fish", "- cmd: echo a\\nb\\\\c\n  when: 1500000000\n  paths:\n    - a\n- cmd: ls\n",
+		[]HistoryEntry{{Line: "echo a\nb\\c", Start: time.Unix(1500000000, 0)}, {Line: "ls"}}},
+}
+
+func TestHistoryImport(t *testing.T) {
+	for _, tt := range historyImportTests {
+		got := historyImporters[tt.shell](tt.data)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("importing %q from %s => %v, want %v", tt.data, tt.shell, got, tt.want)
+		}
+	}
+
+	ed := &Editor{options: newOptions()}
+	ed.histories = []HistoryEntry{{Line: "pwd", Start: time.Unix(1500000005, 0)}}
+	ed.appendHistory("cd")
+	entries := parseZshHistory(": 1500000000:0;make\n: 1500000010:0;ls\n")
+	if n, _ := ed.importHistory(entries); n != 2 {
+		t.Errorf("imported %d entries, want 2", n)
+	}
+	if n, _ := ed.importHistory(entries); n != 0 {
+		t.Errorf("imported %d entries again, want 0", n)
+	}
+	if lines := historyLines(ed); lines != "make pwd ls cd" {
+		t.Errorf("history after importing => %q, want \"make pwd ls cd\"", lines)
+	}
+}
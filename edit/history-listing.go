@@ -0,0 +1,130 @@
+package edit
+
+import "strings"
+
+// History listing mode, started with Alt-r in insert mode, lists the history
+// with the most recent entries first, each line only once, with when it was
+// run. Typing filters the list fuzzily like in the palette. Tab selects or
+// unselects an entry; Enter puts the selected entries into the buffer, joined
+// with "; " in the order they were selected, or the current entry if none is
+// selected, for editing.
+
+// historyListing keeps the status of history listing mode.
+type historyListing struct {
+	filter string
+	// The entries matching the filter, the most recent first.
+	entries []HistoryEntry
+	current int
+	// The selected lines, in the order they were selected.
+	selected []string
+}
+
+// historyTimeFormat is how the start times of entries are shown.
+const historyTimeFormat = "2006-01-02 15:04"
+
+// setFilter sets the filter, and finds the entries of histories matching it.
+func (hl *historyListing) setFilter(ed *Editor, filter string) {
+	hl.filter = filter
+	hl.entries = nil
+	hl.current = 0
+	seen := make(map[string]bool)
+	for i := len(ed.histories) - 1; i >= 0; i-- {
+		e := ed.histories[i]
+		if seen[e.Line] || !ed.inHistoryScope(i) {
+			continue
+		}
+		seen[e.Line] = true
+		if ok, _ := fuzzyMatch(e.Line, filter); ok {
+			hl.entries = append(hl.entries, e)
+		}
+	}
+}
+
+// selectedIndex returns where line is in the selected lines, or -1.
+func (hl *historyListing) selectedIndex(line string) int {
+	for i, s := range hl.selected {
+		if s == line {
+			return i
+		}
+	}
+	return -1
+}
+
+func startHistoryListing(ed *Editor, k Key) *leReturn {
+	ed.reloadHistory()
+	ed.history = historyState{}
+	ed.historyListing = &historyListing{}
+	ed.historyListing.setFilter(ed, "")
+	ed.mode = modeHistoryListing
+	return nil
+}
+
+func selectHistoryListingUp(ed *Editor, k Key) *leReturn {
+	if ed.historyListing.current > 0 {
+		ed.historyListing.current--
+	}
+	return nil
+}
+
+func selectHistoryListingDown(ed *Editor, k Key) *leReturn {
+	hl := ed.historyListing
+	if hl.current < len(hl.entries)-1 {
+		hl.current++
+	}
+	return nil
+}
+
+// toggleHistoryListing selects the current entry, or unselects it if it is
+// selected, and moves on to the next one.
+func toggleHistoryListing(ed *Editor, k Key) *leReturn {
+	hl := ed.historyListing
+	if len(hl.entries) == 0 {
+		return nil
+	}
+	line := hl.entries[hl.current].Line
+	if i := hl.selectedIndex(line); i != -1 {
+		hl.selected = append(hl.selected[:i], hl.selected[i+1:]...)
+	} else {
+		hl.selected = append(hl.selected, line)
+	}
+	return selectHistoryListingDown(ed, k)
+}
+
+func killHistoryListingRuneLeft(ed *Editor, k Key) *leReturn {
+	hl := ed.historyListing
+	runes := []rune(hl.filter)
+	if len(runes) > 0 {
+		hl.setFilter(ed, string(runes[:len(runes)-1]))
+	}
+	return nil
+}
+
+func acceptHistoryListing(ed *Editor, k Key) *leReturn {
+	hl := ed.historyListing
+	switch {
+	case len(hl.selected) > 0:
+		ed.line = strings.Join(hl.selected, "; ")
+	case len(hl.entries) > 0:
+		ed.line = hl.entries[hl.current].Line
+	}
+	ed.dot = len(ed.line)
+	ed.historyListing = nil
+	ed.mode = modeInsert
+	return nil
+}
+
+func cancelHistoryListing(ed *Editor, k Key) *leReturn {
+	ed.historyListing = nil
+	ed.mode = modeInsert
+	return nil
+}
+
+func defaultHistoryListing(ed *Editor, k Key) *leReturn {
+	hl := ed.historyListing
+	if k.Mod == 0 && k.Rune > 0 {
+		hl.setFilter(ed, hl.filter+string(k.Rune))
+	} else {
+		ed.pushTip(ed.trf("Unbound: %s", k))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package edit
+
+import "testing"
+
+var historyListingTests = []struct {
+	keys   []Key
+	wanted string
+}{
+	// The most recent entry, each line only once
+	{[]Key{{Enter, 0}}, "make test"},
+	{[]Key{{Down, 0}, {Enter, 0}}, "ls"},
+	{[]Key{{'i', 0}, {'n', 0}, {Enter, 0}}, "make install"},
+	// Selected entries are joined in the order they are selected
+	{[]Key{{Down, 0}, {Tab, 0}, {Up, 0}, {Up, 0}, {Tab, 0}, {Enter, 0}}, "ls; make test"},
+	// Selecting twice unselects, leaving the current entry
+	{[]Key{{Tab, 0}, {Up, 0}, {Tab, 0}, {Enter, 0}}, "ls"},
+	{[]Key{{'[', Ctrl}}, "echo"},
+}
+
+func TestHistoryListing(t *testing.T) {
+	for _, tt := range historyListingTests {
+		ed := &Editor{options: newOptions(), histories: []HistoryEntry{
+			{Line: "make install"}, {Line: "make test"}, {Line: "ls"}, {Line: "make test"}}}
+		ed.line, ed.dot = "echo", 4
+		keys := append([]Key{{'r', Alt}}, tt.keys...)
+		for _, k := range keys {
+			ed.handleRead(keyRead(k))
+		}
+		if ed.line != tt.wanted || ed.mode != modeInsert {
+			t.Errorf("keys %v => %q, mode %d, want %q, mode %d",
+				keys, ed.line, ed.mode, tt.wanted, modeInsert)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package edit
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Lines can be kept out of the history, so that it stays free of repetition
+// and of secrets. The policies are applied when a line is appended:
+// le:history-dedup drops repeated lines, le:history-ignore-space drops lines
+// starting with a space, and le:history-ignore drops lines matching any of a
+// list of regular expressions, like ones containing PASSWORD=.
+
+func init() {
+	addEditorBuiltin("le:history-dedup", builtinHistoryDedup)
+	addEditorBuiltin("le:history-ignore-space", builtinHistoryIgnoreSpace)
+	addEditorBuiltin("le:history-ignore", builtinHistoryIgnore)
+}
+
+// ignoredInHistory returns whether line is kept out of the history.
+func (o *options) ignoredInHistory(line string) bool {
+	if o.historyIgnoreSpace && line != "" && line[0] == ' ' {
+		return true
+	}
+	for _, re := range o.historyIgnore {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupHistory returns histories prepared for line to be appended, and whether
+// it should be, following the policy set with le:history-dedup.
+func (o *options) dedupHistory(histories []HistoryEntry, line string) ([]HistoryEntry, bool) {
+	switch o.historyDedup {
+	case "consecutive":
+		if n := len(histories); n > 0 && histories[n-1].Line == line {
+			return histories, false
+		}
+	case "all":
+		kept := histories[:0]
+		for _, h := range histories {
+			if h.Line != line {
+				kept = append(kept, h)
+			}
+		}
+		return kept, true
+	}
+	return histories, true
+}
+
+// builtinHistoryDedup implements the le:history-dedup builtin. With no
+// arguments, it prints how repeated lines are dropped from the history. With
+// one argument, off, consecutive or all, it sets that.
+func builtinHistoryDedup(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.historyDedup)
+		return ""
+	case 1:
+		switch dedup := args[0].String(); dedup {
+		case "off", "consecutive", "all":
+			ed.historyDedup = dedup
+			return ""
+		}
+		return "args error"
+	default:
+		return "args error"
+	}
+}
+
+// builtinHistoryIgnoreSpace implements the le:history-ignore-space builtin.
+// With no arguments, it prints whether lines starting with a space are kept
+// out of the history. With one argument, on or off, it sets that.
+func builtinHistoryIgnoreSpace(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.historyIgnoreSpace))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.historyIgnoreSpace = true
+		case "off":
+			ed.historyIgnoreSpace = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinHistoryIgnore implements the le:history-ignore builtin. With no
+// arguments, it prints the patterns of lines kept out of the history, one per
+// line. Otherwise it sets the patterns to its arguments, which are regular
+// expressions; a single empty argument clears them.
+func builtinHistoryIgnore(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) == 0 {
+		out := ev.OutFile()
+		for _, re := range ed.historyIgnore {
+			fmt.Fprintln(out, re)
+		}
+		return ""
+	}
+	if len(args) == 1 && args[0].String() == "" {
+		ed.historyIgnore = nil
+		return ""
+	}
+	var patterns []*regexp.Regexp
+	for _, arg := range args {
+		re, err := regexp.Compile(arg.String())
+		if err != nil {
+			return err.Error()
+		}
+		patterns = append(patterns, re)
+	}
+	ed.historyIgnore = patterns
+	return ""
+}
@@ -0,0 +1,43 @@
+package edit
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var historyPolicyTests = []struct {
+	dedup       string
+	ignoreSpace bool
+	ignore      []string
+	lines       []string
+	want        []string
+}{
+	{"off", false, nil, []string{"ls", "ls", " pwd"}, []string{"ls", "ls", " pwd"}},
+	{"consecutive", false, nil, []string{"ls", "ls", "pwd", "ls"}, []string{"ls", "pwd", "ls"}},
+	{"all", false, nil, []string{"ls", "pwd", "ls", "make"}, []string{"pwd", "ls", "make"}},
+	{"off", true, nil, []string{"ls", " secret", "pwd"}, []string{"ls", "pwd"}},
+	{"off", false, []string{"PASSWORD=", "^rm "},
+		[]string{"PASSWORD=x login", "rm -rf x", "ls"}, []string{"ls"}},
+}
+
+func TestHistoryPolicy(t *testing.T) {
+	for _, tt := range historyPolicyTests {
+		ed := &Editor{options: newOptions()}
+		ed.historyDedup, ed.historyIgnoreSpace = tt.dedup, tt.ignoreSpace
+		for _, p := range tt.ignore {
+			ed.historyIgnore = append(ed.historyIgnore, regexp.MustCompile(p))
+		}
+		for _, line := range tt.lines {
+			ed.appendHistory(line)
+		}
+		var got []string
+		for _, h := range ed.histories {
+			got = append(got, h.Line)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("appending %q with %s, %v, %q => %q, want %q",
+				tt.lines, tt.dedup, tt.ignoreSpace, tt.ignore, got, tt.want)
+		}
+	}
+}
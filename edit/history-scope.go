@@ -0,0 +1,114 @@
+package edit
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// History recall can be scoped to the project of the current directory, so
+// that working in one repository does not bring up commands from another.
+// The project of a directory is its nearest ancestor, or itself, containing
+// one of the project markers of the editor, .git and .elvish-project unless
+// Config.ProjectMarkers is set. Outside of projects, all of the history is
+// recalled.
+// Recall can also be restricted to the current directory itself, which
+// toggle-dir-history turns on and off.
+
+// defaultProjectMarkers are the names of files or directories marking project
+// roots, unless Config.ProjectMarkers is set.
+var defaultProjectMarkers = []string{".git", ".elvish-project"}
+
+func init() {
+	addEditorBuiltin("le:history-scope", builtinHistoryScope)
+}
+
+// projectRoot returns the project root of dir, or "" if dir is not in a
+// project.
+func (o *options) projectRoot(dir string) string {
+	if !path.IsAbs(dir) {
+		return ""
+	}
+	for {
+		for _, marker := range o.projectMarkers {
+			if _, err := os.Stat(path.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// inHistoryScope returns whether the history entry i is recalled in history
+// mode. Project roots are cached in the historyState, which is reset by
+// start-history.
+func (ed *Editor) inHistoryScope(i int) bool {
+	if ed.historyScope == "global" {
+		return true
+	}
+	h := &ed.history
+	if h.roots == nil {
+		h.roots = make(map[string]string)
+		h.wd, _ = os.Getwd()
+		h.root = ed.projectRoot(h.wd)
+	}
+	if ed.historyScope == "directory" {
+		return ed.histories[i].Dir == h.wd
+	}
+	if h.root == "" {
+		return true
+	}
+	dir := ed.histories[i].Dir
+	root, ok := h.roots[dir]
+	if !ok {
+		root = ed.projectRoot(dir)
+		h.roots[dir] = root
+	}
+	return root == h.root
+}
+
+// toggleDirHistory restricts history recall to the current directory, or goes
+// back to the scope before. In history mode, the entry shown is changed to one
+// in the new scope.
+func toggleDirHistory(ed *Editor, k Key) *leReturn {
+	if ed.historyScope == "directory" {
+		ed.historyScope = ed.scopeBeforeDirectory
+		ed.pushTip(ed.tr("history recall not restricted to this directory"))
+	} else {
+		ed.scopeBeforeDirectory = ed.historyScope
+		ed.historyScope = "directory"
+		ed.pushTip(ed.tr("history recall restricted to this directory"))
+	}
+	if ed.mode == modeHistory && !ed.inHistoryScope(ed.history.current) &&
+		!ed.prevHistory() && !ed.nextHistory() {
+		ed.mode = modeInsert
+		ed.pushTip(ed.tr("no matching history item"))
+	}
+	return nil
+}
+
+// builtinHistoryScope implements the le:history-scope builtin. With no
+// arguments, it prints the scope of history recall. With one argument, global,
+// project or directory, it sets the scope.
+func builtinHistoryScope(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.historyScope)
+		return ""
+	case 1:
+		switch scope := args[0].String(); scope {
+		case "global", "project", "directory":
+			ed.historyScope = scope
+			return ""
+		}
+		return "args error"
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,64 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestHistoryScope(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "elvish-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	proj, other := path.Join(tmp, "proj"), path.Join(tmp, "other")
+	for _, dir := range []string{path.Join(proj, ".git"), path.Join(proj, "sub"), other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if root := newOptions().projectRoot(path.Join(proj, "sub")); root != proj {
+		t.Errorf("projectRoot(proj/sub) => %q, want %q", root, proj)
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	ed := &Editor{options: newOptions(), histories: []HistoryEntry{
+		{Line: "make", Dir: proj}, {Line: "ls", Dir: other},
+		{Line: "git log", Dir: path.Join(proj, "sub")}, {Line: "top", Dir: other}}}
+	ed.historyScope = "project"
+	var recalled []string
+	os.Chdir(proj)
+	startHistory(ed, ZeroKey)
+	for {
+		recalled = append(recalled, ed.histories[ed.history.current].Line)
+		if !ed.prevHistory() {
+			break
+		}
+	}
+	if len(recalled) != 2 || recalled[0] != "git log" || recalled[1] != "make" {
+		t.Errorf("recalled %q in the project, want [\"git log\" \"make\"]", recalled)
+	}
+
+	// Outside projects, everything is recalled
+	os.Chdir(other)
+	startHistory(ed, ZeroKey)
+	if line := ed.histories[ed.history.current].Line; line != "top" {
+		t.Errorf("recalled %q outside projects, want \"top\"", line)
+	}
+
+	// Restricted to the directory, and back
+	os.Chdir(proj)
+	startHistory(ed, ZeroKey)
+	toggleDirHistory(ed, ZeroKey)
+	if line := ed.histories[ed.history.current].Line; ed.historyScope != "directory" || line != "make" {
+		t.Errorf("recalled %q with scope %s after toggle-dir-history, want \"make\" with directory",
+			line, ed.historyScope)
+	}
+	toggleDirHistory(ed, ZeroKey)
+	if ed.historyScope != "project" {
+		t.Errorf("scope %s after toggling twice, want project", ed.historyScope)
+	}
+}
@@ -0,0 +1,122 @@
+package edit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Elvish processes using the same history file share their history while
+// running. The file is locked while it is read and appended to, and entries
+// appended by other processes since its end was last seen are read at the
+// start of each ReadLine, and before history recall. By default those entries
+// are put before the ones accepted by this editor, so that recall finds
+// commands of this session first; le:history-local-first off puts all
+// entries in the order they started instead.
+
+func init() {
+	addEditorBuiltin("le:history-local-first", builtinHistoryLocalFirst)
+}
+
+// lockFile locks f with flock, with how being syscall.LOCK_SH or
+// syscall.LOCK_EX. The lock is released when f is closed.
+func lockFile(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// reloadHistory reads entries appended to the history file by other processes.
+// Errors are ignored, leaving the history as it is.
+func (ed *Editor) reloadHistory() {
+	if ed.historyFile == "" {
+		return
+	}
+	f, err := ed.openHistoryFile(os.O_RDONLY, syscall.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	ed.readNewHistory(f)
+}
+
+// readNewHistory reads the complete lines of the locked history file f after
+// historyOffset, and merges their entries into the history. A file that is not
+// the one last read, like one migrated by another process, or that is shorter
+// than historyOffset, has been replaced; only what is appended to it from then
+// on is read.
+func (ed *Editor) readNewHistory(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	replaced := ed.historyInfo != nil && !os.SameFile(info, ed.historyInfo)
+	ed.historyInfo = info
+	if replaced || size <= ed.historyOffset {
+		ed.historyOffset = size
+		return nil
+	}
+	buf := make([]byte, size-ed.historyOffset)
+	n, err := f.ReadAt(buf, ed.historyOffset)
+	if err != nil && n < len(buf) {
+		return err
+	}
+	i := bytes.LastIndexByte(buf, '\n')
+	if i == -1 {
+		return nil
+	}
+	ed.historyOffset += int64(i + 1)
+	entries, _ := parseHistory(buf[:i+1])
+	ed.mergeHistory(entries)
+	return nil
+}
+
+// mergeHistory adds entries of other sessions to the history. Each entry goes
+// before the entries of this session, or if le:history-local-first is off, only
+// before those that started after it.
+func (ed *Editor) mergeHistory(entries []HistoryEntry) {
+	if ed.args == nil {
+		ed.args = make(argIndex)
+	}
+	for _, e := range entries {
+		i := len(ed.histories)
+		for i > 0 && ed.histories[i-1].local &&
+			(ed.historyLocalFirst || ed.histories[i-1].Start.After(e.Start)) {
+			i--
+		}
+		ed.histories = append(ed.histories, HistoryEntry{})
+		copy(ed.histories[i+1:], ed.histories[i:])
+		ed.histories[i] = e
+		ed.args.add(e.Line)
+	}
+}
+
+// builtinHistoryLocalFirst implements the le:history-local-first builtin. With
+// no arguments, it prints whether entries of this session are recalled before
+// those of other sessions. With one argument, on or off, it sets that.
+func builtinHistoryLocalFirst(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.historyLocalFirst))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.historyLocalFirst = true
+		case "off":
+			ed.historyLocalFirst = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
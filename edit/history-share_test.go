@@ -0,0 +1,88 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func historyLines(ed *Editor) string {
+	var lines []string
+	for _, e := range ed.histories {
+		lines = append(lines, e.Line)
+	}
+	return strings.Join(lines, " ")
+}
+
+func TestSharedHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "history")
+
+	for _, localFirst := range []bool{true, false} {
+		ioutil.WriteFile(fname, nil, 0600)
+		ed1, ed2 := &Editor{options: newOptions()}, &Editor{options: newOptions()}
+		ed1.historyLocalFirst, ed2.historyLocalFirst = localFirst, localFirst
+		ed1.LoadHistory(fname)
+		ed2.LoadHistory(fname)
+
+		ed2.appendHistory("b")
+		ed2.CommandFinished(0)
+		ed1.appendHistory("a")
+		ed1.CommandFinished(0)
+		ed2.reloadHistory()
+
+		if lines := historyLines(ed1); lines != "b a" {
+			t.Errorf("history of session 1 => %q, want \"b a\"", lines)
+		}
+		want := "a b"
+		if !localFirst {
+			want = "b a"
+		}
+		if lines := historyLines(ed2); lines != want {
+			t.Errorf("history of session 2 with local-first %v => %q, want %q",
+				localFirst, lines, want)
+		}
+	}
+}
+
+func TestReplacedHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "history")
+
+	ioutil.WriteFile(fname, nil, 0600)
+	ed := &Editor{options: newOptions()}
+	ed.LoadHistory(fname)
+	ed.appendHistory("a")
+	ed.CommandFinished(0)
+
+	// A file replaced by renaming, as when migrated, is told apart by its
+	// identity even when it is no shorter
+	data, err := formatHistory([]HistoryEntry{{Line: "a"}, {Line: "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(fname+".new", data, 0600)
+	os.Rename(fname+".new", fname)
+	ed.reloadHistory()
+	if lines := historyLines(ed); lines != "a" {
+		t.Errorf("history after the file is replaced => %q, want \"a\"", lines)
+	}
+
+	ed.appendHistory("b")
+	ed.CommandFinished(0)
+	loaded := &Editor{options: newOptions()}
+	loaded.LoadHistory(fname)
+	if lines := historyLines(loaded); lines != "a c b" {
+		t.Errorf("replaced file after appending => %q, want \"a c b\"", lines)
+	}
+}
@@ -0,0 +1,168 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Hooks are run by ReadLine at three points: before-readline hooks when it
+// starts, before the prompt is shown; on-accept hooks when a line is
+// accepted, which can rewrite it; and after-readline hooks with the final
+// line, just before ReadLine returns it to be executed.
+//
+// Go programs add hooks with the AddXxx methods of Editor. In elvish, le:hook
+// adds a command as a hook; it is called with the line as its argument,
+// except for before-readline hooks, and the value an on-accept hook outputs,
+// if it outputs one, replaces the line. Panics of hooks, and errors of those
+// added in elvish, are shown as tips at the next prompt.
+
+// hookKinds are the kinds of hooks, in the order they are run.
+var hookKinds = []string{"before-readline", "on-accept", "after-readline"}
+
+func init() {
+	addEditorBuiltin("le:hook", builtinHook)
+	addEditorBuiltin("le:unhook", builtinUnhook)
+}
+
+// AddBeforeReadline adds a function called at the start of each ReadLine.
+func (ed *Editor) AddBeforeReadline(f func()) {
+	ed.beforeReadline = append(ed.beforeReadline, f)
+}
+
+// AddOnAccept adds a function called with each line accepted. The line it
+// returns replaces the accepted one.
+func (ed *Editor) AddOnAccept(f func(line string) string) {
+	ed.onAccept = append(ed.onAccept, f)
+}
+
+// AddAfterReadline adds a function called with each line ReadLine returns,
+// after on-accept functions.
+func (ed *Editor) AddAfterReadline(f func(line string)) {
+	ed.afterReadline = append(ed.afterReadline, f)
+}
+
+// runHook calls f, recording a panic as an error of the hook of kind.
+func (ed *Editor) runHook(kind string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			ed.hookErrors = append(ed.hookErrors, ed.trf("%s hook: %v", kind, r))
+		}
+	}()
+	f()
+}
+
+// runUserHook calls the command added as a hook of kind, with args, and
+// returns the values it outputs. It is evaluated apart, so that $status is
+// still that of the last command of the user; a failure is recorded as an
+// error of the hook instead.
+func (ed *Editor) runUserHook(kind, command string, args ...string) []eval.Value {
+	src := command
+	for _, arg := range args {
+		src += " " + eval.NewString(arg).Repr()
+	}
+	values, status, err := ed.ev.EvalSourceApart(fmt.Sprintf("<%s hook>", kind), src)
+	if err != nil {
+		ed.hookErrors = append(ed.hookErrors, ed.trf("%s hook %s: %s", kind, command, err))
+	} else if status != eval.ExitOK {
+		ed.hookErrors = append(ed.hookErrors, ed.trf("%s hook %s: exited with %d", kind, command, status))
+	}
+	return values
+}
+
+// runBeforeReadline runs the before-readline hooks, and shows the errors of
+// hooks since the last prompt as tips.
+func (ed *Editor) runBeforeReadline() {
+	for _, f := range ed.beforeReadline {
+		ed.runHook("before-readline", f)
+	}
+	for _, command := range ed.userHooks["before-readline"] {
+		ed.runHook("before-readline", func() { ed.runUserHook("before-readline", command) })
+	}
+	for _, msg := range ed.hookErrors {
+		ed.pushTip(msg)
+	}
+	ed.hookErrors = nil
+}
+
+// runAcceptHooks runs the on-accept hooks and then the after-readline hooks
+// on line, and returns the final line.
+func (ed *Editor) runAcceptHooks(line string) string {
+	for _, f := range ed.onAccept {
+		ed.runHook("on-accept", func() { line = f(line) })
+	}
+	for _, command := range ed.userHooks["on-accept"] {
+		ed.runHook("on-accept", func() {
+			if values := ed.runUserHook("on-accept", command, line); len(values) == 1 {
+				line = values[0].String()
+			}
+		})
+	}
+	for _, f := range ed.afterReadline {
+		ed.runHook("after-readline", func() { f(line) })
+	}
+	for _, command := range ed.userHooks["after-readline"] {
+		ed.runHook("after-readline", func() { ed.runUserHook("after-readline", command, line) })
+	}
+	return line
+}
+
+func isHookKind(kind string) bool {
+	for _, k := range hookKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinHook implements the le:hook builtin. With no arguments, it prints
+// the commands added as hooks, by kind. With one argument, the kind of hooks,
+// it prints the commands added as hooks of that kind, one per line. With two,
+// it adds the command as a hook of the kind.
+func builtinHook(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	out := ev.OutFile()
+	switch len(args) {
+	case 0:
+		for _, kind := range hookKinds {
+			fmt.Fprintf(out, "%s: %s\n", kind, strings.Join(ed.userHooks[kind], " "))
+		}
+		return ""
+	case 1, 2:
+		kind := args[0].String()
+		if !isHookKind(kind) {
+			return fmt.Sprintf("no hook kind named %s", kind)
+		}
+		if len(args) == 1 {
+			for _, command := range ed.userHooks[kind] {
+				fmt.Fprintln(out, command)
+			}
+			return ""
+		}
+		ed.userHooks[kind] = append(ed.userHooks[kind], args[1].String())
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinUnhook implements the le:unhook builtin. It removes a command added
+// as a hook of a kind.
+func builtinUnhook(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 2 {
+		return "args error"
+	}
+	kind, command := args[0].String(), args[1].String()
+	if !isHookKind(kind) {
+		return fmt.Sprintf("no hook kind named %s", kind)
+	}
+	commands := ed.userHooks[kind]
+	for i, c := range commands {
+		if c == command {
+			ed.userHooks[kind] = append(commands[:i:i], commands[i+1:]...)
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s is not a hook of %s", command, kind)
+}
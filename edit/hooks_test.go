@@ -0,0 +1,56 @@
+package edit
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+func TestHooks(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	ev := eval.NewEvaluator()
+	ev.SetPorts(devNull, devNull, devNull)
+	ev.SetStatusCallback(nil)
+	ed := &Editor{options: newOptions(), ev: ev}
+	ed.userHooks = map[string][]string{"on-accept": {"put"}, "after-readline": {"nosuch", "cd /nonexistent-dir"}}
+	var logged []string
+	ed.AddOnAccept(func(line string) string { return line + "!" })
+	ed.AddOnAccept(func(line string) string { panic("oops") })
+	ed.AddAfterReadline(func(line string) { logged = append(logged, line) })
+	before := 0
+	ed.AddBeforeReadline(func() { before++ })
+	// The status of the last command of the user
+	ev.SetStatus(3)
+
+	if line := ed.runAcceptHooks("ls"); line != "ls!" {
+		t.Errorf("runAcceptHooks(%q) => %q, want %q", "ls", line, "ls!")
+	}
+	if len(logged) != 1 || logged[0] != "ls!" {
+		t.Errorf("after-readline hook called with %q, want [\"ls!\"]", logged)
+	}
+	if ev.Status() != 3 {
+		t.Errorf("status after user hooks => %v, want 3", ev.Status())
+	}
+
+	ed.runBeforeReadline()
+	if before != 1 {
+		t.Errorf("before-readline hook called %d times, want 1", before)
+	}
+	var tips []string
+	for _, tip := range ed.tips {
+		tips = append(tips, tip.String())
+	}
+	if len(tips) != 3 || !strings.Contains(tips[0], "oops") || !strings.Contains(tips[1], "nosuch") ||
+		!strings.Contains(tips[2], "exited with 1") {
+		t.Errorf("tips after hooks failing => %q, want the panic, the error and the failure", tips)
+	}
+	if ed.hookErrors != nil {
+		t.Errorf("hook errors not cleared after shown: %q", ed.hookErrors)
+	}
+}
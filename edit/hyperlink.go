@@ -0,0 +1,26 @@
+package edit
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// File names in the completion listing and navigation mode are hyperlinks
+// (OSC 8) to file:// URLs on terminals supporting them, so that they can be
+// opened with Ctrl-click or the like. See the hyperlinks feature.
+
+// linkHost is the host of file:// URLs, so that terminals can tell files on
+// remote hosts from local ones.
+var linkHost, _ = os.Hostname()
+
+// fileURL returns the file:// URL of the named file, or "" if its absolute
+// path is unknown.
+func fileURL(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return ""
+	}
+	u := url.URL{Scheme: "file", Host: linkHost, Path: abs}
+	return u.String()
+}
@@ -0,0 +1,20 @@
+package edit
+
+import "testing"
+
+var fileURLTests = []struct {
+	name   string
+	wanted string
+}{
+	{"/", "file://" + linkHost + "/"},
+	{"/tmp/a b", "file://" + linkHost + "/tmp/a%20b"},
+	{"/tmp/a#b?", "file://" + linkHost + "/tmp/a%23b%3F"},
+}
+
+func TestFileURL(t *testing.T) {
+	for _, tt := range fileURLTests {
+		if out := fileURL(tt.name); out != tt.wanted {
+			t.Errorf("fileURL(%q) => %q, want %q", tt.name, out, tt.wanted)
+		}
+	}
+}
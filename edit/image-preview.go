@@ -0,0 +1,433 @@
+package edit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xiaq/elvish/edit/tty"
+	"github.com/xiaq/elvish/util"
+)
+
+// When the file selected in navigation mode is an image and the terminal
+// supports sixel graphics or the kitty graphics protocol, a thumbnail of it
+// is shown in the preview column, scaled down to fit the column while keeping
+// its aspect ratio. The size of the image in cells is worked out from the
+// pixel size of the cells the terminal reports, or guessed when it doesn't.
+//
+// The image is drawn over blank cells by commitBuffer, after the text. Sixel
+// images are just pixels on the screen, which writing the row erases, so they
+// are drawn again whenever a row under them is repainted, and the rows under
+// an image gone are repainted to erase it. Images of the kitty protocol sit
+// above the text, and are deleted explicitly.
+
+// Limits on the images previewed, so that huge ones don't stall the editor.
+const (
+	MaxImagePreviewFileSize = 32 << 20
+	MaxImagePreviewPixels   = 48 << 20
+)
+
+// The cell size assumed when the terminal doesn't report it.
+const (
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+)
+
+// imageExts are the extensions of files previewed as images, which are those
+// of formats the standard library decodes.
+var imageExts = []string{".gif", ".jpeg", ".jpg", ".png"}
+
+func isImageFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range imageExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// graphicsProtocol is the protocol images are sent to the terminal with.
+type graphicsProtocol int
+
+// Possible values for graphicsProtocol.
+const (
+	noGraphics graphicsProtocol = iota
+	sixelGraphics
+	kittyGraphics
+)
+
+// graphics returns the graphics protocol used with caps. The kitty protocol
+// is preferred, since it sends images compressed and in full color.
+func (caps capabilities) graphics() graphicsProtocol {
+	switch {
+	case caps.dumb:
+		return noGraphics
+	case caps.kittyGraphics:
+		return kittyGraphics
+	case caps.sixel:
+		return sixelGraphics
+	default:
+		return noGraphics
+	}
+}
+
+// kittyImageID is the id of the images sent with the kitty protocol. There is
+// at most one image on the screen, and it is deleted by this id. It is not a
+// small number, so that it is unlikely to be used by other programs.
+const kittyImageID = 17740
+
+// kittyChunkSize is the maximum size of the base64 payload of each escape
+// sequence an image is sent in with the kitty protocol.
+const kittyChunkSize = 4096
+
+// kittyDeleteImage deletes the image with kittyImageID, without replying.
+var kittyDeleteImage = fmt.Sprintf("\033_Ga=d,d=I,i=%d,q=2\033\\", kittyImageID)
+
+// bufImage is an image placed in a buffer.
+type bufImage struct {
+	protocol graphicsProtocol
+	// key identifies the image and its size; images with the same key look
+	// the same.
+	key string
+	// The escape sequence that draws the image at the cursor.
+	seq string
+	// The position of the top left corner, and the number of lines covered.
+	line, col, rows int
+}
+
+// same returns whether img and img2 look the same at the same place. Either
+// may be nil.
+func (img *bufImage) same(img2 *bufImage) bool {
+	if img == nil || img2 == nil {
+		return img == img2
+	}
+	return img.protocol == img2.protocol && img.key == img2.key &&
+		img.line == img2.line && img.col == img2.col
+}
+
+// covers returns whether img covers part of line i.
+func (img *bufImage) covers(i int) bool {
+	return img != nil && img.line <= i && i < img.line+img.rows
+}
+
+// navImage is the preview of an image file in navigation mode. Decoding,
+// scaling and encoding it can take long, so they are done in the background
+// by loadNavImage, for the box the latest render wanted to place it in; until
+// the encoding for that box is ready, a placeholder is shown. The decoded
+// image and the latest encoding are kept.
+type navImage struct {
+	path    string
+	modTime time.Time
+	img     image.Image
+	err     error
+	// The placement wanted by the latest render, the one being loaded, and
+	// the one loaded, with its encoding and the number of lines it covers
+	want, loading, loaded imagePlacement
+	seq                   string
+	rows                  int
+}
+
+// imagePlacement is a box of cols by rows cells whose size in pixels is cellW
+// by cellH, that an image is fitted into and encoded for protocol. The zero
+// value is no placement.
+type imagePlacement struct {
+	protocol                 graphicsProtocol
+	cols, rows, cellW, cellH int
+}
+
+func newNavImage(path string, fi os.FileInfo) *navImage {
+	return &navImage{path: path, modTime: fi.ModTime()}
+}
+
+// place returns the image placed in p, or nil if it cannot be decoded or
+// encoded, or if it is not loaded for p yet, in which case p is recorded for
+// loadNavImage.
+func (ni *navImage) place(p imagePlacement) *bufImage {
+	if ni.err != nil {
+		return nil
+	}
+	ni.want = p
+	if ni.loaded != p {
+		return nil
+	}
+	key := fmt.Sprintf("%s %d %v", ni.path, ni.modTime.UnixNano(), p)
+	return &bufImage{protocol: p.protocol, key: key, seq: ni.seq, rows: ni.rows}
+}
+
+// loadNavImage starts loading the image previewed in navigation mode in the
+// background, for the placement the latest render wanted, unless it is loaded
+// or being loaded for it already.
+func (ed *Editor) loadNavImage() {
+	if ed.navigation == nil {
+		return
+	}
+	ni := ed.navigation.image
+	if ni == nil || ni.err != nil || ni.want == (imagePlacement{}) ||
+		ni.want == ni.loaded || ni.want == ni.loading {
+		return
+	}
+	p, img := ni.want, ni.img
+	ni.loading = p
+	go func() {
+		img, seq, rows, err := encodeNavImage(ni.path, img, p)
+		ed.post(func() {
+			if ni.loading == p {
+				ni.loading = imagePlacement{}
+			}
+			ni.img, ni.err = img, err
+			if err == nil {
+				ni.loaded, ni.seq, ni.rows = p, seq, rows
+			}
+		})
+	}()
+}
+
+// encodeNavImage fits img, the image at path, into p and encodes it. If img
+// is nil, it is decoded first, unless it is too large. It returns the decoded
+// image, the encoding and the number of lines it covers.
+func encodeNavImage(path string, img image.Image, p imagePlacement) (image.Image, string, int, error) {
+	if img == nil {
+		var err error
+		img, err = decodeImageFile(path)
+		if err != nil {
+			log.Warn("cannot preview image", "path", path, "err", err)
+			return nil, "", 0, err
+		}
+	}
+	size := img.Bounds().Size()
+	pw, ph, c, r := fitImage(size.X, size.Y, p.cols, p.rows, p.cellW, p.cellH)
+	if p.protocol == sixelGraphics && ph > 6 {
+		// Some terminals round images up to a whole band of 6 pixels
+		ph -= ph % 6
+	}
+	scaled := scaleImage(img, pw, ph)
+	var seq string
+	var err error
+	switch p.protocol {
+	case sixelGraphics:
+		seq = encodeSixel(scaled)
+	case kittyGraphics:
+		seq, err = encodeKitty(scaled, c, r)
+	}
+	if err != nil {
+		log.Warn("cannot encode image", "path", path, "err", err)
+		return nil, "", 0, err
+	}
+	return img, seq, r, nil
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > MaxImagePreviewFileSize {
+		return nil, fmt.Errorf("file larger than %d bytes", MaxImagePreviewFileSize)
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxImagePreviewPixels {
+		return nil, fmt.Errorf("image larger than %d pixels", MaxImagePreviewPixels)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// cellSize returns the size in pixels of a character cell of the terminal,
+// or a guess if it is not reported.
+func (w *writer) cellSize() (width, height int) {
+	if w.file != nil {
+		if width, height, ok := tty.CellSize(int(w.file.Fd())); ok {
+			return width, height
+		}
+	}
+	return defaultCellWidth, defaultCellHeight
+}
+
+// fitImage returns the size in pixels an image of w by h pixels is scaled to
+// to fit in a box of cols by rows cells of cellW by cellH pixels, keeping its
+// aspect ratio and never scaling it up, and the number of columns and lines
+// it covers.
+func fitImage(w, h, cols, rows, cellW, cellH int) (pw, ph, c, r int) {
+	boxW, boxH := cols*cellW, rows*cellH
+	pw, ph = w, h
+	if pw > boxW {
+		pw, ph = boxW, ph*boxW/pw
+	}
+	if ph > boxH {
+		pw, ph = pw*boxH/ph, boxH
+	}
+	if pw < 1 {
+		pw = 1
+	}
+	if ph < 1 {
+		ph = 1
+	}
+	return pw, ph, util.CeilDiv(pw, cellW), util.CeilDiv(ph, cellH)
+}
+
+// scaleImage scales img to w by h pixels. Each pixel is the average of up to
+// 4x4 samples of the area of img it covers.
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	nx, ny := samples(sw, w), samples(sh, h)
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a uint32
+			for j := 0; j < ny; j++ {
+				sy := b.Min.Y + ((y*ny+j)*2+1)*sh/(h*ny*2)
+				for i := 0; i < nx; i++ {
+					sx := b.Min.X + ((x*nx+i)*2+1)*sw/(w*nx*2)
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+				}
+			}
+			n := uint32(nx * ny)
+			k := scaled.PixOffset(x, y)
+			scaled.Pix[k] = uint8(r / n >> 8)
+			scaled.Pix[k+1] = uint8(g / n >> 8)
+			scaled.Pix[k+2] = uint8(bl / n >> 8)
+			scaled.Pix[k+3] = uint8(a / n >> 8)
+		}
+	}
+	return scaled
+}
+
+// samples returns the number of samples along an axis of from pixels scaled
+// to to pixels.
+func samples(from, to int) int {
+	n := from / to
+	if n < 1 {
+		return 1
+	} else if n > 4 {
+		return 4
+	}
+	return n
+}
+
+// encodeSixel encodes img as sixel graphics, with colors reduced to those of
+// a 6x6x6 color cube. Mostly transparent pixels are left alone.
+func encodeSixel(img *image.RGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	buf := new(bytes.Buffer)
+	// P2 = 1: pixels of color 0 stay as they are; raster attributes: 1:1
+	// aspect ratio and the size
+	fmt.Fprintf(buf, "\033P0;1;0q\"1;1;%d;%d", w, h)
+	var defined [216]bool
+	for y0 := 0; y0 < h; y0 += 6 {
+		if y0 > 0 {
+			buf.WriteByte('-')
+		}
+		// The sixels of each color in this band, in the order the colors
+		// first appear
+		bands := make(map[int][]byte)
+		var colors []int
+		for dy := 0; dy < 6 && y0+dy < h; dy++ {
+			for x := 0; x < w; x++ {
+				k := img.PixOffset(x, y0+dy)
+				if img.Pix[k+3] < 0x80 {
+					continue
+				}
+				c := 36*cubeLevel(img.Pix[k]) + 6*cubeLevel(img.Pix[k+1]) + cubeLevel(img.Pix[k+2])
+				if bands[c] == nil {
+					bands[c] = make([]byte, w)
+					colors = append(colors, c)
+				}
+				bands[c][x] |= 1 << uint(dy)
+			}
+		}
+		for i, c := range colors {
+			if i > 0 {
+				// Back to the start of the band
+				buf.WriteByte('$')
+			}
+			if defined[c] {
+				fmt.Fprintf(buf, "#%d", c)
+			} else {
+				// Colors are given in percents
+				fmt.Fprintf(buf, "#%d;2;%d;%d;%d", c, c/36*20, c/6%6*20, c%6*20)
+				defined[c] = true
+			}
+			writeSixels(buf, bands[c])
+		}
+	}
+	buf.WriteString("\033\\")
+	return buf.String()
+}
+
+// cubeLevel returns the level in the 6x6x6 color cube nearest to an 8-bit
+// color component.
+func cubeLevel(v uint8) int {
+	return (int(v)*5 + 127) / 255
+}
+
+// writeSixels writes a row of sixels, with runs of more than 3 of the same
+// sixel compressed. Trailing empty sixels are left out.
+func writeSixels(buf *bytes.Buffer, sixels []byte) {
+	end := len(sixels)
+	for end > 0 && sixels[end-1] == 0 {
+		end--
+	}
+	for i := 0; i < end; {
+		n := 1
+		for i+n < end && sixels[i+n] == sixels[i] {
+			n++
+		}
+		ch := 63 + sixels[i]
+		if n > 3 {
+			fmt.Fprintf(buf, "!%d%c", n, ch)
+		} else {
+			for k := 0; k < n; k++ {
+				buf.WriteByte(ch)
+			}
+		}
+		i += n
+	}
+}
+
+// encodeKitty encodes img as a PNG image to be sent with the kitty graphics
+// protocol and shown over cols by rows cells, in chunks of kittyChunkSize.
+// The cursor is not moved, and the terminal doesn't reply.
+func encodeKitty(img image.Image, cols, rows int) (string, error) {
+	pngBuf := new(bytes.Buffer)
+	if err := png.Encode(pngBuf, img); err != nil {
+		return "", err
+	}
+	data := base64.StdEncoding.EncodeToString(pngBuf.Bytes())
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(data); i += kittyChunkSize {
+		end := i + kittyChunkSize
+		more := 1
+		if end >= len(data) {
+			end, more = len(data), 0
+		}
+		if i == 0 {
+			fmt.Fprintf(buf, "\033_Ga=T,f=100,i=%d,c=%d,r=%d,C=1,q=2,m=%d;%s\033\\",
+				kittyImageID, cols, rows, more, data[i:end])
+		} else {
+			fmt.Fprintf(buf, "\033_Gm=%d;%s\033\\", more, data[i:end])
+		}
+	}
+	return buf.String(), nil
+}
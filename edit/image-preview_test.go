@@ -0,0 +1,196 @@
+package edit
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var fitImageTests = []struct {
+	w, h, cols, rows, cellW, cellH int
+	pw, ph, c, r                   int
+}{
+	// Small images are not scaled up
+	{30, 40, 10, 10, 10, 20, 30, 40, 3, 2},
+	// Wide images are scaled to the width of the box
+	{1000, 100, 10, 10, 10, 20, 100, 10, 10, 1},
+	// Tall images are scaled to the height of the box
+	{100, 1000, 10, 10, 10, 20, 20, 200, 2, 10},
+	// Both
+	{2000, 2000, 10, 5, 10, 20, 100, 100, 10, 5},
+	{10000, 1, 3, 3, 10, 20, 30, 1, 3, 1},
+}
+
+func TestFitImage(t *testing.T) {
+	for _, tt := range fitImageTests {
+		pw, ph, c, r := fitImage(tt.w, tt.h, tt.cols, tt.rows, tt.cellW, tt.cellH)
+		if pw != tt.pw || ph != tt.ph || c != tt.c || r != tt.r {
+			t.Errorf("fitImage(%v, %v, %v, %v, %v, %v) => %v, %v, %v, %v, want %v, %v, %v, %v",
+				tt.w, tt.h, tt.cols, tt.rows, tt.cellW, tt.cellH,
+				pw, ph, c, r, tt.pw, tt.ph, tt.c, tt.r)
+		}
+	}
+}
+
+func TestEncodeSixel(t *testing.T) {
+	// 5x7: red, with a transparent column, and a blue last row
+	img := image.NewRGBA(image.Rect(0, 0, 5, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if y == 6 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	wanted := "\033P0;1;0q\"1;1;5;7" +
+		"#180;2;100;0;0!4~" + "-#5;2;0;0;100!4@" + "\033\\"
+	if out := encodeSixel(img); out != wanted {
+		t.Errorf("encodeSixel => %q, want %q", out, wanted)
+	}
+}
+
+func TestEncodeKitty(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	// Noise that doesn't compress well, to need several chunks
+	x := uint32(1)
+	for i := range img.Pix {
+		x = x*1103515245 + 12345
+		img.Pix[i] = byte(x >> 16)
+	}
+	out, err := encodeKitty(img, 3, 2)
+	if err != nil {
+		t.Fatalf("encodeKitty => error %v", err)
+	}
+	chunks := strings.SplitAfter(out, "\033\\")
+	chunks = chunks[:len(chunks)-1]
+	if len(chunks) < 2 {
+		t.Fatalf("encodeKitty => %d chunks, want several", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[0], "\033_Ga=T,f=100,i=17740,c=3,r=2,C=1,q=2,m=1;") {
+		t.Errorf("first chunk starts %q", chunks[0][:40])
+	}
+	for i, chunk := range chunks[1:] {
+		prefix := "\033_Gm=1;"
+		if i == len(chunks)-2 {
+			prefix = "\033_Gm=0;"
+		}
+		if !strings.HasPrefix(chunk, prefix) {
+			t.Errorf("chunk %d starts %q, want %q", i+1, chunk[:8], prefix)
+		}
+	}
+}
+
+func TestCommitBufferImage(t *testing.T) {
+	f, err := ioutil.TempFile("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newWriter(f, newOptions())
+	w.caps = capabilities{color: true}
+	frame := func(current string, img *bufImage) string {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		b := w.newBuffer(20)
+		b.writes("> ", "")
+		b.dot = b.cursor()
+		for i := 0; i < 3; i++ {
+			b.newline()
+			b.writes(current, "")
+		}
+		b.image = img
+		if err := w.commitBuffer(b); err != nil {
+			t.Fatalf("commitBuffer => error %v", err)
+		}
+		out, _ := ioutil.ReadFile(f.Name())
+		return string(out)
+	}
+	kitty := func(key string) *bufImage {
+		return &bufImage{kittyGraphics, key, "<" + key + ">", 1, 10, 2}
+	}
+	sixel := func(key string) *bufImage {
+		return &bufImage{sixelGraphics, key, "<" + key + ">", 1, 10, 2}
+	}
+
+	if out := frame("a", kitty("1")); !strings.Contains(out, "\0337\033[2A\033[11G<1>\0338") {
+		t.Errorf("frame with a new image => %q, image not drawn", out)
+	}
+	if out := frame("b", kitty("1")); strings.Contains(out, "<1>") || strings.Contains(out, kittyDeleteImage) {
+		t.Errorf("frame with the same kitty image => %q, image drawn again", out)
+	}
+	if out := frame("b", kitty("2")); !strings.Contains(out, kittyDeleteImage+"\0337") || !strings.Contains(out, "<2>") {
+		t.Errorf("frame with another kitty image => %q, old image not replaced", out)
+	}
+	if out := frame("b", nil); !strings.Contains(out, kittyDeleteImage) {
+		t.Errorf("frame without the kitty image => %q, image not deleted", out)
+	}
+
+	if out := frame("b", sixel("3")); !strings.Contains(out, "<3>") {
+		t.Errorf("frame with a new sixel image => %q, image not drawn", out)
+	}
+	if out := frame("b", sixel("3")); strings.Contains(out, "<3>") {
+		t.Errorf("frame with the same sixel image => %q, image drawn again", out)
+	}
+	if out := frame("c", sixel("3")); !strings.Contains(out, "<3>") {
+		t.Errorf("frame repainting lines under a sixel image => %q, image not drawn again", out)
+	}
+	if out := frame("c", nil); strings.Count(out, "c") != 2 {
+		t.Errorf("frame without the sixel image => %q, lines under it not repainted", out)
+	}
+}
+
+func TestRenderNavigationImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	f, err := os.Create("a.png")
+	if err != nil {
+		t.Fatalf("Got error when creating image: %v", err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 400, 100)))
+	f.Close()
+
+	nav := newNavigation()
+	if nav.image == nil {
+		t.Fatalf("navigation of a.png has no image")
+	}
+	o := newOptions()
+	w := newWriter(nil, o)
+	w.caps = capabilities{color: true, sixel: true}
+	ed := &Editor{options: o, writer: w, redraws: make(chan struct{}, 1)}
+	ed.navigation = nav
+	// The image is loaded in the background, and a placeholder shown until
+	// it is ready
+	buf := w.render(&ed.editorState, nil, 80, 24)
+	if buf.image != nil || !strings.Contains(bufferString(buf), "loading image") {
+		t.Errorf("render before loading => image %v, want a placeholder", buf.image)
+	}
+	ed.loadNavImage()
+	<-ed.redraws
+	ed.runPosted()
+	buf = w.render(&ed.editorState, nil, 80, 24)
+	// The preview column of 35 columns starts at column 11 + 31 + 2, under
+	// the line; the image of 350x84 pixels is 35 by 5 cells
+	if img := buf.image; img == nil || img.protocol != sixelGraphics ||
+		img.line != 1 || img.col != 44 || img.rows != 5 {
+		t.Errorf("render => image %v, want a sixel image at 1, 44, 5 lines", img)
+	}
+
+	w.caps.sixel = false
+	if buf := w.render(&editorState{navigation: nav}, nil, 80, 24); buf.image != nil {
+		t.Errorf("render without graphics => image %v, want none", buf.image)
+	}
+}
@@ -0,0 +1,229 @@
+package edit
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// In instant mode, turned on with le:instant, the line is run when no key has
+// been pressed for InstantDelay, and the first lines of what it prints are
+// shown below it, so that the effect of a command can be seen while it is
+// being typed. Only lines that are one command of instantCommands, which have
+// no side effects, with literal arguments and no redirections, are run, and
+// only if the command is the external command Enter would run, not a
+// function. The command is found and run by the evaluator, so that it has the
+// same environment and is checked by the same filter as the commands the user
+// runs. It is run without input, like commands run for their --help, and is
+// killed, with any process it has started, when the line changes, or after
+// InstantTimeout.
+
+const (
+	// InstantDelay is how long to wait after a key before running the line.
+	InstantDelay = 300 * time.Millisecond
+	// InstantTimeout is how long the line is given to run.
+	InstantTimeout = time.Second
+	// InstantLines is the maximum number of lines of output shown.
+	InstantLines = 10
+)
+
+// instantCommands are the commands run in instant mode. Commands that can
+// write files or change the system with some flags, like sort -o or date -s,
+// are left out.
+var instantCommands = map[string]bool{
+	"cat": true, "grep": true, "head": true, "ls": true, "pwd": true,
+	"stat": true, "tail": true, "uname": true, "wc": true, "whoami": true,
+}
+
+func init() {
+	addEditorBuiltin("le:instant", builtinInstant)
+}
+
+// instantResult is what the line printed when run in instant mode.
+type instantResult struct {
+	line   string
+	output []string
+}
+
+// instantWords returns the words of line if it is to be run in instant mode.
+// Lines not complete yet, like ones with a quote not closed, are not run.
+func instantWords(line string) ([]string, bool) {
+	for item := range parse.Lex("<instant>", line).Chan() {
+		if item.Typ == parse.ItemError || item.End == parse.ItemUnterminated {
+			return nil, false
+		}
+	}
+	n, err := parse.Parse("<instant>", line)
+	if err != nil || len(n.Nodes) != 1 || len(n.Nodes[0].Nodes) != 1 {
+		return nil, false
+	}
+	form := n.Nodes[0].Nodes[0]
+	if len(form.Redirs) > 0 || form.StatusRedir != "" {
+		return nil, false
+	}
+	name, ok := literalTerm(form.Command)
+	if !ok || !instantCommands[name] {
+		return nil, false
+	}
+	words := []string{name}
+	if form.Args != nil {
+		for _, term := range form.Args.Nodes {
+			word, ok := literalTerm(term)
+			if !ok {
+				return nil, false
+			}
+			words = append(words, word)
+		}
+	}
+	return words, true
+}
+
+// literalTerm returns the string a term evaluates to, if it only has string
+// literals.
+func literalTerm(term *parse.TermNode) (string, bool) {
+	if term == nil {
+		return "", false
+	}
+	s := ""
+	for _, factor := range term.Nodes {
+		sn, ok := factor.Node.(*parse.StringNode)
+		if factor.Typ != parse.StringFactor || !ok {
+			return "", false
+		}
+		s += sn.Text
+	}
+	return s, true
+}
+
+// runInstant runs cmd and returns the lines it prints on both the standard
+// output and error, at most maxLines of them. Control characters other than
+// tabs are dropped, so that they don't mess up the terminal.
+func runInstant(cmd *exec.Cmd, maxLines int) []string {
+	var out limitedBuffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = append(cmd.Env, "PAGER=cat", "NO_COLOR=1", "TERM=dumb")
+	killGroupOnCancel(cmd)
+	err := cmd.Run()
+	// Processes it has left running are not needed any longer
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if err != nil && out.Len() == 0 {
+		return []string{err.Error()}
+	}
+	return instantLines(out.String(), maxLines)
+}
+
+// instantLines splits what a command printed into at most maxLines lines.
+func instantLines(out string, maxLines int) []string {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	for i, line := range lines {
+		lines[i] = strings.Map(func(r rune) rune {
+			if (r < 0x20 && r != '\t') || r == 0x7f {
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return lines
+}
+
+// scheduleInstant clears the output of the line shown in instant mode when
+// the line has changed, killing it if it is still running, and schedules the
+// new line to be run.
+func (ed *Editor) scheduleInstant() {
+	if !ed.instantMode {
+		ed.stopInstant()
+		return
+	} else if ed.line == ed.instantLine {
+		return
+	}
+	ed.stopInstant()
+	ed.instantLine = ed.line
+	if _, ok := instantWords(ed.line); ok {
+		ed.instantTimer = time.After(InstantDelay)
+	}
+}
+
+// startInstant runs the line in the background, if it is to be run in
+// instant mode.
+func (ed *Editor) startInstant() {
+	ed.instantTimer = nil
+	words, ok := instantWords(ed.instantLine)
+	if !ok {
+		return
+	}
+	if kind, _, err := ed.ev.ResolveCommand(words[0]); err != nil || kind != eval.CommandExternal {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ed.instantCancel = cancel
+	line := ed.instantLine
+	run, stop := context.WithTimeout(ctx, InstantTimeout)
+	cmd, err := ed.ev.ExternalCommand(run, "", words[0], words[1:]...)
+	go func() {
+		defer stop()
+		var output []string
+		if err != nil {
+			// Commands vetoed by the filter show why
+			output = []string{err.Error()}
+		} else {
+			output = runInstant(cmd, InstantLines)
+		}
+		if ctx.Err() == nil {
+			ed.post(func() { ed.applyInstant(&instantResult{line, output}) })
+		}
+	}()
+}
+
+// applyInstant shows the output of the line run in instant mode, unless the
+// line has changed since.
+func (ed *Editor) applyInstant(res *instantResult) {
+	if res.line == ed.instantLine {
+		ed.instant = res.output
+	}
+}
+
+// stopInstant kills the line run in instant mode, and clears its output.
+func (ed *Editor) stopInstant() {
+	if ed.instantCancel != nil {
+		ed.instantCancel()
+		ed.instantCancel = nil
+	}
+	ed.instantTimer = nil
+	ed.instantLine = ""
+	ed.instant = nil
+}
+
+// builtinInstant implements the le:instant builtin. With no arguments, it
+// prints whether instant mode is on. With one, on or off, it turns instant
+// mode on or off.
+func builtinInstant(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), onOff(ed.instantMode))
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.instantMode = true
+		case "off":
+			ed.instantMode = false
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,93 @@
+package edit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+var instantWordsTests = []struct {
+	line  string
+	words []string
+	ok    bool
+}{
+	{"ls", []string{"ls"}, true},
+	{"ls -l `a b` c\"d\"", []string{"ls", "-l", "a b", "cd"}, true},
+	{"rm -rf /", nil, false},
+	{"ls $dir", nil, false},
+	{"ls (pwd)", nil, false},
+	{"ls > out", nil, false},
+	{"ls | wc", nil, false},
+	{"ls; rm a", nil, false},
+	{"ls `a", nil, false},
+	{"", nil, false},
+}
+
+func TestInstantWords(t *testing.T) {
+	for _, tt := range instantWordsTests {
+		words, ok := instantWords(tt.line)
+		if !reflect.DeepEqual(words, tt.words) || ok != tt.ok {
+			t.Errorf("instantWords(%q) => (%q, %v), want (%q, %v)", tt.line, words, ok, tt.words, tt.ok)
+		}
+	}
+}
+
+var runInstantTests = []struct {
+	args     []string
+	maxLines int
+	want     []string
+}{
+	{[]string{"a"}, 10, []string{"a"}},
+	{[]string{"a\nb\nc"}, 2, []string{"a", "b"}},
+	{[]string{"a\tb\033[1m"}, 10, []string{"a\tb[1m"}},
+}
+
+func TestRunInstant(t *testing.T) {
+	ev := eval.NewEvaluator()
+	for _, tt := range runInstantTests {
+		cmd, err := ev.ExternalCommand(context.Background(), "", "echo", tt.args...)
+		if err != nil {
+			t.Fatalf("ExternalCommand(echo) => error %v", err)
+		}
+		lines := runInstant(cmd, tt.maxLines)
+		if !reflect.DeepEqual(lines, tt.want) {
+			t.Errorf("runInstant(echo %q, %v) => %q, want %q", tt.args, tt.maxLines, lines, tt.want)
+		}
+	}
+}
+
+func TestStartInstant(t *testing.T) {
+	ev := eval.NewEvaluator()
+	ev.SetExecFilter(eval.WhitelistExecFilter([]string{"uname"}))
+	ed := &Editor{options: newOptions(), ev: ev, redraws: make(chan struct{}, 1)}
+	run := func(line string) []string {
+		ed.stopInstant()
+		ed.instantLine = line
+		ed.startInstant()
+		if ed.instantCancel == nil {
+			return nil
+		}
+		<-ed.redraws
+		ed.runPosted()
+		return ed.instant
+	}
+
+	if out := run("uname"); len(out) != 1 || out[0] == "" {
+		t.Errorf("instant output of uname => %q, want one line", out)
+	}
+	// Commands vetoed by the filter are not run
+	if out := run("pwd"); len(out) != 1 || !strings.Contains(out[0], "not whitelisted") {
+		t.Errorf("instant output of pwd => %q, want the error of the filter", out)
+	}
+	// Functions are not run
+	src := "fn uname { put a }"
+	n, _ := parse.Parse("<test>", src)
+	ev.Eval("<test>", src, n)
+	if out := run("uname"); out != nil {
+		t.Errorf("instant output of a function => %q, want none", out)
+	}
+}
@@ -51,6 +51,18 @@ const (
 	F10
 	F11
 	F12
+	F13
+	F14
+	F15
+	F16
+	F17
+	F18
+	F19
+	F20
+	F21
+	F22
+	F23
+	F24
 	Up
 	Down
 	Right
@@ -79,6 +91,8 @@ var KeyNames = map[rune]string{
 var FunctionKeyNames = [...]string{
 	"(Invalid)",
 	"F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12",
+	"F13", "F14", "F15", "F16", "F17", "F18", "F19", "F20", "F21", "F22", "F23", "F24",
 	"Up", "Down", "Right", "Left",
 	"Home", "Insert", "Delete", "End", "PageUp", "PageDown",
+	"Default",
 }
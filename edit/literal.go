@@ -0,0 +1,185 @@
+package edit
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Characters that can't be typed, or would run a binding, are inserted in
+// literal mode. It is started in one of three ways:
+//
+// Ctrl-V inserts the next key verbatim, with Ctrl- keys as control characters
+// and Alt- keys prefixed with an escape;
+// Ctrl-V u followed by up to 4 hex digits, or Ctrl-V U followed by up to 8,
+// inserts a codepoint, like in vim. The codepoint ends early at any other key;
+// Alt-k followed by two characters inserts the RFC 1345 digraph they name, such
+// as e' for é or Eu for €.
+
+// literalKind is the kind of input literal mode is reading.
+type literalKind int
+
+const (
+	literalVerbatim literalKind = iota
+	literalCodepoint
+	literalDigraph
+)
+
+// literalState keeps the status of literal mode.
+type literalState struct {
+	kind literalKind
+	// Hex digits or digraph characters typed so far.
+	text string
+	// The maximum number of hex digits of a codepoint.
+	max int
+}
+
+// digraphs is a subset of the digraphs of RFC 1345.
+var digraphs = map[string]rune{
+	"a!": 'à', "a'": 'á', "a>": 'â', "a?": 'ã', "a:": 'ä', "aa": 'å', "ae": 'æ',
+	"A!": 'À', "A'": 'Á', "A>": 'Â', "A?": 'Ã', "A:": 'Ä', "AA": 'Å', "AE": 'Æ',
+	"e!": 'è', "e'": 'é', "e>": 'ê', "e:": 'ë',
+	"E!": 'È', "E'": 'É', "E>": 'Ê', "E:": 'Ë',
+	"i!": 'ì', "i'": 'í', "i>": 'î', "i:": 'ï',
+	"I!": 'Ì', "I'": 'Í', "I>": 'Î', "I:": 'Ï',
+	"o!": 'ò', "o'": 'ó', "o>": 'ô', "o?": 'õ', "o:": 'ö', "o/": 'ø',
+	"O!": 'Ò', "O'": 'Ó', "O>": 'Ô', "O?": 'Õ', "O:": 'Ö', "O/": 'Ø',
+	"u!": 'ù', "u'": 'ú', "u>": 'û', "u:": 'ü',
+	"U!": 'Ù', "U'": 'Ú', "U>": 'Û', "U:": 'Ü',
+	"y'": 'ý', "y:": 'ÿ', "Y'": 'Ý',
+	"c,": 'ç', "C,": 'Ç', "n?": 'ñ', "N?": 'Ñ', "ss": 'ß',
+	"Eu": '€', "Pd": '£', "Ye": '¥', "Ct": '¢',
+	"Co": '©', "Rg": '®', "TM": '™', "SE": '§', "PI": '¶',
+	"DG": '°', "+-": '±', "*X": '×', "-:": '÷', "My": 'µ',
+	"NS": '\u00a0', "-N": '–', "-M": '—', "..": '…',
+	"<<": '«', ">>": '»', "'6": '‘', "'9": '’', "\"6": '“', "\"9": '”',
+	"->": '→', "<-": '←', "-!": '↑', "-v": '↓', "OK": '✓', "XX": '✗',
+	"a*": 'α', "b*": 'β', "g*": 'γ', "d*": 'δ', "e*": 'ε', "l*": 'λ',
+	"m*": 'μ', "p*": 'π', "s*": 'σ', "w*": 'ω', "D*": 'Δ', "S*": 'Σ', "W*": 'Ω',
+	"!=": '≠', "=<": '≤', ">=": '≥', "?2": '≈', "00": '∞',
+}
+
+func startLiteral(ed *Editor, k Key) *leReturn {
+	ed.mode = modeLiteral
+	ed.literal = &literalState{kind: literalVerbatim}
+	return nil
+}
+
+func startDigraph(ed *Editor, k Key) *leReturn {
+	ed.mode = modeLiteral
+	ed.literal = &literalState{kind: literalDigraph}
+	return nil
+}
+
+// keyText returns the text a key stands for when inserted verbatim.
+func keyText(k Key) (string, bool) {
+	prefix := ""
+	if k.Mod&Alt != 0 {
+		prefix = "\033"
+	}
+	switch {
+	case k.Rune < 0:
+		return "", false
+	case k.Mod&^Alt == 0:
+		return prefix + string(k.Rune), true
+	case k.Mod&^Alt == Ctrl && '@' <= k.Rune && k.Rune <= '_':
+		return prefix + string(k.Rune-'@'), true
+	case k.Mod&^Alt == Ctrl && k.Rune == '?':
+		return prefix + "\x7f", true
+	}
+	return "", false
+}
+
+func isHexDigit(r rune) bool {
+	return '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+}
+
+// endLiteral leaves literal mode, inserting text.
+func (ed *Editor) endLiteral(text string) {
+	ed.insertAtDot(text)
+	ed.literal = nil
+	ed.mode = modeInsert
+}
+
+// insertCodepoint leaves literal mode, inserting the codepoint typed so far.
+func (ed *Editor) insertCodepoint() {
+	l := ed.literal
+	n, err := strconv.ParseUint(l.text, 16, 32)
+	r := rune(n)
+	if err != nil || !utf8.ValidRune(r) {
+		ed.pushTip(ed.trf("invalid codepoint %s", l.text))
+		ed.endLiteral("")
+		return
+	}
+	ed.endLiteral(string(r))
+}
+
+func literalKey(ed *Editor, k Key) *leReturn {
+	l := ed.literal
+	switch l.kind {
+	case literalVerbatim:
+		if k.Mod == 0 && (k.Rune == 'u' || k.Rune == 'U') {
+			l.kind = literalCodepoint
+			l.max = 4
+			if k.Rune == 'U' {
+				l.max = 8
+			}
+			return nil
+		}
+		text, ok := keyText(k)
+		if !ok {
+			ed.pushTip(ed.trf("cannot insert %s literally", k))
+		}
+		ed.endLiteral(text)
+	case literalCodepoint:
+		if k.Mod == 0 && isHexDigit(k.Rune) {
+			l.text += string(k.Rune)
+			if len(l.text) == l.max {
+				ed.insertCodepoint()
+			}
+			return nil
+		}
+		if l.text == "" {
+			ed.endLiteral("")
+			return nil
+		}
+		ed.insertCodepoint()
+		if k != (Key{Enter, 0}) {
+			return &leReturn{action: reprocessKey}
+		}
+	case literalDigraph:
+		if k.Mod != 0 || k.Rune < 0x20 {
+			ed.endLiteral("")
+			return nil
+		}
+		l.text += string(k.Rune)
+		if utf8.RuneCountInString(l.text) < 2 {
+			return nil
+		}
+		r, ok := digraphs[l.text]
+		if !ok {
+			// Like vim, also try the characters the other way around
+			runes := []rune(l.text)
+			r, ok = digraphs[string([]rune{runes[1], runes[0]})]
+		}
+		if !ok {
+			ed.pushTip(ed.trf("no digraph %s", l.text))
+			ed.endLiteral("")
+			return nil
+		}
+		ed.endLiteral(string(r))
+	}
+	return nil
+}
+
+// literalModeLine describes what literal mode is reading.
+func (o *options) literalModeLine(l *literalState) string {
+	switch l.kind {
+	case literalCodepoint:
+		return fmt.Sprintf("U+%s", l.text)
+	case literalDigraph:
+		return o.trf("Digraph %s", l.text)
+	default:
+		return o.tr("Literal")
+	}
+}
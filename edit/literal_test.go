@@ -0,0 +1,36 @@
+package edit
+
+import "testing"
+
+var literalTests = []struct {
+	keys   []Key
+	wanted string
+	mode   bufferMode
+}{
+	{[]Key{{'V', Ctrl}, {'A', Ctrl}}, "\x01", modeInsert},
+	{[]Key{{'V', Ctrl}, {'[', Ctrl}}, "\x1b", modeInsert},
+	{[]Key{{'V', Ctrl}, {Tab, 0}}, "\t", modeInsert},
+	{[]Key{{'V', Ctrl}, {'x', Alt}}, "\x1bx", modeInsert},
+	{[]Key{{'V', Ctrl}, {Left, 0}}, "", modeInsert},
+	{[]Key{{'V', Ctrl}, {'u', 0}, {'e', 0}, {'9', 0}}, "", modeLiteral},
+	{[]Key{{'V', Ctrl}, {'u', 0}, {'e', 0}, {'9', 0}, {'x', 0}}, "éx", modeInsert},
+	{[]Key{{'V', Ctrl}, {'u', 0}, {'2', 0}, {'0', 0}, {'a', 0}, {'c', 0}}, "€", modeInsert},
+	{[]Key{{'V', Ctrl}, {'U', 0}, {'1', 0}, {'f', 0}, {'6', 0}, {'0', 0}, {'0', 0}, {Enter, 0}}, "😀", modeInsert},
+	{[]Key{{'V', Ctrl}, {'U', 0}, {'d', 0}, {'8', 0}, {'0', 0}, {'0', 0}, {Enter, 0}}, "", modeInsert},
+	{[]Key{{'k', Alt}, {'e', 0}, {'\'', 0}}, "é", modeInsert},
+	{[]Key{{'k', Alt}, {'u', 0}, {'E', 0}}, "€", modeInsert},
+	{[]Key{{'k', Alt}, {'q', 0}, {'q', 0}}, "", modeInsert},
+	{[]Key{{'k', Alt}, {'[', Ctrl}}, "", modeInsert},
+}
+
+func TestLiteral(t *testing.T) {
+	for _, tt := range literalTests {
+		ed := &Editor{options: newOptions()}
+		for _, k := range tt.keys {
+			ed.handleRead(keyRead(k))
+		}
+		if ed.line != tt.wanted || ed.mode != tt.mode {
+			t.Errorf("keys %v => %q, mode %d, want %q, mode %d", tt.keys, ed.line, ed.mode, tt.wanted, tt.mode)
+		}
+	}
+}
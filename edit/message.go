@@ -0,0 +1,107 @@
+package edit
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// User-visible strings of the editor, like mode lines and tips, are looked up
+// in the message catalog of the editor with tr and trf, using the English text
+// as the key. Translations are added with AddTranslations or le:translate, and
+// the locale is chosen from the environment or with le:locale.
+
+func init() {
+	addEditorBuiltin("le:locale", builtinLocale)
+	addEditorBuiltin("le:translate", builtinTranslate)
+}
+
+// localeFromEnv finds the locale of messages from $LC_ALL, $LC_MESSAGES and
+// $LANG, whichever is set first.
+func localeFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return normalizeLocale(value)
+		}
+	}
+	return ""
+}
+
+// normalizeLocale strips the encoding and modifier from a locale name, like
+// "de_DE.UTF-8@euro". The C and POSIX locales are English.
+func normalizeLocale(name string) string {
+	if i := strings.IndexAny(name, ".@"); i != -1 {
+		name = name[:i]
+	}
+	if name == "C" || name == "POSIX" {
+		return ""
+	}
+	return name
+}
+
+// AddTranslations adds translations of English messages for a locale. A
+// locale with just a language, like "de", is used for all the locales of the
+// language without one of their own.
+func (ed *Editor) AddTranslations(locale string, messages map[string]string) {
+	m := ed.translations[locale]
+	if m == nil {
+		m = make(map[string]string)
+		ed.translations[locale] = m
+	}
+	for msgid, text := range messages {
+		m[msgid] = text
+	}
+}
+
+// tr returns the message msgid translated for the current locale, or msgid
+// itself if it is not translated.
+func (o *options) tr(msgid string) string {
+	if o.locale == "" {
+		return msgid
+	}
+	if text, ok := o.translations[o.locale][msgid]; ok {
+		return text
+	}
+	if i := strings.IndexByte(o.locale, '_'); i != -1 {
+		if text, ok := o.translations[o.locale[:i]][msgid]; ok {
+			return text
+		}
+	}
+	return msgid
+}
+
+// trf is like tr, but formats the message with args like fmt.Sprintf.
+func (o *options) trf(msgid string, args ...interface{}) string {
+	return fmt.Sprintf(o.tr(msgid), args...)
+}
+
+// builtinLocale implements the le:locale builtin. With no arguments, it prints
+// the locale of messages. With one argument, it sets the locale; an empty
+// locale, C or POSIX means English.
+func builtinLocale(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.locale)
+		return ""
+	case 1:
+		ed.locale = normalizeLocale(args[0].String())
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinTranslate implements the le:translate builtin. It takes a locale, an
+// English message and its translation, so that modules written in elvish can
+// ship translations.
+func builtinTranslate(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 3 {
+		return "args error"
+	}
+	ed.AddTranslations(args[0].String(), map[string]string{
+		args[1].String(): args[2].String(),
+	})
+	return ""
+}
@@ -0,0 +1,47 @@
+package edit
+
+import "testing"
+
+var normalizeLocaleTests = []struct {
+	in, wanted string
+}{
+	{"", ""},
+	{"C", ""},
+	{"POSIX", ""},
+	{"C.UTF-8", ""},
+	{"de_DE.UTF-8", "de_DE"},
+	{"de_DE@euro", "de_DE"},
+	{"pt_BR", "pt_BR"},
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	for _, tt := range normalizeLocaleTests {
+		if out := normalizeLocale(tt.in); out != tt.wanted {
+			t.Errorf("normalizeLocale(%q) => %q, want %q", tt.in, out, tt.wanted)
+		}
+	}
+}
+
+var trTests = []struct {
+	locale, msgid, wanted string
+}{
+	{"", "Command", "Command"},
+	{"de_DE", "Command", "Befehl"},
+	{"de_AT", "Command", "Befehl"},
+	{"pt_BR", "Command", "Comando"},
+	{"pt_PT", "Command", "Command"},
+	{"de_DE", "Snippet", "Snippet"},
+}
+
+func TestTr(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.AddTranslations("de", map[string]string{"Command": "Befehl"})
+	ed.AddTranslations("pt_BR", map[string]string{"Command": "Comando"})
+
+	for _, tt := range trTests {
+		ed.locale = tt.locale
+		if out := ed.tr(tt.msgid); out != tt.wanted {
+			t.Errorf("tr(%q) in %q => %q, want %q", tt.msgid, tt.locale, out, tt.wanted)
+		}
+	}
+}
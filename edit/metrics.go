@@ -0,0 +1,157 @@
+package edit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// The editor measures how long it takes to do things that may make it feel
+// slow, so that regressions can be told by numbers:
+//
+//	key-to-render  from handling a key to drawing the result of it
+//	prompt         computing the prompt and the right prompt
+//	refresh        highlighting the line and drawing
+//	completion     finding the candidates of a completion
+//
+// le:metrics prints the number of times each was measured, and the average,
+// the longest and the last time it took; "le:metrics reset" starts over.
+// With le:trace, every measurement is also written to a file as it is made,
+// like
+//
+//	15:04:05.000000 key-to-render 1.204ms
+
+func init() {
+	addEditorBuiltin("le:metrics", builtinMetrics)
+	addEditorBuiltin("le:trace", builtinTrace)
+}
+
+// metric is a summary of the times something took.
+type metric struct {
+	count            int
+	total, max, last time.Duration
+}
+
+func (m *metric) add(d time.Duration) {
+	m.count++
+	m.total += d
+	m.last = d
+	if d > m.max {
+		m.max = d
+	}
+}
+
+// metrics are the metrics of an Editor, by name. They may be measured from
+// other goroutines, like completions.
+type metrics struct {
+	mutex   sync.Mutex
+	metrics map[string]*metric
+	// Where measurements are traced, and the file opened for it by le:trace.
+	trace     io.Writer
+	traceFile *os.File
+}
+
+// add records that name took d.
+func (ms *metrics) add(name string, d time.Duration) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.metrics == nil {
+		ms.metrics = make(map[string]*metric)
+	}
+	m := ms.metrics[name]
+	if m == nil {
+		m = &metric{}
+		ms.metrics[name] = m
+	}
+	m.add(d)
+	if ms.trace != nil {
+		fmt.Fprintf(ms.trace, "%s %s %v\n", time.Now().Format("15:04:05.000000"), name, d)
+	}
+}
+
+// start starts measuring name, and returns a function that stops, to be used
+// like
+//
+//	defer ed.metrics.start("name")()
+func (ms *metrics) start(name string) func() {
+	t := time.Now()
+	return func() { ms.add(name, time.Since(t)) }
+}
+
+// setTrace sets where measurements are traced, closing the file opened by
+// le:trace, if any.
+func (ms *metrics) setTrace(w io.Writer, f *os.File) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.traceFile != nil {
+		ms.traceFile.Close()
+	}
+	ms.trace, ms.traceFile = w, f
+}
+
+// writeTo writes the metrics, sorted by name, one per line.
+func (ms *metrics) writeTo(w io.Writer) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var names []string
+	for name := range ms.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := ms.metrics[name]
+		fmt.Fprintf(w, "%-14s %6d  avg %-10v max %-10v last %v\n", name, m.count,
+			m.total/time.Duration(m.count), m.max, m.last)
+	}
+}
+
+// reset forgets the metrics measured so far.
+func (ms *metrics) reset() {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.metrics = nil
+}
+
+// SetTrace sets where measurements are traced, one per line, or stops tracing
+// them if w is nil.
+func (ed *Editor) SetTrace(w io.Writer) {
+	ed.metrics.setTrace(w, nil)
+}
+
+// builtinMetrics implements the le:metrics builtin. With no arguments, it
+// prints the metrics; with "reset", it forgets them.
+func builtinMetrics(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch {
+	case len(args) == 0:
+		ed.metrics.writeTo(ev.OutFile())
+	case len(args) == 1 && args[0].String() == "reset":
+		ed.metrics.reset()
+	default:
+		return "args error"
+	}
+	return ""
+}
+
+// builtinTrace implements the le:trace builtin. It appends measurements to
+// the file named by its argument, or stops tracing them with "off".
+func builtinTrace(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 1 {
+		return "args error"
+	}
+	name := args[0].String()
+	if name == "off" {
+		ed.metrics.setTrace(nil, nil)
+		return ""
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err.Error()
+	}
+	ed.metrics.setTrace(f, f)
+	return ""
+}
@@ -0,0 +1,49 @@
+package edit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	var ms metrics
+	var trace bytes.Buffer
+	ms.setTrace(&trace, nil)
+	ms.add("refresh", 3*time.Millisecond)
+	ms.add("refresh", time.Millisecond)
+	ms.add("prompt", 2*time.Millisecond)
+	ms.start("completion")()
+
+	m := ms.metrics["refresh"]
+	if m.count != 2 || m.total != 4*time.Millisecond || m.max != 3*time.Millisecond || m.last != time.Millisecond {
+		t.Errorf("refresh => %+v, want 2 measurements, total 4ms, max 3ms and last 1ms", *m)
+	}
+	if n := strings.Count(trace.String(), "\n"); n != 4 {
+		t.Errorf("%d measurements traced, want 4", n)
+	}
+
+	var out bytes.Buffer
+	ms.writeTo(&out)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	wantPrefixes := []string{"completion", "prompt", "refresh"}
+	if len(lines) != len(wantPrefixes) {
+		t.Fatalf("metrics written => %q, want %d lines", out.String(), len(wantPrefixes))
+	}
+	for i, prefix := range wantPrefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d of metrics => %q, want it to start with %q", i, lines[i], prefix)
+		}
+	}
+	if !strings.Contains(lines[2], "avg 2ms") {
+		t.Errorf("metric of refresh => %q, want avg 2ms", lines[2])
+	}
+
+	ms.reset()
+	out.Reset()
+	ms.writeTo(&out)
+	if out.Len() != 0 {
+		t.Errorf("metrics written after reset => %q, want none", out.String())
+	}
+}
@@ -0,0 +1,241 @@
+package edit
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Features that need to ask the user a question, like searching the history
+// or renaming a file in navigation mode, use minibuffer mode. The question is
+// shown in place of the mode line, and the answer is edited there with a
+// small set of keys; Enter accepts it and Ctrl-[ cancels. Questions asked
+// with confirm are answered with y; any other key is no. Questions asked with
+// choose are answered with one of the keys given, and other keys are ignored.
+
+// minibuffer keeps the status of minibuffer mode.
+type minibuffer struct {
+	prompt string
+	text   string
+	dot    int
+	// Whether the question is answered with y or n.
+	yesNo bool
+	// The keys the question is answered with, if it asks for a choice.
+	choices string
+	// A range of the line highlighted while the question is asked, if not
+	// empty.
+	matchStart, matchEnd int
+	// The mode the question is asked from, which is restored when it is
+	// answered or cancelled.
+	mode bufferMode
+	// Called with the answer when it is accepted.
+	done func(ed *Editor, answer string)
+	// Returned by the builtin answering yes to a yes-or-no question, like
+	// one that ends ReadLine.
+	yesReturn *leReturn
+}
+
+// ask asks for a string in minibuffer mode, starting with initial. done is
+// called with the answer if it is accepted, after the mode is restored.
+func (ed *Editor) ask(prompt, initial string, done func(*Editor, string)) {
+	ed.minibuffer = &minibuffer{prompt: prompt, text: initial, dot: len(initial),
+		mode: ed.mode, done: done}
+	ed.mode = modeMinibuffer
+}
+
+// confirm asks a yes-or-no question in minibuffer mode. done is called if the
+// answer is yes, after the mode is restored.
+func (ed *Editor) confirm(prompt string, done func(*Editor)) {
+	ed.minibuffer = &minibuffer{prompt: prompt + " " + ed.tr("(y/n)"), yesNo: true,
+		mode: ed.mode, done: func(ed *Editor, _ string) { done(ed) }}
+	ed.mode = modeMinibuffer
+}
+
+// choose asks a question answered with one of the runes in choices, in
+// minibuffer mode. done is called with the rune as the answer, after the mode
+// is restored.
+func (ed *Editor) choose(prompt, choices string, done func(*Editor, string)) {
+	ed.minibuffer = &minibuffer{prompt: prompt, choices: choices,
+		mode: ed.mode, done: done}
+	ed.mode = modeMinibuffer
+}
+
+// endMinibuffer leaves minibuffer mode, restoring the mode the question was
+// asked from.
+func (ed *Editor) endMinibuffer() *minibuffer {
+	mb := ed.minibuffer
+	ed.minibuffer = nil
+	ed.mode = mb.mode
+	return mb
+}
+
+func acceptMinibuffer(ed *Editor, k Key) *leReturn {
+	if ed.minibuffer.yesNo || ed.minibuffer.choices != "" {
+		// Only y is yes, so that a stray Enter can't remove files
+		return defaultMinibuffer(ed, k)
+	}
+	mb := ed.endMinibuffer()
+	mb.done(ed, mb.text)
+	return nil
+}
+
+func cancelMinibuffer(ed *Editor, k Key) *leReturn {
+	ed.endMinibuffer()
+	return nil
+}
+
+func minibufferKillRuneLeft(ed *Editor, k Key) *leReturn {
+	mb := ed.minibuffer
+	if mb.dot > 0 {
+		_, w := utf8.DecodeLastRuneInString(mb.text[:mb.dot])
+		mb.text = mb.text[:mb.dot-w] + mb.text[mb.dot:]
+		mb.dot -= w
+	}
+	return nil
+}
+
+func minibufferKillLineLeft(ed *Editor, k Key) *leReturn {
+	mb := ed.minibuffer
+	mb.text = mb.text[mb.dot:]
+	mb.dot = 0
+	return nil
+}
+
+func minibufferMoveDotLeft(ed *Editor, k Key) *leReturn {
+	mb := ed.minibuffer
+	_, w := utf8.DecodeLastRuneInString(mb.text[:mb.dot])
+	mb.dot -= w
+	return nil
+}
+
+func minibufferMoveDotRight(ed *Editor, k Key) *leReturn {
+	mb := ed.minibuffer
+	_, w := utf8.DecodeRuneInString(mb.text[mb.dot:])
+	mb.dot += w
+	return nil
+}
+
+func defaultMinibuffer(ed *Editor, k Key) *leReturn {
+	mb := ed.minibuffer
+	if mb.yesNo {
+		mb := ed.endMinibuffer()
+		if k == (Key{'y', 0}) || k == (Key{'Y', 0}) {
+			mb.done(ed, "y")
+			return mb.yesReturn
+		}
+		return nil
+	}
+	if mb.choices != "" {
+		if k.Mod == 0 && k.Rune > 0 && strings.ContainsRune(mb.choices, k.Rune) {
+			mb := ed.endMinibuffer()
+			mb.done(ed, string(k.Rune))
+		} else {
+			ed.pushTip(ed.trf("Answer one of %s", mb.choices))
+		}
+		return nil
+	}
+	if k.Mod == 0 && k.Rune > 0 && utf8.ValidRune(k.Rune) {
+		s := string(k.Rune)
+		mb.text = mb.text[:mb.dot] + s + mb.text[mb.dot:]
+		mb.dot += len(s)
+	} else {
+		ed.pushTip(ed.trf("Unbound: %s", k))
+	}
+	return nil
+}
+
+// Users of the minibuffer
+
+func searchHistory(ed *Editor, k Key) *leReturn {
+	ed.reloadHistory()
+	ed.ask(ed.tr("Search history:"), "", func(ed *Editor, s string) {
+		for i := len(ed.histories) - 1; i >= 0; i-- {
+			if strings.Contains(ed.histories[i].Line, s) && ed.inHistoryScope(i) {
+				ed.line = ed.histories[i].Line
+				ed.dot = len(ed.line)
+				return
+			}
+		}
+		ed.pushTip(ed.tr("no matching history item"))
+	})
+	return nil
+}
+
+func renameNav(ed *Editor, k Key) *leReturn {
+	name := ed.navigation.current.selectedName()
+	if name == "" {
+		return nil
+	}
+	ed.ask(ed.trf("Rename %s to:", name), name, func(ed *Editor, newName string) {
+		if newName == "" || newName == name {
+			return
+		}
+		if err := os.Rename(name, newName); err != nil {
+			ed.pushTip(err.Error())
+		}
+		ed.navigation.refresh()
+		ed.navigation.maintainSelected(newName)
+	})
+	return nil
+}
+
+func removeNav(ed *Editor, k Key) *leReturn {
+	name := ed.navigation.current.selectedName()
+	if name == "" {
+		return nil
+	}
+	ed.confirm(ed.trf("Remove %s?", name), func(ed *Editor) {
+		// Like rm without -r, directories are only removed when empty
+		if err := os.Remove(name); err != nil {
+			ed.pushTip(err.Error())
+		}
+		ed.navigation.refresh()
+	})
+	return nil
+}
+
+// queryReplace asks for a string to replace in the line and its replacement,
+// and then for each occurrence, which is highlighted, whether to replace it:
+// y replaces it, n skips it, a replaces it and all the rest, and q, like
+// Ctrl-[, stops.
+func queryReplace(ed *Editor, k Key) *leReturn {
+	ed.ask(ed.tr("Replace:"), "", func(ed *Editor, from string) {
+		if from == "" {
+			return
+		}
+		ed.ask(ed.trf("Replace %s with:", from), "", func(ed *Editor, to string) {
+			ed.replaceNext(from, to, 0, 0)
+		})
+	})
+	return nil
+}
+
+// replaceNext asks whether to replace the next occurrence of from at or
+// after start, n occurrences having been replaced.
+func (ed *Editor) replaceNext(from, to string, start, n int) {
+	i := strings.Index(ed.line[start:], from)
+	if i == -1 {
+		ed.pushTip(ed.trf("Replaced %d occurrences", n))
+		return
+	}
+	i += start
+	ed.dot = i
+	ed.choose(ed.trf("Replace with %s? (y/n/a/q)", to), "ynaq", func(ed *Editor, answer string) {
+		switch answer {
+		case "y":
+			ed.line = ed.line[:i] + to + ed.line[i+len(from):]
+			ed.replaceNext(from, to, i+len(to), n+1)
+		case "n":
+			ed.replaceNext(from, to, i+len(from), n)
+		case "a":
+			rest := ed.line[i:]
+			n += strings.Count(rest, from)
+			ed.line = ed.line[:i] + strings.Replace(rest, from, to, -1)
+			ed.dot = len(ed.line)
+			ed.pushTip(ed.trf("Replaced %d occurrences", n))
+		case "q":
+			ed.pushTip(ed.trf("Replaced %d occurrences", n))
+		}
+	})
+	ed.minibuffer.matchStart, ed.minibuffer.matchEnd = i, i+len(from)
+}
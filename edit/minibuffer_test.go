@@ -0,0 +1,99 @@
+package edit
+
+import "testing"
+
+var minibufferTests = []struct {
+	keys   []Key
+	wanted string
+	ok     bool
+}{
+	{[]Key{{'a', 0}, {'b', 0}, {Enter, 0}}, "ab", true},
+	{[]Key{{'a', 0}, {'c', 0}, {Left, 0}, {'b', 0}, {Enter, 0}}, "abc", true},
+	{[]Key{{'a', 0}, {'b', 0}, {Backspace, 0}, {Enter, 0}}, "a", true},
+	{[]Key{{'a', 0}, {'b', 0}, {Left, 0}, {'U', Ctrl}, {Enter, 0}}, "b", true},
+	{[]Key{{'a', 0}, {'[', Ctrl}}, "", false},
+}
+
+func TestMinibuffer(t *testing.T) {
+	for _, tt := range minibufferTests {
+		ed := &Editor{options: newOptions()}
+		ed.mode = modeCommand
+		answer, ok := "", false
+		ed.ask("Question:", "", func(ed *Editor, s string) { answer, ok = s, true })
+		for _, k := range tt.keys {
+			ed.handleRead(keyRead(k))
+		}
+		if answer != tt.wanted || ok != tt.ok || ed.mode != modeCommand {
+			t.Errorf("keys %v => %q, %v, mode %d, want %q, %v, mode %d",
+				tt.keys, answer, ok, ed.mode, tt.wanted, tt.ok, modeCommand)
+		}
+	}
+}
+
+var confirmTests = []struct {
+	key    Key
+	wanted bool
+}{
+	{Key{'y', 0}, true},
+	{Key{'Y', 0}, true},
+	{Key{Enter, 0}, false},
+	{Key{'n', 0}, false},
+	{Key{'x', 0}, false},
+	{Key{'[', Ctrl}, false},
+}
+
+func TestConfirm(t *testing.T) {
+	for _, tt := range confirmTests {
+		ed := &Editor{options: newOptions()}
+		yes := false
+		ed.confirm("Sure?", func(ed *Editor) { yes = true })
+		ed.handleRead(keyRead(tt.key))
+		if yes != tt.wanted || ed.mode != modeInsert {
+			t.Errorf("key %v => %v, want %v", tt.key, yes, tt.wanted)
+		}
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.histories = []HistoryEntry{{Line: "echo foo"}, {Line: "ls"}, {Line: "echo bar"}}
+	for _, k := range []Key{{'R', Ctrl}, {'f', 0}, {'o', 0}, {Enter, 0}} {
+		ed.handleRead(keyRead(k))
+	}
+	if ed.line != "echo foo" {
+		t.Errorf("search for fo => %q, want %q", ed.line, "echo foo")
+	}
+}
+
+var queryReplaceTests = []struct {
+	answers string
+	wanted  string
+}{
+	{"yyy", "cp b b b"},
+	{"nyn", "cp a b a"},
+	{"na", "cp a b b"},
+	{"yq", "cp b a a"},
+	{"yx\033", "cp b a a"},
+}
+
+func TestQueryReplace(t *testing.T) {
+	for _, tt := range queryReplaceTests {
+		ed := &Editor{options: newOptions()}
+		ed.line, ed.dot = "cp a a a", 0
+		keys := []Key{{'q', Alt}, {'a', 0}, {Enter, 0}, {'b', 0}, {Enter, 0}}
+		for _, r := range tt.answers {
+			if r == '\033' {
+				keys = append(keys, Key{'[', Ctrl})
+			} else {
+				keys = append(keys, Key{r, 0})
+			}
+		}
+		for _, k := range keys {
+			ed.handleRead(keyRead(k))
+		}
+		if ed.line != tt.wanted || ed.mode != modeInsert {
+			t.Errorf("answers %q => %q, mode %d, want %q, mode %d",
+				tt.answers, ed.line, ed.mode, tt.wanted, modeInsert)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package edit
+
+import (
+	"os"
+	"strings"
+)
+
+// Inside tmux and GNU screen, escape sequences go to the multiplexer, which
+// swallows those it doesn't know, like OSC 8 hyperlinks in older versions,
+// instead of passing them on to the terminal. Both pass on sequences wrapped
+// in a DCS envelope, so with the passthrough feature, which is on inside a
+// multiplexer, the editor wraps the OSC sequences it writes. tmux only passes
+// them on with "set -g allow-passthrough on"; turn the feature off with
+// le:feature on versions of tmux that handle hyperlinks themselves.
+//
+// Queries are only wrapped inside screen, which doesn't answer them; tmux
+// answers queries of the background itself.
+
+// multiplexer is a terminal multiplexer the editor runs in.
+type multiplexer int
+
+// Possible values for multiplexer.
+const (
+	noMultiplexer multiplexer = iota
+	tmuxMultiplexer
+	screenMultiplexer
+)
+
+// screenChunkSize is how many bytes are wrapped in each DCS envelope for
+// screen, which limits the length of DCS sequences.
+const screenChunkSize = 76
+
+// multiplexerFromEnv finds the multiplexer the editor runs in from $TMUX and
+// $STY, which tmux and screen set.
+func multiplexerFromEnv() multiplexer {
+	switch {
+	case os.Getenv("TMUX") != "":
+		return tmuxMultiplexer
+	case os.Getenv("STY") != "":
+		return screenMultiplexer
+	default:
+		return noMultiplexer
+	}
+}
+
+// passthrough wraps seq, an escape sequence, in the envelope m passes on to
+// the terminal.
+func (m multiplexer) passthrough(seq string) string {
+	switch m {
+	case tmuxMultiplexer:
+		// Escapes in the sequence are doubled
+		return "\033Ptmux;" + strings.Replace(seq, "\033", "\033\033", -1) + "\033\\"
+	case screenMultiplexer:
+		// ST would end the envelope, so the sequence is ended with BEL
+		if strings.HasSuffix(seq, "\033\\") {
+			seq = seq[:len(seq)-2] + "\a"
+		}
+		var b strings.Builder
+		for len(seq) > 0 {
+			n := screenChunkSize
+			if n > len(seq) {
+				n = len(seq)
+			}
+			b.WriteString("\033P" + seq[:n] + "\033\\")
+			seq = seq[n:]
+		}
+		return b.String()
+	default:
+		return seq
+	}
+}
+
+// passthroughEscapes wraps the hyperlinks of escapes for a multiplexer.
+type passthroughEscapes struct {
+	escapes
+	mux multiplexer
+}
+
+func (pe passthroughEscapes) hyperlink(link string) string {
+	return pe.mux.passthrough(pe.escapes.hyperlink(link))
+}
+
+// activeEscapes returns the escapes to write with, wrapping sequences for the
+// multiplexer with the passthrough feature.
+func (w *writer) activeEscapes() escapes {
+	if w.caps.passthrough && w.caps.multiplexer != noMultiplexer {
+		return passthroughEscapes{w.esc, w.caps.multiplexer}
+	}
+	return w.esc
+}
+
+// wrapQuery wraps a query for the multiplexer, if it doesn't answer it.
+func (caps capabilities) wrapQuery(query string) string {
+	if caps.passthrough && caps.multiplexer == screenMultiplexer {
+		return caps.multiplexer.passthrough(query)
+	}
+	return query
+}
@@ -0,0 +1,68 @@
+package edit
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+var passthroughTests = []struct {
+	mux    multiplexer
+	seq    string
+	wanted string
+}{
+	{noMultiplexer, "\033]8;;file:///a\033\\", "\033]8;;file:///a\033\\"},
+	{tmuxMultiplexer, "\033]8;;file:///a\033\\", "\033Ptmux;\033\033]8;;file:///a\033\033\\\033\\"},
+	{screenMultiplexer, "\033]8;;file:///a\033\\", "\033P\033]8;;file:///a\a\033\\"},
+	{screenMultiplexer, "\033]8;;" + strings.Repeat("a", 80) + "\033\\",
+		"\033P\033]8;;" + strings.Repeat("a", 71) + "\033\\" +
+			"\033P" + strings.Repeat("a", 9) + "\a\033\\"},
+}
+
+func TestPassthrough(t *testing.T) {
+	for _, tt := range passthroughTests {
+		if out := tt.mux.passthrough(tt.seq); out != tt.wanted {
+			t.Errorf("passthrough(%v, %q) => %q, want %q", tt.mux, tt.seq, out, tt.wanted)
+		}
+	}
+}
+
+var multiplexerEnvTests = []struct {
+	tmux, sty string
+	wanted    multiplexer
+}{
+	{"", "", noMultiplexer},
+	{"/tmp/tmux-0/default,1,0", "", tmuxMultiplexer},
+	{"", "1.pts-0.host", screenMultiplexer},
+}
+
+func TestCapabilitiesInMultiplexer(t *testing.T) {
+	for _, name := range []string{"TERM", "TMUX", "STY"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("TERM", "screen-256color")
+	for _, tt := range multiplexerEnvTests {
+		os.Setenv("TMUX", tt.tmux)
+		os.Setenv("STY", tt.sty)
+		caps := capabilitiesFromEnv()
+		if caps.multiplexer != tt.wanted || caps.passthrough != (tt.wanted != noMultiplexer) {
+			t.Errorf("with TMUX=%q STY=%q, multiplexer %v, passthrough %v, want %v",
+				tt.tmux, tt.sty, caps.multiplexer, caps.passthrough, tt.wanted)
+		}
+		queries := caps.capQueries()
+		if wrapped := strings.Contains(queries, "\033P"); wrapped != (tt.wanted == screenMultiplexer) {
+			t.Errorf("with TMUX=%q STY=%q, queries %q", tt.tmux, tt.sty, queries)
+		}
+	}
+}
+
+func TestPassthroughEscapes(t *testing.T) {
+	w := &writer{esc: xtermEscapes{}, caps: capabilities{passthrough: true, multiplexer: tmuxMultiplexer}}
+	if link := w.activeEscapes().hyperlink(""); link != "\033Ptmux;\033\033]8;;\033\033\\\033\\" {
+		t.Errorf("hyperlink with passthrough in tmux => %q", link)
+	}
+	w.caps.passthrough = false
+	if link := w.activeEscapes().hyperlink(""); link != "\033]8;;\033\\" {
+		t.Errorf("hyperlink without passthrough => %q", link)
+	}
+}
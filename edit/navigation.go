@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 )
 
@@ -15,12 +16,13 @@ var (
 type navColumn struct {
 	names    []string
 	attrs    []string
+	links    []string
 	selected int
 	err      error
 }
 
-func newNavColumn(names, attrs []string) *navColumn {
-	nc := &navColumn{names, attrs, 0, nil}
+func newNavColumn(names, attrs, links []string) *navColumn {
+	nc := &navColumn{names, attrs, links, 0, nil}
 	nc.resetSelected()
 	return nc
 }
@@ -51,6 +53,9 @@ func (nc *navColumn) resetSelected() {
 // TODO(xiaq): Support file preview in navigation mode
 type navigation struct {
 	current, parent, dirPreview *navColumn
+	// The preview of the selected file if it is an image; see
+	// image-preview.go.
+	image *navImage
 }
 
 func newNavigation() *navigation {
@@ -59,21 +64,23 @@ func newNavigation() *navigation {
 	return n
 }
 
-func readdirnames(dir string) (names, attrs []string, err error) {
+func readdirnames(dir string) (names, attrs, links []string, err error) {
 	f, err := os.Open(dir)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	names, err = f.Readdirnames(0)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	sort.Strings(names)
 	attrs = make([]string, len(names))
+	links = make([]string, len(names))
 	for i, name := range names {
 		attrs[i] = defaultLsColor.determineAttr(path.Join(dir, name))
+		links[i] = fileURL(path.Join(dir, name))
 	}
-	return names, attrs, nil
+	return names, attrs, links, nil
 }
 
 func (n *navigation) maintainSelected(name string) {
@@ -86,12 +93,12 @@ func (n *navigation) maintainSelected(name string) {
 
 func (n *navigation) refreshCurrent() {
 	selectedName := n.current.selectedName()
-	names, attrs, err := readdirnames(".")
+	names, attrs, links, err := readdirnames(".")
 	if err != nil {
 		n.current = newErrNavColumn(err)
 		return
 	}
-	n.current = newNavColumn(names, attrs)
+	n.current = newNavColumn(names, attrs, links)
 	if selectedName != "" {
 		// Maintain n.current.selected. The same file, if still present, is
 		// selected. Otherwise a file near it is selected.
@@ -108,14 +115,14 @@ func (n *navigation) refreshParent() {
 		return
 	}
 	if wd == "/" {
-		n.parent = newNavColumn(nil, nil)
+		n.parent = newNavColumn(nil, nil, nil)
 	} else {
-		names, attrs, err := readdirnames("..")
+		names, attrs, links, err := readdirnames("..")
 		if err != nil {
 			n.parent = newErrNavColumn(err)
 			return
 		}
-		n.parent = newNavColumn(names, attrs)
+		n.parent = newNavColumn(names, attrs, links)
 
 		cwd, err := os.Stat(".")
 		if err != nil {
@@ -134,6 +141,8 @@ func (n *navigation) refreshParent() {
 }
 
 func (n *navigation) refreshDirPreview() {
+	image := n.image
+	n.image = nil
 	if n.current.selected != -1 {
 		name := n.current.selectedName()
 		fi, err := os.Stat(name)
@@ -142,15 +151,24 @@ func (n *navigation) refreshDirPreview() {
 			return
 		}
 		if fi.Mode().IsDir() {
-			names, attrs, err := readdirnames(name)
+			names, attrs, links, err := readdirnames(name)
 			if err != nil {
 				n.dirPreview = newErrNavColumn(err)
 				return
 			}
-			n.dirPreview = newNavColumn(names, attrs)
+			n.dirPreview = newNavColumn(names, attrs, links)
 		} else {
 			// TODO(xiaq): Support regular file preview in navigation mode
 			n.dirPreview = nil
+			if fi.Mode().IsRegular() && isImageFile(name) {
+				// Keep the decoded image if the file is unchanged
+				p, _ := filepath.Abs(name)
+				if image != nil && image.path == p && image.modTime.Equal(fi.ModTime()) {
+					n.image = image
+				} else {
+					n.image = newNavImage(p, fi)
+				}
+			}
 		}
 	} else {
 		n.dirPreview = nil
@@ -0,0 +1,75 @@
+package edit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// The editor redraws when keys are read, and also when other goroutines ask
+// it to, like one that watches jobs running in the background. They can show
+// a message, like a job finishing, with Notify, or just redraw with Redraw to
+// update the prompts.
+//
+// Messages are shown above the prompt until a key is pressed, or for
+// MessageTimeout. The last MaxMessages of them are kept, and le:messages
+// prints them.
+
+const (
+	// MessageTimeout is how long messages are shown when no key is pressed.
+	MessageTimeout = 5 * time.Second
+	// MaxMessages is the number of messages le:messages prints.
+	MaxMessages = 100
+)
+
+func init() {
+	addEditorBuiltin("le:messages", builtinMessages)
+}
+
+// Notify shows msg above the prompt, and schedules a redraw. It can be called
+// from any goroutine; when ReadLine is not running, msg is shown when it
+// starts.
+func (ed *Editor) Notify(msg string) {
+	ed.post(func() { ed.showMessage(msg) })
+}
+
+// Redraw schedules a redraw, which also calls the prompt functions again. It
+// can be called from any goroutine, and does nothing when ReadLine is not
+// running.
+func (ed *Editor) Redraw() {
+	select {
+	case ed.redraws <- struct{}{}:
+	default:
+		// A redraw is already scheduled
+	}
+}
+
+// showMessage shows a message of Notify, and keeps it for le:messages.
+func (ed *Editor) showMessage(msg string) {
+	ed.messages = append(ed.messages, msg)
+	ed.messageLog = append(ed.messageLog, msg)
+	if len(ed.messageLog) > MaxMessages {
+		ed.messageLog = append([]string(nil), ed.messageLog[len(ed.messageLog)-MaxMessages:]...)
+	}
+	ed.messagesTimeout = time.After(MessageTimeout)
+}
+
+// clearMessages stops showing messages.
+func (ed *Editor) clearMessages() {
+	ed.messages = nil
+	ed.messagesTimeout = nil
+}
+
+// builtinMessages implements the le:messages builtin. It prints the messages
+// shown, oldest first, one per line.
+func builtinMessages(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) != 0 {
+		return "args error"
+	}
+	out := ev.OutFile()
+	for _, msg := range ed.messageLog {
+		fmt.Fprintln(out, msg)
+	}
+	return ""
+}
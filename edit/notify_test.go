@@ -0,0 +1,46 @@
+package edit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	ed := &Editor{options: newOptions(), redraws: make(chan struct{}, 1)}
+	ed.Notify("job 1 done")
+	ed.Notify("job 2 done")
+	select {
+	case <-ed.redraws:
+	default:
+		t.Errorf("Notify did not schedule a redraw")
+	}
+	ed.runPosted()
+	want := []string{"job 1 done", "job 2 done"}
+	if !reflect.DeepEqual(ed.messages, want) {
+		t.Errorf("messages after Notify => %v, want %v", ed.messages, want)
+	}
+	if ed.messagesTimeout == nil {
+		t.Errorf("messages after Notify are shown forever")
+	}
+	ed.clearMessages()
+	ed.runPosted()
+	if len(ed.messages) != 0 {
+		t.Errorf("messages after clearing => %v, want none", ed.messages)
+	}
+	if !reflect.DeepEqual(ed.messageLog, want) {
+		t.Errorf("message log => %v, want %v", ed.messageLog, want)
+	}
+}
+
+func TestMessageLogSize(t *testing.T) {
+	ed := &Editor{options: newOptions(), redraws: make(chan struct{}, 1)}
+	for i := 0; i < MaxMessages+10; i++ {
+		ed.Notify(fmt.Sprint(i))
+		ed.runPosted()
+	}
+	if len(ed.messageLog) != MaxMessages || ed.messageLog[0] != "10" {
+		t.Errorf("message log after %d messages => %d messages from %s, want %d from 10",
+			MaxMessages+10, len(ed.messageLog), ed.messageLog[0], MaxMessages)
+	}
+}
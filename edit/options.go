@@ -0,0 +1,135 @@
+package edit
+
+import (
+	"os"
+	"regexp"
+)
+
+// options are the settings of an Editor that elvish code changes with le:
+// builtins, like the key bindings and the theme, and that Go programs change
+// with the methods of Editor. Each Editor has its own, starting from the
+// defaults made by newOptions, so that editors of the same program don't
+// affect each other. The writer and the buffers it renders share the options
+// of their editor.
+type options struct {
+	// The attributes of the styles, set by themes; see theme.go.
+	attrs
+	// Overrides of the widths of runes; see wcwidth.go.
+	widthTable
+	// Whether styling is off; all styling is then stripped when committing
+	// buffers, without changing the layout. See SetStyling.
+	noStyle bool
+	// Whether the theme "auto" is applied, and the background last reported
+	// by the terminal, for which it is picked; see background.go.
+	autoTheme      bool
+	termBackground background
+	// What is shown after the output of a command that does not end with a
+	// newline, in the eol-marker style; see le:eol-marker.
+	eolMarkerText string
+
+	// The key bindings of each mode, changed with le:bind.
+	keyBindings map[bufferMode]map[Key]string
+	// Whether full-screen modes are shown on the alternate screen.
+	altScreen bool
+	// Whether flow control is kept while reading a line.
+	flowControl bool
+	// Features whose detection is overridden, with whether they are on.
+	featureOverrides map[string]bool
+	// Whether previews of files are shown.
+	filePreviewMode bool
+	// Whether instant mode is on.
+	instantMode bool
+
+	// The completion script of bash used, or "" if bash completion is off.
+	bashCompletionScript string
+	// How completion starts, "menu" or "prefix"; see le:completion-style.
+	completionStyle string
+	// The maximum number of candidates shown; see le:completion-limit.
+	completionLimit int
+	// The completion rankers that can be chosen, by name, and the names of
+	// those chosen, or none to keep the order candidates are found in.
+	completionRankers map[string]CompletionRanker
+	completionSort    []string
+	// The autosuggestion sources that can be chosen, by name, and the name
+	// of the current one, or "off".
+	historyRankers map[string]HistoryRanker
+	suggestSource  string
+
+	// The checks added with AddDangerCheck, and the patterns added with
+	// le:danger, each of them words separated by spaces.
+	dangerChecks   []DangerCheck
+	dangerPatterns []string
+	// The commands added as hooks with le:hook, by kind.
+	userHooks map[string][]string
+	// The templates of snippets, by name.
+	snippets map[string]string
+
+	// "off", "consecutive" to drop lines repeating the last one, or "all" to
+	// also move lines used before to the end of the history.
+	historyDedup string
+	// Whether lines starting with a space are kept out of the history, and
+	// the patterns of other lines kept out.
+	historyIgnoreSpace bool
+	historyIgnore      []*regexp.Regexp
+	// "global", "project" or "directory", and the scope toggle-dir-history
+	// goes back to.
+	historyScope         string
+	scopeBeforeDirectory string
+	// Names of files or directories marking project roots.
+	projectMarkers []string
+	// Whether entries of other sessions are recalled after those of this
+	// one.
+	historyLocalFirst bool
+
+	// The locale of messages, without encoding or modifier, like "pt_BR", or
+	// "" for English, and the translations of English messages by locale.
+	locale       string
+	translations map[string]map[string]string
+}
+
+// newOptions returns the default options.
+func newOptions() *options {
+	o := &options{
+		attrs:          defaultAttrs(),
+		noStyle:        os.Getenv("NO_COLOR") != "",
+		termBackground: unknownBackground,
+		eolMarkerText:  defaultEOLMarker,
+
+		keyBindings:      make(map[bufferMode]map[Key]string),
+		featureOverrides: parseFeatureOverrides(os.Getenv("ELVISH_FEATURES")),
+		filePreviewMode:  true,
+
+		bashCompletionScript: findBashCompletionScript(),
+		completionStyle:      "menu",
+		completionLimit:      500,
+		completionRankers:    make(map[string]CompletionRanker),
+		historyRankers:       make(map[string]HistoryRanker),
+		suggestSource:        "recent",
+
+		dangerPatterns: append([]string(nil), defaultDangerPatterns...),
+		userHooks:      make(map[string][]string),
+		snippets:       make(map[string]string),
+
+		historyDedup:         "off",
+		historyScope:         "global",
+		scopeBeforeDirectory: "global",
+		projectMarkers:       append([]string(nil), defaultProjectMarkers...),
+		historyLocalFirst:    true,
+
+		locale:       localeFromEnv(),
+		translations: make(map[string]map[string]string),
+	}
+	for mode, kb := range defaultKeyBindings {
+		o.keyBindings[mode] = make(map[Key]string, len(kb))
+		for k, name := range kb {
+			o.keyBindings[mode][k] = name
+		}
+	}
+	for name, f := range builtinCompletionRankers {
+		o.completionRankers[name] = f
+	}
+	for name, f := range builtinHistoryRankers {
+		o.historyRankers[name] = f
+	}
+	return o
+}
@@ -0,0 +1,185 @@
+package edit
+
+import (
+	"sort"
+	"strings"
+)
+
+// The command palette, started with Alt-x in insert mode or : in command mode,
+// lists editor builtins by name, with the keys bound to them in the mode it is
+// started from, and runs the selected one on Enter. Typing filters the list
+// fuzzily: the typed characters must appear in the name in order, and names
+// where they are closer together come first.
+
+// palette keeps the status of palette mode.
+type palette struct {
+	// The mode the palette is started from, where the builtin is run.
+	mode    bufferMode
+	filter  string
+	names   []string
+	current int
+}
+
+// inPalette returns whether the builtin named name is listed in the palette.
+// Builtins that act on the key that runs them, and those of the palette
+// itself, are not. Neither are builtins only bound in modes other than insert
+// and command mode, since they need the state of those modes.
+func (o *options) inPalette(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "default-"), strings.HasSuffix(name, "-palette"),
+		name == "insert-key", name == "vi-command-key", name == "literal-key":
+		return false
+	}
+	boundElsewhere := false
+	for mode, kb := range o.keyBindings {
+		for _, n := range kb {
+			if n != name {
+				continue
+			}
+			if mode == modeInsert || mode == modeCommand {
+				return true
+			}
+			boundElsewhere = true
+		}
+	}
+	return !boundElsewhere
+}
+
+// fuzzyMatch returns whether the runes of filter appear in name in order, and
+// how many runes of name are skipped between the first and last of them.
+func fuzzyMatch(name, filter string) (bool, int) {
+	gaps, started := 0, false
+	rest := []rune(filter)
+	for _, r := range name {
+		if len(rest) == 0 {
+			break
+		}
+		if r == rest[0] {
+			rest = rest[1:]
+			started = true
+		} else if started {
+			gaps++
+		}
+	}
+	return len(rest) == 0, gaps
+}
+
+// paletteNames returns the names of builtins in the palette matching filter,
+// best matches first.
+func (o *options) paletteNames(filter string) []string {
+	var ms paletteMatches
+	for name := range leBuiltins {
+		if !o.inPalette(name) {
+			continue
+		}
+		if ok, gaps := fuzzyMatch(name, filter); ok {
+			ms = append(ms, paletteMatch{name, gaps})
+		}
+	}
+	sort.Sort(ms)
+	names := make([]string, len(ms))
+	for i, m := range ms {
+		names[i] = m.name
+	}
+	return names
+}
+
+type paletteMatch struct {
+	name string
+	gaps int
+}
+
+type paletteMatches []paletteMatch
+
+func (ms paletteMatches) Len() int      { return len(ms) }
+func (ms paletteMatches) Swap(i, j int) { ms[i], ms[j] = ms[j], ms[i] }
+func (ms paletteMatches) Less(i, j int) bool {
+	if ms[i].gaps != ms[j].gaps {
+		return ms[i].gaps < ms[j].gaps
+	}
+	return ms[i].name < ms[j].name
+}
+
+// boundKeys returns the keys bound to the builtin named name in mode, sorted
+// and separated by commas.
+func (o *options) boundKeys(mode bufferMode, name string) string {
+	var keys []Key
+	for k, n := range o.keyBindings[mode] {
+		if n == name && k != DefaultBinding {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(keySlice(keys))
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = k.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// setFilter sets the filter, and finds the builtins in the palette of o
+// matching it.
+func (p *palette) setFilter(o *options, filter string) {
+	p.filter = filter
+	p.names = o.paletteNames(filter)
+	p.current = 0
+}
+
+func startPalette(ed *Editor, k Key) *leReturn {
+	ed.palette = &palette{mode: ed.mode}
+	ed.palette.setFilter(ed.options, "")
+	ed.mode = modePalette
+	return nil
+}
+
+func selectPaletteUp(ed *Editor, k Key) *leReturn {
+	if ed.palette.current > 0 {
+		ed.palette.current--
+	}
+	return nil
+}
+
+func selectPaletteDown(ed *Editor, k Key) *leReturn {
+	if ed.palette.current < len(ed.palette.names)-1 {
+		ed.palette.current++
+	}
+	return nil
+}
+
+func killPaletteRuneLeft(ed *Editor, k Key) *leReturn {
+	runes := []rune(ed.palette.filter)
+	if len(runes) > 0 {
+		ed.palette.setFilter(ed.options, string(runes[:len(runes)-1]))
+	}
+	return nil
+}
+
+func acceptPalette(ed *Editor, k Key) *leReturn {
+	p := ed.palette
+	ed.palette = nil
+	ed.mode = p.mode
+	if len(p.names) == 0 {
+		return nil
+	}
+	ret := leBuiltins[p.names[p.current]](ed, k)
+	if ret != nil && ret.action == reprocessKey {
+		// The key that runs the palette is not for the builtin
+		return nil
+	}
+	return ret
+}
+
+func cancelPalette(ed *Editor, k Key) *leReturn {
+	ed.mode = ed.palette.mode
+	ed.palette = nil
+	return nil
+}
+
+func defaultPalette(ed *Editor, k Key) *leReturn {
+	if k.Mod == 0 && k.Rune > 0 {
+		ed.palette.setFilter(ed.options, ed.palette.filter+string(k.Rune))
+	} else {
+		ed.pushTip(ed.trf("Unbound: %s", k))
+	}
+	return nil
+}
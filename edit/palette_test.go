@@ -0,0 +1,54 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var fuzzyMatchTests = []struct {
+	name, filter string
+	ok           bool
+	gaps         int
+}{
+	{"kill-line-left", "", true, 0},
+	{"kill-line-left", "kill", true, 0},
+	{"kill-line-left", "kll", true, 1},
+	{"kill-line-left", "klr", false, 0},
+	{"start-insert", "sins", true, 5},
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, tt := range fuzzyMatchTests {
+		ok, gaps := fuzzyMatch(tt.name, tt.filter)
+		if ok != tt.ok || ok && gaps != tt.gaps {
+			t.Errorf("fuzzyMatch(%q, %q) => (%v, %d), want (%v, %d)",
+				tt.name, tt.filter, ok, gaps, tt.ok, tt.gaps)
+		}
+	}
+}
+
+func TestPaletteNames(t *testing.T) {
+	o := newOptions()
+	wanted := []string{"start-literal", "start-completion", "start-history-listing"}
+	if names := o.paletteNames("start-li"); !reflect.DeepEqual(names, wanted) {
+		t.Errorf("paletteNames(%q) => %q, want %q", "start-li", names, wanted)
+	}
+	for _, name := range o.paletteNames("") {
+		if !o.inPalette(name) {
+			t.Errorf("paletteNames lists %s", name)
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.line, ed.dot = "echo foo", 8
+	keys := []Key{{'x', Alt}, {'k', 0}, {'l', 0}, {'l', 0}, {'l', 0}, {Enter, 0}}
+	for _, k := range keys {
+		ed.handleRead(keyRead(k))
+	}
+	if ed.line != "" || ed.mode != modeInsert {
+		t.Errorf("palette kill-line-left => %q, mode %d, want %q, mode %d",
+			ed.line, ed.mode, "", modeInsert)
+	}
+}
@@ -0,0 +1,79 @@
+package edit
+
+import (
+	"strings"
+)
+
+// Pastes come as PasteEvents when the terminal supports bracketed paste. A
+// paste of one line without control characters is inserted right away. Other
+// pastes are shown in paste mode to be confirmed, with control characters
+// other than tabs and newlines removed: a newline in a paste inserted blindly
+// would run commands the user has never seen, and control characters can hide
+// them or change the meaning of the line.
+
+// pasteState keeps the status of paste mode.
+type pasteState struct {
+	text string
+	// The number of control characters removed from the text.
+	removed int
+}
+
+// sanitizePaste normalizes line endings in text to \n and removes other
+// control characters except tabs, including C1 controls and DEL. It returns
+// the result and how many characters are removed.
+func sanitizePaste(text string) (string, int) {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	removed := 0
+	clean := strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' && r != '\t' || 0x7f <= r && r < 0xa0 {
+			removed++
+			return -1
+		}
+		return r
+	}, text)
+	return clean, removed
+}
+
+// handlePaste inserts a paste at the dot, or starts paste mode so that it can
+// be confirmed.
+func (ed *Editor) handlePaste(text string) {
+	// Pastes always go into the line; leave other modes
+	ed.mode = modeInsert
+	ed.completion = nil
+	ed.navigation = nil
+	ed.snippet = nil
+
+	clean, removed := sanitizePaste(text)
+	if removed == 0 && !strings.ContainsRune(clean, '\n') {
+		ed.insertAtDot(clean)
+		return
+	}
+	ed.mode = modePaste
+	ed.paste = &pasteState{clean, removed}
+	ed.pushTip(ed.tr("Enter to insert, Ctrl-[ to discard"))
+}
+
+func (ed *Editor) insertAtDot(text string) {
+	ed.line = ed.line[:ed.dot] + text + ed.line[ed.dot:]
+	ed.dot += len(text)
+}
+
+func acceptPaste(ed *Editor, k Key) *leReturn {
+	ed.insertAtDot(ed.paste.text)
+	ed.paste = nil
+	ed.mode = modeInsert
+	return nil
+}
+
+func cancelPaste(ed *Editor, k Key) *leReturn {
+	ed.paste = nil
+	ed.mode = modeInsert
+	ed.pushTip(ed.tr("paste discarded"))
+	return nil
+}
+
+func defaultPaste(ed *Editor, k Key) *leReturn {
+	cancelPaste(ed, k)
+	return &leReturn{action: reprocessKey}
+}
@@ -0,0 +1,48 @@
+package edit
+
+import "testing"
+
+var sanitizePasteTests = []struct {
+	text    string
+	want    string
+	removed int
+}{
+	{"echo hello", "echo hello", 0},
+	{"a\tb\nc", "a\tb\nc", 0},
+	{"a\r\nb\rc", "a\nb\nc", 0},
+	{"rm -rf /\x1b[2K\x1b[1Aecho", "rm -rf /[2K[1Aecho", 2},
+	{"a\x00b\x7fc\u0085d", "abcd", 3},
+	{"好 x", "好 x", 0},
+}
+
+func TestSanitizePaste(t *testing.T) {
+	for _, tt := range sanitizePasteTests {
+		out, removed := sanitizePaste(tt.text)
+		if out != tt.want || removed != tt.removed {
+			t.Errorf("sanitizePaste(%q) => (%q, %v), want (%q, %v)",
+				tt.text, out, removed, tt.want, tt.removed)
+		}
+	}
+}
+
+var handlePasteTests = []struct {
+	text string
+	mode bufferMode
+	line string
+}{
+	{"hello", modeInsert, "echo hello"},
+	{"hello\nrm -rf ~\n", modePaste, "echo "},
+	{"a\x1bb", modePaste, "echo "},
+}
+
+func TestHandlePaste(t *testing.T) {
+	for _, tt := range handlePasteTests {
+		ed := &Editor{options: newOptions()}
+		ed.line, ed.dot = "echo ", 5
+		ed.handlePaste(tt.text)
+		if ed.mode != tt.mode || ed.line != tt.line {
+			t.Errorf("handlePaste(%q) => mode %s, line %q, want mode %s, line %q",
+				tt.text, modeNames[ed.mode], ed.line, modeNames[tt.mode], tt.line)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package edit
+
+// Input methods compose text, like CJK characters from their readings, before
+// committing it as keys. A bridge to an input method can show the text being
+// composed, the preedit string, with SetPreedit; it is drawn underlined at the
+// dot, but not inserted into the line. The bridge sends the committed text as
+// keys, and clears the preedit string, when composition ends.
+
+// preedit is the text being composed with an input method, and the position
+// of the cursor in it.
+type preedit struct {
+	text   string
+	cursor int
+}
+
+// SetPreedit sets the text being composed with an input method, and the byte
+// offset of the cursor in it; an empty text clears it. It can be called from
+// any goroutine, while ReadLine is running or not; it is cleared when
+// ReadLine returns.
+func (ed *Editor) SetPreedit(text string, cursor int) {
+	if cursor < 0 || cursor > len(text) {
+		cursor = len(text)
+	}
+	ed.post(func() { ed.preedit = preedit{text, cursor} })
+}
+
+// clearPreedit clears the text set with SetPreedit.
+func (ed *Editor) clearPreedit() {
+	ed.preedit = preedit{}
+}
+
+// writePreedit writes the preedit string, placing the dot at its cursor, or
+// just places the dot if there is none.
+func (b *buffer) writePreedit(pe preedit) {
+	b.writes(pe.text[:pe.cursor], b.attrForPreedit)
+	b.markDot()
+	b.writes(pe.text[pe.cursor:], b.attrForPreedit)
+}
@@ -0,0 +1,31 @@
+package edit
+
+import "testing"
+
+var setPreeditTests = []struct {
+	text   string
+	cursor int
+	want   preedit
+}{
+	{"你好", 3, preedit{"你好", 3}},
+	{"你好", 0, preedit{"你好", 0}},
+	{"你好", -1, preedit{"你好", 6}},
+	{"你好", 7, preedit{"你好", 6}},
+	{"", 0, preedit{}},
+}
+
+func TestSetPreedit(t *testing.T) {
+	for _, tt := range setPreeditTests {
+		ed := &Editor{options: newOptions(), redraws: make(chan struct{}, 1)}
+		ed.SetPreedit(tt.text, tt.cursor)
+		select {
+		case <-ed.redraws:
+		default:
+			t.Errorf("SetPreedit(%q, %v) did not notify", tt.text, tt.cursor)
+		}
+		ed.runPosted()
+		if ed.preedit != tt.want {
+			t.Errorf("SetPreedit(%q, %v) => %v, want %v", tt.text, tt.cursor, ed.preedit, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package edit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/xiaq/elvish/edit/tty"
+	"github.com/xiaq/elvish/eval"
+)
+
+// previewWidth is the width of previews when the output is not a terminal.
+const previewWidth = 80
+
+// previewHeight is the height previews are rendered with, large enough to
+// never crop them.
+const previewHeight = 1000
+
+// previewLine is the line shown in previews when none is given.
+const previewLine = "echo preview"
+
+func init() {
+	addEditorBuiltin("le:preview", builtinPreview)
+}
+
+// bufferString returns the content of b with SGR sequences for the
+// attributes, unless styling is off. Lines are separated by newlines.
+func bufferString(b *buffer) string {
+	buf := new(bytes.Buffer)
+	attr := ""
+	for i, line := range b.cells {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		for _, c := range line {
+			if !b.noStyle && c.attr != attr {
+				fmt.Fprintf(buf, "\033[m\033[%sm", c.attr)
+				attr = c.attr
+			}
+			buf.WriteRune(c.rune)
+		}
+	}
+	if attr != "" {
+		buf.WriteString("\033[m")
+	}
+	return buf.String()
+}
+
+// preview renders sample frames with the current prompts, one in insert mode
+// and one in command mode, as if the user has typed line.
+func (ed *Editor) preview(line string, width int) (string, error) {
+	if ed.promptFn == nil {
+		return "", fmt.Errorf("no prompt has been shown yet")
+	}
+	var frames []string
+	for _, mode := range []bufferMode{modeInsert, modeCommand} {
+		bs := &editorState{
+			prompt: ed.promptFn(), rprompt: ed.rpromptFn(),
+			line: line, dot: len(line), mode: mode,
+		}
+		for token := range Highlight("<preview>", line, ed.ev) {
+			bs.tokens = append(bs.tokens, token)
+		}
+		frames = append(frames, bufferString(ed.writer.render(bs, nil, width, previewHeight)))
+	}
+	return strings.Join(frames, "\n\n") + "\n", nil
+}
+
+// builtinPreview implements the le:preview builtin. It prints sample frames
+// with the current prompts, so that the effect of changing them can be seen
+// without waiting for the next prompt. Arguments are joined to form the line
+// shown, defaulting to previewLine.
+func builtinPreview(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	line := previewLine
+	if len(args) > 0 {
+		words := make([]string, len(args))
+		for i, a := range args {
+			words[i] = a.String()
+		}
+		line = strings.Join(words, " ")
+	}
+	out := ev.OutFile()
+	width := int(tty.GetWinsize(int(out.Fd())).Col)
+	if width == 0 {
+		width = previewWidth
+	}
+	s, err := ed.preview(line, width)
+	if err != nil {
+		return err.Error()
+	}
+	out.WriteString(s)
+	return ""
+}
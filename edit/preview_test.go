@@ -0,0 +1,23 @@
+package edit
+
+import (
+	"testing"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
+)
+
+func TestPreview(t *testing.T) {
+	o := newOptions()
+	ed := &Editor{writer: newWriter(nil, o), ev: eval.NewEvaluator(), options: o}
+	ed.SetStyling(false)
+	if _, err := ed.preview("ls", 10); err == nil {
+		t.Errorf("preview before ReadLine => no error, want error")
+	}
+	ed.promptFn = func() styled.Text { return styled.Plain("> ") }
+	ed.rpromptFn = func() styled.Text { return styled.Plain("r") }
+	wanted := "> ls     r\n\n> ls     r\nCommand\n"
+	if out, err := ed.preview("ls", 10); out != wanted || err != nil {
+		t.Errorf("preview(%q, 10) => (%q, %v), want (%q, nil)", "ls", out, err, wanted)
+	}
+}
@@ -3,12 +3,20 @@ package edit
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/logger"
 	"github.com/xiaq/elvish/util"
 )
 
+var readerLog = logger.New("reader")
+
 const (
 	ReaderOutChanSize int = 16
 )
@@ -20,14 +28,28 @@ type readerCtrl byte
 const (
 	readerStop readerCtrl = iota
 	readerContinue
-	readerQuit
 )
 
 const (
+	// EscTimeout is the default time to wait for the rest of a sequence
+	// after an Escape.
 	EscTimeout time.Duration = 10 * time.Millisecond
 	CPRTimeout               = 10 * time.Millisecond
 )
 
+// escConfig determines how an Escape not followed by a function key sequence
+// is read.
+type escConfig struct {
+	// How long to wait for another rune after an Escape.
+	timeout time.Duration
+	// If true, an Escape followed by another rune within timeout is read as
+	// an Alt- key. If false, timeout is ignored and an Escape is read as
+	// Ctrl-[ unless a function key sequence is already there.
+	meta bool
+}
+
+var defaultEscConfig = escConfig{EscTimeout, true}
+
 const (
 	RuneTimeout rune = -1
 )
@@ -37,6 +59,10 @@ var (
 	BadCPR     = errors.New("bad CPR")
 )
 
+func init() {
+	addEditorBuiltin("le:escape", builtinEscape)
+}
+
 type BadEscSeq struct {
 	seq string
 	msg string
@@ -50,27 +76,128 @@ func (bes *BadEscSeq) Error() string {
 	return fmt.Sprintf("bad escape sequence %q: %s", bes.seq, bes.msg)
 }
 
+// termReplyType identifies the type of a reply of the terminal to a query.
+type termReplyType int
+
+// Possible values for termReplyType.
+const (
+	replyDA1    termReplyType = iota // Primary device attributes: \e[?...c
+	replyDECRPM                      // Report mode: \e[?mode;value$y
+	replyColor                       // Color: \e]Ps;rgb:r/g/b, ended by \e\\ or BEL
+)
+
+// OSCTimeout is how long the reader waits for each rune of an OSC sequence,
+// which is only sent by the terminal at once as a reply to a query, after the
+// first one.
+const OSCTimeout = 50 * time.Millisecond
+
+// termReply is a reply of the terminal to a query.
+type termReply struct {
+	typ    termReplyType
+	params []int
+}
+
+// FocusEvent is reported by the terminal when its window gains or loses
+// focus, if focus reporting (mode 1004) is turned on.
+type FocusEvent int
+
+// Possible values for FocusEvent.
+const (
+	NoFocusEvent FocusEvent = iota
+	FocusIn                 // \e[I
+	FocusOut                // \e[O
+)
+
+// PasteEvent is text pasted into the terminal, if bracketed paste (mode 2004)
+// is turned on. The terminal sends it between \e[200~ and \e[201~.
+type PasteEvent struct {
+	Text string
+}
+
+// OneRead is a key, a Cursor Position Report (\e[<row>;<col>R, with row and
+// column numbers starting from 1), a reply to another query, a focus event, a
+// paste, or an error.
 type OneRead struct {
-	Key Key
-	CPR pos
-	Err error
+	Key   Key
+	CPR   pos
+	Reply *termReply
+	Focus FocusEvent
+	Paste *PasteEvent
+	Err   error
+}
+
+func keyRead(k Key) OneRead {
+	return OneRead{Key: k, CPR: InvalidPos}
+}
+
+func replyRead(rep *termReply) OneRead {
+	return OneRead{CPR: InvalidPos, Reply: rep}
+}
+
+// timedReader reads runes, giving up after a timeout. It is an interface so
+// that tests can script the runes and the delays between them.
+type timedReader interface {
+	// ReadRuneTimeout reads a rune, returning RuneTimeout if none comes
+	// within d. A negative d means no timeout.
+	ReadRuneTimeout(d time.Duration) rune
+}
+
+// chanTimedReader is a timedReader reading from a channel.
+type chanTimedReader <-chan rune
+
+func (ch chanTimedReader) ReadRuneTimeout(d time.Duration) rune {
+	if d == 0 {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return RuneTimeout
+			}
+			return r
+		default:
+			return RuneTimeout
+		}
+	}
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			// The AsyncReader is closed
+			return RuneTimeout
+		}
+		return r
+	case <-util.After(d):
+		return RuneTimeout
+	}
 }
 
 // Reader converts a stream of runes into a stream of Keys
 type Reader struct {
 	ar         *util.AsyncReader
+	timed      timedReader
 	ones       chan OneRead
 	ctrl       chan readerCtrl
 	ctrlAck    chan bool
+	escCh      chan escConfig
+	esc        escConfig
 	currentSeq string
+	// Runes read ahead by readOne and to be read again.
+	unread []rune
+	// quit is closed by Close, and done when the goroutine exits.
+	quit, done chan struct{}
+	closeOnce  sync.Once
 }
 
 func NewReader(f *os.File) *Reader {
+	ar := util.NewAsyncReader(f)
 	rd := &Reader{
-		ar:      util.NewAsyncReader(f),
+		ar:      ar,
+		timed:   chanTimedReader(ar.Chan()),
 		ones:    make(chan OneRead, ReaderOutChanSize),
 		ctrl:    make(chan readerCtrl),
 		ctrlAck: make(chan bool),
+		escCh:   make(chan escConfig),
+		esc:     defaultEscConfig,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 	go rd.run()
 	return rd
@@ -81,8 +208,23 @@ func (rd *Reader) Chan() <-chan OneRead {
 }
 
 func (rd *Reader) sendCtrl(c readerCtrl) {
-	rd.ctrl <- c
-	<-rd.ctrlAck
+	select {
+	case rd.ctrl <- c:
+		<-rd.ctrlAck
+	case <-rd.done:
+	}
+}
+
+// SetEscape sets how an Escape not followed by a function key sequence is
+// read. If meta is true, it is read as an Alt- key when another key follows
+// within timeout, and as Ctrl-[ otherwise. If meta is false, it is always read
+// as Ctrl-[ without waiting.
+func (rd *Reader) SetEscape(timeout time.Duration, meta bool) {
+	select {
+	case rd.escCh <- escConfig{timeout, meta}:
+		<-rd.ctrlAck
+	case <-rd.done:
+	}
 }
 
 func (rd *Reader) Stop() {
@@ -95,9 +237,17 @@ func (rd *Reader) Continue() {
 	rd.sendCtrl(readerContinue)
 }
 
-func (rd *Reader) Quit() {
-	rd.ar.Quit()
-	rd.sendCtrl(readerQuit)
+// Close stops the Reader and waits for its goroutines to exit, whether it is
+// stopped or running, even if nobody is receiving from Chan. The channel
+// returned by Chan is closed. It is safe to call Close more than once.
+func (rd *Reader) Close() error {
+	var err error
+	rd.closeOnce.Do(func() {
+		close(rd.quit)
+		err = rd.ar.Close()
+		<-rd.done
+	})
+	return err
 }
 
 func (rd *Reader) badEscSeq(msg string) {
@@ -105,13 +255,11 @@ func (rd *Reader) badEscSeq(msg string) {
 }
 
 func (rd *Reader) readRune(d time.Duration) rune {
-	select {
-	case r := <-rd.ar.Chan():
+	r := rd.timed.ReadRuneTimeout(d)
+	if r != RuneTimeout {
 		rd.currentSeq += string(r)
-		return r
-	case <-util.After(d):
-		return RuneTimeout
 	}
+	return r
 }
 
 func (rd *Reader) readAssertedRune(r rune, d time.Duration) {
@@ -144,36 +292,75 @@ func xtermModify(k Key, mod int, seq string) (Key, error) {
 	return k, nil
 }
 
-// G3 style function key sequences: ^[O followed by exactly one character.
-var g3Seq = map[rune]rune{
+// G3 style function key sequences: ^[O followed by exactly one character,
+// optionally preceded by an xterm modifier.
+var g3Seq = map[rune]Key{
 	// F1-F4: xterm, libvte and tmux
-	'P': F1, 'Q': F2,
-	'R': F3, 'S': F4,
+	'P': Key{F1, 0}, 'Q': Key{F2, 0},
+	'R': Key{F3, 0}, 'S': Key{F4, 0},
 
 	// Home and End: libvte
-	'H': Home, 'F': End,
+	'H': Key{Home, 0}, 'F': Key{End, 0},
+
+	// Arrow keys in application cursor mode, and Enter in application keypad
+	// mode: xterm and most others
+	'A': Key{Up, 0}, 'B': Key{Down, 0},
+	'C': Key{Right, 0}, 'D': Key{Left, 0},
+	'M': Key{Enter, 0},
+
+	// Ctrl- arrow keys: rxvt
+	'a': Key{Up, Ctrl}, 'b': Key{Down, Ctrl},
+	'c': Key{Right, Ctrl}, 'd': Key{Left, Ctrl},
+
+	// The keypad in application keypad mode (DECKPAM), read as the keys they
+	// are labelled with: VT100 and most others
+	'p': Key{'0', 0}, 'q': Key{'1', 0}, 'r': Key{'2', 0}, 's': Key{'3', 0},
+	't': Key{'4', 0}, 'u': Key{'5', 0}, 'v': Key{'6', 0}, 'w': Key{'7', 0},
+	'x': Key{'8', 0}, 'y': Key{'9', 0},
+	'j': Key{'*', 0}, 'k': Key{'+', 0}, 'l': Key{',', 0}, 'm': Key{'-', 0},
+	'n': Key{'.', 0}, 'o': Key{'/', 0}, 'X': Key{'=', 0},
 }
 
-func (rd *Reader) readOne(r rune) (k Key, cpr pos, err error) {
-	defer util.Recover(&err)
+func (rd *Reader) readOne(r rune) (or OneRead) {
+	or.CPR = InvalidPos
+	defer func() {
+		if or.Err != nil {
+			readerLog.Warn("bad read", "rune", string(r), "seq", rd.currentSeq, "err", or.Err)
+		} else {
+			readerLog.Debug("read", "rune", string(r), "seq", rd.currentSeq, "key", or.Key)
+		}
+	}()
+	defer util.Recover(&or.Err)
 
 	rd.currentSeq = ""
 
 	switch r {
 	case Tab, Enter, Backspace:
-		k = Key{r, 0}
+		or.Key = Key{r, 0}
 	case 0x0:
-		k = Key{'`', Ctrl} // ^@
+		or.Key = Key{'`', Ctrl} // ^@
 	case 0x1d:
-		k = Key{'6', Ctrl} // ^^
+		or.Key = Key{'6', Ctrl} // ^^
 	case 0x1f:
-		k = Key{'/', Ctrl} // ^_
+		or.Key = Key{'/', Ctrl} // ^_
 	case 0x1b: // ^[ Escape
-		//rd.timed.Timeout = EscTimeout
-		//defer func() { rd.timed.Timeout = -1 }()
-		r2 := rd.readRune(EscTimeout)
+		escape := Key{'[', Ctrl}
+		timeout := rd.esc.timeout
+		if !rd.esc.meta {
+			timeout = 0
+		}
+		r2 := rd.readRune(timeout)
 		if r2 == RuneTimeout {
-			return Key{'[', Ctrl}, InvalidPos, nil
+			return keyRead(escape)
+		}
+		// altOr returns the Alt- key for r, or with meta off, Escape and
+		// reads r again.
+		altOr := func(r rune) Key {
+			if rd.esc.meta {
+				return Key{r, Alt}
+			}
+			rd.unread = append(rd.unread, r)
+			return escape
 		}
 		switch r2 {
 		case '[':
@@ -181,16 +368,24 @@ func (rd *Reader) readOne(r rune) (k Key, cpr pos, err error) {
 			// Read numeric parameters (if any)
 			nums := make([]int, 0, 2)
 			seq := "\x1b["
-			timeout := EscTimeout
+			// Replies to queries start with '?' and may contain '$'.
+			private, dollar := false, false
 			for {
 				r = rd.readRune(timeout)
 				// Timeout can only happen at first readRune.
 				if r == RuneTimeout {
-					return Key{'[', Alt}, InvalidPos, nil
+					return keyRead(altOr('['))
 				}
 				seq += string(r)
 				// After first rune read we turn off the timeout
 				timeout = -1
+				if r == '?' && len(seq) == 3 {
+					private = true
+					continue
+				} else if r == '$' && private && !dollar {
+					dollar = true
+					continue
+				}
 				if r != ';' && (r < '0' || r > '9') {
 					break
 				}
@@ -205,79 +400,229 @@ func (rd *Reader) readOne(r rune) (k Key, cpr pos, err error) {
 					nums[cur] = nums[cur]*10 + int(r-'0')
 				}
 			}
+			if private {
+				switch {
+				case r == 'c' && !dollar:
+					return replyRead(&termReply{replyDA1, nums})
+				case r == 'y' && dollar && len(nums) == 2:
+					return replyRead(&termReply{replyDECRPM, nums})
+				}
+				rd.badEscSeq("bad reply")
+			}
+			if (r == 'I' || r == 'O') && len(nums) == 0 {
+				focus := FocusIn
+				if r == 'O' {
+					focus = FocusOut
+				}
+				return OneRead{CPR: InvalidPos, Focus: focus}
+			}
+			if r == '[' && len(nums) == 0 {
+				// Linux console: ^[[[ followed by exactly one character
+				r = rd.readRune(-1)
+				if k, ok := linuxSeq[r]; ok {
+					return keyRead(Key{k, 0})
+				}
+				rd.badEscSeq("")
+			}
+			if r == '~' && len(nums) == 1 && nums[0] == 200 {
+				return rd.readPaste()
+			}
 			if r == 'R' {
 				// CPR
 				if len(nums) != 2 {
 					rd.badEscSeq("bad cpr")
 				}
-				return ZeroKey, pos{nums[0], nums[1]}, nil
+				return OneRead{CPR: pos{nums[0], nums[1]}}
 			} else {
 				k, err := parseCSI(nums, r, seq)
-				return k, InvalidPos, err
+				return OneRead{Key: k, CPR: InvalidPos, Err: err}
 			}
 		case 'O':
 			// G3 style function key sequence: read one rune.
-			r = rd.readRune(EscTimeout)
+			r = rd.readRune(timeout)
 			if r == RuneTimeout {
-				return Key{r2, Alt}, InvalidPos, nil
+				return keyRead(altOr(r2))
 			}
-			r, ok := g3Seq[r]
-			if ok {
-				return Key{r, 0}, InvalidPos, nil
+			mod := 0
+			if '0' <= r && r <= '9' {
+				// Modified, as sent by older xterm and konsole: \eO5P
+				// (Ctrl-F1)
+				mod = int(r - '0')
+				r = rd.readRune(-1)
+			}
+			if k, ok := g3Seq[r]; ok {
+				k, err := xtermModify(k, mod, rd.currentSeq)
+				return OneRead{Key: k, CPR: InvalidPos, Err: err}
 			}
 			rd.badEscSeq("")
+		case ']':
+			r = rd.readRune(timeout)
+			if r < '0' || r > '9' {
+				k := altOr(r2)
+				if r != RuneTimeout {
+					rd.unread = append(rd.unread, r)
+				}
+				return keyRead(k)
+			}
+			return rd.readOSC(r)
 		}
-		return Key{r2, Alt}, InvalidPos, nil
+		return keyRead(altOr(r2))
 	default:
 		// Sane Ctrl- sequences that agree with the keyboard...
 		if 0x1 <= r && r <= 0x1d {
-			k = Key{r + 0x40, Ctrl}
+			or.Key = Key{r + 0x40, Ctrl}
 		} else {
-			k = Key{r, 0}
+			or.Key = Key{r, 0}
 		}
 	}
-	return k, InvalidPos, nil
+	return or
+}
+
+// readOSC reads the rest of an OSC sequence after \e] and the first digit of
+// its parameter. Only replies with a color are understood.
+func (rd *Reader) readOSC(r rune) OneRead {
+	ps := 0
+	for '0' <= r && r <= '9' {
+		ps = ps*10 + int(r-'0')
+		r = rd.readRune(OSCTimeout)
+	}
+	if r != ';' {
+		rd.badEscSeq("bad OSC")
+	}
+	var text []rune
+	for {
+		r = rd.readRune(OSCTimeout)
+		if r == RuneTimeout {
+			rd.badEscSeq("unterminated OSC")
+		} else if r == 0x7 {
+			break
+		} else if r == 0x1b {
+			rd.readAssertedRune('\\', OSCTimeout)
+			break
+		}
+		text = append(text, r)
+	}
+	rgb, ok := parseRGB(string(text))
+	if !ok {
+		rd.badEscSeq("bad reply")
+	}
+	return replyRead(&termReply{replyColor, append([]int{ps}, rgb[:]...)})
+}
+
+// parseRGB parses a color in the rgb:r/g/b form of XParseColor, where each
+// component has 1 to 4 hex digits, into components of 16 bits.
+func parseRGB(s string) (rgb [3]int, ok bool) {
+	if !strings.HasPrefix(s, "rgb:") {
+		return rgb, false
+	}
+	parts := strings.Split(s[4:], "/")
+	if len(parts) != 3 {
+		return rgb, false
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 16)
+		if err != nil || len(part) == 0 || len(part) > 4 {
+			return rgb, false
+		}
+		rgb[i] = int(n * 0xffff / (1<<(4*uint(len(part))) - 1))
+	}
+	return rgb, true
+}
+
+// pasteEnd ends the text of a bracketed paste.
+const pasteEnd = "\x1b[201~"
+
+// readPaste reads the text of a bracketed paste, after \e[200~. If the input
+// ends before pasteEnd, what has been read is taken as the text.
+func (rd *Reader) readPaste() OneRead {
+	var text []rune
+	for {
+		r := rd.timed.ReadRuneTimeout(-1)
+		if r == RuneTimeout {
+			break
+		}
+		text = append(text, r)
+		if n := len(text) - len(pasteEnd); r == '~' && n >= 0 && string(text[n:]) == pasteEnd {
+			text = text[:n]
+			break
+		}
+	}
+	return OneRead{CPR: InvalidPos, Paste: &PasteEvent{string(text)}}
 }
 
 func (rd *Reader) stop() (quit bool) {
 	for {
 		select {
+		case rd.esc = <-rd.escCh:
+			rd.ctrlAck <- true
 		case ctrl := <-rd.ctrl:
 			rd.ctrlAck <- true
-			switch ctrl {
-			case readerQuit:
-				return true
-			case readerContinue:
+			if ctrl == readerContinue {
 				return false
 			}
+		case <-rd.quit:
+			return true
 		}
 	}
 }
 
+// send delivers or to the channel. It returns false if the Reader has been
+// closed in the meantime.
+func (rd *Reader) send(or OneRead) bool {
+	select {
+	case rd.ones <- or:
+		return true
+	case <-rd.quit:
+		return false
+	}
+}
+
 func (rd *Reader) run() {
+	defer close(rd.done)
 	defer close(rd.ones)
 
 	runes := rd.ar.Chan()
 
 	for {
+		if len(rd.unread) > 0 {
+			r := rd.unread[0]
+			rd.unread = rd.unread[1:]
+			if !rd.send(rd.readOne(r)) {
+				return
+			}
+			continue
+		}
 		select {
-		case r := <-runes:
-			k, c, e := rd.readOne(r)
-			rd.ones <- OneRead{k, c, e}
-		case ctrl := <-rd.ctrl:
-			rd.ctrlAck <- true
-			switch ctrl {
-			case readerStop:
-				if rd.stop() {
+		case r, ok := <-runes:
+			if !ok {
+				// End of input; report it once and wait to be closed
+				runes = nil
+				if !rd.send(OneRead{CPR: InvalidPos, Err: io.EOF}) {
 					return
 				}
-			case readerQuit:
+				continue
+			}
+			if !rd.send(rd.readOne(r)) {
 				return
 			}
+		case rd.esc = <-rd.escCh:
+			rd.ctrlAck <- true
+		case ctrl := <-rd.ctrl:
+			rd.ctrlAck <- true
+			if ctrl == readerStop && rd.stop() {
+				return
+			}
+		case <-rd.quit:
+			return
 		}
 	}
 }
 
+// The tables below map CSI-style function key sequences to keys. To support
+// a new terminal, add its sequences to the table for their form, or add a
+// table for a new form to parseCSI.
+
+// No parameters, or 1 and an xterm modifier: \e[A (Up), \e[1;5A (Ctrl-Up)
 var keyByLast = map[rune]Key{
 	'A': Key{Up, 0}, 'B': Key{Down, 0},
 	'C': Key{Right, 0}, 'D': Key{Left, 0},
@@ -285,11 +630,41 @@ var keyByLast = map[rune]Key{
 	'Z': Key{Tab, Shift},
 }
 
-// last == '~'
+// No parameters only
+var keyByLastOnly = map[rune]Key{
+	// Shift- arrow keys: rxvt
+	'a': Key{Up, Shift}, 'b': Key{Down, Shift},
+	'c': Key{Right, Shift}, 'd': Key{Left, Shift},
+}
+
+// last == '~', with a number and optionally an xterm modifier: \e[5~
+// (PageUp), \e[5;5~ (Ctrl-PageUp)
 var keyByNum0 = map[int]rune{
 	1: Home, 2: Insert, 3: Delete, 4: End, 5: PageUp, 6: PageDown,
+	// rxvt
+	7: Home, 8: End,
 	11: F1, 12: F2, 13: F3, 14: F4,
 	15: F5, 17: F6, 18: F7, 19: F8, 20: F9, 21: F10, 23: F11, 24: F12,
+	// VT220 and rxvt
+	25: F13, 26: F14, 28: F15, 29: F16, 31: F17, 32: F18, 33: F19, 34: F20,
+}
+
+// last == 'u', with a number and optionally an xterm modifier: the kitty
+// keyboard protocol, which has codes for F13 and above: \e[57376u (F13)
+var keyByNumU = map[int]rune{
+	57376: F13, 57377: F14, 57378: F15, 57379: F16, 57380: F17, 57381: F18,
+	57382: F19, 57383: F20, 57384: F21, 57385: F22, 57386: F23, 57387: F24,
+}
+
+// rxvt sends the sequences in keyByNum0 with '~' replaced by one of these to
+// indicate modifiers: \e[5^ (Ctrl-PageUp)
+var modByLast = map[rune]Mod{
+	'$': Shift, '^': Ctrl, '@': Ctrl | Shift,
+}
+
+// ^[[[ followed by exactly one character: Linux console
+var linuxSeq = map[rune]rune{
+	'A': F1, 'B': F2, 'C': F3, 'D': F4, 'E': F5,
 }
 
 // last == '~', num[0] == 27
@@ -297,12 +672,12 @@ var keyByNum0 = map[int]rune{
 // keyboard that can generate such sequences, but assumably some PC keyboard
 // with a numpad can.
 var keyByNum2 = map[int]rune{
-	9: '\t', 13: '\r',
+	9: '\t', 13: Enter,
 	33: '!', 35: '#', 39: '\'', 40: '(', 41: ')', 43: '+', 44: ',', 45: '-',
 	46: '.',
 	48: '0', 49: '1', 50: '2', 51: '3', 52: '4', 53: '5', 54: '6', 55: '7',
 	56: '8', 57: '9',
-	58: ':', 59: ';', 60: '<', 61: '=', 62: '>', 63: ';',
+	58: ':', 59: ';', 60: '<', 61: '=', 62: '>', 63: '?',
 }
 
 // Parse a CSI-style function key sequence.
@@ -319,6 +694,26 @@ func parseCSI(nums []int, last rune, seq string) (Key, error) {
 		}
 	}
 
+	if k, ok := keyByLastOnly[last]; ok && len(nums) == 0 {
+		return k, nil
+	}
+
+	if mod, ok := modByLast[last]; ok && len(nums) == 1 {
+		if r, ok := keyByNum0[nums[0]]; ok {
+			return Key{r, mod}, nil
+		}
+	}
+
+	if last == 'u' && (len(nums) == 1 || len(nums) == 2) {
+		if r, ok := keyByNumU[nums[0]]; ok {
+			k := Key{r, 0}
+			if len(nums) == 1 {
+				return k, nil
+			}
+			return xtermModify(k, nums[1], seq)
+		}
+	}
+
 	if last == '~' {
 		if len(nums) == 1 || len(nums) == 2 {
 			if r, ok := keyByNum0[nums[0]]; ok {
@@ -340,3 +735,36 @@ func parseCSI(nums []int, last rune, seq string) (Key, error) {
 
 	return ZeroKey, newBadEscSeq(seq, "")
 }
+
+// builtinEscape implements the le:escape builtin, which sets how the reader of
+// the editor reads an Escape. It takes either "meta" and an optional timeout
+// like "50ms", defaulting to EscTimeout, or "immediate". See
+// (*Reader).SetEscape.
+func builtinEscape(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	if len(args) == 0 || len(args) > 2 {
+		return "args error"
+	}
+	switch args[0].String() {
+	case "meta":
+		timeout := EscTimeout
+		if len(args) == 2 {
+			var err error
+			timeout, err = time.ParseDuration(args[1].String())
+			if err != nil {
+				return err.Error()
+			}
+			if timeout < 0 {
+				return "negative timeout"
+			}
+		}
+		ed.reader.SetEscape(timeout, true)
+	case "immediate":
+		if len(args) != 1 {
+			return "args error"
+		}
+		ed.reader.SetEscape(0, false)
+	default:
+		return "policy must be meta or immediate"
+	}
+	return ""
+}
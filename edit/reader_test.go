@@ -0,0 +1,282 @@
+package edit
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pause in a script makes the scriptedReader wait this long before the next
+// rune.
+type pause time.Duration
+
+// scriptedReader is a timedReader that plays a script of runes and pauses,
+// without actually waiting.
+type scriptedReader struct {
+	script []interface{}
+}
+
+func (sr *scriptedReader) ReadRuneTimeout(d time.Duration) rune {
+	waited := pause(0)
+	for len(sr.script) > 0 {
+		switch item := sr.script[0].(type) {
+		case rune:
+			sr.script = sr.script[1:]
+			return item
+		case pause:
+			if d >= 0 && time.Duration(waited+item) > d {
+				sr.script[0] = item - (pause(d) - waited)
+				return RuneTimeout
+			}
+			waited += item
+			sr.script = sr.script[1:]
+		}
+	}
+	return RuneTimeout
+}
+
+// script converts a string and pauses into a script.
+func script(items ...interface{}) []interface{} {
+	var s []interface{}
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			for _, r := range str {
+				s = append(s, r)
+			}
+		} else {
+			s = append(s, item)
+		}
+	}
+	return s
+}
+
+// readAll reads all of a script with readOne, as Reader.run does.
+func readAll(items []interface{}) []OneRead {
+	return readAllEsc(defaultEscConfig, items)
+}
+
+// readAllEsc is like readAll, with Escape read according to esc.
+func readAllEsc(esc escConfig, items []interface{}) []OneRead {
+	rd := &Reader{timed: &scriptedReader{items}, esc: esc}
+	var ones []OneRead
+	for {
+		var r rune
+		if len(rd.unread) > 0 {
+			r = rd.unread[0]
+			rd.unread = rd.unread[1:]
+		} else if r = rd.timed.ReadRuneTimeout(-1); r == RuneTimeout {
+			return ones
+		}
+		rd.currentSeq = ""
+		ones = append(ones, rd.readOne(r))
+	}
+}
+
+const long = pause(EscTimeout * 2)
+
+var readerTests = []struct {
+	script []interface{}
+	wanted []OneRead
+}{
+	// Plain keys and Ctrl- keys
+	{script("a\t\x01\x7f"), []OneRead{keyRead(Key{'a', 0}),
+		keyRead(Key{Tab, 0}), keyRead(Key{'A', Ctrl}), keyRead(Key{Backspace, 0})}},
+	// Escape alone, and followed by a key after a timeout
+	{script("\x1b"), []OneRead{keyRead(Key{'[', Ctrl})}},
+	{script("\x1b", long, "a"), []OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'a', 0})}},
+	// Alt- keys, including the prefixes of function key sequences
+	{script("\x1ba"), []OneRead{keyRead(Key{'a', Alt})}},
+	{script("\x1b["), []OneRead{keyRead(Key{'[', Alt})}},
+	{script("\x1bO", long), []OneRead{keyRead(Key{'O', Alt})}},
+	// Function keys, with sequences split by short pauses
+	{script("\x1b[A"), []OneRead{keyRead(Key{Up, 0})}},
+	{script("\x1b", pause(EscTimeout/2), "[1;5C"), []OneRead{keyRead(Key{Right, Ctrl})}},
+	{script("\x1b[5~\x1b[27;3;13~"), []OneRead{keyRead(Key{PageUp, 0}),
+		keyRead(Key{Enter, Alt})}},
+	{script("\x1b[27;5;63~"), []OneRead{keyRead(Key{'?', Ctrl})}},
+	{script("\x1bOP"), []OneRead{keyRead(Key{F1, 0})}},
+	// SS3 variants
+	{script("\x1bOA\x1bOM"), []OneRead{keyRead(Key{Up, 0}), keyRead(Key{Enter, 0})}},
+	{script("\x1bO5P"), []OneRead{keyRead(Key{F1, Ctrl})}},
+	// rxvt
+	{script("\x1b[7~\x1b[8~\x1b[11~"), []OneRead{keyRead(Key{Home, 0}),
+		keyRead(Key{End, 0}), keyRead(Key{F1, 0})}},
+	{script("\x1bOa\x1b[d"), []OneRead{keyRead(Key{Up, Ctrl}),
+		keyRead(Key{Left, Shift})}},
+	{script("\x1b[5^\x1b[2$\x1b[3@"), []OneRead{keyRead(Key{PageUp, Ctrl}),
+		keyRead(Key{Insert, Shift}), keyRead(Key{Delete, Ctrl | Shift})}},
+	// Higher function keys
+	{script("\x1b[25~\x1b[34;5~\x1b[57387u\x1b[57376;2u"), []OneRead{
+		keyRead(Key{F13, 0}), keyRead(Key{F20, Ctrl}), keyRead(Key{F24, 0}),
+		keyRead(Key{F13, Shift})}},
+	// Keypad in application keypad mode
+	{script("\x1bOq\x1bOk\x1bOM"), []OneRead{keyRead(Key{'1', 0}),
+		keyRead(Key{'+', 0}), keyRead(Key{Enter, 0})}},
+	// Linux console
+	{script("\x1b[[A\x1b[[E"), []OneRead{keyRead(Key{F1, 0}), keyRead(Key{F5, 0})}},
+	// CPR and replies to queries
+	{script("\x1b[3;5R"), []OneRead{{CPR: pos{3, 5}}}},
+	{script("\x1b[?62;22c"), []OneRead{{CPR: InvalidPos,
+		Reply: &termReply{replyDA1, []int{62, 22}}}}},
+	{script("\x1b[?c"), []OneRead{{CPR: InvalidPos, Reply: &termReply{replyDA1, []int{}}}}},
+	{script("\x1b]11;rgb:ffff/ffff/ffff\x1b\\"), []OneRead{{CPR: InvalidPos,
+		Reply: &termReply{replyColor, []int{11, 0xffff, 0xffff, 0xffff}}}}},
+	{script("\x1b]11;rgb:00/80/f\x07"), []OneRead{{CPR: InvalidPos,
+		Reply: &termReply{replyColor, []int{11, 0, 0x8080, 0xffff}}}}},
+	// Alt-] is not taken as the start of an OSC sequence
+	{script("\x1b]a"), []OneRead{keyRead(Key{']', Alt}), keyRead(Key{'a', 0})}},
+	// Bracketed paste, terminated or cut short
+	{script("\x1b[200~a\x1b[A\nb\x1b[201~c"), []OneRead{
+		{CPR: InvalidPos, Paste: &PasteEvent{"a\x1b[A\nb"}}, keyRead(Key{'c', 0})}},
+	{script("\x1b[200~ab"), []OneRead{{CPR: InvalidPos, Paste: &PasteEvent{"ab"}}}},
+	// Focus events
+	{script("\x1b[I\x1b[O"), []OneRead{{CPR: InvalidPos, Focus: FocusIn},
+		{CPR: InvalidPos, Focus: FocusOut}}},
+}
+
+var escReaderTests = []struct {
+	esc    escConfig
+	script []interface{}
+	wanted []OneRead
+}{
+	// A longer timeout
+	{escConfig{EscTimeout * 4, true}, script("\x1b", long, "a"),
+		[]OneRead{keyRead(Key{'a', Alt})}},
+	{escConfig{EscTimeout * 4, true}, script("\x1b", long, long, long, "a"),
+		[]OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'a', 0})}},
+	// Immediate Escape; function key sequences still work when they come at
+	// once
+	{escConfig{0, false}, script("\x1ba"),
+		[]OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'a', 0})}},
+	{escConfig{0, false}, script("\x1b\x1b"),
+		[]OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'[', Ctrl})}},
+	{escConfig{0, false}, script("\x1b["),
+		[]OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'[', 0})}},
+	{escConfig{0, false}, script("\x1bO", pause(1), "P"),
+		[]OneRead{keyRead(Key{'[', Ctrl}), keyRead(Key{'O', 0}), keyRead(Key{'P', 0})}},
+	{escConfig{0, false}, script("\x1b[A\x1bOP"),
+		[]OneRead{keyRead(Key{Up, 0}), keyRead(Key{F1, 0})}},
+	{escConfig{0, false}, script("\x1b", pause(1), "[A"), []OneRead{
+		keyRead(Key{'[', Ctrl}), keyRead(Key{'[', 0}), keyRead(Key{'A', 0})}},
+}
+
+func TestReader(t *testing.T) {
+	for _, tt := range readerTests {
+		if out := readAll(tt.script); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("reading %q => %v, want %v", tt.script, out, tt.wanted)
+		}
+	}
+	for _, tt := range escReaderTests {
+		if out := readAllEsc(tt.esc, tt.script); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("reading %q with %v => %v, want %v", tt.script, tt.esc, out, tt.wanted)
+		}
+	}
+}
+
+var badReaderTests = [][]interface{}{
+	script("\x1b[5;9~"),
+	script("\x1b[99~"),
+	script("\x1b[1;2;3A"),
+	script("\x1b[1R"),
+	script("\x1b[?1$x"),
+	script("\x1bOz"),
+	script("\x1bO9P"),
+	script("\x1b[1a"),
+	script("\x1b[99^"),
+	script("\x1b[[x"),
+	script("\x1b[97u"),
+	script("\x1b]11x"),
+	script("\x1b]11;foo\x07"),
+	script("\x1b]11;rgb:1/2/3"),
+}
+
+func TestReaderBadSequences(t *testing.T) {
+	for _, s := range badReaderTests {
+		out := readAll(s)
+		if len(out) != 1 || out[0].Err == nil {
+			t.Errorf("reading %q => %v, want one error", s, out)
+		}
+	}
+}
+
+// FuzzReader checks that reading arbitrary input never panics or blocks, and
+// that each read is a key, a CPR, a reply or a *BadEscSeq. Run it with
+// go test -fuzz FuzzReader.
+func FuzzReader(f *testing.F) {
+	for _, tt := range readerTests {
+		f.Add(scriptString(tt.script))
+	}
+	for _, s := range badReaderTests {
+		f.Add(scriptString(s))
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, or := range readAll(script(s)) {
+			if or.Err != nil {
+				if _, ok := or.Err.(*BadEscSeq); !ok {
+					t.Errorf("reading %q => error %v of type %T, want *BadEscSeq", s, or.Err, or.Err)
+				}
+				continue
+			}
+			n := 0
+			if or.Key != ZeroKey {
+				n++
+			}
+			if or.CPR != InvalidPos {
+				n++
+			}
+			if or.Reply != nil {
+				n++
+			}
+			if or.Focus != NoFocusEvent {
+				n++
+			}
+			if or.Paste != nil {
+				n++
+			}
+			if n != 1 {
+				t.Errorf("reading %q => %v, want exactly one of key, CPR, reply, focus event and paste", s, or)
+			}
+		}
+	})
+}
+
+// scriptString returns the runes of a script as a string, dropping pauses.
+func scriptString(items []interface{}) string {
+	var rs []rune
+	for _, item := range items {
+		if r, ok := item.(rune); ok {
+			rs = append(rs, r)
+		}
+	}
+	return string(rs)
+}
+
+func TestReaderClose(t *testing.T) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	r, w := os.NewFile(uintptr(fds[0]), "r"), os.NewFile(uintptr(fds[1]), "w")
+	defer r.Close()
+	defer w.Close()
+
+	rd := NewReader(r)
+	// Nobody receives these keys
+	w.WriteString(strings.Repeat("a", ReaderOutChanSize*2))
+	time.Sleep(10 * time.Millisecond)
+	done := make(chan error)
+	go func() { done <- rd.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Close() => %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not return")
+	}
+	for range rd.Chan() {
+	}
+}
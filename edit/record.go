@@ -0,0 +1,142 @@
+package edit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xiaq/elvish/edit/tty"
+)
+
+// Sessions can be recorded, so that problems with drawing on terminals that
+// are hard to come by can be reproduced. With Record, or the -record flag of
+// elvish, the editor writes the frames it draws, the keys it reads and the
+// size of the terminal to a file, with the time since the recording started.
+// Replay, or the -replay flag, draws the frames again with the same timing.
+//
+// Recordings are in the format of asciinema, version 2, so other tools can
+// play them too: a header object, followed by one event per line, like
+//
+//	[0.52, "o", "\u001b[?25l\r> ls"]
+//
+// for a frame drawn, with "i" for a key read, whose name is recorded instead
+// of the bytes the terminal sent, and "r" for a new size, like "80x24".
+
+// MaxReplayGap is the longest Replay waits between two events, so that the
+// time the user spent away from the terminal is skipped.
+const MaxReplayGap = 2 * time.Second
+
+// recordHeader is the header of a recording.
+type recordHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// recorder writes the events of a recording.
+type recorder struct {
+	w             io.Writer
+	start         time.Time
+	width, height int
+}
+
+// event writes an event of type typ.
+func (r *recorder) event(typ, data string) {
+	b, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), typ, data})
+	r.w.Write(append(b, '\n'))
+}
+
+// resize writes an "r" event if the size has changed.
+func (r *recorder) resize(width, height int) {
+	if width != r.width || height != r.height {
+		r.width, r.height = width, height
+		r.event("r", fmt.Sprintf("%dx%d", width, height))
+	}
+}
+
+// Record starts recording the session to w, or stops recording if w is nil.
+func (ed *Editor) Record(w io.Writer) error {
+	if w == nil {
+		ed.writer.recorder = nil
+		return nil
+	}
+	width, height, _ := tty.Size(int(ed.writer.file.Fd()))
+	header := recordHeader{2, width, height, time.Now().Unix(),
+		map[string]string{"TERM": os.Getenv("TERM"), "SHELL": "elvish"}}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	ed.writer.recorder = &recorder{w, time.Now(), header.Width, header.Height}
+	return nil
+}
+
+// recordOutput records a frame written to the terminal, if the session is
+// being recorded.
+func (w *writer) recordOutput(p []byte) {
+	if w.recorder != nil {
+		w.recorder.event("o", string(p))
+	}
+}
+
+// recordInput records a key or paste read, if the session is being recorded.
+func (ed *Editor) recordInput(or OneRead) {
+	if ed.writer == nil || ed.writer.recorder == nil {
+		return
+	}
+	r := ed.writer.recorder
+	if or.Paste != nil {
+		r.event("i", or.Paste.Text)
+	} else {
+		r.event("i", or.Key.String())
+	}
+}
+
+// Replay reads a recording from r, and writes the frames in it to out, with
+// the same timing, but waiting at most MaxReplayGap between them.
+func Replay(r io.Reader, out io.Writer) error {
+	dec := json.NewDecoder(r)
+	var header recordHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("bad header: %v", err)
+	} else if header.Version != 2 {
+		return fmt.Errorf("unsupported version %d", header.Version)
+	}
+	last := 0.0
+	for {
+		var event []interface{}
+		if err := dec.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(event) != 3 {
+			return fmt.Errorf("bad event %v", event)
+		}
+		t, ok1 := event[0].(float64)
+		typ, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("bad event %v", event)
+		}
+		if typ != "o" {
+			continue
+		}
+		gap := time.Duration((t - last) * float64(time.Second))
+		if gap > MaxReplayGap {
+			gap = MaxReplayGap
+		}
+		time.Sleep(gap)
+		last = t
+		if _, err := io.WriteString(out, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package edit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var rec bytes.Buffer
+	rec.WriteString(`{"version":2,"width":80,"height":24,"timestamp":0,"env":{}}` + "\n")
+	r := &recorder{&rec, time.Now(), 80, 24}
+	r.event("o", "\033[?25l> ")
+	r.event("i", "a")
+	r.resize(80, 24)
+	r.resize(100, 30)
+	r.event("o", "a\033[?25h")
+
+	if n := strings.Count(rec.String(), `"r"`); n != 1 {
+		t.Errorf("%d resize events recorded, want 1", n)
+	}
+	var out bytes.Buffer
+	if err := Replay(&rec, &out); err != nil {
+		t.Errorf("Replay => %v, want nil", err)
+	}
+	if want := "\033[?25l> a\033[?25h"; out.String() != want {
+		t.Errorf("Replay wrote %q, want %q", out.String(), want)
+	}
+}
+
+var badRecordings = []string{
+	"",
+	`{"version":1}`,
+	`{"version":2}` + "\n" + `[0, "o"]`,
+	`{"version":2}` + "\n" + `["0", "o", "x"]`,
+}
+
+func TestReplayBad(t *testing.T) {
+	for _, s := range badRecordings {
+		if err := Replay(strings.NewReader(s), new(bytes.Buffer)); err == nil {
+			t.Errorf("Replay(%q) => nil, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package edit
+
+import (
+	"unicode/utf8"
+)
+
+// A selection is made by moving the dot with Shift held in insert mode, or
+// in visual mode, which is started with v in command mode. In insert mode,
+// keys that don't extend the selection end it. In visual mode, the selection
+// can be deleted, yanked or changed like with the text objects of vi.
+
+// selection is a selected range of the line, from anchor to the dot.
+type selection struct {
+	anchor int
+	// Whether the rune at the end of the range is included, as in visual
+	// mode.
+	inclusive bool
+}
+
+// selectionRange returns the range of bytes selected in line, or an empty
+// range if sel is nil.
+func selectionRange(line string, dot int, sel *selection) (int, int) {
+	if sel == nil {
+		return 0, 0
+	}
+	start, end := sel.anchor, dot
+	if start > end {
+		start, end = end, start
+	}
+	if sel.inclusive && end < len(line) {
+		_, w := utf8.DecodeRuneInString(line[end:])
+		end += w
+	}
+	return start, end
+}
+
+// extendSelection returns a builtin that runs f, a motion, with the selection
+// started at the dot if there is none.
+func extendSelection(f leBuiltin) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		if ed.selection == nil {
+			ed.selection = &selection{anchor: ed.dot}
+		}
+		return f(ed, k)
+	}
+}
+
+// isSelectionBuiltin returns whether the builtin named name keeps the
+// selection in insert mode.
+func isSelectionBuiltin(name string) bool {
+	switch name {
+	case "extend-selection-left", "extend-selection-right",
+		"extend-selection-left-word", "extend-selection-right-word":
+		return true
+	}
+	return false
+}
+
+func startVisual(ed *Editor, k Key) *leReturn {
+	ed.mode = modeVisual
+	ed.selection = &selection{anchor: ed.dot, inclusive: true}
+	return nil
+}
+
+func cancelVisual(ed *Editor, k Key) *leReturn {
+	ed.mode = modeCommand
+	ed.selection = nil
+	return nil
+}
+
+// visualOperator returns a builtin that yanks the selection, and deletes it if
+// op is d or c; c also starts insert mode.
+func visualOperator(op rune) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		start, end := selectionRange(ed.line, ed.dot, ed.selection)
+		ed.setRegister('"', viRegister{ed.line[start:end], false})
+		if op != 'y' {
+			ed.line = ed.line[:start] + ed.line[end:]
+		}
+		ed.dot = start
+		ed.selection = nil
+		ed.mode = modeCommand
+		if op == 'c' {
+			ed.mode = modeInsert
+		}
+		return nil
+	}
+}
+
+func defaultVisual(ed *Editor, k Key) *leReturn {
+	ed.pushTip(ed.trf("Unbound: %s", k))
+	return nil
+}
@@ -0,0 +1,55 @@
+package edit
+
+import "testing"
+
+var selectionRangeTests = []struct {
+	line       string
+	dot        int
+	sel        *selection
+	start, end int
+}{
+	{"echo", 2, nil, 0, 0},
+	{"echo foo", 2, &selection{anchor: 5}, 2, 5},
+	{"echo foo", 8, &selection{anchor: 5}, 5, 8},
+	{"echo foo", 5, &selection{anchor: 2, inclusive: true}, 2, 6},
+	{"echo 好", 2, &selection{anchor: 5, inclusive: true}, 2, 8},
+	{"echo", 4, &selection{anchor: 1, inclusive: true}, 1, 4},
+}
+
+func TestSelectionRange(t *testing.T) {
+	for _, tt := range selectionRangeTests {
+		if start, end := selectionRange(tt.line, tt.dot, tt.sel); start != tt.start || end != tt.end {
+			t.Errorf("selectionRange(%q, %d, %v) => (%d, %d), want (%d, %d)",
+				tt.line, tt.dot, tt.sel, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestShiftSelection(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.line, ed.dot = "echo foo", 8
+	ed.handleRead(keyRead(Key{Left, Shift}))
+	ed.handleRead(keyRead(Key{Left, Shift}))
+	if start, end := selectionRange(ed.line, ed.dot, ed.selection); start != 6 || end != 8 {
+		t.Errorf("Shift-Left twice selects (%d, %d), want (6, 8)", start, end)
+	}
+	ed.handleRead(keyRead(Key{Left, 0}))
+	if ed.selection != nil {
+		t.Errorf("Left does not end the selection")
+	}
+}
+
+func TestVisual(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.mode = modeCommand
+	ed.line, ed.dot = "echo foo bar", 5
+	for _, r := range "vwd" {
+		ed.handleRead(keyRead(Key{r, 0}))
+	}
+	if ed.line != "echo bar" || ed.mode != modeCommand || ed.selection != nil {
+		t.Errorf("vwd => %q, mode %d, selection %v", ed.line, ed.mode, ed.selection)
+	}
+	if reg := ed.registers['"']; reg.text != "foo " {
+		t.Errorf("vwd => register %q, want %q", reg.text, "foo ")
+	}
+}
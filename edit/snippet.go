@@ -0,0 +1,339 @@
+package edit
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Snippets are templates of commands, defined with le:snippet and inserted
+// from the snippet listing. Placeholders in a template look like {input}; when
+// a snippet is inserted, they are kept in the line, and Tab
+// (next-placeholder-or-complete) jumps to them in turn, removing each so that
+// it can be filled in.
+//
+// Templates can also have numbered fields, like ${1:pattern} or ${2}, which
+// are replaced with their text, after the colon, when the snippet is inserted.
+// Tab jumps to them in the order of their numbers, with ${0} last, putting the
+// dot after their text. The text of fields not edited yet is highlighted, and
+// typing in such a field, or deleting from it, replaces all of it. Templates
+// with fields have no {input} placeholders.
+
+// placeholderPattern matches placeholders in templates.
+var placeholderPattern = regexp.MustCompile(`\{[a-zA-Z0-9_-]+\}`)
+
+// fieldPattern matches fields in templates.
+var fieldPattern = regexp.MustCompile(`\$\{([0-9]+)(?::([^}]*))?\}`)
+
+// snippetField is a numbered field of the last inserted snippet. Its range of
+// the line is kept up to date as the line is edited.
+type snippetField struct {
+	number     int
+	start, end int
+	// Whether the text of the field is still that of the template.
+	fresh bool
+}
+
+func init() {
+	addEditorBuiltin("le:snippet", builtinSnippet)
+}
+
+// snippetListing keeps the status of the snippet listing.
+type snippetListing struct {
+	names   []string
+	current int
+}
+
+// newSnippetListing lists snippets, which maps names of snippets to their
+// templates.
+func newSnippetListing(snippets map[string]string) *snippetListing {
+	names := make([]string, 0, len(snippets))
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &snippetListing{names, 0}
+}
+
+func (sl *snippetListing) prev() {
+	if sl.current > 0 {
+		sl.current--
+	}
+}
+
+func (sl *snippetListing) next() {
+	if sl.current < len(sl.names)-1 {
+		sl.current++
+	}
+}
+
+// placeholders returns the placeholders in template, in order.
+func placeholders(template string) []string {
+	return placeholderPattern.FindAllString(template, -1)
+}
+
+// expandFields replaces the fields in template with their text. It returns
+// the result and the fields, in the order they are jumped to, with ranges in
+// the result.
+func expandFields(template string) (string, []snippetField) {
+	var b strings.Builder
+	var fields []snippetField
+	last := 0
+	for _, m := range fieldPattern.FindAllStringSubmatchIndex(template, -1) {
+		b.WriteString(template[last:m[0]])
+		number, _ := strconv.Atoi(template[m[2]:m[3]])
+		text := ""
+		if m[4] != -1 {
+			text = template[m[4]:m[5]]
+		}
+		start := b.Len()
+		b.WriteString(text)
+		fields = append(fields, snippetField{number, start, b.Len(), true})
+		last = m[1]
+	}
+	b.WriteString(template[last:])
+	// ${0} comes last; fields with the same number in the order they appear
+	sort.SliceStable(fields, func(i, j int) bool {
+		ni, nj := fields[i].number, fields[j].number
+		return ni != 0 && (nj == 0 || ni < nj)
+	})
+	return b.String(), fields
+}
+
+// insertSnippet inserts the template of the snippet at the dot, and jumps to
+// its first field or placeholder, if any.
+func (ed *Editor) insertSnippet(name string) {
+	template := ed.snippets[name]
+	if fieldPattern.MatchString(template) {
+		text, fields := expandFields(template)
+		for i := range fields {
+			fields[i].start += ed.dot
+			fields[i].end += ed.dot
+		}
+		ed.line = ed.line[:ed.dot] + text + ed.line[ed.dot:]
+		ed.dot += len(text)
+		ed.placeholders = nil
+		ed.field, ed.fields, ed.fieldLine = nil, fields, ed.line
+		ed.nextField()
+		return
+	}
+	ed.line = ed.line[:ed.dot] + template + ed.line[ed.dot:]
+	ed.placeholders = placeholders(template)
+	start := ed.dot
+	ed.dot += len(template)
+	if len(ed.placeholders) > 0 {
+		ed.dot = start
+		ed.nextPlaceholder()
+	}
+}
+
+// nextField jumps to the next field of the last inserted snippet, and shows
+// its number in the tips. It returns false if there are no more fields. Fields
+// that have been edited away are skipped. ${0} is the end of the snippet, and
+// is left right away.
+func (ed *Editor) nextField() bool {
+	ed.adjustFields()
+	if len(ed.fields) == 0 {
+		ed.field = nil
+		return false
+	}
+	f := ed.fields[0]
+	ed.fields = ed.fields[1:]
+	ed.field = &f
+	ed.dot = f.end
+	if f.number == 0 {
+		ed.field, ed.fields = nil, nil
+		return true
+	}
+	ed.pushTip(ed.trf("Field %d", f.number))
+	return true
+}
+
+// adjustFields updates the ranges of the fields for the changes made to the
+// line since they were last updated. A change inside a field, or at either
+// end of the current one, resizes it; a change before a field moves it, and
+// fields partly changed are dropped.
+func (ed *Editor) adjustFields() {
+	old := ed.fieldLine
+	ed.fieldLine = ed.line
+	if old == ed.line || (ed.field == nil && len(ed.fields) == 0) {
+		return
+	}
+	p := 0
+	for p < len(old) && p < len(ed.line) && old[p] == ed.line[p] {
+		p++
+	}
+	s := 0
+	for s < len(old)-p && s < len(ed.line)-p && old[len(old)-1-s] == ed.line[len(ed.line)-1-s] {
+		s++
+	}
+	start, end, delta := p, len(old)-s, len(ed.line)-len(old)
+	adjust := func(f *snippetField, current bool) bool {
+		switch {
+		case end < f.start || (end == f.start && !(current && start == end)):
+			f.start += delta
+			f.end += delta
+		case start > f.end || (start == f.end && (!current || end > start)):
+		case f.start <= start && end <= f.end:
+			f.end += delta
+			f.fresh = false
+		default:
+			return false
+		}
+		return true
+	}
+	if ed.field != nil && !adjust(ed.field, true) {
+		ed.field = nil
+	}
+	fields := ed.fields[:0]
+	for _, f := range ed.fields {
+		if adjust(&f, false) {
+			fields = append(fields, f)
+		}
+	}
+	ed.fields = fields
+}
+
+// clearFreshField deletes the text of the current field if it has not been
+// edited and the builtin named name, about to be run, inserts a rune or
+// deletes one next to the dot in it. It returns whether the builtin is done
+// by that, which is the case for deletions.
+func (ed *Editor) clearFreshField(name string, k Key) bool {
+	ed.adjustFields()
+	f := ed.field
+	if f == nil || !f.fresh || f.start == f.end || ed.dot < f.start || ed.dot > f.end {
+		return false
+	}
+	switch name {
+	case "default-insert":
+		if k.Mod != 0 || !unicode.IsGraphic(k.Rune) {
+			return false
+		}
+	case "kill-rune-left", "kill-rune-right":
+	default:
+		return false
+	}
+	ed.line = ed.line[:f.start] + ed.line[f.end:]
+	ed.dot = f.start
+	ed.adjustFields()
+	return name != "default-insert"
+}
+
+// inFreshField returns whether the byte at i is in a field of the last
+// inserted snippet that has not been edited.
+func inFreshField(bs *editorState, i int) bool {
+	if f := bs.field; f != nil && f.fresh && f.start <= i && i < f.end {
+		return true
+	}
+	for _, f := range bs.fields {
+		if f.fresh && f.start <= i && i < f.end {
+			return true
+		}
+	}
+	return false
+}
+
+// nextPlaceholder removes the next placeholder of the last inserted snippet
+// from the line, puts the dot where it was and shows its name in the tips. It
+// returns false if there are no more placeholders. Placeholders that have been
+// edited away are skipped. Fields are jumped to with nextField instead.
+func (ed *Editor) nextPlaceholder() bool {
+	if ed.field != nil || len(ed.fields) > 0 {
+		return ed.nextField()
+	}
+	for len(ed.placeholders) > 0 {
+		p := ed.placeholders[0]
+		ed.placeholders = ed.placeholders[1:]
+		// Look after the dot first, since placeholders are filled in order
+		i := strings.Index(ed.line[ed.dot:], p)
+		if i >= 0 {
+			i += ed.dot
+		} else {
+			i = strings.Index(ed.line, p)
+		}
+		if i < 0 {
+			continue
+		}
+		ed.line = ed.line[:i] + ed.line[i+len(p):]
+		ed.dot = i
+		ed.pushTip(ed.trf("Placeholder %s", p[1:len(p)-1]))
+		return true
+	}
+	return false
+}
+
+func startSnippet(ed *Editor, k Key) *leReturn {
+	if len(ed.snippets) == 0 {
+		ed.pushTip(ed.tr("no snippets; define some with le:snippet"))
+		return nil
+	}
+	ed.mode = modeSnippet
+	ed.snippet = newSnippetListing(ed.snippets)
+	return nil
+}
+
+func selectSnippetUp(ed *Editor, k Key) *leReturn {
+	ed.snippet.prev()
+	return nil
+}
+
+func selectSnippetDown(ed *Editor, k Key) *leReturn {
+	ed.snippet.next()
+	return nil
+}
+
+func acceptSnippet(ed *Editor, k Key) *leReturn {
+	sl := ed.snippet
+	ed.snippet = nil
+	ed.mode = modeInsert
+	ed.insertSnippet(sl.names[sl.current])
+	return nil
+}
+
+func cancelSnippet(ed *Editor, k Key) *leReturn {
+	ed.snippet = nil
+	ed.mode = modeInsert
+	return nil
+}
+
+func defaultSnippet(ed *Editor, k Key) *leReturn {
+	cancelSnippet(ed, k)
+	return &leReturn{action: reprocessKey}
+}
+
+// nextPlaceholderOrComplete jumps to the next placeholder of the last
+// inserted snippet, or starts completion if there is none.
+func nextPlaceholderOrComplete(ed *Editor, k Key) *leReturn {
+	if ed.nextPlaceholder() {
+		return nil
+	}
+	return startCompletion(ed, k)
+}
+
+// builtinSnippet implements the le:snippet builtin. With a name and a
+// template, it defines a snippet, replacing any snippet with the same name;
+// with a name and an empty template, it deletes the snippet. With no
+// arguments, it prints all snippets.
+func builtinSnippet(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		for _, name := range newSnippetListing(ed.snippets).names {
+			fmt.Fprintf(ev.OutFile(), "%s: %s\n", name, ed.snippets[name])
+		}
+	case 2:
+		name, template := args[0].String(), args[1].String()
+		if template == "" {
+			delete(ed.snippets, name)
+		} else {
+			ed.snippets[name] = template
+		}
+	default:
+		return "args error"
+	}
+	return ""
+}
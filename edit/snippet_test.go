@@ -0,0 +1,103 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var snippetTests = []struct {
+	line     string
+	dot      int
+	template string
+	// The line and dot after inserting the snippet, and after each jump to a
+	// placeholder, with what is typed in between.
+	typed []string
+	lines []string
+	dots  []int
+}{
+	{"", 0, "ls -l", nil, []string{"ls -l"}, []int{5}},
+	{"x; ", 3, "ffmpeg -i {input} -c:v {codec} {output}", []string{"a.mp4", "h264", ""},
+		[]string{"x; ffmpeg -i  -c:v {codec} {output}",
+			"x; ffmpeg -i a.mp4 -c:v  {output}",
+			"x; ffmpeg -i a.mp4 -c:v h264 "},
+		[]int{13, 24, 29}},
+}
+
+func TestSnippet(t *testing.T) {
+	for _, tt := range snippetTests {
+		ed := &Editor{options: newOptions()}
+		ed.snippets = map[string]string{"s": tt.template}
+		ed.line, ed.dot = tt.line, tt.dot
+		ed.insertSnippet("s")
+		for i := range tt.lines {
+			if i > 0 {
+				if i-1 < len(tt.typed) {
+					typed := tt.typed[i-1]
+					ed.line = ed.line[:ed.dot] + typed + ed.line[ed.dot:]
+					ed.dot += len(typed)
+				}
+				ed.nextPlaceholder()
+			}
+			if ed.line != tt.lines[i] || ed.dot != tt.dots[i] {
+				t.Errorf("snippet %q, step %d => (%q, %d), want (%q, %d)",
+					tt.template, i, ed.line, ed.dot, tt.lines[i], tt.dots[i])
+			}
+		}
+		if ed.nextPlaceholder() {
+			t.Errorf("snippet %q has placeholders left after filling", tt.template)
+		}
+	}
+}
+
+var expandFieldsTests = []struct {
+	template string
+	text     string
+	fields   []snippetField
+}{
+	{"a ${2:b} ${1} ${0} ${1:cd}", "a b   cd",
+		[]snippetField{{1, 4, 4, true}, {1, 6, 8, true}, {2, 2, 3, true}, {0, 5, 5, true}}},
+	{"${x} $1", "${x} $1", nil},
+}
+
+func TestExpandFields(t *testing.T) {
+	for _, tt := range expandFieldsTests {
+		text, fields := expandFields(tt.template)
+		if text != tt.text || !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("expandFields(%q) => (%q, %v), want (%q, %v)", tt.template, text, fields, tt.text, tt.fields)
+		}
+	}
+}
+
+func TestSnippetFields(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.snippets = map[string]string{"each": "each ${1:f} ${2:list}; ${0}"}
+	ed.line, ed.dot = "x; ", 3
+	ed.insertSnippet("each")
+	// Keys typed, and the line and dot after each
+	steps := []struct {
+		key  Key
+		line string
+		dot  int
+	}{
+		{Key{'g', 0}, "x; each g list; ", 9},
+		{Key{'h', 0}, "x; each gh list; ", 10},
+		{Key{Tab, 0}, "x; each gh list; ", 15},
+		{Key{Backspace, 0}, "x; each gh ; ", 11},
+		{Key{'x', 0}, "x; each gh x; ", 12},
+		{Key{Left, 0}, "x; each gh x; ", 11},
+		{Key{'y', 0}, "x; each gh yx; ", 12},
+		{Key{Tab, 0}, "x; each gh yx; ", 15},
+	}
+	if ed.line != "x; each f list; " || ed.dot != 9 {
+		t.Errorf("after inserting => (%q, %d), want (%q, %d)", ed.line, ed.dot, "x; each f list; ", 9)
+	}
+	for _, step := range steps {
+		ed.handleRead(keyRead(step.key))
+		if ed.line != step.line || ed.dot != step.dot {
+			t.Errorf("after %s => (%q, %d), want (%q, %d)", step.key, ed.line, ed.dot, step.line, step.dot)
+		}
+	}
+	if ed.field != nil || len(ed.fields) != 0 {
+		t.Errorf("fields left after ${0}: %v, %v", ed.field, ed.fields)
+	}
+}
@@ -0,0 +1,56 @@
+// Package styled implements text with styling, shared by the prompts, tips,
+// mode lines and completion candidates of the editor.
+package styled
+
+import "bytes"
+
+// Segment is a piece of text with the same style. Style is an SGR attribute
+// string like "1;33". An empty Style means the default style of wherever the
+// Segment is being written.
+type Segment struct {
+	Text  string
+	Style string
+}
+
+// Text is a sequence of styled segments.
+type Text []Segment
+
+// Plain makes a Text of a single unstyled segment.
+func Plain(s string) Text {
+	return Text{Segment{s, ""}}
+}
+
+// New makes a Text of a single segment with the given style.
+func New(s, style string) Text {
+	return Text{Segment{s, style}}
+}
+
+// String returns the content of t with all styling stripped.
+func (t Text) String() string {
+	buf := new(bytes.Buffer)
+	for _, seg := range t {
+		buf.WriteString(seg.Text)
+	}
+	return buf.String()
+}
+
+// Concat returns the concatenation of t and all of ts.
+func (t Text) Concat(ts ...Text) Text {
+	r := append(Text(nil), t...)
+	for _, t2 := range ts {
+		r = append(r, t2...)
+	}
+	return r
+}
+
+// Join concatenates ts, inserting sep between adjacent elements.
+func Join(ts []Text, sep Text) Text {
+	var r Text
+	for i, t := range ts {
+		if i > 0 {
+			r = append(r, sep...)
+		}
+		r = append(r, t...)
+	}
+	return r
+}
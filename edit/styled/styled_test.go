@@ -0,0 +1,21 @@
+package styled
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	text := Text{{"foo", "1"}, {"bar", ""}}
+	if s := text.String(); s != "foobar" {
+		t.Errorf("String() => %q, want %q", s, "foobar")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	joined := Join([]Text{New("a", "1"), Plain("b")}, Plain(", "))
+	wanted := Text{{"a", "1"}, {", ", ""}, {"b", ""}}
+	if !reflect.DeepEqual(joined, wanted) {
+		t.Errorf("Join(...) => %v, want %v", joined, wanted)
+	}
+}
@@ -0,0 +1,173 @@
+package edit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+// Autosuggestions are history entries that start with the line, shown after
+// the dot when it is at the end of the line in insert mode. Which of the
+// matching entries is suggested is decided by the current source, chosen with
+// le:suggest-source, "recent" at first.
+
+// HistoryEntry is an entry of the history, with the directory the line was
+// accepted in. Entries read from history files of the plain format, and lines
+// still running, have a zero Duration and Status; entries of the plain format
+// also have no Dir or Start.
+type HistoryEntry struct {
+	Line  string    `json:"line"`
+	Dir   string    `json:"dir,omitempty"`
+	Start time.Time `json:"start"`
+	// How long the line ran, and its exit status.
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status"`
+	// Whether the entry was accepted by this editor, rather than read from
+	// the history file.
+	local bool
+}
+
+// HistoryRanker scores candidates for autosuggestion. The candidates are
+// indices of entries in history, from the oldest to the most recent, and dir
+// is the current directory. It returns the scores of the candidates, in the
+// same order. The candidate with the highest score is suggested, the most
+// recent one on ties; candidates scoring 0 or less are never suggested.
+type HistoryRanker func(history []HistoryEntry, candidates []int, dir string) []float64
+
+// builtinHistoryRankers maps names of the autosuggestion sources every Editor
+// has to their rankers.
+var builtinHistoryRankers = map[string]HistoryRanker{
+	"recent":   rankRecent,
+	"frecency": rankFrecency,
+}
+
+// FrecencyHalfLife is the number of lines accepted after which a use of an
+// entry counts half as much towards its frecency.
+const FrecencyHalfLife = 100
+
+func init() {
+	addEditorBuiltin("le:suggest-source", builtinSuggestSource)
+}
+
+// AddHistoryRanker adds an autosuggestion source that can be chosen with
+// le:suggest-source.
+func (ed *Editor) AddHistoryRanker(name string, f HistoryRanker) {
+	if _, ok := ed.historyRankers[name]; ok || name == "off" {
+		panic("history ranker redefined: " + name)
+	}
+	ed.historyRankers[name] = f
+}
+
+// rankRecent prefers the most recent candidate.
+func rankRecent(history []HistoryEntry, candidates []int, dir string) []float64 {
+	scores := make([]float64, len(candidates))
+	for i := range candidates {
+		scores[i] = 1
+	}
+	return scores
+}
+
+// rankFrecency prefers candidates that are used often and recently in dir.
+// Each use counts 1 when it is the last entry, decaying with
+// FrecencyHalfLife. Candidates never used in dir score 0.
+func rankFrecency(history []HistoryEntry, candidates []int, dir string) []float64 {
+	frecency := make(map[string]float64)
+	for i, h := range history {
+		if h.Dir == dir {
+			age := float64(len(history) - 1 - i)
+			frecency[h.Line] += math.Pow(0.5, age/FrecencyHalfLife)
+		}
+	}
+	scores := make([]float64, len(candidates))
+	for i, j := range candidates {
+		scores[i] = frecency[history[j].Line]
+	}
+	return scores
+}
+
+// suggest returns the autosuggestion for line from history with the ranker
+// named source, or "" if there is none. It returns the part after line.
+func (o *options) suggest(history []HistoryEntry, line, dir, source string) string {
+	ranker := o.historyRankers[source]
+	if ranker == nil || line == "" {
+		return ""
+	}
+	var candidates []int
+	for i, h := range history {
+		if len(h.Line) > len(line) && strings.HasPrefix(h.Line, line) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	best, bestScore := -1, 0.0
+	for i, score := range ranker(history, candidates, dir) {
+		if score > 0 && score >= bestScore {
+			best, bestScore = candidates[i], score
+		}
+	}
+	if best == -1 {
+		return ""
+	}
+	return history[best].Line[len(line):]
+}
+
+// updateSuggestion updates the autosuggestion shown after the line.
+func (ed *Editor) updateSuggestion() {
+	ed.suggestion = ""
+	if ed.mode != modeInsert || ed.dot != len(ed.line) {
+		return
+	}
+	dir, _ := os.Getwd()
+	ed.suggestion = ed.suggest(ed.histories, ed.line, dir, ed.suggestSource)
+}
+
+// acceptSuggestionOrMoveDotRight inserts the autosuggestion, or moves the dot
+// right if there is none.
+func acceptSuggestionOrMoveDotRight(ed *Editor, k Key) *leReturn {
+	if ed.suggestion == "" || ed.dot != len(ed.line) {
+		return moveDotRight(ed, k)
+	}
+	ed.line += ed.suggestion
+	ed.dot = len(ed.line)
+	ed.suggestion = ""
+	return nil
+}
+
+// builtinSuggestSource implements the le:suggest-source builtin. With no
+// arguments, it prints the name of the current autosuggestion source, followed
+// by the other available ones. With one argument, it chooses the source; off
+// turns autosuggestions off.
+func builtinSuggestSource(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		var names []string
+		for name := range ed.historyRankers {
+			if name != ed.suggestSource {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if ed.suggestSource != "off" {
+			names = append(names, "off")
+		}
+		fmt.Fprintf(ev.OutFile(), "%s (available: %s)\n",
+			ed.suggestSource, strings.Join(names, " "))
+		return ""
+	case 1:
+		name := args[0].String()
+		if _, ok := ed.historyRankers[name]; !ok && name != "off" {
+			return fmt.Sprintf("no suggestion source named %s", name)
+		}
+		ed.suggestSource = name
+		return ""
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,34 @@
+package edit
+
+import "testing"
+
+var suggestHistory = []HistoryEntry{
+	{Line: "make test", Dir: "/src"},
+	{Line: "make test", Dir: "/src"},
+	{Line: "make install", Dir: "/src"},
+	{Line: "make clean", Dir: "/tmp"},
+	{Line: "ls", Dir: "/src"},
+}
+
+var suggestTests = []struct {
+	line, dir, source string
+	want              string
+}{
+	{"make", "/src", "recent", " clean"},
+	{"make", "/src", "frecency", " test"},
+	{"make", "/tmp", "frecency", " clean"},
+	{"make", "/home", "frecency", ""},
+	{"make i", "/src", "recent", "nstall"},
+	{"ls", "/src", "recent", ""},
+	{"", "/src", "recent", ""},
+	{"make", "/src", "off", ""},
+}
+
+func TestSuggest(t *testing.T) {
+	for _, tt := range suggestTests {
+		if out := newOptions().suggest(suggestHistory, tt.line, tt.dir, tt.source); out != tt.want {
+			t.Errorf("suggest(suggestHistory, %q, %q, %q) => %q, want %q",
+				tt.line, tt.dir, tt.source, out, tt.want)
+		}
+	}
+}
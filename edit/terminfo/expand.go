@@ -0,0 +1,191 @@
+package terminfo
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Expand expands a parameterized string capability with integer parameters,
+// like tparm(3). String parameters are not supported.
+func Expand(s string, params ...int) string {
+	var p [9]int
+	copy(p[:], params)
+	var vars [52]int
+	var stack []int
+	push := func(v int) {
+		stack = append(stack, v)
+	}
+	pop := func() int {
+		if len(stack) == 0 {
+			return 0
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	boolInt := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	varIndex := func(c byte) int {
+		switch {
+		case 'a' <= c && c <= 'z':
+			return int(c - 'a')
+		case 'A' <= c && c <= 'Z':
+			return 26 + int(c-'A')
+		}
+		return -1
+	}
+
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			break
+		}
+		switch c := s[i]; c {
+		case '%':
+			buf.WriteByte('%')
+		case 'c':
+			buf.WriteByte(byte(pop()))
+		case 'd', 's':
+			buf.WriteString(strconv.Itoa(pop()))
+		case 'p':
+			if i+1 < len(s) && '1' <= s[i+1] && s[i+1] <= '9' {
+				i++
+				push(p[s[i]-'1'])
+			}
+		case 'P', 'g':
+			if i+1 < len(s) {
+				i++
+				if j := varIndex(s[i]); j >= 0 {
+					if c == 'P' {
+						vars[j] = pop()
+					} else {
+						push(vars[j])
+					}
+				}
+			}
+		case '\'':
+			if i+2 < len(s) {
+				push(int(s[i+1]))
+				i += 2
+			}
+		case '{':
+			j := i + 1
+			for j < len(s) && s[j] != '}' {
+				j++
+			}
+			n, _ := strconv.Atoi(s[i+1 : j])
+			push(n)
+			i = j
+		case 'l':
+			pop()
+			push(0)
+		case '+', '-', '*', '/', 'm', '&', '|', '^', '=', '>', '<', 'A', 'O':
+			b, a := pop(), pop()
+			switch c {
+			case '+':
+				push(a + b)
+			case '-':
+				push(a - b)
+			case '*':
+				push(a * b)
+			case '/':
+				if b != 0 {
+					push(a / b)
+				} else {
+					push(0)
+				}
+			case 'm':
+				if b != 0 {
+					push(a % b)
+				} else {
+					push(0)
+				}
+			case '&':
+				push(a & b)
+			case '|':
+				push(a | b)
+			case '^':
+				push(a ^ b)
+			case '=':
+				push(boolInt(a == b))
+			case '>':
+				push(boolInt(a > b))
+			case '<':
+				push(boolInt(a < b))
+			case 'A':
+				push(boolInt(a != 0 && b != 0))
+			case 'O':
+				push(boolInt(a != 0 || b != 0))
+			}
+		case '!':
+			push(boolInt(pop() == 0))
+		case '~':
+			push(^pop())
+		case 'i':
+			p[0]++
+			p[1]++
+		case '?', ';':
+		case 't':
+			if pop() == 0 {
+				i = skipConditional(s, i+1, true)
+			}
+		case 'e':
+			i = skipConditional(s, i+1, false)
+		default:
+			// printf-style format: %[[:]flags][width[.precision]][doxX]
+			j := i
+			if s[j] == ':' {
+				j++
+			}
+			for j < len(s) && bytes.IndexByte([]byte("-+# .0123456789"), s[j]) >= 0 {
+				j++
+			}
+			if j < len(s) && bytes.IndexByte([]byte("doxX"), s[j]) >= 0 {
+				spec := s[i:j]
+				if len(spec) > 0 && spec[0] == ':' {
+					spec = spec[1:]
+				}
+				fmt.Fprintf(buf, "%"+spec+string(s[j]), pop())
+				i = j
+			}
+		}
+	}
+	return buf.String()
+}
+
+// skipConditional skips from i to right after the %; (or %e, if stopAtElse is
+// true) that ends the current level of conditional, and returns the index of
+// the last byte skipped.
+func skipConditional(s string, i int, stopAtElse bool) int {
+	depth := 0
+	for ; i < len(s)-1; i++ {
+		if s[i] != '%' {
+			continue
+		}
+		i++
+		switch s[i] {
+		case '?':
+			depth++
+		case ';':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case 'e':
+			if depth == 0 && stopAtElse {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,194 @@
+// Package terminfo reads compiled terminfo databases and expands
+// parameterized capabilities.
+package terminfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Indices of some string capabilities, in the standard order of term.h.
+const (
+	CarriageReturn    = 2
+	ClrEol            = 6
+	ClrEos            = 7
+	ColumnAddress     = 8
+	CursorAddress     = 10
+	CursorDown        = 11
+	CursorInvisible   = 13
+	CursorLeft        = 14
+	CursorNormal      = 16
+	CursorRight       = 17
+	CursorUp          = 19
+	EnterCaMode       = 28
+	EraseChars        = 37
+	ExitAttributeMode = 39
+	ExitCaMode        = 40
+	ParmDownCursor    = 107
+	ParmRightCursor   = 112
+	ParmUpCursor      = 114
+)
+
+// Magic numbers of the legacy format and the format with 32-bit numbers.
+const (
+	magicLegacy = 0432
+	magic32     = 01036
+)
+
+var (
+	ErrNotFound  = errors.New("terminfo entry not found")
+	ErrBadMagic  = errors.New("bad terminfo magic number")
+	ErrTruncated = errors.New("terminfo entry truncated")
+)
+
+// Terminfo is a parsed terminfo entry. Absent capabilities are false, -1 and
+// "" respectively.
+type Terminfo struct {
+	Names   []string
+	Bools   []bool
+	Numbers []int
+	Strings []string
+}
+
+// String returns the string capability with index i, or "" if it is absent.
+func (ti *Terminfo) String(i int) string {
+	if i < len(ti.Strings) {
+		return ti.Strings[i]
+	}
+	return ""
+}
+
+// dirs returns the directories searched for terminfo entries, in order.
+func dirs() []string {
+	var ds []string
+	if d := os.Getenv("TERMINFO"); d != "" {
+		ds = append(ds, d)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		ds = append(ds, path.Join(home, ".terminfo"))
+	}
+	if dirs := os.Getenv("TERMINFO_DIRS"); dirs != "" {
+		ds = append(ds, strings.Split(dirs, ":")...)
+	}
+	return append(ds, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo")
+}
+
+// Load finds and parses the terminfo entry for a terminal name, like the
+// value of $TERM.
+func Load(term string) (*Terminfo, error) {
+	if term == "" || strings.ContainsRune(term, '/') {
+		return nil, ErrNotFound
+	}
+	for _, d := range dirs() {
+		// Entries are either under the first letter of the name, or the
+		// hexadecimal code of it (on Darwin).
+		for _, sub := range []string{term[:1], hexByte(term[0])} {
+			data, err := ioutil.ReadFile(path.Join(d, sub, term))
+			if err == nil {
+				return Parse(data)
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func hexByte(b byte) string {
+	const digits = "0123456789abcdef"
+	return string([]byte{digits[b>>4], digits[b&0xf]})
+}
+
+// Parse parses a compiled terminfo entry. Extended capabilities are ignored.
+func Parse(data []byte) (*Terminfo, error) {
+	if len(data) < 12 {
+		return nil, ErrTruncated
+	}
+	var h [6]int
+	for i := range h {
+		h[i] = int(int16(binary.LittleEndian.Uint16(data[2*i:])))
+	}
+	numSize := 2
+	switch h[0] {
+	case magicLegacy:
+	case magic32:
+		numSize = 4
+	default:
+		return nil, ErrBadMagic
+	}
+	nameSize, nBools, nNums, nStrs, tableSize := h[1], h[2], h[3], h[4], h[5]
+	if nameSize < 0 || nBools < 0 || nNums < 0 || nStrs < 0 || tableSize < 0 {
+		return nil, ErrTruncated
+	}
+
+	pos := 12
+	need := func(n int) ([]byte, error) {
+		if pos+n > len(data) {
+			return nil, ErrTruncated
+		}
+		b := data[pos : pos+n]
+		pos += n
+		return b, nil
+	}
+
+	ti := &Terminfo{}
+	names, err := need(nameSize)
+	if err != nil {
+		return nil, err
+	}
+	ti.Names = strings.Split(strings.TrimRight(string(names), "\x00"), "|")
+
+	bools, err := need(nBools)
+	if err != nil {
+		return nil, err
+	}
+	ti.Bools = make([]bool, nBools)
+	for i, b := range bools {
+		ti.Bools[i] = b == 1
+	}
+	// Numbers start on an even byte
+	if pos%2 == 1 {
+		pos++
+	}
+
+	nums, err := need(nNums * numSize)
+	if err != nil {
+		return nil, err
+	}
+	ti.Numbers = make([]int, nNums)
+	for i := range ti.Numbers {
+		if numSize == 2 {
+			ti.Numbers[i] = int(int16(binary.LittleEndian.Uint16(nums[2*i:])))
+		} else {
+			ti.Numbers[i] = int(int32(binary.LittleEndian.Uint32(nums[4*i:])))
+		}
+		if ti.Numbers[i] < 0 {
+			ti.Numbers[i] = -1
+		}
+	}
+
+	offsets, err := need(nStrs * 2)
+	if err != nil {
+		return nil, err
+	}
+	table, err := need(tableSize)
+	if err != nil {
+		return nil, err
+	}
+	ti.Strings = make([]string, nStrs)
+	for i := range ti.Strings {
+		off := int(int16(binary.LittleEndian.Uint16(offsets[2*i:])))
+		// Negative offsets are absent or cancelled capabilities
+		if off < 0 || off >= len(table) {
+			continue
+		}
+		end := off
+		for end < len(table) && table[end] != 0 {
+			end++
+		}
+		ti.Strings[i] = string(table[off:end])
+	}
+	return ti, nil
+}
@@ -0,0 +1,97 @@
+package terminfo
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+var expandTests = []struct {
+	s      string
+	params []int
+	wanted string
+}{
+	{"\033[%p1%dA", []int{3}, "\033[3A"},
+	{"\033[%i%p1%dG", []int{0}, "\033[1G"},
+	{"\033[%i%p1%d;%p2%dH", []int{4, 9}, "\033[5;10H"},
+	{"%p1%{10}%+%d", []int{5}, "15"},
+	{"%p1%02d", []int{7}, "07"},
+	{"%p1%c", []int{'x'}, "x"},
+	{"%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%;", []int{2}, "32"},
+	{"%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%;", []int{10}, "92"},
+	{"%%", nil, "%"},
+}
+
+func TestExpand(t *testing.T) {
+	for _, tt := range expandTests {
+		if out := Expand(tt.s, tt.params...); out != tt.wanted {
+			t.Errorf("Expand(%q, %v) => %q, want %q", tt.s, tt.params, out, tt.wanted)
+		}
+	}
+}
+
+// compile makes a legacy compiled terminfo entry.
+func compile(names string, bools []bool, nums []int, strs []string) []byte {
+	var table []byte
+	var offsets []int
+	for _, s := range strs {
+		if s == "" {
+			offsets = append(offsets, -1)
+			continue
+		}
+		offsets = append(offsets, len(table))
+		table = append(append(table, s...), 0)
+	}
+	le := binary.LittleEndian
+	put16 := func(b []byte, v int) []byte {
+		var a [2]byte
+		le.PutUint16(a[:], uint16(int16(v)))
+		return append(b, a[:]...)
+	}
+	var data []byte
+	for _, v := range []int{magicLegacy, len(names) + 1, len(bools), len(nums), len(strs), len(table)} {
+		data = put16(data, v)
+	}
+	data = append(append(data, names...), 0)
+	for _, b := range bools {
+		if b {
+			data = append(data, 1)
+		} else {
+			data = append(data, 0)
+		}
+	}
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	for _, n := range nums {
+		data = put16(data, n)
+	}
+	for _, o := range offsets {
+		data = put16(data, o)
+	}
+	return append(data, table...)
+}
+
+func TestParse(t *testing.T) {
+	data := compile("test|a test terminal", []bool{true, false},
+		[]int{80, -1}, []string{"", "\007", "\r"})
+	ti, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(...) => error %v", err)
+	}
+	wanted := &Terminfo{
+		Names:   []string{"test", "a test terminal"},
+		Bools:   []bool{true, false},
+		Numbers: []int{80, -1},
+		Strings: []string{"", "\007", "\r"},
+	}
+	if !reflect.DeepEqual(ti, wanted) {
+		t.Errorf("Parse(...) => %#v, want %#v", ti, wanted)
+	}
+	if s := ti.String(CarriageReturn); s != "\r" {
+		t.Errorf("String(CarriageReturn) => %q, want %q", s, "\r")
+	}
+	if _, err := Parse(data[:20]); err != ErrTruncated {
+		t.Errorf("Parse(truncated) => error %v, want %v", err, ErrTruncated)
+	}
+}
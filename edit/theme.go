@@ -0,0 +1,352 @@
+package edit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// styles returns the style registry of a, mapping style names to the fields
+// holding the corresponding attributes. Styles whose names start with "+" are
+// appended to another attribute, so their values should start with ";".
+func (a *attrs) styles() map[string]*string {
+	return map[string]*string{
+		"prompt":             &a.attrForPrompt,
+		"rprompt":            &a.attrForRprompt,
+		"mode":               &a.attrForMode,
+		"tip":                &a.attrForTip,
+		"scroll-mark":        &a.attrForScrollMark,
+		"completed-history":  &a.attrForCompletedHistory,
+		"+completed":         &a.attrForCompleted,
+		"+current-candidate": &a.attrForCurrentCompletion,
+		"+selected-file":     &a.attrForSelectedFile,
+		"eol-marker":         &a.attrForEOLMarker,
+		"+line-error":        &a.attrForLineError,
+		"suggestion":         &a.attrForSuggestion,
+		"+selection":         &a.attrForSelection,
+		"description":        &a.attrForDescription,
+		"group-header":       &a.attrForGroupHeader,
+		"preedit":            &a.attrForPreedit,
+		"message":            &a.attrForMessage,
+		"instant":            &a.attrForInstant,
+		"+snippet-field":     &a.attrForSnippetField,
+		"+match":             &a.attrForMatch,
+	}
+}
+
+// SetStyling turns styling of the prompts, syntax highlighting, completion
+// candidates and everything else on or off. It defaults to off if $NO_COLOR
+// is set and non-empty (see http://no-color.org), and on otherwise. It can
+// also be changed at runtime with le:styling.
+func (ed *Editor) SetStyling(on bool) {
+	ed.noStyle = !on
+}
+
+// highlightStyles maps names of styles used in syntax highlighting to the
+// item types they apply to.
+var highlightStyles = map[string][]parse.ItemType{
+	"comment":          {parse.ItemSpace},
+	"string":           {parse.ItemSingleQuoted, parse.ItemDoubleQuoted},
+	"redir":            {parse.ItemRedirLeader, parse.ItemStatusRedirLeader},
+	"pipe":             {parse.ItemPipe},
+	"error":            {parse.ItemError},
+	"bracket":          {parse.ItemQuestionLParen, parse.ItemLParen, parse.ItemRParen, parse.ItemLBracket, parse.ItemRBracket, parse.ItemLBrace, parse.ItemRBrace},
+	"ampersand":        {parse.ItemAmpersand},
+	"dollar":           {parse.ItemDollar},
+	"command":          {ItemValidCommand},
+	"invalid-command":  {ItemInvalidCommand},
+	"variable":         {ItemValidVariable},
+	"invalid-variable": {ItemInvalidVariable},
+}
+
+// checkStyle checks whether attr can be set as the attribute of a style in the
+// registry.
+func (a *attrs) checkStyle(name, attr string) error {
+	_, isStyle := a.styles()[name]
+	_, isHighlight := highlightStyles[name]
+	if !isStyle && !isHighlight {
+		return fmt.Errorf("no style named %s", name)
+	}
+	if strings.HasPrefix(name, "+") && attr != "" && attr[0] != ';' {
+		return fmt.Errorf("style %s must be empty or start with ;", name)
+	}
+	return nil
+}
+
+// setStyle sets the attribute of a style in the registry.
+func (a *attrs) setStyle(name, attr string) error {
+	if err := a.checkStyle(name, attr); err != nil {
+		return err
+	}
+	if p, ok := a.styles()[name]; ok {
+		*p = attr
+	}
+	for _, t := range highlightStyles[name] {
+		a.attrForType[t] = attr
+	}
+	return nil
+}
+
+// theme is a named bundle of settings of the style registry. Styles not in a
+// theme are left unchanged when it is applied.
+type theme map[string]string
+
+// apply applies the theme to a. If any of the styles is invalid, nothing is
+// changed.
+func (th theme) apply(a *attrs) error {
+	names := make([]string, 0, len(th))
+	for name := range th {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := a.checkStyle(name, th[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		a.setStyle(name, th[name])
+	}
+	return nil
+}
+
+// themes contains the builtin themes. The "default" theme is populated from
+// defaultAttrs.
+var themes = map[string]theme{
+	"solarized-dark": {
+		"prompt": "38;5;33", "rprompt": "38;5;240;7", "mode": "1;38;5;136;7",
+		"tip": "38;5;244", "scroll-mark": "1;38;5;61",
+		"completed-history": "38;5;240;4", "+completed": ";4",
+		"+current-candidate": ";7", "+selected-file": ";7", "+selection": ";7",
+		"comment": "38;5;240", "string": "38;5;37", "redir": "38;5;64",
+		"pipe": "38;5;64", "error": "38;5;160", "bracket": "1;38;5;33",
+		"ampersand": "1", "dollar": "38;5;125", "command": "38;5;64",
+		"invalid-command": "38;5;160", "variable": "38;5;125",
+		"invalid-variable": "38;5;160",
+	},
+	"gruvbox": {
+		"prompt": "38;5;214", "rprompt": "38;5;245;7", "mode": "1;38;5;208;7",
+		"tip": "38;5;245", "scroll-mark": "1;38;5;175",
+		"completed-history": "38;5;245;4", "+completed": ";4",
+		"+current-candidate": ";7", "+selected-file": ";7",
+		"comment": "38;5;245", "string": "38;5;142", "redir": "38;5;108",
+		"pipe": "38;5;108", "error": "38;5;167", "bracket": "1;38;5;109",
+		"ampersand": "1", "dollar": "38;5;175", "command": "38;5;142",
+		"invalid-command": "38;5;167", "variable": "38;5;175",
+		"invalid-variable": "38;5;167",
+	},
+	"mono": {
+		"prompt": "", "rprompt": "7", "mode": "1;7", "tip": "",
+		"scroll-mark": "1", "completed-history": "4", "+completed": ";4",
+		"+current-candidate": ";7", "+selected-file": ";7",
+		"eol-marker": "7", "+line-error": ";4", "suggestion": "2",
+		"comment": "", "string": "", "redir": "", "pipe": "", "error": "4",
+		"bracket": "1", "ampersand": "1", "dollar": "", "command": "",
+		"invalid-command": "4", "variable": "", "invalid-variable": "4",
+		"description": "2", "group-header": "1;4",
+	},
+	// For terminals with a light background, avoiding yellows and light
+	// greys that are hard to read on them.
+	"light": {
+		"prompt": "", "rprompt": "7", "mode": "1;7;34",
+		"tip": "38;5;242", "scroll-mark": "1;38;5;25",
+		"completed-history": "38;5;242;4", "+completed": ";4",
+		"+current-candidate": ";7", "+selected-file": ";7", "+selection": ";7",
+		"suggestion": "38;5;246", "description": "38;5;242",
+		"comment": "38;5;242", "string": "38;5;130", "redir": "38;5;28",
+		"pipe": "38;5;28", "error": "38;5;160", "bracket": "1;38;5;25",
+		"ampersand": "1", "dollar": "38;5;90", "command": "38;5;28",
+		"invalid-command": "38;5;160", "variable": "38;5;90",
+		"invalid-variable": "38;5;160",
+	},
+	// Bold and bright colors only, with errors also underlined, for low
+	// vision and washed out screens.
+	"high-contrast": {
+		"prompt": "1", "rprompt": "1;7", "mode": "1;7", "tip": "1",
+		"scroll-mark": "1;7", "completed-history": "1;4", "+completed": ";1;4",
+		"+current-candidate": ";1;7", "+selected-file": ";1;7",
+		"+selection": ";1;7", "eol-marker": "1;7", "+line-error": ";1;4",
+		"suggestion": "96", "description": "97", "group-header": "1;4;97",
+		"comment": "96", "string": "93", "redir": "92", "pipe": "92",
+		"error": "1;4;91", "bracket": "1;94", "ampersand": "1",
+		"dollar": "95", "command": "1;92", "invalid-command": "1;4;91",
+		"variable": "95", "invalid-variable": "1;4;91",
+	},
+}
+
+func init() {
+	a := defaultAttrs()
+	def := theme{}
+	for name, p := range a.styles() {
+		def[name] = *p
+	}
+	for name, types := range highlightStyles {
+		def[name] = a.attrForType[types[0]]
+	}
+	themes["default"] = def
+
+	addEditorBuiltin("le:theme", builtinTheme)
+	addEditorBuiltin("le:styling", builtinStyling)
+	addEditorBuiltin("le:eol-marker", builtinEOLMarker)
+}
+
+// themeDir is where themes not builtin are looked up, relative to $HOME.
+const themeDir = ".elvish/themes"
+
+// ThemeFile is the theme file applied by main at startup if it exists,
+// relative to $HOME. It has the format of the files in themeDir.
+const ThemeFile = ".elvish/theme"
+
+// DefaultThemeName returns the name of the builtin theme that suits the
+// terminal: "light" if $COLORFGBG, set by some terminals as the foreground
+// and background colors separated by ";", says that the background is white,
+// and "default" otherwise.
+func DefaultThemeName() string {
+	fgbg := os.Getenv("COLORFGBG")
+	switch fgbg[strings.LastIndexByte(fgbg, ';')+1:] {
+	case "7", "15":
+		return "light"
+	default:
+		return "default"
+	}
+}
+
+// LoadTheme applies a theme, found like with le:theme: a builtin theme or one
+// in themeDir by name, or a theme file by path if name contains a slash. The
+// name "auto" picks a builtin theme by the background of the terminal; see
+// background.go.
+func (ed *Editor) LoadTheme(name string) error {
+	if name == "auto" {
+		ed.autoTheme = true
+		return themes[ed.autoThemeName()].apply(&ed.attrs)
+	}
+	th, err := findTheme(name)
+	if err != nil {
+		return err
+	}
+	if err := th.apply(&ed.attrs); err != nil {
+		return err
+	}
+	ed.autoTheme = false
+	return nil
+}
+
+// loadTheme reads a theme from a file. Each line of the file contains a style
+// name and the attribute, separated by whitespace. Empty lines and lines
+// starting with # are ignored.
+func loadTheme(fname string) (theme, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	th := theme{}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			th[fields[0]] = ""
+		case 2:
+			th[fields[0]] = fields[1]
+		default:
+			return nil, fmt.Errorf("%s:%d: bad theme line", fname, lineno)
+		}
+	}
+	return th, scanner.Err()
+}
+
+// findTheme finds a theme by name. A name containing a slash is taken as a
+// path to a theme file. Otherwise builtin themes are searched first, followed
+// by files in themeDir.
+func findTheme(name string) (theme, error) {
+	if strings.ContainsRune(name, '/') {
+		return loadTheme(name)
+	}
+	if th, ok := themes[name]; ok {
+		return th, nil
+	}
+	return loadTheme(path.Join(os.Getenv("HOME"), themeDir, name))
+}
+
+// builtinTheme implements the le:theme builtin. With no arguments, it lists
+// the builtin themes, and auto. With one argument, it switches to the named
+// theme.
+func builtinTheme(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		names := []string{"auto"}
+		for name := range themes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(ev.OutFile(), strings.Join(names, " "))
+		return ""
+	case 1:
+		if err := ed.LoadTheme(args[0].String()); err != nil {
+			return err.Error()
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinStyling implements the le:styling builtin. With no arguments, it
+// prints whether styling is on. With one argument, on or off, it turns styling
+// on or off.
+func builtinStyling(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		if ed.noStyle {
+			fmt.Fprintln(ev.OutFile(), "off")
+		} else {
+			fmt.Fprintln(ev.OutFile(), "on")
+		}
+		return ""
+	case 1:
+		switch args[0].String() {
+		case "on":
+			ed.SetStyling(true)
+		case "off":
+			ed.SetStyling(false)
+		default:
+			return "args error"
+		}
+		return ""
+	default:
+		return "args error"
+	}
+}
+
+// builtinEOLMarker implements the le:eol-marker builtin. With no arguments, it
+// prints the marker shown after output that lacks a trailing newline. With one
+// argument, which must be a single line, it sets the marker; % and ⏎ are
+// popular choices.
+func builtinEOLMarker(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ev.OutFile(), ed.eolMarkerText)
+		return ""
+	case 1:
+		marker := args[0].String()
+		if marker == "" || strings.ContainsAny(marker, "\n\r\033") {
+			return "bad marker"
+		}
+		ed.eolMarkerText = marker
+		return ""
+	default:
+		return "args error"
+	}
+}
@@ -0,0 +1,65 @@
+package edit
+
+import (
+	"os"
+	"testing"
+)
+
+func TestThemes(t *testing.T) {
+	a := defaultAttrs()
+	for name, th := range themes {
+		for style := range th {
+			if _, ok := a.styles()[style]; ok {
+				continue
+			}
+			if _, ok := highlightStyles[style]; ok {
+				continue
+			}
+			t.Errorf("theme %s sets nonexistent style %s", name, style)
+		}
+	}
+	if err := themes["mono"].apply(&a); err != nil {
+		t.Errorf("applying theme mono => error %v", err)
+	}
+	if a.attrForMode != "1;7" || a.attrForType[ItemValidCommand] != "" {
+		t.Errorf("theme mono not applied")
+	}
+}
+
+var badThemes = []theme{
+	{"prompt": "1", "no-such-style": "1"},
+	{"prompt": "1", "+completed": "4"},
+}
+
+func TestThemeApplyBad(t *testing.T) {
+	for _, th := range badThemes {
+		a := defaultAttrs()
+		a.attrForPrompt = ""
+		if err := th.apply(&a); err == nil {
+			t.Errorf("%v.apply() => no error, want error", th)
+		}
+		if a.attrForPrompt != "" {
+			t.Errorf("%v.apply() changed prompt, want no change", th)
+		}
+	}
+}
+
+var defaultThemeNameTests = []struct {
+	colorfgbg string
+	name      string
+}{
+	{"", "default"},
+	{"15;0", "default"},
+	{"0;15", "light"},
+	{"0;default;7", "light"},
+}
+
+func TestDefaultThemeName(t *testing.T) {
+	defer os.Setenv("COLORFGBG", os.Getenv("COLORFGBG"))
+	for _, tt := range defaultThemeNameTests {
+		os.Setenv("COLORFGBG", tt.colorfgbg)
+		if name := DefaultThemeName(); name != tt.name {
+			t.Errorf("DefaultThemeName() with COLORFGBG=%q => %q, want %q", tt.colorfgbg, name, tt.name)
+		}
+	}
+}
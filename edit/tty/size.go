@@ -0,0 +1,41 @@
+package tty
+
+import (
+	"os"
+	"strconv"
+	"unsafe"
+)
+
+// Size returns the width and height of the terminal on fd, and whether the
+// terminal reports them. When the ioctl fails, or reports a size of 0 as some
+// pty implementations do, they are taken from $COLUMNS and $LINES, or are
+// 80x24, so that there is always some room to lay things out.
+func Size(fd int) (width, height int, ok bool) {
+	var ws Winsize
+	err := Ioctl(fd, TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if err == nil && ws.Col > 0 && ws.Row > 0 {
+		return int(ws.Col), int(ws.Row), true
+	}
+	return envSize("COLUMNS", 80), envSize("LINES", 24), false
+}
+
+// envSize returns the positive number in the environment variable name, or
+// fallback.
+func envSize(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// CellSize returns the width and height in pixels of a character cell of the
+// terminal on fd, and whether the terminal reports them, which not all
+// terminals do.
+func CellSize(fd int) (width, height int, ok bool) {
+	var ws Winsize
+	err := Ioctl(fd, TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if err != nil || ws.Col == 0 || ws.Row == 0 || ws.Xpixel < ws.Col || ws.Ypixel < ws.Row {
+		return 0, 0, false
+	}
+	return int(ws.Xpixel / ws.Col), int(ws.Ypixel / ws.Row), true
+}
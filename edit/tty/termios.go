@@ -52,3 +52,9 @@ func (term *Termios) SetIcanon(v bool) {
 func (term *Termios) SetEcho(v bool) {
 	setFlag(&term.Lflag, syscall.ECHO, v)
 }
+
+// SetIxon sets whether Ctrl-S and Ctrl-Q stop and restart output, for flow
+// control.
+func (term *Termios) SetIxon(v bool) {
+	setFlag(&term.Iflag, syscall.IXON, v)
+}
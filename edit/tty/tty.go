@@ -4,12 +4,17 @@ package tty
 import (
 	"os"
 	"syscall"
+
+	"github.com/xiaq/elvish/logger"
 )
 
+var log = logger.New("tty")
+
 func Ioctl(fd int, req int, arg uintptr) error {
 	_, _, e := syscall.Syscall(
 		syscall.SYS_IOCTL, uintptr(fd), uintptr(req), arg)
 	if e != 0 {
+		log.Debug("ioctl failed", "fd", fd, "req", req, "err", e)
 		return os.NewSyscallError("ioctl", e)
 	}
 	return nil
@@ -18,3 +23,9 @@ func Ioctl(fd int, req int, arg uintptr) error {
 func FlushInput(fd int) error {
 	return Ioctl(fd, TCFLSH, TCIFLUSH)
 }
+
+// IsTerminal returns whether fd is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := NewTermiosFromFd(fd)
+	return err == nil
+}
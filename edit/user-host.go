@@ -0,0 +1,114 @@
+package edit
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xiaq/elvish/edit/styled"
+)
+
+// Words starting with ~ complete to the home directories of users, since
+// elvish doesn't expand ~ itself; the candidates are shown as ~user. After an
+// @, as in ssh user@host, host names are completed.
+
+// passwdFile is where users and their home directories are read from.
+var passwdFile = "/etc/passwd"
+
+// hostsFiles are where host names are read from: the hosts file, and static
+// hosts published by avahi.
+var hostsFiles = []string{"/etc/hosts", "/etc/avahi/hosts"}
+
+// readHomes returns the home directories of users from a file in the format
+// of /etc/passwd.
+func readHomes(fname string) (map[string]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	homes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 6 && fields[0] != "" && !strings.HasPrefix(fields[0], "#") {
+			homes[fields[0]] = fields[5]
+		}
+	}
+	return homes, scanner.Err()
+}
+
+// readHostNames returns the host names in files in the format of /etc/hosts:
+// an address followed by names on each line, with comments after #. Neither
+// missing files nor addresses are errors.
+func readHostNames(fnames []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, fname := range fnames {
+		f, err := os.Open(fname)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i != -1 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			for i := 1; i < len(fields); i++ {
+				if !seen[fields[i]] {
+					seen[fields[i]] = true
+					names = append(names, fields[i])
+				}
+			}
+		}
+		f.Close()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeUser completes pattern, ~ followed by the start of a user name, to
+// home directories.
+func (ed *Editor) completeUser(c *completion, pattern string) {
+	homes, err := readHomes(passwdFile)
+	if err != nil {
+		ed.pushTip(err.Error())
+		return
+	}
+	var users []string
+	for user := range homes {
+		if strings.HasPrefix(user, pattern[1:]) {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	c.candidates = nil
+	for _, user := range users {
+		home := strings.TrimSuffix(homes[user], "/") + "/"
+		cand := newCandidate()
+		cand.push(home, ed.attrForType[c.typ])
+		cand.display = styled.Plain("~" + user)
+		cand.description = home
+		cand.group = ed.tr("users")
+		c.candidates = append(c.candidates, cand)
+	}
+	if len(c.candidates) == 0 {
+		ed.pushStyledTip(styled.Plain("No completion for ").Concat(
+			styled.New(pattern, ed.attrForTip+ed.attrForCompleted)))
+		return
+	}
+	ed.showCompletion(c, pattern, func(*candidate) {})
+}
+
+// completeHost completes pattern, with an @ at i, to host names after the @.
+func (ed *Editor) completeHost(c *completion, pattern string, i int) {
+	var names []string
+	for _, host := range readHostNames(hostsFiles) {
+		names = append(names, pattern[:i+1]+host)
+	}
+	ed.applyCompletion(&completionResult{
+		ed.generation, c, pattern, nil, names, nil, false, nil, "hosts"})
+}
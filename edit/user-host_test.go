@@ -0,0 +1,55 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+func TestUserHostCompletion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elvishtest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string, h []string) { passwdFile, hostsFiles = p, h }(passwdFile, hostsFiles)
+	passwdFile = filepath.Join(dir, "passwd")
+	hostsFiles = []string{filepath.Join(dir, "hosts"), filepath.Join(dir, "nosuchfile")}
+	ioutil.WriteFile(passwdFile, []byte(
+		"root:x:0:0:root:/root:/bin/sh\n"+
+			"alice:x:1000:1000::/home/alice:/bin/sh\n"+
+			"alan:x:1001:1001::/home/alan/:/bin/sh\n"), 0644)
+	ioutil.WriteFile(hostsFiles[0], []byte(
+		"127.0.0.1 localhost\n"+
+			"# 10.0.0.1 commented\n"+
+			"10.0.0.2 build build.lan # the build server\n"), 0644)
+
+	completeTests := []struct {
+		line     string
+		texts    []string
+		displays []string
+	}{
+		{"ls ~al", []string{"/home/alan/", "/home/alice/"}, []string{"~alan", "~alice"}},
+		{"ssh me@b", []string{"me@build", "me@build.lan"}, []string{"me@build", "me@build.lan"}},
+		{"ssh @l", []string{"@localhost"}, []string{"@localhost"}},
+	}
+	ed := NewEditor(nil, eval.NewEvaluator(), nil)
+	for _, tt := range completeTests {
+		ed.line, ed.dot, ed.mode, ed.completion = tt.line, len(tt.line), modeInsert, nil
+		startCompletion(ed, Key{Tab, 0})
+		var texts, displays []string
+		if ed.completion != nil {
+			for _, c := range ed.completion.candidates {
+				texts = append(texts, c.text)
+				displays = append(displays, c.display.String())
+			}
+		}
+		if !reflect.DeepEqual(texts, tt.texts) || !reflect.DeepEqual(displays, tt.displays) {
+			t.Errorf("completing %q => %q, %q, want %q, %q", tt.line, texts, displays, tt.texts, tt.displays)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package edit
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/parse"
+)
+
+// variableNames returns the names of vars, the variables of the global scope,
+// and of environ, the environment variables, as they are written in code: $name
+// and $env[NAME]. It also returns their values, with newlines replaced by
+// spaces, as descriptions.
+func variableNames(vars map[string]eval.Value, environ map[string]string) ([]string, map[string]string) {
+	var names []string
+	descs := make(map[string]string)
+	oneLine := func(s string) string { return strings.Replace(s, "\n", " ", -1) }
+	for name, v := range vars {
+		names = append(names, "$"+name)
+		descs["$"+name] = oneLine(v.Repr())
+	}
+	for name, v := range environ {
+		names = append(names, "$env["+name+"]")
+		descs["$env["+name+"]"] = oneLine(v)
+	}
+	sort.Strings(names)
+	return names, descs
+}
+
+// completeVariable completes the name of a variable starting with prefix,
+// written from start in the line with $.
+func (ed *Editor) completeVariable(c *completion, start int, prefix string) {
+	c.start, c.end, c.typ = start, ed.dot, parse.ItemBare
+	names, descs := variableNames(ed.ev.Variables(), ed.ev.Environ())
+	ed.applyCompletion(&completionResult{
+		ed.generation, c, "$" + prefix, nil, names, nil, false, descs, "variables"})
+}
@@ -0,0 +1,38 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaq/elvish/eval"
+)
+
+func TestVariableNames(t *testing.T) {
+	vars := map[string]eval.Value{"pid": eval.NewString("1"), "a": eval.NewString("x\ny")}
+	environ := map[string]string{"HOME": "/home/u"}
+	names, descs := variableNames(vars, environ)
+	wantedNames := []string{"$a", "$env[HOME]", "$pid"}
+	wantedDescs := map[string]string{"$a": `"x\ny"`, "$env[HOME]": "/home/u", "$pid": "1"}
+	if !reflect.DeepEqual(names, wantedNames) || !reflect.DeepEqual(descs, wantedDescs) {
+		t.Errorf("variableNames => (%q, %q), want (%q, %q)", names, descs, wantedNames, wantedDescs)
+	}
+}
+
+func TestCompleteVariable(t *testing.T) {
+	ed := NewEditor(nil, eval.NewEvaluatorWithInputs(eval.DeterministicInputs(0)), nil)
+	for _, line := range []string{"echo $pi", "echo $"} {
+		ed.line, ed.dot, ed.mode, ed.completion = line, len(line), modeInsert, nil
+		startCompletion(ed, Key{Tab, 0})
+		if ed.completion == nil {
+			t.Errorf("completing %q => no completion", line)
+			continue
+		}
+		found := false
+		for _, c := range ed.completion.candidates {
+			found = found || c.text == "$pid" && c.description == "1"
+		}
+		if !found {
+			t.Errorf("completing %q => no $pid with its value", line)
+		}
+	}
+}
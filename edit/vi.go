@@ -0,0 +1,353 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiaq/elvish/util"
+)
+
+// Commands of vi operating on registers are typed in command mode, going
+// through vi-pending mode until they are complete:
+//
+//	["x] d|y|c (the same key again, or a text object)
+//	["x] p|P
+//
+// The register is a letter, an uppercase letter appending to the register of
+// the lowercase one, or " for the unnamed register, which is also used when
+// no register is given. Deletes and yanks always set the unnamed register too.
+// Text objects are iw and aw for words, i" and a" for strings quoted with ",
+// ' or `, and i( and a( for text in parentheses, brackets or braces.
+
+// viRegister is the content of a register.
+type viRegister struct {
+	text string
+	// Whether the text is whole lines, yanked with yy or deleted with dd.
+	linewise bool
+}
+
+// viCommand is a command parsed by parseViCommand.
+type viCommand struct {
+	register rune
+	// One of d, y, c, p and P.
+	op rune
+	// The text object, or "" for the whole line.
+	object string
+}
+
+// viObjectPairs maps the characters naming block text objects to the pairs
+// enclosing them.
+var viObjectPairs = map[rune]string{
+	'(': "()", ')': "()", 'b': "()",
+	'[': "[]", ']': "[]",
+	'{': "{}", '}': "{}", 'B': "{}",
+}
+
+// parseViCommand parses keys typed for a vi command. It returns the command
+// and whether it is complete; an invalid command is an error.
+func parseViCommand(keys string) (viCommand, bool, error) {
+	cmd := viCommand{register: '"'}
+	rest := []rune(keys)
+	if len(rest) > 0 && rest[0] == '"' {
+		if len(rest) == 1 {
+			return cmd, false, nil
+		}
+		r := rest[1]
+		if r != '"' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') {
+			return cmd, false, fmt.Errorf("invalid register %c", r)
+		}
+		cmd.register = r
+		rest = rest[2:]
+	}
+	if len(rest) == 0 {
+		return cmd, false, nil
+	}
+	cmd.op = rest[0]
+	switch cmd.op {
+	case 'p', 'P':
+		if len(rest) > 1 {
+			return cmd, false, fmt.Errorf("invalid command %s", keys)
+		}
+		return cmd, true, nil
+	case 'd', 'y', 'c':
+	default:
+		return cmd, false, fmt.Errorf("invalid command %s", keys)
+	}
+	switch {
+	case len(rest) == 1:
+		return cmd, false, nil
+	case len(rest) == 2 && rest[1] == cmd.op:
+		return cmd, true, nil
+	case rest[1] != 'i' && rest[1] != 'a':
+		return cmd, false, fmt.Errorf("invalid command %s", keys)
+	case len(rest) == 2:
+		return cmd, false, nil
+	case len(rest) == 3 && isViObject(rest[2]):
+		cmd.object = string(rest[1:])
+		return cmd, true, nil
+	}
+	return cmd, false, fmt.Errorf("invalid command %s", keys)
+}
+
+func isViObject(r rune) bool {
+	return r == 'w' || r == '"' || r == '\'' || r == '`' || viObjectPairs[r] != ""
+}
+
+// viObjectRange returns the range of bytes of a text object around dot in
+// line. It returns false if there is no such object.
+func viObjectRange(line string, dot int, object string) (int, int, bool) {
+	around := object[0] == 'a'
+	r, _ := utf8.DecodeRuneInString(object[1:])
+	switch {
+	case r == 'w':
+		return wordObject(line, dot, around)
+	case viObjectPairs[r] != "":
+		pair := viObjectPairs[r]
+		return blockObject(line, dot, pair[0], pair[1], around)
+	default:
+		return quoteObject(line, dot, byte(r), around)
+	}
+}
+
+// runeClass classifies runes for word objects: spaces, word characters and
+// other characters.
+func runeClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// wordObject returns the run of runes of the same class as the one at dot.
+// With around, spaces after it are included, or spaces before it if there are
+// none after.
+func wordObject(line string, dot int, around bool) (int, int, bool) {
+	if dot >= len(line) {
+		if dot == 0 {
+			return 0, 0, false
+		}
+		_, w := utf8.DecodeLastRuneInString(line)
+		dot = len(line) - w
+	}
+	r, _ := utf8.DecodeRuneInString(line[dot:])
+	class := runeClass(r)
+	notInClass := func(r rune) bool { return runeClass(r) != class }
+	start := 0
+	if i := strings.LastIndexFunc(line[:dot], notInClass); i != -1 {
+		_, w := utf8.DecodeRuneInString(line[i:])
+		start = i + w
+	}
+	end := len(line)
+	if i := strings.IndexFunc(line[dot:], notInClass); i != -1 {
+		end = dot + i
+	}
+	if around && class != 0 {
+		isSpace := func(r rune) bool { return runeClass(r) == 0 }
+		if trailing := len(line[end:]) - len(strings.TrimLeftFunc(line[end:], isSpace)); trailing > 0 {
+			end += trailing
+		} else {
+			start = len(strings.TrimRightFunc(line[:start], isSpace))
+		}
+	}
+	return start, end, true
+}
+
+// quoteObject returns the string quoted with q around dot, or the first one
+// after dot. Quotes are paired from the start of the line. With around, the
+// quotes are included.
+func quoteObject(line string, dot int, q byte, around bool) (int, int, bool) {
+	open := -1
+	for i := 0; i < len(line); i++ {
+		if line[i] != q {
+			continue
+		}
+		if open == -1 {
+			open = i
+			continue
+		}
+		if i >= dot {
+			if around {
+				return open, i + 1, true
+			}
+			return open + 1, i, true
+		}
+		open = -1
+	}
+	return 0, 0, false
+}
+
+// blockObject returns the text in the innermost pair of open and close around
+// dot. With around, the pair is included.
+func blockObject(line string, dot int, open, close byte, around bool) (int, int, bool) {
+	start := -1
+	depth := 0
+	for i := dot; i >= 0; i-- {
+		if i == len(line) {
+			continue
+		}
+		if line[i] == close && i != dot {
+			depth++
+		} else if line[i] == open {
+			if depth == 0 {
+				start = i
+				break
+			}
+			depth--
+		}
+	}
+	if start == -1 {
+		return 0, 0, false
+	}
+	depth = 0
+	for i := start + 1; i < len(line); i++ {
+		if line[i] == open {
+			depth++
+		} else if line[i] == close {
+			if depth == 0 {
+				if around {
+					return start, i + 1, true
+				}
+				return start + 1, i, true
+			}
+			depth--
+		}
+	}
+	return 0, 0, false
+}
+
+// setRegister sets a register and the unnamed register. An uppercase register
+// is appended to the lowercase one.
+func (ed *Editor) setRegister(name rune, reg viRegister) {
+	if ed.registers == nil {
+		ed.registers = make(map[rune]viRegister)
+	}
+	if unicode.IsUpper(name) {
+		name = unicode.ToLower(name)
+		if old, ok := ed.registers[name]; ok {
+			if old.linewise || reg.linewise {
+				reg = viRegister{old.text + "\n" + reg.text, true}
+			} else {
+				reg.text = old.text + reg.text
+			}
+		}
+	}
+	ed.registers[name] = reg
+	ed.registers['"'] = reg
+}
+
+// runViCommand runs a complete vi command.
+func (ed *Editor) runViCommand(cmd viCommand) error {
+	switch cmd.op {
+	case 'p', 'P':
+		reg, ok := ed.registers[unicode.ToLower(cmd.register)]
+		if !ok {
+			return fmt.Errorf("register %c is empty", cmd.register)
+		}
+		ed.putRegister(reg, cmd.op == 'P')
+		return nil
+	}
+
+	var start, end int
+	linewise := cmd.object == ""
+	if linewise {
+		start = util.FindLastSOL(ed.line[:ed.dot])
+		end = util.FindFirstEOL(ed.line[ed.dot:]) + ed.dot
+	} else {
+		var ok bool
+		start, end, ok = viObjectRange(ed.line, ed.dot, cmd.object)
+		if !ok {
+			return fmt.Errorf("no %s object here", cmd.object)
+		}
+	}
+	ed.setRegister(cmd.register, viRegister{ed.line[start:end], linewise})
+
+	switch cmd.op {
+	case 'y':
+		ed.dot = start
+	case 'd', 'c':
+		if linewise && cmd.op == 'd' {
+			// Take a newline along with the line
+			if end < len(ed.line) {
+				end++
+			} else if start > 0 {
+				start--
+			}
+		}
+		ed.line = ed.line[:start] + ed.line[end:]
+		ed.dot = start
+		if cmd.op == 'c' {
+			ed.mode = modeInsert
+		}
+	}
+	return nil
+}
+
+// putRegister puts the text of a register after the dot, or before it if
+// before is true. Whole lines are put after or before the current line.
+func (ed *Editor) putRegister(reg viRegister, before bool) {
+	var at int
+	text := reg.text
+	switch {
+	case reg.linewise && before:
+		at = util.FindLastSOL(ed.line[:ed.dot])
+		text += "\n"
+	case reg.linewise:
+		at = util.FindFirstEOL(ed.line[ed.dot:]) + ed.dot
+		text = "\n" + text
+	case before || ed.dot == len(ed.line):
+		at = ed.dot
+	default:
+		_, w := utf8.DecodeRuneInString(ed.line[ed.dot:])
+		at = ed.dot + w
+	}
+	ed.line = ed.line[:at] + text + ed.line[at:]
+	if reg.linewise {
+		ed.dot = at
+		if !before {
+			ed.dot++
+		}
+	} else {
+		_, w := utf8.DecodeLastRuneInString(text)
+		ed.dot = at + len(text) - w
+	}
+}
+
+// viCommandKey adds a key to the vi command being typed, running the command
+// when it is complete.
+func viCommandKey(ed *Editor, k Key) *leReturn {
+	if k.Mod != 0 || k.Rune < 0x20 || k.Rune == 0x7f {
+		return cancelViCommand(ed, k)
+	}
+	ed.viKeys += string(k.Rune)
+	cmd, complete, err := parseViCommand(ed.viKeys)
+	switch {
+	case err != nil:
+		ed.pushTip(err.Error())
+	case !complete:
+		ed.mode = modeViPending
+		ed.hints = ed.viPendingHints(ed.viKeys)
+		return nil
+	default:
+		ed.mode = modeCommand
+		if err := ed.runViCommand(cmd); err != nil {
+			ed.pushTip(err.Error())
+		}
+	}
+	ed.viKeys = ""
+	if ed.mode == modeViPending {
+		ed.mode = modeCommand
+	}
+	return nil
+}
+
+func cancelViCommand(ed *Editor, k Key) *leReturn {
+	ed.viKeys = ""
+	ed.mode = modeCommand
+	return nil
+}
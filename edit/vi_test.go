@@ -0,0 +1,119 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var parseViCommandTests = []struct {
+	keys     string
+	cmd      viCommand
+	complete bool
+	err      bool
+}{
+	{"d", viCommand{'"', 'd', ""}, false, false},
+	{"dd", viCommand{'"', 'd', ""}, true, false},
+	{"di", viCommand{'"', 'd', ""}, false, false},
+	{"di(", viCommand{'"', 'd', "i("}, true, false},
+	{"caw", viCommand{'"', 'c', "aw"}, true, false},
+	{`"`, viCommand{'"', 0, ""}, false, false},
+	{`"a`, viCommand{'a', 0, ""}, false, false},
+	{`"ayy`, viCommand{'a', 'y', ""}, true, false},
+	{`"Ayi"`, viCommand{'A', 'y', `i"`}, true, false},
+	{`"ap`, viCommand{'a', 'p', ""}, true, false},
+	{`"1p`, viCommand{}, false, true},
+	{"dx", viCommand{}, false, true},
+	{"diq", viCommand{}, false, true},
+	{"dy", viCommand{}, false, true},
+}
+
+func TestParseViCommand(t *testing.T) {
+	for _, tt := range parseViCommandTests {
+		cmd, complete, err := parseViCommand(tt.keys)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseViCommand(%q) => no error, want error", tt.keys)
+			}
+			continue
+		}
+		if cmd != tt.cmd || complete != tt.complete || err != nil {
+			t.Errorf("parseViCommand(%q) => (%v, %v, %v), want (%v, %v, nil)",
+				tt.keys, cmd, complete, err, tt.cmd, tt.complete)
+		}
+	}
+}
+
+var viObjectRangeTests = []struct {
+	line       string
+	dot        int
+	object     string
+	start, end int
+	ok         bool
+}{
+	{"echo foo-bar baz", 6, "iw", 5, 8, true},
+	{"echo foo-bar baz", 6, "aw", 4, 8, true},
+	{"echo foo-bar baz", 10, "aw", 9, 13, true},
+	{"echo foo baz", 11, "aw", 8, 12, true},
+	{"echo foo  bar", 8, "iw", 8, 10, true},
+	{"", 0, "iw", 0, 0, false},
+	{`echo "a b" "c"`, 7, `i"`, 6, 9, true},
+	{`echo "a b" "c"`, 7, `a"`, 5, 10, true},
+	{`echo "a b" "c"`, 0, `i"`, 6, 9, true},
+	{`echo "a b" "c"`, 12, `i"`, 12, 13, true},
+	{"echo `a`", 6, "i`", 6, 7, true},
+	{`echo "a`, 6, `i"`, 0, 0, false},
+	{"echo (f (g) x)", 13, "i(", 6, 13, true},
+	{"echo (f (g) x)", 9, "a(", 8, 11, true},
+	{"echo (f (g) x)", 10, "ib", 9, 10, true},
+	{"echo (f (g) x)", 5, "a)", 5, 14, true},
+	{"echo {a [b] c}", 9, "i[", 9, 10, true},
+	{"echo {a [b] c}", 9, "iB", 6, 13, true},
+	{"echo (f", 6, "i(", 0, 0, false},
+	{"echo f)", 3, "i(", 0, 0, false},
+}
+
+func TestViObjectRange(t *testing.T) {
+	for _, tt := range viObjectRangeTests {
+		start, end, ok := viObjectRange(tt.line, tt.dot, tt.object)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("viObjectRange(%q, %d, %q) => (%d, %d, %v), want (%d, %d, %v)",
+				tt.line, tt.dot, tt.object, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestViCommands(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.mode = modeCommand
+	ed.line, ed.dot = "echo (a b) c", 7
+	typeKeys := func(keys string) {
+		for _, r := range keys {
+			ed.handleRead(keyRead(Key{r, 0}))
+		}
+	}
+
+	typeKeys(`"adi(`)
+	if ed.line != "echo () c" || ed.dot != 6 || ed.mode != modeCommand {
+		t.Errorf(`"adi( => %q, %d, mode %d`, ed.line, ed.dot, ed.mode)
+	}
+	typeKeys(`"aP`)
+	if ed.line != "echo (a b) c" || ed.dot != 8 {
+		t.Errorf(`"aP => %q, %d`, ed.line, ed.dot)
+	}
+	typeKeys("yy")
+	if reg := ed.registers['"']; !reflect.DeepEqual(reg, viRegister{"echo (a b) c", true}) {
+		t.Errorf("yy => register %v", reg)
+	}
+	typeKeys("P")
+	if ed.line != "echo (a b) c\necho (a b) c" || ed.dot != 0 {
+		t.Errorf("P => %q, %d", ed.line, ed.dot)
+	}
+	typeKeys("dd")
+	if ed.line != "echo (a b) c" || ed.dot != 0 {
+		t.Errorf("dd => %q, %d", ed.line, ed.dot)
+	}
+	typeKeys("ciw")
+	if ed.line != " (a b) c" || ed.mode != modeInsert {
+		t.Errorf("ciw => %q, mode %d", ed.line, ed.mode)
+	}
+}
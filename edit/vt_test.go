@@ -0,0 +1,212 @@
+package edit
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// vtCell is a cell of a virtual terminal. A zero rune marks the right half of
+// a wide character, or a cell never written to.
+type vtCell struct {
+	r    rune
+	attr string
+}
+
+// vt is a virtual terminal that interprets the subset of escape sequences
+// emitted by the writer, with autowrap off. It is used to check what the
+// writer actually puts on the screen, instead of the bytes it emits. Like
+// the tty driver does with ONLCR, it treats "\n" as "\r\n".
+type vt struct {
+	width, height int
+	cells         [][]vtCell
+	line, col     int
+	attr          string
+}
+
+func newVT(width, height int) *vt {
+	t := &vt{width: width, height: height}
+	t.cells = make([][]vtCell, height)
+	for i := range t.cells {
+		t.cells[i] = make([]vtCell, width)
+	}
+	return t
+}
+
+func (t *vt) clamp() {
+	if t.col < 0 {
+		t.col = 0
+	} else if t.col >= t.width {
+		t.col = t.width - 1
+	}
+	if t.line < 0 {
+		t.line = 0
+	}
+}
+
+func (t *vt) lineFeed() {
+	t.line++
+	if t.line == t.height {
+		// Scroll up
+		t.cells = append(t.cells[1:], make([]vtCell, t.width))
+		t.line--
+	}
+}
+
+func (t *vt) erase(line, from, to int) {
+	for i := from; i < to && i < t.width; i++ {
+		t.cells[line][i] = vtCell{' ', t.attr}
+	}
+}
+
+// Write interprets p. It returns an error on sequences it doesn't know.
+func (t *vt) Write(p []byte) (int, error) {
+	s := string(p)
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch r {
+		case '\r':
+			t.col = 0
+		case '\n':
+			t.col = 0
+			t.lineFeed()
+		case '\b':
+			t.col--
+			t.clamp()
+		case '\033':
+			n, err := t.escape(s)
+			if err != nil {
+				return 0, err
+			}
+			s = s[n:]
+		default:
+			w := WcWidth(r)
+			if w == 0 || t.col+w > t.width {
+				continue
+			}
+			t.cells[t.line][t.col] = vtCell{r, t.attr}
+			for i := 1; i < w; i++ {
+				t.cells[t.line][t.col+i] = vtCell{0, t.attr}
+			}
+			t.col += w
+			// Autowrap is off, so the cursor sticks at the last column
+			if t.col >= t.width {
+				t.col = t.width - 1
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// escape interprets the escape sequence in s, which follows an ESC. It
+// returns the number of bytes consumed.
+func (t *vt) escape(s string) (int, error) {
+	if len(s) == 0 || s[0] != '[' {
+		return 0, fmt.Errorf("unsupported escape %q", s)
+	}
+	i := 1
+	private := i < len(s) && s[i] == '?'
+	if private {
+		i++
+	}
+	start := i
+	for i < len(s) && (s[i] == ';' || '0' <= s[i] && s[i] <= '9') {
+		i++
+	}
+	if i == len(s) {
+		return 0, fmt.Errorf("incomplete CSI %q", s)
+	}
+	params := s[start:i]
+	final := s[i]
+	i++
+	if private {
+		// Modes (autowrap, cursor visibility, synchronized updates) do not
+		// change the screen
+		if final != 'h' && final != 'l' {
+			return 0, fmt.Errorf("unsupported private CSI %q", s[:i])
+		}
+		return i, nil
+	}
+
+	n := 1
+	if params != "" {
+		if v, err := strconv.Atoi(params); err == nil {
+			n = v
+		}
+	}
+	switch final {
+	case 'A':
+		t.line -= n
+	case 'B':
+		t.line += n
+		if t.line >= t.height {
+			t.line = t.height - 1
+		}
+	case 'C':
+		t.col += n
+	case 'G':
+		t.col = n - 1
+	case 'K':
+		t.erase(t.line, t.col, t.width)
+	case 'J':
+		t.erase(t.line, t.col, t.width)
+		for l := t.line + 1; l < t.height; l++ {
+			t.erase(l, 0, t.width)
+		}
+	case 'X':
+		t.erase(t.line, t.col, t.col+n)
+	case 'm':
+		if params == "" || params == "0" {
+			t.attr = ""
+		} else {
+			t.attr = params
+		}
+	default:
+		return 0, fmt.Errorf("unsupported CSI %q", s[:i])
+	}
+	t.clamp()
+	return i, nil
+}
+
+// screenshot returns a textual representation of the screen: the rows with
+// trailing spaces trimmed, the cursor position, and the styled runs of each
+// row.
+func (t *vt) screenshot() string {
+	buf := new(bytes.Buffer)
+	for _, row := range t.cells {
+		var line []rune
+		for j := 0; j < len(row); {
+			if r := row[j].r; r == 0 {
+				line = append(line, ' ')
+				j++
+			} else {
+				line = append(line, r)
+				// Skip the right half of wide characters
+				if w := WcWidth(r); w > 1 {
+					j += w
+				} else {
+					j++
+				}
+			}
+		}
+		buf.WriteString(strings.TrimRight(string(line), " ") + "\n")
+	}
+	fmt.Fprintf(buf, "cursor: %d %d\n", t.line, t.col)
+	for i, row := range t.cells {
+		for j := 0; j < len(row); {
+			attr := row[j].attr
+			k := j + 1
+			for k < len(row) && row[k].attr == attr {
+				k++
+			}
+			if attr != "" {
+				fmt.Fprintf(buf, "style: %d %d-%d %s\n", i, j, k-1, attr)
+			}
+			j = k
+		}
+	}
+	return buf.String()
+}
@@ -1,107 +1,132 @@
 package edit
 
 import (
+	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/eval"
 )
 
-// Taken from http://www.cl.cam.ac.uk/~mgk25/ucs/wcwidth.c (public domain)
-var combining = [][2]rune{
-	{0x0300, 0x036F}, {0x0483, 0x0486}, {0x0488, 0x0489},
-	{0x0591, 0x05BD}, {0x05BF, 0x05BF}, {0x05C1, 0x05C2},
-	{0x05C4, 0x05C5}, {0x05C7, 0x05C7}, {0x0600, 0x0603},
-	{0x0610, 0x0615}, {0x064B, 0x065E}, {0x0670, 0x0670},
-	{0x06D6, 0x06E4}, {0x06E7, 0x06E8}, {0x06EA, 0x06ED},
-	{0x070F, 0x070F}, {0x0711, 0x0711}, {0x0730, 0x074A},
-	{0x07A6, 0x07B0}, {0x07EB, 0x07F3}, {0x0901, 0x0902},
-	{0x093C, 0x093C}, {0x0941, 0x0948}, {0x094D, 0x094D},
-	{0x0951, 0x0954}, {0x0962, 0x0963}, {0x0981, 0x0981},
-	{0x09BC, 0x09BC}, {0x09C1, 0x09C4}, {0x09CD, 0x09CD},
-	{0x09E2, 0x09E3}, {0x0A01, 0x0A02}, {0x0A3C, 0x0A3C},
-	{0x0A41, 0x0A42}, {0x0A47, 0x0A48}, {0x0A4B, 0x0A4D},
-	{0x0A70, 0x0A71}, {0x0A81, 0x0A82}, {0x0ABC, 0x0ABC},
-	{0x0AC1, 0x0AC5}, {0x0AC7, 0x0AC8}, {0x0ACD, 0x0ACD},
-	{0x0AE2, 0x0AE3}, {0x0B01, 0x0B01}, {0x0B3C, 0x0B3C},
-	{0x0B3F, 0x0B3F}, {0x0B41, 0x0B43}, {0x0B4D, 0x0B4D},
-	{0x0B56, 0x0B56}, {0x0B82, 0x0B82}, {0x0BC0, 0x0BC0},
-	{0x0BCD, 0x0BCD}, {0x0C3E, 0x0C40}, {0x0C46, 0x0C48},
-	{0x0C4A, 0x0C4D}, {0x0C55, 0x0C56}, {0x0CBC, 0x0CBC},
-	{0x0CBF, 0x0CBF}, {0x0CC6, 0x0CC6}, {0x0CCC, 0x0CCD},
-	{0x0CE2, 0x0CE3}, {0x0D41, 0x0D43}, {0x0D4D, 0x0D4D},
-	{0x0DCA, 0x0DCA}, {0x0DD2, 0x0DD4}, {0x0DD6, 0x0DD6},
-	{0x0E31, 0x0E31}, {0x0E34, 0x0E3A}, {0x0E47, 0x0E4E},
-	{0x0EB1, 0x0EB1}, {0x0EB4, 0x0EB9}, {0x0EBB, 0x0EBC},
-	{0x0EC8, 0x0ECD}, {0x0F18, 0x0F19}, {0x0F35, 0x0F35},
-	{0x0F37, 0x0F37}, {0x0F39, 0x0F39}, {0x0F71, 0x0F7E},
-	{0x0F80, 0x0F84}, {0x0F86, 0x0F87}, {0x0F90, 0x0F97},
-	{0x0F99, 0x0FBC}, {0x0FC6, 0x0FC6}, {0x102D, 0x1030},
-	{0x1032, 0x1032}, {0x1036, 0x1037}, {0x1039, 0x1039},
-	{0x1058, 0x1059}, {0x1160, 0x11FF}, {0x135F, 0x135F},
-	{0x1712, 0x1714}, {0x1732, 0x1734}, {0x1752, 0x1753},
-	{0x1772, 0x1773}, {0x17B4, 0x17B5}, {0x17B7, 0x17BD},
-	{0x17C6, 0x17C6}, {0x17C9, 0x17D3}, {0x17DD, 0x17DD},
-	{0x180B, 0x180D}, {0x18A9, 0x18A9}, {0x1920, 0x1922},
-	{0x1927, 0x1928}, {0x1932, 0x1932}, {0x1939, 0x193B},
-	{0x1A17, 0x1A18}, {0x1B00, 0x1B03}, {0x1B34, 0x1B34},
-	{0x1B36, 0x1B3A}, {0x1B3C, 0x1B3C}, {0x1B42, 0x1B42},
-	{0x1B6B, 0x1B73}, {0x1DC0, 0x1DCA}, {0x1DFE, 0x1DFF},
-	{0x200B, 0x200F}, {0x202A, 0x202E}, {0x2060, 0x2063},
-	{0x206A, 0x206F}, {0x20D0, 0x20EF}, {0x302A, 0x302F},
-	{0x3099, 0x309A}, {0xA806, 0xA806}, {0xA80B, 0xA80B},
-	{0xA825, 0xA826}, {0xFB1E, 0xFB1E}, {0xFE00, 0xFE0F},
-	{0xFE20, 0xFE23}, {0xFEFF, 0xFEFF}, {0xFFF9, 0xFFFB},
-	{0x10A01, 0x10A03}, {0x10A05, 0x10A06}, {0x10A0C, 0x10A0F},
-	{0x10A38, 0x10A3A}, {0x10A3F, 0x10A3F}, {0x1D167, 0x1D169},
-	{0x1D173, 0x1D182}, {0x1D185, 0x1D18B}, {0x1D1AA, 0x1D1AD},
-	{0x1D242, 0x1D244}, {0xE0001, 0xE0001}, {0xE0020, 0xE007F},
-	{0xE0100, 0xE01EF},
-}
-
-func isCombining(r rune) bool {
-	n := len(combining)
-	i := sort.Search(n, func(i int) bool { return r <= combining[i][1] })
-	return i < n && r >= combining[i][0]
+//go:generate go run gen-wcwidth.go -o wcwidth-table.go
+
+// The widths of runes come from the tables in wcwidth-table.go, which
+// gen-wcwidth.go generates from the Unicode Character Database, and can be
+// overridden for ranges of runes with le:wcwidth or OverrideWcWidth, for
+// terminals that follow another version of Unicode or disagree with it, like
+// on the width of emoji. Overrides are made for an Editor, which lays out what
+// it draws with them.
+
+// widthOverride makes the runes from from to to, inclusive, width wide.
+type widthOverride struct {
+	from, to rune
+	width    int
 }
 
+// widthTable is a list of overrides of widths, the latest last. Each Editor
+// has its own, since the terminals of editors may disagree; the functions
+// like WcWidth use none.
+type widthTable []widthOverride
+
+func init() {
+	addEditorBuiltin("le:wcwidth", builtinWcWidth)
+}
+
+// OverrideWcWidth makes the runes from from to to, inclusive, width wide for
+// the editor. It takes precedence over earlier overrides.
+func (ed *Editor) OverrideWcWidth(from, to rune, width int) {
+	ed.widthTable = append(ed.widthTable, widthOverride{from, to, width})
+}
+
+// ResetWcWidth removes all overrides made with OverrideWcWidth.
+func (ed *Editor) ResetWcWidth() {
+	ed.widthTable = nil
+}
+
+// inRanges returns whether r is in one of the sorted ranges.
+func inRanges(r rune, ranges [][2]rune) bool {
+	n := len(ranges)
+	i := sort.Search(n, func(i int) bool { return r <= ranges[i][1] })
+	return i < n && r >= ranges[i][0]
+}
+
+// WcWidth returns the width of r in the tables generated from the Unicode
+// Character Database, or -1 if r is a control character.
 func WcWidth(r rune) int {
+	return widthTable(nil).wcWidth(r)
+}
+
+func WcWidths(s string) int {
+	return widthTable(nil).wcWidths(s)
+}
+
+func TrimWcWidth(s string, wmax int) string {
+	return widthTable(nil).trimWcWidth(s, wmax)
+}
+
+// EllipsizeWcWidth is like TrimWcWidth, but ends the string with an ellipsis
+// when it is trimmed.
+func EllipsizeWcWidth(s string, wmax int) string {
+	return widthTable(nil).ellipsizeWcWidth(s, wmax)
+}
+
+// TrimStyledWcWidth is like TrimWcWidth, but works on styled texts.
+func TrimStyledWcWidth(t styled.Text, wmax int) styled.Text {
+	return widthTable(nil).trimStyledWcWidth(t, wmax)
+}
+
+// EllipsizeStyledWcWidth is like EllipsizeWcWidth, but works on styled texts.
+// The ellipsis takes the style of the last segment kept.
+func EllipsizeStyledWcWidth(t styled.Text, wmax int) styled.Text {
+	return widthTable(nil).ellipsizeStyledWcWidth(t, wmax)
+}
+
+// ForceStyledWcWidth is like ForceWcWidth, but works on styled texts. The
+// padding takes the style of the last segment.
+func ForceStyledWcWidth(t styled.Text, width int) styled.Text {
+	return widthTable(nil).forceStyledWcWidth(t, width)
+}
+
+func ForceWcWidth(s string, width int) string {
+	return widthTable(nil).forceWcWidth(s, width)
+}
+
+// The methods of widthTable are like the functions of the same names, with
+// the overrides of the table applied.
+
+func (wt widthTable) wcWidth(r rune) int {
+	for i := len(wt) - 1; i >= 0; i-- {
+		if o := wt[i]; o.from <= r && r <= o.to {
+			return o.width
+		}
+	}
 	switch {
 	case r == 0:
 		return 0
 	case r < 32 || (0x7f <= r && r < 0xa0): // Control character
 		return -1
-	case isCombining(r):
+	case inRanges(r, combining):
 		return 0
-	}
-
-	if r >= 0x1100 &&
-		(r <= 0x115f || /* Hangul Jamo init. consonants */
-			r == 0x2329 || r == 0x232a ||
-			(r >= 0x2e80 && r <= 0xa4cf &&
-				r != 0x303f) || /* CJK ... Yi */
-			(r >= 0xac00 && r <= 0xd7a3) || /* Hangul Syllables */
-			(r >= 0xf900 && r <= 0xfaff) || /* CJK Compatibility Ideographs */
-			(r >= 0xfe10 && r <= 0xfe19) || /* Vertical forms */
-			(r >= 0xfe30 && r <= 0xfe6f) || /* CJK Compatibility Forms */
-			(r >= 0xff00 && r <= 0xff60) || /* Fullwidth Forms */
-			(r >= 0xffe0 && r <= 0xffe6) ||
-			(r >= 0x20000 && r <= 0x2fffd) ||
-			(r >= 0x30000 && r <= 0x3fffd)) {
+	case inRanges(r, wide):
 		return 2
 	}
 	return 1
 }
 
-func WcWidths(s string) (w int) {
+func (wt widthTable) wcWidths(s string) (w int) {
 	for _, r := range s {
-		w += WcWidth(r)
+		w += wt.wcWidth(r)
 	}
 	return
 }
 
-func TrimWcWidth(s string, wmax int) string {
+func (wt widthTable) trimWcWidth(s string, wmax int) string {
 	w := 0
 	for i, r := range s {
-		w += WcWidth(r)
+		w += wt.wcWidth(r)
 		if w > wmax {
 			return s[:i]
 		}
@@ -109,10 +134,60 @@ func TrimWcWidth(s string, wmax int) string {
 	return s
 }
 
-func ForceWcWidth(s string, width int) string {
+func (wt widthTable) ellipsizeWcWidth(s string, wmax int) string {
+	if wt.wcWidths(s) <= wmax {
+		return s
+	}
+	if wmax < 1 {
+		return ""
+	}
+	return wt.trimWcWidth(s, wmax-1) + "…"
+}
+
+func (wt widthTable) trimStyledWcWidth(t styled.Text, wmax int) styled.Text {
+	var trimmed styled.Text
+	for _, seg := range t {
+		text := wt.trimWcWidth(seg.Text, wmax)
+		trimmed = append(trimmed, styled.Segment{Text: text, Style: seg.Style})
+		if len(text) < len(seg.Text) {
+			break
+		}
+		wmax -= wt.wcWidths(text)
+	}
+	return trimmed
+}
+
+func (wt widthTable) ellipsizeStyledWcWidth(t styled.Text, wmax int) styled.Text {
+	if wt.wcWidths(t.String()) <= wmax {
+		return t
+	}
+	if wmax < 1 {
+		return nil
+	}
+	t = wt.trimStyledWcWidth(t, wmax-1)
+	style := ""
+	if len(t) > 0 {
+		style = t[len(t)-1].Style
+	}
+	return t.Concat(styled.New("…", style))
+}
+
+func (wt widthTable) forceStyledWcWidth(t styled.Text, width int) styled.Text {
+	t = wt.trimStyledWcWidth(t, width)
+	if w := wt.wcWidths(t.String()); w < width {
+		style := ""
+		if len(t) > 0 {
+			style = t[len(t)-1].Style
+		}
+		t = append(t, styled.Segment{Text: strings.Repeat(" ", width-w), Style: style})
+	}
+	return t
+}
+
+func (wt widthTable) forceWcWidth(s string, width int) string {
 	w := 0
 	for i, r := range s {
-		w0 := WcWidth(r)
+		w0 := wt.wcWidth(r)
 		w += w0
 		if w > width {
 			w -= w0
@@ -122,3 +197,58 @@ func ForceWcWidth(s string, width int) string {
 	}
 	return s + strings.Repeat(" ", width-w)
 }
+
+// parseRune parses a rune given as itself, or as its code point in hex after
+// U+ or 0x.
+func parseRune(s string) (rune, bool) {
+	if r, size := utf8.DecodeRuneInString(s); size == len(s) && r != utf8.RuneError {
+		return r, true
+	}
+	upper := strings.ToUpper(s)
+	if !strings.HasPrefix(upper, "U+") && !strings.HasPrefix(upper, "0X") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 32)
+	if err != nil || n > utf8.MaxRune {
+		return 0, false
+	}
+	return rune(n), true
+}
+
+// builtinWcWidth implements the le:wcwidth builtin. With no arguments, it
+// prints the overrides of widths, one per line. With one argument, a rune, it
+// prints its width; the argument clear removes all overrides instead. With
+// three arguments, the first and last runes of a range and a width of 0, 1 or
+// 2, it overrides the widths of the range, e.g. le:wcwidth U+1F300 U+1F64F 2.
+func builtinWcWidth(ed *Editor, ev *eval.Evaluator, args []eval.Value) string {
+	out := ev.OutFile()
+	switch len(args) {
+	case 0:
+		for _, o := range ed.widthTable {
+			fmt.Fprintf(out, "%U %U %d\n", o.from, o.to, o.width)
+		}
+		return ""
+	case 1:
+		if args[0].String() == "clear" {
+			ed.ResetWcWidth()
+			return ""
+		}
+		r, ok := parseRune(args[0].String())
+		if !ok {
+			return "args error"
+		}
+		fmt.Fprintln(out, ed.wcWidth(r))
+		return ""
+	case 3:
+		from, ok1 := parseRune(args[0].String())
+		to, ok2 := parseRune(args[1].String())
+		width, err := strconv.Atoi(args[2].String())
+		if !ok1 || !ok2 || from > to || err != nil || width < 0 || width > 2 {
+			return "args error"
+		}
+		ed.OverrideWcWidth(from, to, width)
+		return ""
+	default:
+		return "args error"
+	}
+}
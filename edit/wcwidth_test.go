@@ -1,7 +1,10 @@
 package edit
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/xiaq/elvish/edit/styled"
 )
 
 var wcwidthTests = []struct {
@@ -23,3 +26,102 @@ func TestWcwidth(t *testing.T) {
 		}
 	}
 }
+
+func TestOverrideWcWidth(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.OverrideWcWidth(0x1F300, 0x1F64F, 2)
+	ed.OverrideWcWidth('a', 'z', 2)
+	ed.OverrideWcWidth('x', 'x', 1)
+	for r, wanted := range map[rune]int{'😀': 2, 'a': 2, 'x': 1, 'A': 1, '好': 2} {
+		if out := ed.wcWidth(r); out != wanted {
+			t.Errorf("wcwidth(%q) with overrides => %v, want %v", r, out, wanted)
+		}
+	}
+	if out := WcWidth('a'); out != 1 {
+		t.Errorf("WcWidth('a') with overrides of an editor => %v, want 1", out)
+	}
+	ed.ResetWcWidth()
+	if out := ed.wcWidth('a'); out != 1 {
+		t.Errorf("wcwidth('a') after ResetWcWidth => %v, want 1", out)
+	}
+}
+
+var parseRuneTests = []struct {
+	s      string
+	r      rune
+	wanted bool
+}{
+	{"a", 'a', true},
+	{"好", '好', true},
+	{"U+1F300", 0x1F300, true},
+	{"0x41", 'A', true},
+	{"ab", 0, false},
+	{"U+110000", 0, false},
+}
+
+func TestParseRune(t *testing.T) {
+	for _, tt := range parseRuneTests {
+		if r, ok := parseRune(tt.s); r != tt.r || ok != tt.wanted {
+			t.Errorf("parseRune(%q) => (%q, %v), want (%q, %v)", tt.s, r, ok, tt.r, tt.wanted)
+		}
+	}
+}
+
+var forceStyledWcWidthTests = []struct {
+	in     styled.Text
+	width  int
+	wanted styled.Text
+}{
+	{styled.New("foo", "1"), 5, styled.New("foo", "1").Concat(styled.New("  ", "1"))},
+	{styled.New("fo", "1").Concat(styled.New("obar", "4")), 4,
+		styled.New("fo", "1").Concat(styled.New("ob", "4"))},
+	{nil, 2, styled.Plain("  ")},
+}
+
+func TestForceStyledWcWidth(t *testing.T) {
+	for _, tt := range forceStyledWcWidthTests {
+		out := ForceStyledWcWidth(tt.in, tt.width)
+		if !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("ForceStyledWcWidth(%v, %v) => %v, want %v", tt.in, tt.width, out, tt.wanted)
+		}
+	}
+}
+
+var ellipsizeStyledWcWidthTests = []struct {
+	in     styled.Text
+	wmax   int
+	wanted styled.Text
+}{
+	{styled.New("foo", "1"), 3, styled.New("foo", "1")},
+	{styled.New("fo", "1").Concat(styled.New("obar", "4")), 4,
+		styled.New("fo", "1").Concat(styled.New("o", "4"), styled.New("…", "4"))},
+	{styled.New("foo", "1"), 0, nil},
+}
+
+func TestEllipsizeStyledWcWidth(t *testing.T) {
+	for _, tt := range ellipsizeStyledWcWidthTests {
+		out := EllipsizeStyledWcWidth(tt.in, tt.wmax)
+		if !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("EllipsizeStyledWcWidth(%v, %v) => %v, want %v", tt.in, tt.wmax, out, tt.wanted)
+		}
+	}
+}
+
+var ellipsizeWcWidthTests = []struct {
+	s      string
+	wmax   int
+	wanted string
+}{
+	{"abc", 3, "abc"},
+	{"abcd", 3, "ab…"},
+	{"好好好", 4, "好…"},
+	{"abc", 0, ""},
+}
+
+func TestEllipsizeWcWidth(t *testing.T) {
+	for _, tt := range ellipsizeWcWidthTests {
+		if out := EllipsizeWcWidth(tt.s, tt.wmax); out != tt.wanted {
+			t.Errorf("EllipsizeWcWidth(%q, %d) => %q, want %q", tt.s, tt.wmax, out, tt.wanted)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package edit
+
+import (
+	"time"
+
+	"github.com/xiaq/elvish/edit/tty"
+)
+
+// The editor lays out what it draws by the size of the terminal, which it
+// gets with tty.Size. Terminals that don't report their size, to which
+// tty.Size guesses one, don't send SIGWINCH when it changes either, so while
+// ReadLine runs, the size is polled every SizePollInterval until the terminal
+// reports it, like ptys whose size is only set after the shell starts.
+
+// SizePollInterval is how often the size of the terminal is polled when it is
+// not reported.
+const SizePollInterval = time.Second
+
+// pollSize polls the size of the terminal in the background, redrawing when it
+// changes, until the terminal reports it. It returns a function that stops
+// polling.
+func (ed *Editor) pollSize() func() {
+	fd := int(ed.writer.file.Fd())
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(SizePollInterval)
+		defer ticker.Stop()
+		width, height, _ := tty.Size(fd)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			w, h, ok := tty.Size(fd)
+			if w != width || h != height || ok {
+				width, height = w, h
+				ed.Redraw()
+			}
+			if ok {
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,39 @@
+package edit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xiaq/elvish/edit/tty"
+)
+
+var sizeFallbackTests = []struct {
+	columns, lines string
+	width, height  int
+}{
+	{"", "", 80, 24},
+	{"120", "40", 120, 40},
+	{"0", "-1", 80, 24},
+	{"wide", "40", 80, 40},
+}
+
+func TestSizeFallback(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	for _, name := range []string{"COLUMNS", "LINES"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	for _, tt := range sizeFallbackTests {
+		os.Setenv("COLUMNS", tt.columns)
+		os.Setenv("LINES", tt.lines)
+		width, height, ok := tty.Size(int(w.Fd()))
+		if width != tt.width || height != tt.height || ok {
+			t.Errorf("Size of a pipe with COLUMNS=%q LINES=%q => (%v, %v, %v), want (%v, %v, false)",
+				tt.columns, tt.lines, width, height, ok, tt.width, tt.height)
+		}
+	}
+}
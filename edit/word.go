@@ -0,0 +1,130 @@
+package edit
+
+import (
+	"unicode"
+
+	"github.com/xiaq/elvish/parse"
+)
+
+// Word motion and killing come in three flavors, each with its own builtins,
+// such as move-dot-left-word, move-dot-right-big-word and kill-token-left:
+//
+// word: runs of letters, digits and underscores, like in Emacs;
+// big-word: runs of non-whitespace characters, like WORD in vi;
+// token: tokens of the lexer, so that a quoted string or a parenthesis is one
+// word.
+
+// span is the byte range [start, end) of a word in the line.
+type span struct {
+	start, end int
+}
+
+// moveDotLeftBy returns a builtin moving the dot to the start of the word
+// before it, with words found by words.
+func moveDotLeftBy(words func(string) []span) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		ed.dot = wordLeft(words(ed.line), ed.dot)
+		return nil
+	}
+}
+
+// moveDotRightBy returns a builtin moving the dot to the end of the word after
+// it, with words found by words.
+func moveDotRightBy(words func(string) []span) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		ed.dot = wordRight(words(ed.line), ed.dot, len(ed.line))
+		return nil
+	}
+}
+
+// killLeftBy returns a builtin killing from the start of the word before the
+// dot to the dot, with words found by words.
+func killLeftBy(words func(string) []span) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		left := wordLeft(words(ed.line), ed.dot)
+		ed.line = ed.line[:left] + ed.line[ed.dot:]
+		ed.dot = left
+		return nil
+	}
+}
+
+// killRightBy returns a builtin killing from the dot to the end of the word
+// after it, with words found by words.
+func killRightBy(words func(string) []span) leBuiltin {
+	return func(ed *Editor, k Key) *leReturn {
+		right := wordRight(words(ed.line), ed.dot, len(ed.line))
+		ed.line = ed.line[:ed.dot] + ed.line[right:]
+		return nil
+	}
+}
+
+// runs returns the maximal runs of runes in line satisfying f.
+func runs(line string, f func(rune) bool) []span {
+	var spans []span
+	start := -1
+	for i, r := range line {
+		if f(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			spans = append(spans, span{start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		spans = append(spans, span{start, len(line)})
+	}
+	return spans
+}
+
+func alnumWords(line string) []span {
+	return runs(line, func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	})
+}
+
+func bigWords(line string) []span {
+	return runs(line, func(r rune) bool { return !unicode.IsSpace(r) })
+}
+
+// tokenWords returns the tokens of line, except spaces, comments and newlines.
+// Text the lexer can't make tokens of is split into big words.
+func tokenWords(line string) []span {
+	var spans []span
+	for item := range parse.Lex("<line>", line).Chan() {
+		start := int(item.Pos)
+		switch item.Typ {
+		case parse.ItemSpace, parse.ItemEndOfLine, parse.ItemEOF:
+		case parse.ItemError:
+			for _, s := range bigWords(line[start:]) {
+				spans = append(spans, span{start + s.start, start + s.end})
+			}
+			return spans
+		default:
+			spans = append(spans, span{start, start + len(item.Val)})
+		}
+	}
+	return spans
+}
+
+// wordLeft returns the start of the word before dot, or 0 if there is none.
+func wordLeft(words []span, dot int) int {
+	left := 0
+	for _, w := range words {
+		if w.start < dot {
+			left = w.start
+		}
+	}
+	return left
+}
+
+// wordRight returns the end of the word after dot, or n if there is none.
+func wordRight(words []span, dot, n int) int {
+	for _, w := range words {
+		if w.end > dot {
+			return w.end
+		}
+	}
+	return n
+}
@@ -0,0 +1,65 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+var wordsTests = []struct {
+	words  func(string) []span
+	line   string
+	wanted []span
+}{
+	{alnumWords, "", nil},
+	{alnumWords, "ls a/b_c", []span{{0, 2}, {3, 4}, {5, 8}}},
+	{bigWords, "ls  a/b_c ", []span{{0, 2}, {4, 9}}},
+	{tokenWords, "echo `a b`|wc", []span{{0, 4}, {5, 10}, {10, 11}, {11, 13}}},
+	{tokenWords, "echo (f) # x", []span{{0, 4}, {5, 6}, {6, 7}, {7, 8}}},
+}
+
+func TestWords(t *testing.T) {
+	for _, tt := range wordsTests {
+		if out := tt.words(tt.line); !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("words(%q) => %v, want %v", tt.line, out, tt.wanted)
+		}
+	}
+}
+
+var wordMotionTests = []struct {
+	words       func(string) []span
+	line        string
+	dot         int
+	left, right int
+}{
+	{alnumWords, "ls a/bc d", 6, 5, 7},
+	{alnumWords, "ls a/bc d", 7, 5, 9},
+	{alnumWords, "ls a/bc d", 0, 0, 2},
+	{alnumWords, "ls a/bc  ", 9, 5, 9},
+	{bigWords, "ls a/bc d", 6, 3, 7},
+	{tokenWords, "echo `a b`", 10, 5, 10},
+}
+
+func TestWordMotion(t *testing.T) {
+	for _, tt := range wordMotionTests {
+		words := tt.words(tt.line)
+		if left := wordLeft(words, tt.dot); left != tt.left {
+			t.Errorf("wordLeft(%q, %d) => %d, want %d", tt.line, tt.dot, left, tt.left)
+		}
+		if right := wordRight(words, tt.dot, len(tt.line)); right != tt.right {
+			t.Errorf("wordRight(%q, %d) => %d, want %d", tt.line, tt.dot, right, tt.right)
+		}
+	}
+}
+
+func TestKillWord(t *testing.T) {
+	ed := &Editor{options: newOptions()}
+	ed.line, ed.dot = "cp a/bc d", 7
+	leBuiltins["kill-word-left"](ed, Key{})
+	if ed.line != "cp a/ d" || ed.dot != 5 {
+		t.Errorf("kill-word-left => %q, %d, want %q, %d", ed.line, ed.dot, "cp a/ d", 5)
+	}
+	leBuiltins["kill-big-word-right"](ed, Key{})
+	if ed.line != "cp a/" || ed.dot != 5 {
+		t.Errorf("kill-big-word-right => %q, %d, want %q, %d", ed.line, ed.dot, "cp a/", 5)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/xiaq/elvish/edit/styled"
 	"github.com/xiaq/elvish/edit/tty"
+	"github.com/xiaq/elvish/parse"
 	"github.com/xiaq/elvish/util"
 )
 
@@ -25,6 +27,8 @@ type cell struct {
 	rune
 	width byte
 	attr  string
+	// The URL the cell is a hyperlink to, if any.
+	link string
 }
 
 // pos is the position within a buffer.
@@ -51,12 +55,23 @@ func lineWidth(cs []cell) int {
 type buffer struct {
 	width, col, indent int
 	newlineWhenFull    bool
+	noWrap             bool     // If true, only explicit newlines start new lines.
 	cells              [][]cell // cells reflect len(cells) lines on the terminal.
 	dot                pos      // dot is what the user perceives as the cursor.
+	// Whether the dot has just been placed with markDot, and moves with the
+	// next cell if that wraps to the next line.
+	dotAtNext bool
+	// The URL cells written are hyperlinks to, if any.
+	link string
+	// The image drawn over the cells, if any.
+	image *bufImage
+	// The options of the editor the buffer is rendered for, which decide
+	// the widths of runes and the attributes of styles.
+	*options
 }
 
-func newBuffer(width int) *buffer {
-	return &buffer{width: width, cells: [][]cell{make([]cell, 0, width)}}
+func (o *options) newBuffer(width int) *buffer {
+	return &buffer{width: width, cells: [][]cell{make([]cell, 0, width)}, options: o}
 }
 
 func (b *buffer) appendCell(c cell) {
@@ -116,24 +131,38 @@ func (b *buffer) extendHorizontal(b2 *buffer, w, m int) {
 	}
 }
 
+// markDot places the dot where the next cell is written: at the cursor, or
+// at the start of the next line if the next cell doesn't fit on this one.
+func (b *buffer) markDot() {
+	b.dot = b.cursor()
+	b.dotAtNext = true
+}
+
 // write appends a single rune to a buffer.
 func (b *buffer) write(r rune, attr string) {
 	if r == '\n' {
+		b.dotAtNext = false
 		b.newline()
 		return
 	} else if !unicode.IsPrint(r) {
 		// BUG(xiaq): buffer.write drops unprintable runes silently
 		return
 	}
-	wd := WcWidth(r)
-	c := cell{r, byte(wd), attr}
+	wd := b.wcWidth(r)
+	c := cell{r, byte(wd), attr, b.link}
+	dotAtNext := b.dotAtNext
+	b.dotAtNext = false
 
-	if b.col+wd > b.width {
+	if !b.noWrap && b.col+wd > b.width {
+		// A wide rune in the last column wraps, leaving the column empty
 		b.newline()
+		if dotAtNext {
+			b.dot = b.cursor()
+		}
 		b.appendCell(c)
 	} else {
 		b.appendCell(c)
-		if b.col == b.width && b.newlineWhenFull {
+		if !b.noWrap && b.col == b.width && b.newlineWhenFull {
 			b.newline()
 		}
 	}
@@ -145,8 +174,33 @@ func (b *buffer) writes(s string, attr string) {
 	}
 }
 
+// writeStyled writes a styled text. Segments without a style are written with
+// defaultAttr.
+func (b *buffer) writeStyled(t styled.Text, defaultAttr string) {
+	for _, seg := range t {
+		attr := seg.Style
+		if attr == "" {
+			attr = defaultAttr
+		}
+		b.writes(seg.Text, attr)
+	}
+}
+
+// appendStyle returns a copy of t, with more appended to the style of each
+// segment.
+func appendStyle(t styled.Text, more string) styled.Text {
+	r := make(styled.Text, len(t))
+	for i, seg := range t {
+		r[i] = styled.Segment{Text: seg.Text, Style: seg.Style + more}
+	}
+	return r
+}
+
+// writePadding writes w spaces, or nothing if w is not positive.
 func (b *buffer) writePadding(w int, attr string) {
-	b.writes(strings.Repeat(" ", w), attr)
+	if w > 0 {
+		b.writes(strings.Repeat(" ", w), attr)
+	}
 }
 
 func (b *buffer) line() int {
@@ -166,6 +220,58 @@ func (b *buffer) trimToLines(low, high int) {
 	}
 	b.cells = b.cells[low:high]
 	b.dot.line -= low
+	// The cursor is left at the end of the last line after writing.
+	if n := len(b.cells); n > 0 {
+		b.col = lineWidth(b.cells[n-1])
+	}
+}
+
+// cropRow crops a line to the columns [low, low+w). If the line is truncated
+// on either side, the corresponding edge column is replaced by a marker with
+// the attribute markAttr. Wide cells that straddle an edge are replaced by
+// spaces.
+func cropRow(row []cell, low, w int, markAttr string) []cell {
+	cropped := make([]cell, 0, w)
+	start, end := low, low+w
+	if low > 0 {
+		cropped = append(cropped, cell{'<', 1, markAttr, ""})
+		start++
+	}
+	truncated := lineWidth(row) > end
+	if truncated {
+		end--
+	}
+	col := 0
+	for _, c := range row {
+		cw := int(c.width)
+		if col >= start && col+cw <= end {
+			cropped = append(cropped, c)
+		} else if col < end && col+cw > start {
+			for i := util.MaxInt(col, start); i < col+cw && i < end; i++ {
+				cropped = append(cropped, cell{' ', 1, c.attr, c.link})
+			}
+		}
+		col += cw
+	}
+	if truncated {
+		cropped = append(cropped, cell{'>', 1, markAttr, ""})
+	}
+	return cropped
+}
+
+// scrollToDot crops all lines of b horizontally to b.width columns, choosing
+// the leftmost column so that the dot is visible. It is used for buffers with
+// noWrap set.
+func (b *buffer) scrollToDot() {
+	low := 0
+	if b.dot.col > b.width-2 {
+		low = b.dot.col - b.width/2
+	}
+	for i, row := range b.cells {
+		b.cells[i] = cropRow(row, low, b.width, b.attrForScrollMark)
+	}
+	b.dot.col -= low
+	b.col = lineWidth(b.cells[len(b.cells)-1])
 }
 
 // writer is the part of an Editor responsible for keeping the status of and
@@ -173,25 +279,51 @@ func (b *buffer) trimToLines(low, high int) {
 type writer struct {
 	file   *os.File
 	oldBuf *buffer
+	// If horizontalScroll is true, long lines are scrolled horizontally
+	// within one terminal row instead of being soft-wrapped.
+	horizontalScroll bool
+	caps             capabilities
+	esc              escapes
+	// The height of the terminal as of the last redraw, or 0 if unknown.
+	height int
+	// Where frames are recorded, if the session is being recorded.
+	recorder *recorder
+	// What was drawn on the primary screen, while on the alternate screen,
+	// and the sequence to switch screens at the start of the next frame.
+	primaryBuf   *buffer
+	screenSwitch string
+	// The image on the screen, if any.
+	image *bufImage
+	// The options of the editor.
+	*options
 }
 
-func newWriter(f *os.File) *writer {
-	writer := &writer{file: f, oldBuf: newBuffer(0)}
+func newWriter(f *os.File, o *options) *writer {
+	writer := &writer{file: f, oldBuf: o.newBuffer(0),
+		caps: capabilitiesFromEnv(), esc: xtermEscapes{}, options: o}
 	return writer
 }
 
+// queryCursor asks the terminal to report the position of the cursor with a
+// Device Status Report. The terminal replies with a Cursor Position Report,
+// which the reader delivers as OneRead.CPR.
+func (w *writer) queryCursor() error {
+	_, err := w.file.WriteString("\033[6n")
+	return err
+}
+
 // deltaPos calculates the escape sequence needed to move the cursor from one
 // position to another.
-func deltaPos(from, to pos) []byte {
+func deltaPos(es escapes, from, to pos) []byte {
 	buf := new(bytes.Buffer)
 	if from.line < to.line {
 		// move down
-		fmt.Fprintf(buf, "\033[%dB", to.line-from.line)
+		buf.WriteString(es.down(to.line - from.line))
 	} else if from.line > to.line {
 		// move up
-		fmt.Fprintf(buf, "\033[%dA", from.line-to.line)
+		buf.WriteString(es.up(from.line - to.line))
 	}
-	fmt.Fprintf(buf, "\033[%dG", to.col+1)
+	buf.WriteString(es.column(to.col))
 	return buf.Bytes()
 }
 
@@ -208,10 +340,152 @@ func compareRows(r1, r2 []cell) (bool, int) {
 	}
 }
 
+// echThreshold is the minimal length of a run of blank cells for which
+// writeRowTail erases with ECH and skips over instead of writing spaces.
+const echThreshold = 8
+
+func isBlank(c cell) bool {
+	return c.rune == ' ' && c.attr == "" && c.link == ""
+}
+
+func writeAttr(bytesBuf *bytes.Buffer, es escapes, attr string, current *string) {
+	if attr == *current {
+		return
+	}
+	bytesBuf.WriteString(es.resetAttr())
+	if attr != "" {
+		fmt.Fprintf(bytesBuf, "\033[%sm", attr)
+	}
+	*current = attr
+}
+
+// writeLink writes the sequence to start a hyperlink to link, or to end the
+// current one if link is empty, unless link is already current.
+func writeLink(bytesBuf *bytes.Buffer, es escapes, link string, current *string) {
+	if link == *current {
+		return
+	}
+	bytesBuf.WriteString(es.hyperlink(link))
+	*current = link
+}
+
+// writeRowTail writes the escape sequences needed to update a terminal line
+// that is currently oldWidth columns wide with row[j:], assuming that the
+// cursor is already in the column of row[j]. Long runs of blank cells are
+// erased with ECH (if there is old content to erase) and skipped over, and old
+// content beyond the last non-blank cell is erased with EL, both in place of
+// writing spaces. attr keeps track of the current SGR attribute. Hyperlinks
+// are ended before the cursor leaves them.
+func writeRowTail(bytesBuf *bytes.Buffer, es escapes, row []cell, j, oldWidth int, attr *string) {
+	link := ""
+	end := len(row)
+	for end > j && isBlank(row[end-1]) {
+		end--
+	}
+	col := lineWidth(row[:j])
+	for k := j; k < end; {
+		c := row[k]
+		if isBlank(c) {
+			n := 1
+			for k+n < end && isBlank(row[k+n]) {
+				n++
+			}
+			if n >= echThreshold {
+				// ECH uses the current background color
+				writeAttr(bytesBuf, es, "", attr)
+				writeLink(bytesBuf, es, "", &link)
+				if col < oldWidth {
+					bytesBuf.WriteString(es.eraseChars(n))
+				}
+				bytesBuf.WriteString(es.right(n))
+				k += n
+				col += n
+				continue
+			}
+		}
+		if c.width > 0 {
+			writeAttr(bytesBuf, es, c.attr, attr)
+			writeLink(bytesBuf, es, c.link, &link)
+		}
+		bytesBuf.WriteString(string(c.rune))
+		k++
+		col += int(c.width)
+	}
+	writeLink(bytesBuf, es, "", &link)
+	if col < oldWidth {
+		// EL uses the current background color too
+		writeAttr(bytesBuf, es, "", attr)
+		bytesBuf.WriteString(es.eraseLine())
+	}
+}
+
+// dumbRow returns the cells of the line of buf where the dot is, trimmed to
+// fit within one less than the width of buf so that they never wrap, and the
+// width of the part before the dot.
+func dumbRow(buf *buffer) (row []cell, dotCol int) {
+	if buf.dot.line >= len(buf.cells) {
+		return nil, 0
+	}
+	row = buf.cells[buf.dot.line]
+	col := 0
+	for i, c := range row {
+		if col+int(c.width) > buf.width-1 {
+			row = row[:i]
+			break
+		}
+		col += int(c.width)
+	}
+	dotCol = buf.dot.col
+	if dotCol > col {
+		dotCol = col
+	}
+	return row, dotCol
+}
+
+// commitBufferDumb is the variant of commitBuffer for dumb terminals, which
+// are not sent any escape sequences. Lacking cursor motions, it only draws
+// the line with the dot, by rewinding with a carriage return and overwriting
+// the old content, padded with spaces if it was wider.
+func (w *writer) commitBufferDumb(buf *buffer) error {
+	row, dotCol := dumbRow(buf)
+	oldRow, _ := dumbRow(w.oldBuf)
+
+	bytesBuf := new(bytes.Buffer)
+	bytesBuf.WriteString("\r")
+	for _, c := range row {
+		bytesBuf.WriteRune(c.rune)
+	}
+	if pad := lineWidth(oldRow) - lineWidth(row); pad > 0 {
+		bytesBuf.WriteString(strings.Repeat(" ", pad))
+	}
+	// Move to the dot by rewriting what comes before it
+	bytesBuf.WriteString("\r")
+	col := 0
+	for _, c := range row {
+		if col >= dotCol {
+			break
+		}
+		bytesBuf.WriteRune(c.rune)
+		col += int(c.width)
+	}
+
+	_, err := w.file.Write(bytesBuf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.recordOutput(bytesBuf.Bytes())
+
+	w.oldBuf = buf
+	return nil
+}
+
 // commitBuffer updates the terminal display to reflect current buffer.
 // TODO Instead of erasing w.oldBuf entirely and then draw buf, compute a
 // delta between w.oldBuf and buf
 func (w *writer) commitBuffer(buf *buffer) error {
+	if w.caps.dumb {
+		return w.commitBufferDumb(buf)
+	}
 	var fullRefresh bool
 	if buf.width != w.oldBuf.width && w.oldBuf.cells != nil {
 		// Width change, force full refresh
@@ -219,51 +493,113 @@ func (w *writer) commitBuffer(buf *buffer) error {
 		fullRefresh = true
 	}
 
+	if w.noStyle || !w.caps.color || !w.caps.trueColor || !w.caps.hyperlinks {
+		for _, line := range buf.cells {
+			for i := range line {
+				if !w.caps.hyperlinks {
+					line[i].link = ""
+				}
+				if w.noStyle {
+					line[i].attr = ""
+				} else if !w.caps.color {
+					line[i].attr = stripColor(line[i].attr)
+				} else if !w.caps.trueColor {
+					line[i].attr = reduceTrueColor(line[i].attr)
+				}
+			}
+		}
+	}
+
 	bytesBuf := new(bytes.Buffer)
+	es := w.activeEscapes()
+	// Whether anything is repainted, as opposed to just moving the cursor
+	repaint := w.screenSwitch != ""
+	bytesBuf.WriteString(w.screenSwitch)
+	w.screenSwitch = ""
 
 	// Rewind cursor
 	if pLine := w.oldBuf.dot.line; pLine > 0 {
-		fmt.Fprintf(bytesBuf, "\033[%dA", pLine)
+		bytesBuf.WriteString(es.up(pLine))
 	}
 	bytesBuf.WriteString("\r")
 
+	// A changed image is drawn again; a sixel image gone or changed is
+	// erased by repainting the lines under it (see image-preview.go).
+	img, oldImg := buf.image, w.image
+	drawImage := img != nil && !img.same(oldImg)
+	eraseSixel := oldImg != nil && oldImg.protocol == sixelGraphics && !oldImg.same(img)
+
 	attr := ""
 	for i, line := range buf.cells {
 		if i > 0 {
 			bytesBuf.WriteString("\n")
 		}
-		var j int // First column where buf and oldBuf differ
+		var j int // First cell where buf and oldBuf differ
+		// The terminal line is unknown; assume it is filled.
+		oldWidth := buf.width
 		// No need to update current line
-		if i < len(w.oldBuf.cells) {
+		if i < len(w.oldBuf.cells) && !(eraseSixel && oldImg.covers(i)) {
 			var eq bool
 			if eq, j = compareRows(line, w.oldBuf.cells[i]); eq {
 				continue
 			}
+			oldWidth = lineWidth(w.oldBuf.cells[i])
 		}
-		// Move to the first differing column and erase the rest of line
-		fmt.Fprintf(bytesBuf, "\033[%dG\033[K", j+1)
-		for _, c := range line[j:] {
-			if c.width > 0 && c.attr != attr {
-				fmt.Fprintf(bytesBuf, "\033[m\033[%sm", c.attr)
-				attr = c.attr
-			}
-			bytesBuf.WriteString(string(c.rune))
+		repaint = true
+		if img != nil && img.protocol == sixelGraphics && img.covers(i) {
+			drawImage = true
 		}
+		// Move to the first differing column and write the rest of line
+		bytesBuf.WriteString(es.column(lineWidth(line[:j])))
+		writeRowTail(bytesBuf, es, line, j, oldWidth, &attr)
 	}
-	// If the old buffer is higher, erase old content
-	if len(w.oldBuf.cells) > len(buf.cells) || fullRefresh {
-		bytesBuf.WriteString("\n\033[J\033[A")
-	}
+	// Reset the attribute before erasing, since erasures use it too
 	if attr != "" {
-		bytesBuf.WriteString("\033[m")
+		bytesBuf.WriteString(es.resetAttr())
+	}
+	// If the old buffer is higher, erase old content. On a full refresh the
+	// old content is unknown, so it is always erased, unless buf reaches the
+	// bottom of the terminal: there is nothing below then, and the newline
+	// would scroll the terminal.
+	if len(w.oldBuf.cells) > len(buf.cells) ||
+		fullRefresh && (w.height == 0 || len(buf.cells) < w.height) {
+		repaint = true
+		bytesBuf.WriteString("\n" + es.eraseDown() + es.up(1))
 	}
 	cursor := buf.cursor()
-	bytesBuf.Write(deltaPos(cursor, buf.dot))
+	if oldImg != nil && oldImg.protocol == kittyGraphics && (img == nil || drawImage) {
+		repaint = true
+		bytesBuf.WriteString(kittyDeleteImage)
+	}
+	if drawImage {
+		// Draw the image at its corner, saving and restoring the cursor
+		// around it, since it may or may not move the cursor
+		repaint = true
+		bytesBuf.WriteString("\0337")
+		bytesBuf.Write(deltaPos(es, cursor, pos{img.line, img.col}))
+		bytesBuf.WriteString(img.seq)
+		bytesBuf.WriteString("\0338")
+	}
+	w.image = img
+	bytesBuf.Write(deltaPos(es, cursor, buf.dot))
 
-	_, err := w.file.Write(bytesBuf.Bytes())
+	frame := bytesBuf.Bytes()
+	if repaint {
+		// Hide the cursor while repainting, so that it doesn't visibly jump
+		// around the screen
+		frame = []byte(es.hideCursor() + string(frame) + es.showCursor())
+		// Wrap the frame in a synchronized update, so that it appears
+		// atomically on terminals that support it
+		if w.caps.syncUpdate {
+			frame = []byte(beginSyncUpdate + string(frame) + endSyncUpdate)
+		}
+	}
+
+	_, err := w.file.Write(frame)
 	if err != nil {
 		return err
 	}
+	w.recordOutput(frame)
 
 	w.oldBuf = buf
 	return nil
@@ -300,13 +636,27 @@ func findWindow(height, selected, max int) (low, high int) {
 	}
 }
 
+// findViewport finds a window of at most height lines in a total number of
+// nlines lines that contains the line with the dot. Unlike findWindow, it
+// keeps as many of the top lines as possible, since they contain the prompt
+// and the beginning of the line being edited.
+func findViewport(nlines, dotLine, height int) (low, high int) {
+	if nlines <= height {
+		return 0, nlines
+	}
+	if dotLine < height {
+		return 0, height
+	}
+	return dotLine + 1 - height, dotLine + 1
+}
+
 func trimToWindow(s []string, selected, max int) ([]string, int) {
 	low, high := findWindow(len(s), selected, max)
 	return s[low:high], low
 }
 
-func renderNavColumn(nc *navColumn, w, h int) *buffer {
-	b := newBuffer(w)
+func (o *options) renderNavColumn(nc *navColumn, w, h int) *buffer {
+	b := o.newBuffer(w)
 	low, high := findWindow(len(nc.names), nc.selected, h)
 	for i := low; i < high; i++ {
 		if i > low {
@@ -315,15 +665,19 @@ func renderNavColumn(nc *navColumn, w, h int) *buffer {
 		text := nc.names[i]
 		attr := nc.attrs[i]
 		if i == nc.selected {
-			attr += attrForSelectedFile
+			attr += o.attrForSelectedFile
 		}
 		if w >= navigationListingMinWidthForPadding {
 			padding := navigationListingColPadding
 			b.writePadding(padding, attr)
-			b.writes(ForceWcWidth(text, w-2), attr)
+			b.link = nc.links[i]
+			b.writes(o.forceWcWidth(text, w-2), attr)
+			b.link = ""
 			b.writePadding(padding, attr)
 		} else {
-			b.writes(ForceWcWidth(text, w), attr)
+			b.link = nc.links[i]
+			b.writes(o.forceWcWidth(text, w), attr)
+			b.link = ""
 		}
 	}
 	return b
@@ -331,19 +685,237 @@ func renderNavColumn(nc *navColumn, w, h int) *buffer {
 
 // refresh redraws the line editor. The dot is passed as an index into text;
 // the corresponding position will be calculated.
-func (w *writer) refresh(bs *editorState, histories []string) error {
-	winsize := tty.GetWinsize(int(w.file.Fd()))
-	width, height := int(winsize.Col), int(winsize.Row)
+func (w *writer) refresh(bs *editorState, histories []HistoryEntry) error {
+	width, height, _ := tty.Size(int(w.file.Fd()))
+	return w.redraw(bs, histories, width, height)
+}
+
+// redraw is like refresh, but with a given terminal size.
+func (w *writer) redraw(bs *editorState, histories []HistoryEntry, width, height int) error {
+	w.height = height
+	if w.recorder != nil {
+		w.recorder.resize(width, height)
+	}
+	w.switchScreen(bs, width)
+	return w.commitBuffer(w.render(bs, histories, width, height))
+}
+
+// writeColumns lays out items in as many columns as fit in b, filling each
+// column from top to bottom, and writes the window of at most height lines
+// that shows the item current, which is highlighted. Items are hyperlinks to
+// the corresponding links, if links is not nil. It returns the number of
+// lines of the whole layout.
+func writeColumns(b *buffer, items []styled.Text, links []string, current, height int) int {
+	// First decide the shape (# of rows and columns)
+	colWidth := 0
+	margin := completionListingColMargin
+	for _, item := range items {
+		width := b.wcWidths(item.String())
+		if colWidth < width {
+			colWidth = width
+		}
+	}
+
+	cols := (b.width + margin) / (colWidth + margin)
+	if cols == 0 {
+		cols = 1
+	}
+	lines := util.CeilDiv(len(items), cols)
+	if lines == 0 {
+		return 0
+	}
+
+	// Determine the window to show.
+	selected := 0
+	if current != -1 {
+		selected = current % lines
+	}
+	low, high := findWindow(lines, selected, height)
+	for i := low; i < high; i++ {
+		if i > low {
+			b.newline()
+		}
+		for j := 0; j < cols; j++ {
+			k := j*lines + i
+			if k >= len(items) {
+				continue
+			}
+			t := items[k]
+			if k == current {
+				t = appendStyle(t, b.attrForCurrentCompletion)
+			}
+			if links != nil {
+				b.link = links[k]
+			}
+			b.writeStyled(b.forceStyledWcWidth(t, colWidth), "")
+			b.link = ""
+			b.writePadding(margin, "")
+		}
+	}
+	return lines
+}
+
+func hasDescriptions(cands []*candidate) bool {
+	for _, cand := range cands {
+		if cand.description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// countGroups returns the number of groups of candidates, which are
+// contiguous.
+func countGroups(cands []*candidate) int {
+	n := 0
+	for i, cand := range cands {
+		if i == 0 || cand.group != cands[i-1].group {
+			n++
+		}
+	}
+	return n
+}
+
+// writeDescribed writes candidates one per line, followed by their
+// descriptions, and returns the number of candidates. The candidates take at
+// most half of the width, and the descriptions are trimmed with an ellipsis to
+// fit. If there are several groups of candidates, each group starts with a
+// header.
+func writeDescribed(b *buffer, cands []*candidate, current, height int) int {
+	colWidth := 0
+	for _, cand := range cands {
+		if w := b.wcWidths(cand.text); colWidth < w {
+			colWidth = w
+		}
+	}
+	if max := b.width / 2; colWidth > max {
+		colWidth = max
+	}
+	sep := " — "
+	descWidth := b.width - colWidth - b.wcWidths(sep)
+
+	// Lay out the rows: headers are -1, and candidates their indices
+	headers := countGroups(cands) > 1
+	var rows []int
+	currentRow := 0
+	for i, cand := range cands {
+		if headers && (i == 0 || cand.group != cands[i-1].group) {
+			rows = append(rows, -1)
+		}
+		if i == current {
+			currentRow = len(rows)
+		}
+		rows = append(rows, i)
+	}
+
+	low, high := findWindow(len(rows), currentRow, height)
+	for r := low; r < high; r++ {
+		if r > low {
+			b.newline()
+		}
+		i := rows[r]
+		if i == -1 {
+			b.writes(b.trimWcWidth(cands[rows[r+1]].group, b.width), b.attrForGroupHeader)
+			continue
+		}
+		cand := cands[i]
+		t := b.forceStyledWcWidth(cand.display, colWidth)
+		if i == current {
+			t = appendStyle(t, b.attrForCurrentCompletion)
+		}
+		b.link = cand.link
+		b.writeStyled(t, "")
+		b.link = ""
+		if cand.description != "" && descWidth > 0 {
+			b.writes(sep, "")
+			b.writes(b.ellipsizeWcWidth(cand.description, descWidth), b.attrForDescription)
+		}
+	}
+	return len(cands)
+}
+
+// pasteModeLine describes a paste waiting to be confirmed.
+func (o *options) pasteModeLine(p *pasteState) string {
+	text := o.tr("Paste 1 line")
+	if n := strings.Count(p.text, "\n") + 1; n > 1 {
+		text = o.trf("Paste %d lines", n)
+	}
+	switch {
+	case p.removed == 1:
+		text += o.tr(", 1 control char removed")
+	case p.removed > 1:
+		text += o.trf(", %d control chars removed", p.removed)
+	}
+	return text
+}
+
+// errorRange returns the range of bytes in the line to mark for err: from the
+// position of err to the end of the word containing it, such as $nosuch. It
+// returns an empty range if err is nil.
+func errorRange(tokens []parse.Item, err *util.ContextualError) (int, int) {
+	if err == nil {
+		return 0, 0
+	}
+	pos := err.Pos()
+	start, end := 0, 0
+	for _, token := range tokens {
+		tokenEnd := int(token.Pos) + len(token.Val)
+		if end == 0 {
+			if int(token.Pos) <= pos && pos < tokenEnd {
+				start, end = pos, tokenEnd
+			}
+			continue
+		}
+		switch token.Typ {
+		case parse.ItemSpace, parse.ItemSemicolon, parse.ItemPipe,
+			parse.ItemEndOfLine, parse.ItemEOF:
+			return start, end
+		}
+		end = tokenEnd
+	}
+	return start, end
+}
+
+// render lays out the line editor in a buffer for a terminal of the given
+// size.
+func (w *writer) render(bs *editorState, histories []HistoryEntry, width, height int) *buffer {
+	if width < 1 {
+		// The width is unknown
+		width = 1
+	}
+	var bufMessages, bufLine, bufMode, bufTips, bufListing, buf *buffer
+	// bufMessages
+	if len(bs.messages) > 0 {
+		b := w.newBuffer(width)
+		bufMessages = b
+		for i, msg := range bs.messages {
+			if i > 0 {
+				b.newline()
+			}
+			b.writes(w.trimWcWidth(msg, width), w.attrForMessage)
+		}
+	}
 
-	var bufLine, bufMode, bufTips, bufListing, buf *buffer
 	// bufLine
-	b := newBuffer(width)
+	b := w.newBuffer(width)
 	bufLine = b
 
 	b.newlineWhenFull = true
+	// Dumb terminals can only show one line, which is scrolled like in
+	// horizontalScroll
+	b.noWrap = w.horizontalScroll || w.caps.dumb
 
-	b.writes(bs.prompt, attrForPrompt)
+	// A one-line prompt wider than the terminal is ellipsized, leaving a
+	// column for the cursor
+	prompt := bs.prompt
+	if s := prompt.String(); !strings.Contains(s, "\n") && w.wcWidths(s) >= width {
+		prompt = w.ellipsizeStyledWcWidth(prompt, width-1)
+	}
+	b.writeStyled(prompt, w.attrForPrompt)
 
+	// Continuation lines are indented to align with the first one, unless
+	// the prompt takes half of the width or more, which leaves too little
+	// room
 	if b.line() == 0 && b.col*2 < b.width {
 		b.indent = b.col
 	}
@@ -351,11 +923,13 @@ func (w *writer) refresh(bs *editorState, histories []string) error {
 	// i keeps track of number of bytes written.
 	i := 0
 	if bs.dot == 0 {
-		b.dot = b.cursor()
+		b.writePreedit(bs.preedit)
 	}
 
 	comp := bs.completion
 	var suppress = false
+	errStart, errEnd := errorRange(bs.tokens, bs.lineError)
+	selStart, selEnd := selectionRange(bs.line, bs.dot, bs.selection)
 
 tokens:
 	for _, token := range bs.tokens {
@@ -363,27 +937,34 @@ tokens:
 			if suppress && i < comp.end {
 				// Silence the part that is being completed
 			} else {
-				b.write(r, attrForType[token.Typ])
+				attr := w.attrForType[token.Typ]
+				if errStart <= i && i < errEnd {
+					attr += w.attrForLineError
+				}
+				if selStart <= i && i < selEnd {
+					attr += w.attrForSelection
+				}
+				if inFreshField(bs, i) {
+					attr += w.attrForSnippetField
+				}
+				if mb := bs.minibuffer; mb != nil && mb.matchStart <= i && i < mb.matchEnd {
+					attr += w.attrForMatch
+				}
+				b.write(r, attr)
 			}
 			i += utf8.RuneLen(r)
 			if comp != nil && comp.current != -1 && i == comp.start {
 				// Put the current candidate and instruct text up to comp.end
 				// to be suppressed. The cursor should be placed correctly
 				// (i.e. right after the candidate)
-				for _, part := range comp.candidates[comp.current].parts {
-					attr := attrForType[comp.typ]
-					if part.completed {
-						attr += attrForCompleted
-					}
-					b.writes(part.text, attr)
-				}
+				b.writeStyled(comp.candidates[comp.current].parts, "")
 				suppress = true
 			}
 			if bs.mode == modeHistory && i == len(bs.history.prefix) {
 				break tokens
 			}
 			if bs.dot == i {
-				b.dot = b.cursor()
+				b.writePreedit(bs.preedit)
 			}
 		}
 	}
@@ -392,49 +973,94 @@ tokens:
 		// Put the rest of current history, position the cursor at the
 		// end of the line, and finish writing
 		h := bs.history
-		b.writes(histories[h.current][len(h.prefix):], attrForCompletedHistory)
+		b.writes(histories[h.current].Line[len(h.prefix):], w.attrForCompletedHistory)
 		b.dot = b.cursor()
+	} else if bs.suggestion != "" {
+		b.writes(bs.suggestion, w.attrForSuggestion)
+	}
+
+	if b.noWrap {
+		b.scrollToDot()
 	}
 
 	// Write rprompt
-	padding := b.width - b.col - WcWidths(bs.rprompt)
+	padding := b.width - b.col - w.wcWidths(bs.rprompt.String())
 	if padding >= 1 {
 		b.newlineWhenFull = false
 		b.writePadding(padding, "")
-		b.writes(bs.rprompt, attrForRprompt)
+		b.writeStyled(bs.rprompt, w.attrForRprompt)
 	}
 
 	// bufMode
-	if bs.mode != modeInsert {
-		b := newBuffer(width)
+	if bs.mode == modeMinibuffer {
+		// The question and the answer, with the cursor in the answer
+		mb := bs.minibuffer
+		b := w.newBuffer(width)
+		bufMode = b
+		b.newlineWhenFull = true
+		b.writes(mb.prompt, w.attrForMode)
+		b.writes(" ", "")
+		b.writes(mb.text[:mb.dot], "")
+		b.dot = b.cursor()
+		b.writes(mb.text[mb.dot:], "")
+	} else if bs.mode != modeInsert {
+		b := w.newBuffer(width)
 		bufMode = b
 		text := ""
 		switch bs.mode {
 		case modeCommand:
-			text = "Command"
+			text = w.tr("Command")
 		case modeCompletion:
-			text = fmt.Sprintf("Completing %s", bs.line[comp.start:comp.end])
+			text = w.trf("Completing %s", bs.line[comp.start:comp.end])
 		case modeNavigation:
-			text = "Navigating"
+			text = w.tr("Navigating")
 		case modeHistory:
-			text = fmt.Sprintf("History #%d", bs.history.current)
+			text = w.trf("History #%d", bs.history.current)
+		case modeSnippet:
+			text = w.tr("Snippet")
+		case modePaste:
+			text = w.pasteModeLine(bs.paste)
+		case modeViPending:
+			text = w.tr("Command") + " " + bs.viKeys
+		case modeVisual:
+			text = w.tr("Visual")
+		case modeLiteral:
+			text = w.literalModeLine(bs.literal)
+		case modePalette:
+			text = w.tr("Palette") + " " + bs.palette.filter
+		case modeHistoryListing:
+			hl := bs.historyListing
+			text = w.tr("History") + " " + hl.filter
+			if len(hl.selected) > 0 {
+				text = w.trf("History (%d selected)", len(hl.selected)) + " " + hl.filter
+			}
 		}
-		b.writes(TrimWcWidth(text, width), attrForMode)
+		b.writeStyled(w.trimStyledWcWidth(styled.Plain(text), width), w.attrForMode)
 	}
 
 	// bufTips
 	// TODO tips is assumed to contain no newlines.
-	if len(bs.tips) > 0 {
-		b := newBuffer(width)
+	tips := bs.tips
+	if bs.lineError != nil {
+		tips = append(tips[:len(tips):len(tips)],
+			styled.Plain(bs.lineError.Msg()))
+	}
+	if len(tips) > 0 {
+		b := w.newBuffer(width)
 		bufTips = b
-		b.writes(TrimWcWidth(strings.Join(bs.tips, ", "), width), attrForTip)
+		tips := styled.Join(tips, styled.Plain(", "))
+		b.writeStyled(w.trimStyledWcWidth(tips, width), w.attrForTip)
 	}
 
 	hListing := 0
-	// Trim lines and determine the maximum height for bufListing
+	// Trim lines and determine the maximum height for bufListing. Messages
+	// are only shown when everything else but the listing fits
+	if height < lines(bufMessages, bufLine, bufMode, bufTips) {
+		bufMessages = nil
+	}
 	switch {
-	case height >= lines(bufLine, bufMode, bufTips):
-		hListing = height - lines(bufLine, bufMode, bufTips)
+	case height >= lines(bufMessages, bufLine, bufMode, bufTips):
+		hListing = height - lines(bufMessages, bufLine, bufMode, bufTips)
 	case height >= lines(bufLine, bufTips):
 		bufMode, bufListing = nil, nil
 	case height >= lines(bufLine):
@@ -449,58 +1075,178 @@ tokens:
 
 	// Render bufListing under the maximum height constraint
 	nav := bs.navigation
-	if hListing > 0 && comp != nil || nav != nil {
-		b := newBuffer(width)
+	sl := bs.snippet
+	paste := bs.paste
+	pal := bs.palette
+	hl := bs.historyListing
+	// The output of the line in instant mode is only shown when there is
+	// nothing else to list
+	instant := bs.mode == modeInsert && bs.hints == nil && len(bs.instant) > 0
+	if hListing > 0 && (comp != nil || nav != nil || sl != nil || paste != nil || bs.hints != nil || pal != nil || hl != nil || instant) {
+		b := w.newBuffer(width)
+		// Completion listing, with a footer for omitted candidates, and the
+		// preview of the file under the current candidate beside them
+		fp := bs.filePreview
+		if comp == nil || width < filePreviewMinWidth {
+			fp = nil
+		}
+		wPreview := width * 45 / 100
+		if fp != nil {
+			b = w.newBuffer(width - wPreview - completionListingColMargin)
+		}
 		bufListing = b
-		// Completion listing
-		if comp != nil {
-			// Layout candidates in multiple columns
-			cands := comp.candidates
-
-			// First decide the shape (# of rows and columns)
-			colWidth := 0
-			margin := completionListingColMargin
-			for _, cand := range cands {
-				width := WcWidths(cand.text)
-				if colWidth < width {
-					colWidth = width
+		hCands := hListing
+		if comp != nil && comp.omitted > 0 && hListing > 1 {
+			hCands--
+		}
+		if comp != nil && (hasDescriptions(comp.candidates) || countGroups(comp.candidates) > 1) {
+			bs.completionLines = writeDescribed(b, comp.candidates, comp.current, hCands)
+		} else if comp != nil {
+			items := make([]styled.Text, len(comp.candidates))
+			links := make([]string, len(comp.candidates))
+			for i, cand := range comp.candidates {
+				items[i] = cand.display
+				links[i] = cand.link
+			}
+			bs.completionLines = writeColumns(b, items, links, comp.current, hCands)
+		}
+		if hCands < hListing {
+			b.newline()
+			b.writes(w.trimWcWidth(w.trf("…and %d more", comp.omitted), b.width), w.attrForDescription)
+		}
+		if fp != nil {
+			// As high as the listing, but not too low to be useful
+			h := len(b.cells)
+			if h < filePreviewMinLines {
+				h = filePreviewMinLines
+			}
+			if h > hListing {
+				h = hListing
+			}
+			b.extendHorizontal(w.renderFilePreview(fp, wPreview, h), b.width, completionListingColMargin)
+			b.width = width
+		}
+
+		// Binding hints
+		if bs.hints != nil {
+			items := make([]styled.Text, len(bs.hints))
+			for i, hint := range bs.hints {
+				items[i] = styled.Plain(hint)
+			}
+			writeColumns(b, items, nil, -1, hListing)
+		}
+
+		// Snippet listing: one snippet per line, with its template
+		if sl != nil {
+			nameWidth := 0
+			for _, name := range sl.names {
+				if w := w.wcWidths(name); nameWidth < w {
+					nameWidth = w
 				}
 			}
+			low, high := findWindow(len(sl.names), sl.current, hListing)
+			for i := low; i < high; i++ {
+				if i > low {
+					b.newline()
+				}
+				name := sl.names[i]
+				attr := ""
+				if i == sl.current {
+					attr = w.attrForCurrentCompletion
+				}
+				// Templates may contain newlines; show them on one line
+				template := strings.Replace(w.snippets[name], "\n", " ", -1)
+				text := w.forceWcWidth(name, nameWidth) + "  " + template
+				b.writes(w.trimWcWidth(text, width), attr)
+			}
+		}
 
-			cols := (b.width + margin) / (colWidth + margin)
-			if cols == 0 {
-				cols = 1
+		// Palette: one builtin per line, with the keys bound to it
+		if pal != nil {
+			nameWidth := 0
+			for _, name := range pal.names {
+				if w := w.wcWidths(name); nameWidth < w {
+					nameWidth = w
+				}
+			}
+			low, high := findWindow(len(pal.names), pal.current, hListing)
+			for i := low; i < high; i++ {
+				if i > low {
+					b.newline()
+				}
+				name := pal.names[i]
+				attr := ""
+				if i == pal.current {
+					attr = w.attrForCurrentCompletion
+				}
+				text := w.forceWcWidth(name, nameWidth) + "  " + w.boundKeys(pal.mode, name)
+				b.writes(w.trimWcWidth(text, width), attr)
 			}
-			lines := util.CeilDiv(len(cands), cols)
-			bs.completionLines = lines
+		}
 
-			// Determine the window to show.
-			low, high := findWindow(lines, comp.current%lines, hListing)
+		// History listing: one entry per line, with when it started and
+		// whether it is selected
+		if hl != nil {
+			low, high := findWindow(len(hl.entries), hl.current, hListing)
 			for i := low; i < high; i++ {
 				if i > low {
 					b.newline()
 				}
-				for j := 0; j < cols; j++ {
-					k := j*lines + i
-					if k >= len(cands) {
-						continue
-					}
-					attr := cands[k].attr
-					if k == comp.current {
-						attr += attrForCurrentCompletion
-					}
-					text := cands[k].text
-					b.writes(ForceWcWidth(text, colWidth), attr)
-					b.writePadding(margin, "")
+				e := hl.entries[i]
+				attr := ""
+				if i == hl.current {
+					attr = w.attrForCurrentCompletion
+				}
+				mark := "  "
+				if hl.selectedIndex(e.Line) != -1 {
+					mark = "+ "
+				}
+				start := strings.Repeat(" ", len(historyTimeFormat))
+				if !e.Start.IsZero() {
+					start = e.Start.Format(historyTimeFormat)
+				}
+				line := strings.Replace(e.Line, "\n", " ", -1)
+				b.writes(w.trimWcWidth(mark+start+"  "+line, width), attr)
+			}
+		}
+
+		// Paste preview: the first lines of the paste
+		if paste != nil {
+			lines := strings.Split(paste.text, "\n")
+			for i := 0; i < len(lines) && i < hListing; i++ {
+				if i > 0 {
+					b.newline()
+				}
+				line := strings.Replace(lines[i], "\t", "    ", -1)
+				b.writes(w.trimWcWidth(line, width), "")
+			}
+		}
+
+		// Instant mode: the first lines of the output of the line
+		if instant {
+			for i := 0; i < len(bs.instant) && i < hListing; i++ {
+				if i > 0 {
+					b.newline()
 				}
+				line := strings.Replace(bs.instant[i], "\t", "    ", -1)
+				b.writes(w.trimWcWidth(line, width), w.attrForInstant)
 			}
 		}
 
 		// Navigation listing
 		if nav != nil {
 			margin := navigationListingColMargin
+			protocol := w.caps.graphics()
+			img := nav.image
+			if protocol == noGraphics {
+				img = nil
+			}
+			cellW, cellH := defaultCellWidth, defaultCellHeight
+			if img != nil {
+				cellW, cellH = w.cellSize()
+			}
 			var ratioParent, ratioCurrent, ratioPreview int
-			if nav.dirPreview != nil {
+			if nav.dirPreview != nil || img != nil || bs.filePreview != nil {
 				ratioParent = 15
 				ratioCurrent = 40
 				ratioPreview = 45
@@ -510,20 +1256,42 @@ tokens:
 				// Leave some space at the right side
 			}
 
-			w := width - margin*2
+			wNav := width - margin*2
 
-			wParent := w * ratioParent / 100
-			wCurrent := w * ratioCurrent / 100
-			wPreview := w * ratioPreview / 100
+			wParent := wNav * ratioParent / 100
+			wCurrent := wNav * ratioCurrent / 100
+			wPreview := wNav * ratioPreview / 100
 
-			b := renderNavColumn(nav.parent, wParent, hListing)
+			b := w.renderNavColumn(nav.parent, wParent, hListing)
 			bufListing = b
 
-			bCurrent := renderNavColumn(nav.current, wCurrent, hListing)
+			bCurrent := w.renderNavColumn(nav.current, wCurrent, hListing)
 			b.extendHorizontal(bCurrent, wParent, margin)
 
-			if wPreview > 0 {
-				bPreview := renderNavColumn(nav.dirPreview, wPreview, hListing)
+			if wPreview > 0 && nav.dirPreview != nil {
+				bPreview := w.renderNavColumn(nav.dirPreview, wPreview, hListing)
+				b.extendHorizontal(bPreview, wParent+wCurrent+margin, margin)
+			} else if wPreview > 0 && img != nil {
+				rows := hListing
+				if protocol == sixelGraphics && rows > 1 {
+					// Leave the last line free, since terminals may
+					// scroll when a sixel image reaches the bottom
+					rows--
+				}
+				p := imagePlacement{protocol, wPreview, rows, cellW, cellH}
+				if b.image = img.place(p); b.image != nil {
+					b.image.col = wParent + wCurrent + margin*2
+					// The lines under the image must be on the screen
+					for len(b.cells) < b.image.rows {
+						b.appendLine()
+					}
+				} else if img.err == nil {
+					bPreview := w.newBuffer(wPreview)
+					bPreview.writes(w.trimWcWidth(w.tr("loading image…"), wPreview), w.attrForDescription)
+					b.extendHorizontal(bPreview, wParent+wCurrent+margin, margin)
+				}
+			} else if wPreview > 0 && bs.filePreview != nil {
+				bPreview := w.renderFilePreview(bs.filePreview, wPreview, hListing)
 				b.extendHorizontal(bPreview, wParent+wCurrent+margin, margin)
 			}
 		}
@@ -531,9 +1299,35 @@ tokens:
 
 	// Combine buffers (reusing bufLine)
 	buf = bufLine
+	if bs.mode == modeMinibuffer && bufMode != nil {
+		buf.dot = pos{len(bufLine.cells) + bufMode.dot.line, bufMode.dot.col}
+	}
+	if bufMessages != nil && buf != nil {
+		buf.cells = append(bufMessages.cells, buf.cells...)
+		buf.dot.line += len(bufMessages.cells)
+	}
 	buf.extend(bufMode)
 	buf.extend(bufTips)
+	if bufListing != nil && bufListing.image != nil {
+		img := *bufListing.image
+		img.line += len(buf.cells)
+		buf.image = &img
+	}
 	buf.extend(bufListing)
 
-	return w.commitBuffer(buf)
+	// Crop the composed buffer to a viewport around the dot. If buf were
+	// taller than the terminal, writing it would scroll the terminal and
+	// invalidate the cursor positions commitBuffer relies on.
+	if height >= 1 && len(buf.cells) > height {
+		low, high := findViewport(len(buf.cells), buf.dot.line, height)
+		buf.trimToLines(low, high)
+		if img := buf.image; img != nil {
+			img.line -= low
+			if img.line < 0 || img.line+img.rows > len(buf.cells) {
+				buf.image = nil
+			}
+		}
+	}
+
+	return buf
 }
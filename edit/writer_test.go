@@ -0,0 +1,266 @@
+package edit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var findViewportTests = []struct {
+	nlines, dotLine, height int
+	low, high               int
+}{
+	{3, 0, 5, 0, 3},
+	{10, 2, 5, 0, 5},
+	{10, 4, 5, 0, 5},
+	{10, 5, 5, 1, 6},
+	{10, 9, 5, 5, 10},
+}
+
+func TestFindViewport(t *testing.T) {
+	for _, tt := range findViewportTests {
+		low, high := findViewport(tt.nlines, tt.dotLine, tt.height)
+		if low != tt.low || high != tt.high {
+			t.Errorf("findViewport(%v, %v, %v) => (%v, %v), want (%v, %v)",
+				tt.nlines, tt.dotLine, tt.height, low, high, tt.low, tt.high)
+		}
+	}
+}
+
+func cellsOf(s string) []cell {
+	var cs []cell
+	for _, r := range s {
+		cs = append(cs, cell{r, byte(WcWidth(r)), "", ""})
+	}
+	return cs
+}
+
+func runesOf(cs []cell) string {
+	var rs []rune
+	for _, c := range cs {
+		rs = append(rs, c.rune)
+	}
+	return string(rs)
+}
+
+var cropRowTests = []struct {
+	row    string
+	low, w int
+	wanted string
+}{
+	{"abc", 0, 5, "abc"},
+	{"abcdefg", 0, 5, "abcd>"},
+	{"abcdefg", 2, 5, "<defg"},
+	{"abcdefgh", 2, 5, "<def>"},
+	{"a好bcdef", 2, 5, "<bcd>"},
+	{"a好bcdef", 1, 5, "< bc>"},
+}
+
+func TestCropRow(t *testing.T) {
+	for _, tt := range cropRowTests {
+		cropped := cropRow(cellsOf(tt.row), tt.low, tt.w, "")
+		if out := runesOf(cropped); out != tt.wanted {
+			t.Errorf("cropRow(%q, %v, %v) => %q, want %q",
+				tt.row, tt.low, tt.w, out, tt.wanted)
+		}
+	}
+}
+
+var writeRowTailTests = []struct {
+	row      string
+	j        int
+	oldWidth int
+	wanted   string
+}{
+	{"abc", 0, 0, "abc"},
+	{"abc", 1, 10, "bc\033[K"},
+	{"abc   ", 0, 3, "abc"},
+	{"a          b", 0, 0, "a\033[10Cb"},
+	{"a          b", 0, 20, "a\033[10X\033[10Cb\033[K"},
+	{"a  b", 0, 0, "a  b"},
+}
+
+func TestWriteRowTail(t *testing.T) {
+	for _, tt := range writeRowTailTests {
+		b := new(bytes.Buffer)
+		attr := ""
+		writeRowTail(b, xtermEscapes{}, cellsOf(tt.row), tt.j, tt.oldWidth, &attr)
+		if out := b.String(); out != tt.wanted {
+			t.Errorf("writeRowTail(%q, %v, %v) => %q, want %q",
+				tt.row, tt.j, tt.oldWidth, out, tt.wanted)
+		}
+	}
+}
+
+func TestWriteRowTailLinks(t *testing.T) {
+	row := cellsOf("ab c")
+	row[0].link, row[1].link = "file:///a", "file:///a"
+	b := new(bytes.Buffer)
+	attr := ""
+	writeRowTail(b, xtermEscapes{}, row, 0, 0, &attr)
+	wanted := "\033]8;;file:///a\033\\ab\033]8;;\033\\ c"
+	if out := b.String(); out != wanted {
+		t.Errorf("writeRowTail with links => %q, want %q", out, wanted)
+	}
+}
+
+func TestCommitBufferSyncUpdate(t *testing.T) {
+	f, err := ioutil.TempFile("", "elvishtest.")
+	if err != nil {
+		t.Fatalf("Got error when creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newWriter(f, newOptions())
+	w.caps.syncUpdate = true
+	frame := func(s string) string {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		b := w.newBuffer(20)
+		b.writes(s, "")
+		b.dot = b.cursor()
+		if err := w.commitBuffer(b); err != nil {
+			t.Fatalf("commitBuffer => error %v", err)
+		}
+		out, _ := ioutil.ReadFile(f.Name())
+		return string(out)
+	}
+
+	if out := frame("foo"); !strings.HasPrefix(out, beginSyncUpdate) ||
+		!strings.HasSuffix(out, endSyncUpdate) {
+		t.Errorf("repainted frame %q not wrapped in synchronized update", out)
+	}
+	if out := frame("foo"); strings.Contains(out, beginSyncUpdate) {
+		t.Errorf("unchanged frame %q wrapped in synchronized update", out)
+	}
+	hide, show := xtermEscapes{}.hideCursor(), xtermEscapes{}.showCursor()
+	if out := frame("bar"); !strings.HasPrefix(out, beginSyncUpdate+hide) ||
+		!strings.HasSuffix(out, show+endSyncUpdate) {
+		t.Errorf("repainted frame %q doesn't hide cursor", out)
+	}
+}
+
+func TestCommitBufferDumb(t *testing.T) {
+	f, err := ioutil.TempFile("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newWriter(f, newOptions())
+	w.caps = capabilities{dumb: true}
+	frame := func(s string, dot int) string {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		b := w.newBuffer(20)
+		b.writes(s[:dot], "1")
+		b.dot = b.cursor()
+		b.writes(s[dot:], "1")
+		if err := w.commitBuffer(b); err != nil {
+			t.Fatalf("commitBuffer => error %v", err)
+		}
+		out, _ := ioutil.ReadFile(f.Name())
+		return string(out)
+	}
+
+	if out := frame("> foobar", 5); out != "\r> foobar\r> foo" {
+		t.Errorf("dumb frame => %q, want %q", out, "\r> foobar\r> foo")
+	}
+	if out := frame("> fo", 4); out != "\r> fo    \r> fo" {
+		t.Errorf("dumb frame => %q, want %q", out, "\r> fo    \r> fo")
+	}
+}
+
+func TestCommitBufferNoStyle(t *testing.T) {
+	f, err := ioutil.TempFile("", "elvish-test")
+	if err != nil {
+		t.Fatalf("Got error when creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	o := newOptions()
+	frame := func(attr string) string {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		w := newWriter(f, o)
+		w.caps = capabilities{color: true}
+		b := w.newBuffer(20)
+		b.writes("foo", attr)
+		b.newline()
+		b.writes("bar", attr)
+		b.dot = b.cursor()
+		if err := w.commitBuffer(b); err != nil {
+			t.Fatalf("commitBuffer => error %v", err)
+		}
+		out, _ := ioutil.ReadFile(f.Name())
+		return string(out)
+	}
+
+	o.noStyle = true
+	if out, wanted := frame("1;31"), frame(""); out != wanted {
+		t.Errorf("frame with styling off => %q, want %q", out, wanted)
+	}
+	o.noStyle = false
+	if out := frame("1;31"); !strings.Contains(out, "\033[1;31m") {
+		t.Errorf("frame with styling on => %q, no styling", out)
+	}
+}
+
+// runePositions lays out line after a prompt promptWidth wide, the way render
+// does, and returns where each rune of line starts and where the line ends.
+func runePositions(promptWidth, width int, line string) []pos {
+	indent := 0
+	if promptWidth*2 < width {
+		indent = promptWidth
+	}
+	var ps []pos
+	p := pos{0, promptWidth}
+	for _, r := range line {
+		w := WcWidth(r)
+		if p.col+w > width {
+			p = pos{p.line + 1, indent}
+		}
+		ps = append(ps, p)
+		p.col += w
+		if p.col == width {
+			p = pos{p.line + 1, indent}
+		}
+	}
+	return append(ps, p)
+}
+
+var wideDotLines = []string{"好好好好好好", "a好好b好好", "好a好好ab"}
+
+// TestDotWithWideRunes checks where the cursor is put for every dot in lines
+// with wide runes, with the lines starting at every column.
+func TestDotWithWideRunes(t *testing.T) {
+	for width := 4; width <= 9; width++ {
+		for promptWidth := 0; promptWidth < width; promptWidth++ {
+			prompt := strings.Repeat(">", promptWidth)
+			for _, line := range wideDotLines {
+				ps := runePositions(promptWidth, width, line)
+				k := 0
+				for dot := range line + " " {
+					bs := newFixture(prompt, line)
+					bs.dot = dot
+					screen, err := renderToVT(width, 10, false, []*editorState{bs})
+					if err != nil {
+						t.Fatal(err)
+					}
+					wanted := fmt.Sprintf("cursor: %d %d\n", ps[k].line, ps[k].col)
+					if !strings.Contains(screen, wanted) {
+						t.Errorf("width %d, prompt %q, line %q, dot %d: screen is\n%s\nwant %s",
+							width, prompt, line, dot, screen, wanted)
+					}
+					k++
+				}
+			}
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 )
 
 type builtinFuncImpl func(*Evaluator, []Value) string
@@ -26,10 +27,39 @@ var builtinFuncs = map[string]builtinFunc{
 	"printchan": builtinFunc{printchan, [2]StreamType{chanStream, fdStream}},
 	"feedchan":  builtinFunc{feedchan, [2]StreamType{fdStream, chanStream}},
 	"cd":        builtinFunc{cd, [2]StreamType{}},
+	"load-env":  builtinFunc{loadEnv, [2]StreamType{}},
 	"+":         builtinFunc{plus, [2]StreamType{0, chanStream}},
 	"-":         builtinFunc{minus, [2]StreamType{0, chanStream}},
 	"*":         builtinFunc{times, [2]StreamType{0, chanStream}},
 	"/":         builtinFunc{divide, [2]StreamType{0, chanStream}},
+	"now":       builtinFunc{now, [2]StreamType{0, chanStream}},
+	"rand":      builtinFunc{randFloat, [2]StreamType{0, chanStream}},
+	"randint":   builtinFunc{randInt, [2]StreamType{0, chanStream}},
+}
+
+func init() {
+	// Needed to avoid initialization loop
+	builtinFuncs["which"] = builtinFunc{which, [2]StreamType{0, fdStream}}
+}
+
+// AddBuiltinFunc adds a builtin function that takes no input and writes no
+// output. It is used by other packages to make their functionalities callable
+// from elvish code, and should be called during initialization. Like other
+// builtin functions, f returns an error message, or "" on success.
+func AddBuiltinFunc(name string, f func(*Evaluator, []Value) string) {
+	if _, ok := builtinFuncs[name]; ok {
+		panic("builtin function redefined: " + name)
+	}
+	builtinFuncs[name] = builtinFunc{f, [2]StreamType{}}
+}
+
+// AddPrintingBuiltinFunc is like AddBuiltinFunc, but the function writes
+// text output to the file returned by Evaluator.OutFile, like print.
+func AddPrintingBuiltinFunc(name string, f func(*Evaluator, []Value) string) {
+	if _, ok := builtinFuncs[name]; ok {
+		panic("builtin function redefined: " + name)
+	}
+	builtinFuncs[name] = builtinFunc{f, [2]StreamType{0, fdStream}}
 }
 
 func fn(ev *Evaluator, args []Value) string {
@@ -117,6 +147,9 @@ func feedchan(ev *Evaluator, args []Value) string {
 }
 
 func cd(ev *Evaluator, args []Value) string {
+	if ev.restricted {
+		return "restricted: cd is disabled"
+	}
 	var dir string
 	if len(args) == 0 {
 		user, err := user.Current()
@@ -135,6 +168,87 @@ func cd(ev *Evaluator, args []Value) string {
 	return ""
 }
 
+// loadEnv loads dotenv files, as parsed by parseDotenv, into the
+// environment. With -local, assignments not prefixed by export define
+// variables instead. With -raw, no substitution is done. If any file fails to
+// parse, nothing is loaded.
+func loadEnv(ev *Evaluator, args []Value) string {
+	if ev.restricted {
+		return "restricted: load-env is disabled"
+	}
+	local, raw := false, false
+options:
+	for len(args) > 0 {
+		switch args[0].String() {
+		case "-local":
+			local = true
+		case "-raw":
+			raw = true
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "args error"
+	}
+
+	ev.env.fill()
+	lookup := func(name string) string { return ev.env.m[name] }
+	var entries []dotenvEntry
+	for _, a := range args {
+		f, err := os.Open(a.String())
+		if err != nil {
+			return err.Error()
+		}
+		more, err := parseDotenv(a.String(), f, lookup, !raw)
+		f.Close()
+		if err != nil {
+			return err.Error()
+		}
+		entries = append(entries, more...)
+	}
+
+	for _, e := range entries {
+		if local && !e.exported {
+			ev.scope[e.name] = valuePtr(NewString(e.value))
+			continue
+		}
+		ev.env.m[e.name] = e.value
+		if e.name == "PATH" {
+			ev.searchPaths = strings.Split(e.value, ":")
+		}
+	}
+	return ""
+}
+
+// which prints how each argument would be resolved as a command name. It
+// fails if any of them is not found.
+func which(ev *Evaluator, args []Value) string {
+	if len(args) == 0 {
+		return "args error"
+	}
+	msg := ""
+	out := ev.OutFile()
+	for _, a := range args {
+		name := a.String()
+		kind, where, err := ev.ResolveCommand(name)
+		switch {
+		case err != nil:
+			if msg == "" {
+				msg = name + ": " + err.Error()
+			}
+		case kind == CommandDefinedFunction && where != "":
+			fmt.Fprintf(out, "%s: %s defined at %s\n", name, kind, where)
+		case kind == CommandExternal:
+			fmt.Fprintf(out, "%s: %s %s\n", name, kind, where)
+		default:
+			fmt.Fprintf(out, "%s: %s\n", name, kind)
+		}
+	}
+	return msg
+}
+
 func toFloats(args []Value) (nums []float64, err error) {
 	for _, a := range args {
 		a, ok := a.(*String)
@@ -115,6 +115,9 @@ func compileVarSet(cp *Compiler, args *parse.TermListNode, v bool) strOp {
 			checkSetType(cp, args, f, vop)
 		}
 		return func(ev *Evaluator) string {
+			if msg := ev.checkRestrictedVars(f.names); msg != "" {
+				return msg
+			}
 			for i, name := range f.names {
 				ev.scope[name] = valuePtr(f.types[i].Default())
 			}
@@ -149,6 +152,9 @@ func doSet(ev *Evaluator, names []string, values []Value) string {
 	if len(names) != len(values) {
 		return "arity mismatch"
 	}
+	if msg := ev.checkRestrictedVars(names); msg != "" {
+		return msg
+	}
 
 	for i, name := range names {
 		// TODO Prevent overriding builtin variables e.g. $pid $env
@@ -180,6 +186,9 @@ func compileDel(cp *Compiler, fn *parse.FormNode) strOp {
 		f.names = append(f.names, name)
 	}
 	return func(ev *Evaluator) string {
+		if msg := ev.checkRestrictedVars(f.names); msg != "" {
+			return msg
+		}
 		for _, name := range f.names {
 			delete(ev.scope, name)
 		}
@@ -0,0 +1,130 @@
+package eval
+
+// A calculator for infix arithmetic expressions.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// calcOps are the binary operators understood by Calc, with the same
+// semantics as the builtins +, -, * and /.
+var calcOps = map[byte]func(a, b float64) float64{
+	'+': func(a, b float64) float64 { return a + b },
+	'-': func(a, b float64) float64 { return a - b },
+	'*': func(a, b float64) float64 { return a * b },
+	'/': func(a, b float64) float64 { return a / b },
+}
+
+// calcParser is a recursive descent parser for arithmetic expressions that
+// evaluates as it parses.
+type calcParser struct {
+	text string
+	pos  int
+}
+
+func (cp *calcParser) skipSpace() {
+	for cp.pos < len(cp.text) && unicode.IsSpace(rune(cp.text[cp.pos])) {
+		cp.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of text.
+func (cp *calcParser) peek() byte {
+	cp.skipSpace()
+	if cp.pos == len(cp.text) {
+		return 0
+	}
+	return cp.text[cp.pos]
+}
+
+// expr parses terms separated by + and -.
+func (cp *calcParser) expr() (float64, error) {
+	v, err := cp.term()
+	for err == nil && (cp.peek() == '+' || cp.peek() == '-') {
+		op := cp.text[cp.pos]
+		cp.pos++
+		var w float64
+		w, err = cp.term()
+		v = calcOps[op](v, w)
+	}
+	return v, err
+}
+
+// term parses factors separated by * and /.
+func (cp *calcParser) term() (float64, error) {
+	v, err := cp.factor()
+	for err == nil && (cp.peek() == '*' || cp.peek() == '/') {
+		op := cp.text[cp.pos]
+		cp.pos++
+		var w float64
+		w, err = cp.factor()
+		v = calcOps[op](v, w)
+	}
+	return v, err
+}
+
+// factor parses a number, a parenthesized expression, or a factor preceded by
+// a unary + or -.
+func (cp *calcParser) factor() (float64, error) {
+	switch c := cp.peek(); {
+	case c == '+' || c == '-':
+		cp.pos++
+		v, err := cp.factor()
+		if c == '-' {
+			v = -v
+		}
+		return v, err
+	case c == '(':
+		cp.pos++
+		v, err := cp.expr()
+		if err != nil {
+			return 0, err
+		}
+		if cp.peek() != ')' {
+			return 0, cp.errorf("expect )")
+		}
+		cp.pos++
+		return v, nil
+	case c == '.' || '0' <= c && c <= '9':
+		start := cp.pos
+		for cp.pos < len(cp.text) && strings.IndexByte("0123456789.eE", cp.text[cp.pos]) >= 0 {
+			// Allow signs in exponents
+			if c := cp.text[cp.pos]; (c == 'e' || c == 'E') && cp.pos+1 < len(cp.text) &&
+				(cp.text[cp.pos+1] == '+' || cp.text[cp.pos+1] == '-') {
+				cp.pos++
+			}
+			cp.pos++
+		}
+		v, err := strconv.ParseFloat(cp.text[start:cp.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad number %s", cp.text[start:cp.pos])
+		}
+		return v, nil
+	case c == 0:
+		return 0, cp.errorf("unexpected end of expression")
+	default:
+		return 0, cp.errorf("unexpected %q", c)
+	}
+}
+
+func (cp *calcParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%d: %s", cp.pos, fmt.Sprintf(format, args...))
+}
+
+// Calc evaluates an arithmetic expression of numbers, the binary operators +,
+// -, * and /, unary + and -, and parentheses, with the usual precedence. The
+// result is formatted like the outputs of the arithmetic builtins.
+func Calc(text string) (string, error) {
+	cp := &calcParser{text: text}
+	v, err := cp.expr()
+	if err != nil {
+		return "", err
+	}
+	if cp.peek() != 0 {
+		return "", cp.errorf("unexpected %q", cp.text[cp.pos])
+	}
+	return fmt.Sprintf("%g", v), nil
+}
@@ -0,0 +1,31 @@
+package eval
+
+import "testing"
+
+var calcTests = []struct {
+	text, out string
+}{
+	{"1", "1"},
+	{" 1 + 2 * 3 ", "7"},
+	{"(1 + 2) * 3", "9"},
+	{"10 - 2 - 3", "5"},
+	{"8 / 2 / 2", "2"},
+	{"-(2 + 3) * -2", "10"},
+	{"1.5e+2 + .5", "150.5"},
+	{"1 / 0", "+Inf"},
+}
+
+var badCalcTests = []string{"", "1 +", "(1", "1 2", "a", "1..2"}
+
+func TestCalc(t *testing.T) {
+	for _, tt := range calcTests {
+		if out, err := Calc(tt.text); out != tt.out || err != nil {
+			t.Errorf("Calc(%q) => (%q, %v), want (%q, nil)", tt.text, out, err, tt.out)
+		}
+	}
+	for _, text := range badCalcTests {
+		if out, err := Calc(text); err == nil {
+			t.Errorf("Calc(%q) => %q, want error", text, out)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 // Compiler compiles an Elvish AST into an Op.
 type Compiler struct {
+	// Where forms are counted when they are run, if not nil.
+	coverage *Coverage
 	compilerEphemeral
 }
 
@@ -95,7 +97,9 @@ func (cp *Compiler) compileClosure(cn *parse.ClosureNode) (valuesOp, map[string]
 	cp.enclosed = make(map[string]Type)
 	cp.popScope()
 
-	return combineClosure(ops, enclosed, bounds), enclosed, bounds
+	lineno, colno, _ := util.FindContext(cp.text, int(cn.Position()))
+	location := fmt.Sprintf("%s:%d:%d", cp.name, lineno+1, colno+1)
+	return combineClosure(ops, enclosed, bounds, location), enclosed, bounds
 }
 
 func (cp *Compiler) compilePipeline(pn *parse.PipelineNode) (valuesOp, [2]StreamType) {
@@ -218,7 +222,17 @@ func (cp *Compiler) compileForm(fn *parse.FormNode) (stateUpdatesOp, [2]StreamTy
 	} else {
 		tlist = cp.compileTermList(fn.Args)
 	}
-	return combineForm(fn, cmdOp, tlist, ports, annotation), annotation.streamTypes
+	op := combineForm(fn, cmdOp, tlist, ports, annotation)
+	if c := cp.coverage; c != nil {
+		name, pos := cp.name, int(fn.Pos)
+		c.add(name, cp.text, pos)
+		inner := op
+		op = func(ev *Evaluator) <-chan *StateUpdate {
+			c.hit(name, pos)
+			return inner(ev)
+		}
+	}
+	return op, annotation.streamTypes
 }
 
 func (cp *Compiler) compileRedir(r parse.Redir) portOp {
@@ -238,7 +252,11 @@ func (cp *Compiler) compileRedir(r parse.Redir) portOp {
 		}
 	case *parse.FilenameRedir:
 		fnameOp := cp.compileTerm(r.Filename)
+		writes := r.Flag&(os.O_WRONLY|os.O_RDWR) != 0
 		return func(ev *Evaluator) *port {
+			if writes && ev.restricted {
+				ev.errorfNode(r, "restricted: cannot redirect output to file")
+			}
 			fname := string(*ev.asSingleString(
 				r.Filename, fnameOp.f(ev), "filename"))
 			// TODO haz hardcoded permbits now
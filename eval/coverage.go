@@ -0,0 +1,167 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Coverage counts how many times each form of the code compiled by an
+// Evaluator is run. It is turned on with Evaluator.SetCoverage, and reports
+// which lines of each source ran, as text or HTML.
+type Coverage struct {
+	mutex   sync.Mutex
+	sources map[string]*coveredSource
+}
+
+// coveredSource is the counts of the forms of a source, by position.
+type coveredSource struct {
+	text   string
+	counts map[int]int
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{sources: make(map[string]*coveredSource)}
+}
+
+// SetCoverage turns on coverage counting for code compiled by ev from now on,
+// recording the counts in c. A nil c turns it off.
+func (ev *Evaluator) SetCoverage(c *Coverage) {
+	ev.Compiler.coverage = c
+}
+
+// add records a form at pos of the source, not run yet.
+func (c *Coverage) add(name, text string, pos int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	src := c.sources[name]
+	if src == nil || src.text != text {
+		src = &coveredSource{text, make(map[int]int)}
+		c.sources[name] = src
+	}
+	if _, ok := src.counts[pos]; !ok {
+		src.counts[pos] = 0
+	}
+}
+
+// hit counts a run of the form at pos of the source.
+func (c *Coverage) hit(name string, pos int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sources[name].counts[pos]++
+}
+
+// Names returns the names of the sources covered, sorted.
+func (c *Coverage) Names() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var names []string
+	for name := range c.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lineCounts returns the lines of a source, and how many times the most run
+// form starting on each line is run, or -1 for lines without forms.
+func (c *Coverage) lineCounts(name string) ([]string, []int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	src := c.sources[name]
+	if src == nil {
+		return nil, nil
+	}
+	lines := strings.Split(src.text, "\n")
+	counts := make([]int, len(lines))
+	for i := range counts {
+		counts[i] = -1
+	}
+	for pos, n := range src.counts {
+		i := strings.Count(src.text[:pos], "\n")
+		if n > counts[i] {
+			counts[i] = n
+		}
+	}
+	return lines, counts
+}
+
+// Percent returns the percentage of forms of a source that have run.
+func (c *Coverage) Percent(name string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	src := c.sources[name]
+	if src == nil || len(src.counts) == 0 {
+		return 100
+	}
+	run := 0
+	for _, n := range src.counts {
+		if n > 0 {
+			run++
+		}
+	}
+	return 100 * float64(run) / float64(len(src.counts))
+}
+
+// WriteText writes a report of all sources to w. Each line is prefixed with
+// the number of runs, ##### for lines that have not run, or nothing for lines
+// without forms.
+func (c *Coverage) WriteText(w io.Writer) error {
+	for _, name := range c.Names() {
+		if _, err := fmt.Fprintf(w, "%s: %.1f%% of forms run\n", name, c.Percent(name)); err != nil {
+			return err
+		}
+		lines, counts := c.lineCounts(name)
+		for i, line := range lines {
+			prefix := ""
+			switch {
+			case counts[i] == 0:
+				prefix = "#####"
+			case counts[i] > 0:
+				prefix = fmt.Sprint(counts[i])
+			}
+			if _, err := fmt.Fprintf(w, "%5s: %s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteHTML writes a report of all sources to w as an HTML page, with lines
+// that have run in green and lines that have not in red.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	var b bytes.Buffer
+	b.WriteString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Coverage</title><style>
+pre { margin: 0 }
+.run { background: #dfd }
+.not-run { background: #fdd }
+.count { color: #888; display: inline-block; width: 5em; text-align: right; margin-right: 1em }
+</style></head><body>
+`)
+	for _, name := range c.Names() {
+		fmt.Fprintf(&b, "<h2>%s: %.1f%% of forms run</h2>\n",
+			html.EscapeString(name), c.Percent(name))
+		lines, counts := c.lineCounts(name)
+		for i, line := range lines {
+			class, count := "", ""
+			switch {
+			case counts[i] == 0:
+				class, count = "not-run", "0"
+			case counts[i] > 0:
+				class, count = "run", fmt.Sprint(counts[i])
+			}
+			fmt.Fprintf(&b, "<pre class=\"%s\"><span class=\"count\">%s</span>%s</pre>\n",
+				class, count, html.EscapeString(line))
+		}
+	}
+	b.WriteString("</body></html>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
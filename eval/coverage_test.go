@@ -0,0 +1,36 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	src := "put a\n# comment\nput {\n\tput b }"
+	ev := NewEvaluator()
+	ev.SetStatusCallback(nil)
+	c := NewCoverage()
+	ev.SetCoverage(c)
+	if _, err := ev.EvalSource("<test>", src); err != nil {
+		t.Fatalf("EvalSource(%q) => error %v", src, err)
+	}
+
+	var b bytes.Buffer
+	c.WriteText(&b)
+	wanted := `<test>: 66.7% of forms run
+    1: put a
+     : # comment
+    1: put {
+#####: 	put b }
+`
+	if b.String() != wanted {
+		t.Errorf("WriteText => %q, want %q", b.String(), wanted)
+	}
+
+	b.Reset()
+	c.WriteHTML(&b)
+	if !strings.Contains(b.String(), `<pre class="run"><span class="count">1</span>put a</pre>`) {
+		t.Errorf("WriteHTML => %q, missing a line that has run", b.String())
+	}
+}
@@ -0,0 +1,141 @@
+package eval
+
+// Parsing of dotenv files.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// dotenvEntry is an assignment in a dotenv file.
+type dotenvEntry struct {
+	name, value string
+	// Whether the assignment is prefixed by "export".
+	exported bool
+}
+
+// parseDotenv parses a dotenv file. Each line is empty, a comment starting
+// with #, or an assignment NAME=value, optionally
+// with spaces around the =, and optionally prefixed by "export". A
+// value is either:
+//
+// Unquoted: leading and trailing spaces are trimmed, and a # preceded by a
+// space starts a comment.
+//
+// Single-quoted: taken literally.
+//
+// Double-quoted: \n, \t, \\, \", \$ are escape sequences.
+//
+// If subst is true, $NAME and ${NAME} in unquoted and double-quoted values are
+// substituted with the values of earlier assignments, or lookup(NAME) if
+// there is none. Unknown names are substituted with empty strings.
+func parseDotenv(name string, r io.Reader, lookup func(string) string, subst bool) ([]dotenvEntry, error) {
+	var entries []dotenvEntry
+	values := make(map[string]string)
+	get := func(name string) string {
+		if v, ok := values[name]; ok {
+			return v
+		}
+		return lookup(name)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var e dotenvEntry
+		if strings.HasPrefix(line, "export ") {
+			e.exported = true
+			line = strings.TrimSpace(line[len("export "):])
+		}
+		i := strings.IndexRune(line, '=')
+		if i < 0 || !isDotenvName(strings.TrimSpace(line[:i])) {
+			return nil, fmt.Errorf("%s:%d: bad assignment", name, lineno)
+		}
+		e.name = strings.TrimSpace(line[:i])
+		value, err := parseDotenvValue(strings.TrimSpace(line[i+1:]), get, subst)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, lineno, err)
+		}
+		e.value = value
+		values[e.name] = value
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func isDotenvName(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' ||
+			i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func parseDotenvValue(s string, get func(string) string, subst bool) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexRune(s[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		if rest := strings.TrimSpace(s[end+2:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("garbage after quoted value")
+		}
+		return s[1 : end+1], nil
+	case strings.HasPrefix(s, "\""):
+		var buf []rune
+		rs := []rune(s[1:])
+		for i := 0; i < len(rs); i++ {
+			switch rs[i] {
+			case '\\':
+				i++
+				if i == len(rs) {
+					return "", fmt.Errorf("unterminated double-quoted value")
+				}
+				switch rs[i] {
+				case 'n':
+					buf = append(buf, '\n')
+				case 't':
+					buf = append(buf, '\t')
+				case '\\', '"', '$':
+					// An escaped $ is kept as a NUL during substitution
+					if rs[i] == '$' && subst {
+						buf = append(buf, 0)
+					} else {
+						buf = append(buf, rs[i])
+					}
+				default:
+					return "", fmt.Errorf("bad escape sequence \\%c", rs[i])
+				}
+			case '"':
+				if rest := strings.TrimSpace(string(rs[i+1:])); rest != "" && rest[0] != '#' {
+					return "", fmt.Errorf("garbage after quoted value")
+				}
+				v := string(buf)
+				if subst {
+					v = os.Expand(v, get)
+				}
+				return strings.Replace(v, "\x00", "$", -1), nil
+			default:
+				buf = append(buf, rs[i])
+			}
+		}
+		return "", fmt.Errorf("unterminated double-quoted value")
+	default:
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		if subst {
+			s = os.Expand(s, get)
+		}
+		return s, nil
+	}
+}
@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseDotenvTests = []struct {
+	text   string
+	subst  bool
+	wanted []dotenvEntry
+}{
+	{"# comment\n\nA=1\n", true, []dotenvEntry{{"A", "1", false}}},
+	{"export A = foo bar # comment", true, []dotenvEntry{{"A", "foo bar", true}}},
+	{"A='$HOME # not comment'", true, []dotenvEntry{{"A", "$HOME # not comment", false}}},
+	{`A="a\tb\n\"\$HOME\""`, true, []dotenvEntry{{"A", "a\tb\n\"$HOME\"", false}}},
+	{"A=x\nB=${A}y\nC=\"$B $HOME\"", true, []dotenvEntry{
+		{"A", "x", false}, {"B", "xy", false}, {"C", "xy /home", false}}},
+	{"A=x\nB=$A$HOME", false, []dotenvEntry{{"A", "x", false}, {"B", "$A$HOME", false}}},
+	{"A=$UNKNOWN", true, []dotenvEntry{{"A", "", false}}},
+}
+
+var badDotenvTests = []string{
+	"A",
+	"=1",
+	"1A=1",
+	"A='unterminated",
+	`A="unterminated`,
+	`A="bad \q"`,
+	"A='x' y",
+}
+
+func testLookup(name string) string {
+	if name == "HOME" {
+		return "/home"
+	}
+	return ""
+}
+
+func TestParseDotenv(t *testing.T) {
+	for _, tt := range parseDotenvTests {
+		out, err := parseDotenv("<test>", strings.NewReader(tt.text), testLookup, tt.subst)
+		if err != nil || !reflect.DeepEqual(out, tt.wanted) {
+			t.Errorf("parseDotenv(%q) => (%v, %v), want (%v, nil)", tt.text, out, err, tt.wanted)
+		}
+	}
+	for _, text := range badDotenvTests {
+		if _, err := parseDotenv("<test>", strings.NewReader(text), testLookup, true); err == nil {
+			t.Errorf("parseDotenv(%q) => no error, want error", text)
+		}
+	}
+}
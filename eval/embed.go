@@ -0,0 +1,75 @@
+package eval
+
+import (
+	"os"
+
+	"github.com/xiaq/elvish/parse"
+)
+
+// Go programs can embed elvish as a scripting or configuration language with
+// an Evaluator alone, without the line editor or the daemon:
+//
+//	ev := eval.NewEvaluator()
+//	ev.SetPorts(nil, os.Stdout, os.Stderr)
+//	ev.SetStatusCallback(nil)
+//	values, err := ev.EvalSource("config.elv", src)
+//
+// Values output by the code, like with put, are returned by EvalSource, while
+// bytes output, like with print or by external commands, go to the output
+// port.
+
+// SetPorts replaces the standard input, output and error ports used by code
+// evaluated by ev. A nil file makes the port closed.
+func (ev *Evaluator) SetPorts(in, out, err *os.File) {
+	ev.ports = []*port{&port{f: in}, &port{f: out}, &port{f: err}}
+}
+
+// SetStatusCallback sets the function called with the exit values of each
+// pipeline evaluated at the top level. By default, exit values indicating
+// failures are printed to the standard output of the process. A nil cb turns
+// this off; the status is still available from Status.
+func (ev *Evaluator) SetStatusCallback(cb func([]Value)) {
+	ev.statusCb = cb
+}
+
+// EvalSource parses, compiles and evaluates src, and returns the values it
+// outputs. The name of src is used for diagnostic messages. Like Eval, it
+// sets the status, which callers should check for failures that are not
+// errors, like external commands exiting with non-zero codes.
+func (ev *Evaluator) EvalSource(name, src string) ([]Value, error) {
+	n, err := parse.Parse(name, src)
+	if err != nil {
+		ev.SetStatus(ExitException)
+		return nil, err
+	}
+
+	// Capture values output to the output port, keeping its file for bytes
+	out := ev.ports[1]
+	ch := make(chan Value)
+	done := make(chan []Value)
+	go func() {
+		var vs []Value
+		for v := range ch {
+			vs = append(vs, v)
+		}
+		done <- vs
+	}()
+	ev.ports[1] = &port{f: out.f, ch: ch}
+	err = ev.Eval(name, src, n)
+	ev.ports[1] = out
+	close(ch)
+	return <-done, err
+}
+
+// EvalSourceApart is like EvalSource, but evaluates src with a copy of ev
+// that has its own status, which it returns: the status of ev, $status and
+// $pipestatus are left alone, and the status callback is not called. It is
+// for code run on behalf of the user rather than by them, like hooks.
+func (ev *Evaluator) EvalSourceApart(name, src string) ([]Value, int, error) {
+	newEv := ev.copy(name, false)
+	newEv.statusCb = nil
+	// Like the forms of pipelines, it only records its status
+	newEv.concurrent = true
+	values, err := newEv.EvalSource(name, src)
+	return values, newEv.status, err
+}
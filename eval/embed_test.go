@@ -0,0 +1,64 @@
+package eval
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var evalSourceTests = []struct {
+	src    string
+	values []string
+	out    string
+	err    bool
+}{
+	{"put a b; put c", []string{"a", "b", "c"}, "", false},
+	{"print a; put b", []string{"b"}, "a", false},
+	{"put (put x)", []string{"x"}, "", false},
+	{"put $nosuch", nil, "", true},
+	{"put (", nil, "", true},
+}
+
+func TestEvalSource(t *testing.T) {
+	for _, tt := range evalSourceTests {
+		f, err := ioutil.TempFile("", "elvish-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		ev := NewEvaluator()
+		ev.SetPorts(nil, f, f)
+		ev.SetStatusCallback(nil)
+		values, err := ev.EvalSource("<test>", tt.src)
+		var strs []string
+		for _, v := range values {
+			strs = append(strs, v.String())
+		}
+		out, _ := ioutil.ReadFile(f.Name())
+		if !reflect.DeepEqual(strs, tt.values) || string(out) != tt.out || (err != nil) != tt.err {
+			t.Errorf("EvalSource(%q) => (%v, %v), output %q, want (%v, error %v), output %q",
+				tt.src, strs, err, out, tt.values, tt.err, tt.out)
+		}
+	}
+}
+
+func TestEvalSourceApart(t *testing.T) {
+	ev := NewEvaluator()
+	ev.SetPorts(nil, nil, nil)
+	called := false
+	ev.SetStatusCallback(func([]Value) { called = true })
+	values, status, err := ev.EvalSourceApart("<test>", `put a; cd /nonexistent-dir`)
+	if len(values) != 1 || status != ExitFailure || err != nil {
+		t.Errorf("EvalSourceApart => (%v, %v, %v), want ([a], %v, <nil>)", values, status, err, ExitFailure)
+	}
+	if ev.Status() != ExitOK || (*ev.scope["status"]).String() != strconv.Itoa(ExitOK) {
+		t.Errorf("EvalSourceApart changed the status of the evaluator to %v", ev.Status())
+	}
+	if called {
+		t.Errorf("EvalSourceApart called the status callback")
+	}
+}
@@ -0,0 +1,112 @@
+package eval
+
+// Environment profiles, named sets of environment variables that commands can
+// be run with.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func init() {
+	builtinFuncs["env-profile"] = builtinFunc{envProfile, [2]StreamType{0, fdStream}}
+	builtinFuncs["with-env"] = builtinFunc{withEnv, [2]StreamType{fdStream, fdStream}}
+}
+
+// envProfiles maps names of environment profiles to their variables. It is
+// shared by an Evaluator and its copies.
+type envProfiles map[string]map[string]string
+
+// EnvProfileNames returns the names of the environment profiles defined with
+// env-profile, sorted.
+func (ev *Evaluator) EnvProfileNames() []string {
+	names := make([]string, 0, len(ev.envProfiles))
+	for name := range ev.envProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envProfile implements the env-profile builtin. With a name and assignments
+// of the form NAME=value, it defines an environment profile, replacing any
+// profile with the same name. With no arguments, it prints all profiles.
+func envProfile(ev *Evaluator, args []Value) string {
+	if len(args) == 0 {
+		out := ev.OutFile()
+		for _, name := range ev.EnvProfileNames() {
+			fmt.Fprintf(out, "%s:\n", name)
+			vars := ev.envProfiles[name]
+			for _, k := range sortedKeys(vars) {
+				fmt.Fprintf(out, "  %s=%s\n", k, quote(vars[k]))
+			}
+		}
+		return ""
+	}
+	if ev.restricted {
+		return "restricted: env-profile may not define profiles"
+	}
+	vars := make(map[string]string)
+	for _, a := range args[1:] {
+		s := a.String()
+		i := strings.IndexRune(s, '=')
+		if i < 0 || !isDotenvName(s[:i]) {
+			return "bad assignment " + quote(s)
+		}
+		vars[s[:i]] = s[i+1:]
+	}
+	ev.envProfiles[args[0].String()] = vars
+	return ""
+}
+
+// withEnv implements the with-env builtin, which takes the name of an
+// environment profile and an external command with arguments, and runs the
+// command with the variables of the profile added to the environment. If the
+// profile sets PATH, the command is also searched for in it.
+func withEnv(ev *Evaluator, args []Value) string {
+	if len(args) < 2 {
+		return "args error"
+	}
+	vars, ok := ev.envProfiles[args[0].String()]
+	if !ok {
+		return "no environment profile named " + args[0].String()
+	}
+
+	// The ports are closed by execBuiltinFunc after we return
+	newEv := ev.copy(fmt.Sprintf("<with-env %s>", args[0]), false)
+	ev.env.fill()
+	newEv.env = &Env{make(map[string]string, len(ev.env.m)+len(vars))}
+	for k, v := range ev.env.m {
+		newEv.env.m[k] = v
+	}
+	for k, v := range vars {
+		newEv.env.m[k] = v
+	}
+	if path, ok := vars["PATH"]; ok {
+		newEv.searchPaths = strings.Split(path, ":")
+	}
+
+	name := args[1].String()
+	path, err := newEv.search(name)
+	if err != nil {
+		return err.Error()
+	}
+	fm := &form{name: name, args: args[2:], Command: Command{Path: path}}
+	msg := ""
+	for update := range newEv.execExternal(fm) {
+		if update.Terminated {
+			msg = update.Msg
+		}
+	}
+	return msg
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"syscall"
 
 	"github.com/xiaq/elvish/parse"
 	"github.com/xiaq/elvish/util"
@@ -24,6 +23,17 @@ type Evaluator struct {
 	searchPaths []string
 	ports       []*port
 	statusCb    func([]Value)
+	status      int   // Exit code of the last pipeline.
+	pipeStatus  []int // Exit codes of the forms of the last pipeline.
+	// Whether the Evaluator runs alongside others sharing the same variables,
+	// like those of the forms of a pipeline, in which case its status is not
+	// kept in $status and $pipestatus.
+	concurrent  bool
+	restricted  bool
+	execFilter  ExecFilter
+	envProfiles envProfiles
+	inputs      Inputs
+	editor      interface{}  // The line editor, see SetEditor.
 	nodes       []parse.Node // A stack that keeps track of nodes being evaluated.
 }
 
@@ -40,18 +50,24 @@ func statusOk(vs []Value) bool {
 	return true
 }
 
-// NewEvaluator creates a new Evaluator from a slice of environment strings
-// in the form "key=value".
+// NewEvaluator creates a new Evaluator with the inputs of the process.
 func NewEvaluator() *Evaluator {
+	return NewEvaluatorWithInputs(DefaultInputs())
+}
+
+// NewEvaluatorWithInputs creates a new Evaluator with the given inputs.
+func NewEvaluatorWithInputs(in Inputs) *Evaluator {
 	env := NewEnv()
-	env.fill()
-	pid := NewString(strconv.Itoa(syscall.Getpid()))
+	env.fillFrom(in.Environ)
+	pid := NewString(strconv.Itoa(in.Pid))
 	g := map[string]*Value{
 		"env": valuePtr(env), "pid": valuePtr(pid),
+		"status":     valuePtr(NewString(strconv.Itoa(ExitOK))),
+		"pipestatus": valuePtr(newStatusTable([]int{ExitOK})),
 	}
 	ev := &Evaluator{
 		Compiler: &Compiler{},
-		scope:    g, env: env,
+		scope:    g, env: env, envProfiles: make(envProfiles), inputs: in,
 		ports: []*port{
 			&port{f: os.Stdin}, &port{f: os.Stdout}, &port{f: os.Stderr}},
 		statusCb: func(vs []Value) {
@@ -85,19 +101,110 @@ func (ev *Evaluator) copy(name string, moveShouldClose bool) *Evaluator {
 	newEv.name = name
 	newEv.ports = make([]*port, len(ev.ports))
 	for i, p := range ev.ports {
+		if p == nil {
+			continue
+		}
 		newEv.ports[i] = &port{}
 		*newEv.ports[i] = *p
+		if moveShouldClose {
+			p.shouldClose = false
+		} else {
+			newEv.ports[i].shouldClose = false
+		}
+	}
+	return newEv
+}
+
+// SetRestricted puts the Evaluator into restricted mode, for use as a
+// constrained shell. In restricted mode, cd is disabled, command names may
+// not contain slashes, output may not be redirected to files and variables in
+// restrictedVars may not be modified. Restricted mode cannot be turned off.
+func (ev *Evaluator) SetRestricted() {
+	ev.restricted = true
+}
+
+// Restricted returns whether the Evaluator is in restricted mode.
+func (ev *Evaluator) Restricted() bool {
+	return ev.restricted
+}
+
+// SetEditor records the line editor using the Evaluator, for builtins of the
+// editor to find the one they are called from. Evaluators copied from it, like
+// those of closures and pipelines, share it.
+func (ev *Evaluator) SetEditor(ed interface{}) {
+	ev.editor = ed
+}
+
+// Editor returns the line editor recorded with SetEditor, or nil.
+func (ev *Evaluator) Editor() interface{} {
+	return ev.editor
+}
+
+// restrictedVars contains variables that may not be modified in restricted
+// mode. $env is included since it contains $PATH.
+var restrictedVars = map[string]bool{
+	"env": true,
+}
+
+// checkRestrictedVars returns an error message if any of names may not be
+// modified because the Evaluator is in restricted mode, or "" otherwise.
+func (ev *Evaluator) checkRestrictedVars(names []string) string {
+	if !ev.restricted {
+		return ""
 	}
-	if moveShouldClose {
-		for _, port := range ev.ports {
-			port.shouldClose = false
+	for _, name := range names {
+		if restrictedVars[name] {
+			return "restricted: cannot modify $" + name
 		}
-	} else {
-		for _, port := range newEv.ports {
-			port.shouldClose = false
+	}
+	return ""
+}
+
+// Status returns the exit code of the last pipeline evaluated, as defined by
+// pipelineStatus. It is used as the exit code of elvish when running a script.
+func (ev *Evaluator) Status() int {
+	return ev.status
+}
+
+// SetStatus records the exit code of a pipeline, also exposing it as $status
+// if the variable is visible in the current scope. Eval sets it automatically;
+// callers only need it for failures before Eval, like parse errors.
+func (ev *Evaluator) SetStatus(code int) {
+	ev.setStatus(code, []int{code})
+}
+
+// PipeStatus returns the exit codes of the forms of the last pipeline
+// evaluated, also available as $pipestatus.
+func (ev *Evaluator) PipeStatus() []int {
+	return ev.pipeStatus
+}
+
+// Variables returns the variables of the global scope. Functions, which are
+// variables named fn-<name>, are not included.
+func (ev *Evaluator) Variables() map[string]Value {
+	vars := make(map[string]Value)
+	for name, p := range ev.scope {
+		if !strings.HasPrefix(name, "fn-") {
+			vars[name] = *p
 		}
 	}
-	return newEv
+	return vars
+}
+
+// Environ returns the environment variables.
+func (ev *Evaluator) Environ() map[string]string {
+	ev.env.fill()
+	environ := make(map[string]string, len(ev.env.m))
+	for k, v := range ev.env.m {
+		environ[k] = v
+	}
+	return environ
+}
+
+// OutFile returns the file of the output port. It is meant to be used by
+// functions added with AddPrintingBuiltinFunc.
+func (ev *Evaluator) OutFile() *os.File {
+	return ev.ports[1].f
 }
 
 func (ev *Evaluator) port(i int) *port {
@@ -125,13 +232,29 @@ func (ev *Evaluator) MakeCompilerScope() map[string]Type {
 }
 
 // Eval evaluates a chunk node n. The name and text of it is used for
-// diagnostic messages.
+// diagnostic messages. If compilation or evaluation fails, the status is set
+// to ExitException.
 func (ev *Evaluator) Eval(name, text string, n *parse.ChunkNode) error {
 	op, err := ev.Compiler.Compile(name, text, n, ev.MakeCompilerScope())
+	if err == nil {
+		err = ev.eval(name, text, op)
+	}
+	if err != nil {
+		ev.SetStatus(ExitException)
+	}
+	return err
+}
+
+// Check parses and compiles text without evaluating it, and returns the first
+// error found. Errors found by the parser and the compiler are
+// *util.ContextualError's.
+func (ev *Evaluator) Check(name, text string) error {
+	n, err := parse.Parse(name, text)
 	if err != nil {
 		return err
 	}
-	return ev.eval(name, text, op)
+	_, err = NewCompiler().Compile(name, text, n, ev.MakeCompilerScope())
+	return err
 }
 
 func (ev *Evaluator) eval(name, text string, op Op) (err error) {
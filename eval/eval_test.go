@@ -1,9 +1,17 @@
 package eval
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
 	"strconv"
+	"strings"
 	"syscall"
 	"testing"
+
+	"github.com/xiaq/elvish/parse"
+	"github.com/xiaq/elvish/util"
 )
 
 func strsEqual(s1 []string, s2 []string) bool {
@@ -25,3 +33,340 @@ func TestNewEvaluator(t *testing.T) {
 		t.Errorf(`ev.scope["pid"] = %v, want %v`, ev.scope["pid"], pid)
 	}
 }
+
+func TestVariables(t *testing.T) {
+	ev := NewEvaluatorWithInputs(DeterministicInputs(0))
+	ev.scope["fn-f"] = valuePtr(NewString(""))
+	vars := ev.Variables()
+	for _, name := range []string{"env", "pid", "status", "pipestatus"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("Variables() has no %s", name)
+		}
+	}
+	if _, ok := vars["fn-f"]; ok {
+		t.Errorf("Variables() has fn-f")
+	}
+	if environ := ev.Environ(); !reflect.DeepEqual(environ, map[string]string{"PATH": DeterministicPath}) {
+		t.Errorf("Environ() => %v, want only PATH", environ)
+	}
+}
+
+var statusTests = []struct {
+	text   string
+	status int
+}{
+	{"cd /", ExitOK},
+	{"cd /nonexistent-dir", ExitFailure},
+	{`sh -c "exit 3"`, 3},
+	{`sh -c "kill -9 $$"`, ExitSignalBase + 9},
+	{`sh -c "exit 3" | sh -c "exit 0"`, 3},
+	{`sh -c "exit 3" | sh -c "exit 4"`, 4},
+	{`sh -c "exit 3"; cd /`, ExitOK},
+	{`{ sh -c "exit 5" }`, 5},
+	{"yes | head -n 1", ExitOK},
+	{`sh -c "kill -PIPE $$" | sh -c "exit 0"`, ExitOK},
+	{`sh -c "exit 0" | sh -c "kill -PIPE $$"`, exitBrokenPipe},
+	{"echo a > /dev/null | cat", ExitOK},
+}
+
+func TestStatus(t *testing.T) {
+	for _, tt := range statusTests {
+		ev := NewEvaluator()
+		ev.statusCb = nil
+		n, err := parse.Parse("<test>", tt.text)
+		if err != nil {
+			t.Fatalf("parse.Parse(%q) => error %v", tt.text, err)
+		}
+		if err := ev.Eval("<test>", tt.text, n); err != nil {
+			t.Errorf("Eval(%q) => error %v", tt.text, err)
+			continue
+		}
+		if ev.Status() != tt.status {
+			t.Errorf("Eval(%q); Status() => %v, want %v", tt.text, ev.Status(), tt.status)
+		}
+		wanted := strconv.Itoa(tt.status)
+		if s := (*ev.scope["status"]).String(); s != wanted {
+			t.Errorf("Eval(%q); $status = %v, want %v", tt.text, s, wanted)
+		}
+	}
+}
+
+var pipeStatusTests = []struct {
+	text       string
+	pipeStatus []int
+}{
+	{"cd /", []int{ExitOK}},
+	{`sh -c "exit 3" | sh -c "exit 0"`, []int{3, ExitOK}},
+	{`sh -c "exit 0" | sh -c "exit 4" | sh -c "exit 5"`, []int{ExitOK, 4, 5}},
+	{"yes | head -n 1 > /dev/null", []int{exitBrokenPipe, ExitOK}},
+	{"echo (put a) | echo (put b) > /dev/null", []int{ExitOK, ExitOK}},
+}
+
+func TestPipeStatus(t *testing.T) {
+	for _, tt := range pipeStatusTests {
+		ev := NewEvaluator()
+		ev.statusCb = nil
+		n, err := parse.Parse("<test>", tt.text)
+		if err != nil {
+			t.Fatalf("parse.Parse(%q) => error %v", tt.text, err)
+		}
+		if err := ev.Eval("<test>", tt.text, n); err != nil {
+			t.Errorf("Eval(%q) => error %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(ev.PipeStatus(), tt.pipeStatus) {
+			t.Errorf("Eval(%q); PipeStatus() => %v, want %v", tt.text, ev.PipeStatus(), tt.pipeStatus)
+		}
+		if s, wanted := (*ev.scope["pipestatus"]).Repr(), newStatusTable(tt.pipeStatus).Repr(); s != wanted {
+			t.Errorf("Eval(%q); $pipestatus = %v, want %v", tt.text, s, wanted)
+		}
+	}
+}
+
+var pipelineErrorTests = []struct {
+	text string
+	err  string
+}{
+	{"no-such-command | cat", "not found"},
+	{"yes | no-such-command", "not found"},
+	{"put a b | printchan > /nonexistent-dir/a", "failed to open file"},
+}
+
+func TestPipelineError(t *testing.T) {
+	ev := NewEvaluator()
+	ev.statusCb = nil
+	// The error of a form that cannot be started doesn't leave the other
+	// forms waiting for it
+	for _, tt := range pipelineErrorTests {
+		n, err := parse.Parse("<test>", tt.text)
+		if err != nil {
+			t.Fatalf("parse.Parse(%q) => error %v", tt.text, err)
+		}
+		err = ev.Eval("<test>", tt.text, n)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Eval(%q) => error %v, want error containing %q", tt.text, err, tt.err)
+		}
+	}
+}
+
+func TestStatusOfException(t *testing.T) {
+	ev := NewEvaluator()
+	ev.statusCb = nil
+	for _, text := range []string{`sh -c "exit 3"`, "put $nosuchvar"} {
+		n, err := parse.Parse("<test>", text)
+		if err != nil {
+			t.Fatalf("parse.Parse(%q) => error %v", text, err)
+		}
+		ev.Eval("<test>", text, n)
+	}
+	if ev.Status() != ExitException {
+		t.Errorf("Status() after exception => %v, want %v", ev.Status(), ExitException)
+	}
+	if s := (*ev.scope["status"]).String(); s != strconv.Itoa(ExitException) {
+		t.Errorf("$status after exception = %v, want %v", s, ExitException)
+	}
+}
+
+var restrictedTests = []struct {
+	text   string
+	status int
+	fails  bool
+}{
+	{"cd /", ExitFailure, false},
+	{"sh -c true", ExitOK, false},
+	{"/bin/sh -c true", 0, true},
+	{"./sh -c true", 0, true},
+	{"sh -c true > /dev/null", 0, true},
+	{"sh -c true < /dev/null", ExitOK, false},
+	{"set $env = $env", ExitFailure, false},
+	{"var $env env", ExitFailure, false},
+}
+
+func TestRestricted(t *testing.T) {
+	for _, tt := range restrictedTests {
+		ev := NewEvaluator()
+		ev.statusCb = nil
+		ev.SetRestricted()
+		n, err := parse.Parse("<test>", tt.text)
+		if err != nil {
+			t.Fatalf("parse.Parse(%q) => error %v", tt.text, err)
+		}
+		err = ev.Eval("<test>", tt.text, n)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("Eval(%q) in restricted mode => no error, want error", tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Eval(%q) in restricted mode => error %v", tt.text, err)
+		} else if ev.Status() != tt.status {
+			t.Errorf("Eval(%q) in restricted mode; Status() => %v, want %v", tt.text, ev.Status(), tt.status)
+		}
+	}
+}
+
+func TestExecFilter(t *testing.T) {
+	var logged []string
+	ev := NewEvaluator()
+	ev.statusCb = nil
+	ev.SetExecFilter(ChainExecFilters(
+		func(path string, argv []string, dir string) error {
+			logged = append(logged, strings.Join(argv[1:], " "))
+			return nil
+		},
+		WhitelistExecFilter([]string{"true"})))
+
+	for _, tt := range []struct {
+		text   string
+		status int
+	}{
+		{"true a b", ExitOK},
+		{`sh -c "exit 0"`, ExitCannotExec},
+	} {
+		n, _ := parse.Parse("<test>", tt.text)
+		if err := ev.Eval("<test>", tt.text, n); err != nil {
+			t.Errorf("Eval(%q) => error %v", tt.text, err)
+		} else if ev.Status() != tt.status {
+			t.Errorf("Eval(%q); Status() => %v, want %v", tt.text, ev.Status(), tt.status)
+		}
+	}
+	wanted := []string{"a b", "-c exit 0"}
+	if !reflect.DeepEqual(logged, wanted) {
+		t.Errorf("logged %v, want %v", logged, wanted)
+	}
+}
+
+func TestExternalCommand(t *testing.T) {
+	var logged []string
+	ev := NewEvaluator()
+	ev.searchPaths = []string{"/bin", "/usr/bin"}
+	ev.SetExecFilter(ChainExecFilters(
+		func(path string, argv []string, dir string) error {
+			logged = append(logged, dir+": "+strings.Join(argv[1:], " "))
+			return nil
+		},
+		WhitelistExecFilter([]string{"sh"})))
+	ev.env.m["FOO"] = "bar"
+
+	cmd, err := ev.ExternalCommand(context.Background(), "/", "sh", "-c", "echo $FOO; pwd")
+	if err != nil {
+		t.Fatalf("ExternalCommand(sh) => error %v", err)
+	}
+	if out, err := cmd.Output(); string(out) != "bar\n/\n" || err != nil {
+		t.Errorf("output of ExternalCommand(sh) => (%q, %v), want (\"bar\\n/\\n\", nil)", out, err)
+	}
+	if _, err := ev.ExternalCommand(context.Background(), "", "true"); err == nil {
+		t.Errorf("ExternalCommand(true) => no error, want error from the filter")
+	}
+	if _, err := ev.ExternalCommand(context.Background(), "", "no-such-command"); err == nil {
+		t.Errorf("ExternalCommand(no-such-command) => no error")
+	}
+	if len(logged) != 2 || logged[0] != "/: -c echo $FOO; pwd" {
+		t.Errorf("logged %q, want the commands found", logged)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestLogExecFilter(t *testing.T) {
+	var buf bytes.Buffer
+	err := LogExecFilter(&buf)("/bin/a\nb", []string{"a\nb", "c"}, "/")
+	if err != nil {
+		t.Errorf("LogExecFilter(...) => error %v", err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("LogExecFilter(...) wrote %q, want one line", buf.String())
+	}
+
+	err = LogExecFilter(failingWriter{})("/bin/true", []string{"true"}, "/")
+	if err == nil {
+		t.Errorf("LogExecFilter(failing writer) => no error, want error")
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	ev := NewEvaluator()
+	ev.statusCb = nil
+	for _, tt := range []struct {
+		text   string
+		status int
+	}{
+		{"env-profile p FOO=bar PATH=/bin:/usr/bin", ExitOK},
+		{"env-profile bad 1=2", ExitFailure},
+		{`with-env p sh -c "test x$FOO = xbar"`, ExitOK},
+		{`sh -c "test x$FOO = xbar"`, ExitFailure},
+		{"with-env q true", ExitFailure},
+	} {
+		n, _ := parse.Parse("<test>", tt.text)
+		if err := ev.Eval("<test>", tt.text, n); err != nil {
+			t.Errorf("Eval(%q) => error %v", tt.text, err)
+		} else if ev.Status() != tt.status {
+			t.Errorf("Eval(%q); Status() => %v, want %v", tt.text, ev.Status(), tt.status)
+		}
+	}
+	if names := ev.EnvProfileNames(); !reflect.DeepEqual(names, []string{"p"}) {
+		t.Errorf("EnvProfileNames() => %v, want [p]", names)
+	}
+}
+
+func TestResolveCommand(t *testing.T) {
+	ev := NewEvaluator()
+	ev.statusCb = nil
+	ev.searchPaths = []string{"/bin"}
+	text := "fn f { put x }"
+	n, _ := parse.Parse("<test>", text)
+	if err := ev.Eval("<test>", text, n); err != nil {
+		t.Fatalf("Eval(%q) => error %v", text, err)
+	}
+	for _, tt := range []struct {
+		name  string
+		kind  CommandKind
+		where string
+		ok    bool
+	}{
+		{"f", CommandDefinedFunction, "<test>:1:7", true},
+		{"var", CommandBuiltinSpecial, "", true},
+		{"put", CommandBuiltinFunction, "", true},
+		{"sh", CommandExternal, "/bin/sh", true},
+		{"/bin/sh", CommandExternal, "/bin/sh", true},
+		{"no-such-command", CommandExternal, "", false},
+	} {
+		kind, where, err := ev.ResolveCommand(tt.name)
+		if kind != tt.kind || (tt.ok && where != tt.where) || (err == nil) != tt.ok {
+			t.Errorf("ResolveCommand(%q) => (%v, %q, %v), want (%v, %q, ok=%v)",
+				tt.name, kind, where, err, tt.kind, tt.where, tt.ok)
+		}
+	}
+}
+
+var checkTests = []struct {
+	text string
+	pos  int // -1 for no error
+}{
+	{"echo $pid", -1},
+	{"echo $nosuch x", 5},
+	{"echo )", 5},
+}
+
+func TestCheck(t *testing.T) {
+	ev := NewEvaluator()
+	for _, tt := range checkTests {
+		pos := -1
+		switch err := ev.Check("<test>", tt.text).(type) {
+		case nil:
+		case *util.ContextualError:
+			pos = err.Pos()
+		default:
+			t.Errorf("Check(%q) => %v, want a ContextualError", tt.text, err)
+			continue
+		}
+		if pos != tt.pos {
+			t.Errorf("Check(%q) => error at %d, want %d", tt.text, pos, tt.pos)
+		}
+	}
+}
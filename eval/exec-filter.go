@@ -0,0 +1,72 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ExecFilter is called before every external command is executed, with the
+// full path of the command, the argument list (including argv[0]) and the
+// working directory. If it returns a non-nil error, the command is not
+// executed and fails with the error.
+type ExecFilter func(path string, argv []string, dir string) error
+
+// SetExecFilter sets the ExecFilter of the Evaluator. Use ChainExecFilters to
+// set more than one.
+func (ev *Evaluator) SetExecFilter(f ExecFilter) {
+	ev.execFilter = f
+}
+
+// ChainExecFilters combines several ExecFilter's into one, which calls them in
+// order and stops at the first error.
+func ChainExecFilters(fs ...ExecFilter) ExecFilter {
+	return func(path string, argv []string, dir string) error {
+		for _, f := range fs {
+			if err := f(path, argv, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LogExecFilter returns an ExecFilter that writes one line for each command
+// to w. w may be a file or a *syslog.Writer. It fails closed: a command that
+// cannot be logged is vetoed with the write error, so that no command escapes
+// the audit log.
+func LogExecFilter(w io.Writer) ExecFilter {
+	return func(path string, argv []string, dir string) error {
+		quoted := make([]string, len(argv))
+		for i, arg := range argv {
+			quoted[i] = quote(arg)
+		}
+		_, err := fmt.Fprintf(w, "%s exec %s in %s: %s\n",
+			time.Now().Format(time.RFC3339), quote(path), quote(dir),
+			strings.Join(quoted, " "))
+		return err
+	}
+}
+
+// WhitelistExecFilter returns an ExecFilter that vetoes all commands except
+// those in allowed. An element of allowed containing a slash matches the full
+// path of a command; others match the last component of it.
+func WhitelistExecFilter(allowed []string) ExecFilter {
+	paths := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, a := range allowed {
+		if strings.ContainsRune(a, '/') {
+			paths[a] = true
+		} else {
+			names[a] = true
+		}
+	}
+	return func(p string, argv []string, dir string) error {
+		if paths[p] || names[path.Base(p)] {
+			return nil
+		}
+		return fmt.Errorf("%s is not whitelisted", p)
+	}
+}
@@ -1,14 +1,19 @@
 package eval
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"strings"
 	"syscall"
 
+	"github.com/xiaq/elvish/logger"
 	"github.com/xiaq/elvish/util"
 )
 
+var log = logger.New("eval")
+
 const (
 	// FdNil is a special impossible fd value. Used for "close fd" in
 	// syscall.ProcAttr.Files.
@@ -76,22 +81,57 @@ type form struct {
 // closePorts closes all ports in ev.ports that were marked shouldClose.
 func (ev *Evaluator) closePorts() {
 	for _, port := range ev.ports {
-		if !port.shouldClose {
-			continue
-		}
-		if port.f != nil {
-			port.f.Close()
-		}
-		if port.ch != nil {
-			close(port.ch)
+		if port != nil && port.shouldClose {
+			port.close()
 		}
 	}
 }
 
+// close closes the file and channel of the port.
+func (p *port) close() {
+	if p.f != nil {
+		p.f.Close()
+	}
+	if p.ch != nil {
+		close(p.ch)
+	}
+}
+
 // StateUpdate represents a change of state of a command.
 type StateUpdate struct {
 	Terminated bool
 	Msg        string
+	Code       int // Exit code, meaningful when Terminated is true
+}
+
+// Exit codes, following the conventions of POSIX shells. A command killed by
+// a signal has an exit code of ExitSignalBase plus the signal number.
+const (
+	ExitOK         = 0
+	ExitFailure    = 1 // A builtin or closure failed
+	ExitException  = 2 // An exception was raised during parsing or evaluation
+	ExitCannotExec = 126
+	ExitSignalBase = 128
+)
+
+// msgCode maps the message returned by a builtin to an exit code.
+func msgCode(msg string) int {
+	if msg == "" {
+		return ExitOK
+	}
+	return ExitFailure
+}
+
+// waitStatusCode maps the wait status of a terminated process to an exit code.
+func waitStatusCode(ws syscall.WaitStatus) int {
+	switch {
+	case ws.Exited():
+		return ws.ExitStatus()
+	case ws.Signaled():
+		return ExitSignalBase + int(ws.Signal())
+	default:
+		return ExitOK
+	}
 }
 
 func isExecutable(path string) bool {
@@ -111,6 +151,9 @@ func isExecutable(path string) bool {
 
 // Search for executable `exe`.
 func (ev *Evaluator) search(exe string) (string, error) {
+	if ev.restricted && strings.ContainsRune(exe, '/') {
+		return "", fmt.Errorf("restricted: command name may not contain /")
+	}
 	for _, p := range []string{"/", "./", "../"} {
 		if strings.HasPrefix(exe, p) {
 			if isExecutable(exe) {
@@ -128,6 +171,48 @@ func (ev *Evaluator) search(exe string) (string, error) {
 	return "", fmt.Errorf("external command not found")
 }
 
+// CommandKind is the kind of command a command name resolves to.
+type CommandKind int
+
+// Possible values of CommandKind, in the order command names are resolved.
+const (
+	CommandDefinedFunction CommandKind = iota
+	CommandBuiltinSpecial
+	CommandBuiltinFunction
+	CommandExternal
+)
+
+var commandKindNames = [...]string{
+	CommandDefinedFunction: "function",
+	CommandBuiltinSpecial:  "builtin special form",
+	CommandBuiltinFunction: "builtin function",
+	CommandExternal:        "external command",
+}
+
+func (k CommandKind) String() string {
+	return commandKindNames[k]
+}
+
+// ResolveCommand finds out how the command name would be resolved if used in
+// a form now. For a function, where is the location of its definition, if
+// known; for an external command, where is its full path. The error is
+// non-nil if no external command is found.
+func (ev *Evaluator) ResolveCommand(name string) (kind CommandKind, where string, err error) {
+	if v, ok := ev.scope["fn-"+name]; ok {
+		if c, ok := (*v).(*Closure); ok {
+			return CommandDefinedFunction, c.Location, nil
+		}
+	}
+	if _, ok := builtinSpecials[name]; ok {
+		return CommandBuiltinSpecial, "", nil
+	}
+	if _, ok := builtinFuncs[name]; ok {
+		return CommandBuiltinFunction, "", nil
+	}
+	path, err := ev.search(name)
+	return CommandExternal, path, err
+}
+
 // execCommand executes a command.
 func (ev *Evaluator) execForm(fm *form) <-chan *StateUpdate {
 	switch {
@@ -151,7 +236,8 @@ func (ev *Evaluator) execClosure(fm *form) <-chan *StateUpdate {
 	// TODO Support optional/rest argument
 	if len(fm.args) != len(fm.Closure.ArgNames) {
 		// TODO Check arity before exec'ing
-		update <- &StateUpdate{Terminated: true, Msg: "arity mismatch"}
+		update <- &StateUpdate{Terminated: true, Msg: "arity mismatch",
+			Code: ExitFailure}
 		close(update)
 		return update
 	}
@@ -171,13 +257,15 @@ func (ev *Evaluator) execClosure(fm *form) <-chan *StateUpdate {
 	go func() {
 		// TODO Support calling closure originated in another source.
 		err := newEv.eval(ev.name, ev.text, fm.Closure.Op)
+		code := newEv.status
 		if err != nil {
 			fmt.Print(err.(*util.ContextualError).Pprint())
+			code = ExitException
 		}
 		// Ports are closed after executaion of closure is complete.
 		newEv.closePorts()
 		// TODO Support returning value.
-		update <- &StateUpdate{Terminated: true}
+		update <- &StateUpdate{Terminated: true, Code: code}
 		close(update)
 	}()
 	return update
@@ -190,7 +278,7 @@ func (ev *Evaluator) execBuiltinSpecial(fm *form) <-chan *StateUpdate {
 		msg := fm.Special(ev)
 		// Ports are closed after executaion of builtin is complete.
 		ev.closePorts()
-		update <- &StateUpdate{Terminated: true, Msg: msg}
+		update <- &StateUpdate{Terminated: true, Msg: msg, Code: msgCode(msg)}
 		close(update)
 	}()
 	return update
@@ -204,7 +292,7 @@ func (ev *Evaluator) execBuiltinFunc(fm *form) <-chan *StateUpdate {
 		msg := fm.Func(ev, fm.args)
 		// Ports are closed after executaion of builtin is complete.
 		ev.closePorts()
-		update <- &StateUpdate{Terminated: true, Msg: msg}
+		update <- &StateUpdate{Terminated: true, Msg: msg, Code: msgCode(msg)}
 		close(update)
 	}()
 	return update
@@ -250,7 +338,8 @@ func waitStateUpdate(pid int, update chan<- *StateUpdate) {
 			break
 		}
 		update <- &StateUpdate{
-			Terminated: ws.Exited(), Msg: printStatus(ws)}
+			Terminated: ws.Exited() || ws.Signaled(), Msg: printStatus(ws),
+			Code: waitStatusCode(ws)}
 	}
 	close(update)
 }
@@ -274,16 +363,32 @@ func (ev *Evaluator) execExternal(fm *form) <-chan *StateUpdate {
 		args[i+1] = a.String()
 	}
 
+	if err := ev.CheckExec(fm.Path, args, ""); err != nil {
+		// Ports are closed as if the command had been executed.
+		ev.closePorts()
+		update := make(chan *StateUpdate, 1)
+		update <- &StateUpdate{Terminated: true, Msg: err.Error(),
+			Code: ExitCannotExec}
+		close(update)
+		return update
+	}
+
 	sys := syscall.SysProcAttr{}
 	attr := syscall.ProcAttr{Env: ev.env.Export(), Files: files[:], Sys: &sys}
 	pid, err := syscall.ForkExec(fm.Path, args, &attr)
 	// Ports are closed after fork-exec of external is complete.
 	ev.closePorts()
+	if err != nil {
+		log.Warn("cannot exec", "path", fm.Path, "err", err)
+	} else {
+		log.Debug("exec", "path", fm.Path, "args", strings.Join(args[1:], " "), "pid", pid)
+	}
 
 	update := make(chan *StateUpdate)
 	if err != nil {
 		go func() {
-			update <- &StateUpdate{Terminated: true, Msg: err.Error()}
+			update <- &StateUpdate{Terminated: true, Msg: err.Error(),
+				Code: ExitCannotExec}
 			close(update)
 		}()
 	} else {
@@ -292,3 +397,46 @@ func (ev *Evaluator) execExternal(fm *form) <-chan *StateUpdate {
 
 	return update
 }
+
+// CheckExec calls the ExecFilter of the Evaluator, if any, for the command at
+// path run with args in dir, or in the working directory if dir is empty. It
+// is for commands the caller runs itself, and may be called from any
+// goroutine.
+func (ev *Evaluator) CheckExec(path string, args []string, dir string) error {
+	if ev.execFilter == nil {
+		return nil
+	}
+	if dir == "" {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			dir = "?"
+		}
+	}
+	err := ev.execFilter(path, args, dir)
+	if err != nil {
+		log.Info("exec filtered", "path", path, "err", err)
+	}
+	return err
+}
+
+// ExternalCommand prepares the external command name to be run with args by
+// Go code instead of a form, like the editor does for previews. The command
+// is found like that of a form, runs with the environment of the Evaluator
+// in dir, or in the working directory if dir is empty, and is checked by the
+// ExecFilter, so that commands run on behalf of the user are restricted and
+// audited like those the user runs. The command is killed when ctx is done.
+func (ev *Evaluator) ExternalCommand(ctx context.Context, dir, name string, args ...string) (*exec.Cmd, error) {
+	path, err := ev.search(name)
+	if err != nil {
+		return nil, err
+	}
+	argv := append([]string{path}, args...)
+	if err := ev.CheckExec(path, argv, dir); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = dir
+	cmd.Env = ev.env.Export()
+	return cmd, nil
+}
@@ -0,0 +1,114 @@
+package eval
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Inputs are what an Evaluator and its builtins take from the outside world,
+// other than files and commands. Evaluators created with NewEvaluator use
+// DefaultInputs; tests of scripts can use DeterministicInputs instead, so that
+// each run gives the same results.
+type Inputs struct {
+	// Environment variables, in the form "key=value" like os.Environ.
+	Environ []string
+	// The value of $pid.
+	Pid int
+	// Now returns the current time, used by now.
+	Now func() time.Time
+	// The source of randomness of rand and randint.
+	Rand *rand.Rand
+}
+
+// DeterministicEpoch is the time of the first call to Now of the inputs
+// returned by DeterministicInputs.
+var DeterministicEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// DeterministicPath is the only environment variable of the inputs returned by
+// DeterministicInputs, so that external commands can still be found.
+const DeterministicPath = "/usr/local/bin:/usr/bin:/bin"
+
+// inputsMutex serializes calls to Now and Rand of Inputs, which are shared by
+// Evaluators running concurrently in pipelines.
+var inputsMutex sync.Mutex
+
+// DefaultInputs returns the inputs of the process: its environment and pid,
+// the real time and randomness seeded with it.
+func DefaultInputs() Inputs {
+	return Inputs{
+		Environ: os.Environ(),
+		Pid:     syscall.Getpid(),
+		Now:     time.Now,
+		Rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// DeterministicInputs returns fake inputs that are the same for the same
+// seed. The environment only has $PATH, set to DeterministicPath, the pid is
+// 1, the time starts at DeterministicEpoch and advances by a second each time
+// Now is called, and randomness is seeded with seed.
+func DeterministicInputs(seed int64) Inputs {
+	t := DeterministicEpoch
+	return Inputs{
+		Environ: []string{"PATH=" + DeterministicPath},
+		Pid:     1,
+		Now: func() time.Time {
+			now := t
+			t = t.Add(time.Second)
+			return now
+		},
+		Rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// now outputs the current time in the format of RFC 3339.
+func now(ev *Evaluator, args []Value) string {
+	if len(args) > 0 {
+		return "args error"
+	}
+	inputsMutex.Lock()
+	t := ev.inputs.Now()
+	inputsMutex.Unlock()
+	ev.ports[1].ch <- NewString(t.Format(time.RFC3339))
+	return ""
+}
+
+// randFloat outputs a random number in [0, 1).
+func randFloat(ev *Evaluator, args []Value) string {
+	if len(args) > 0 {
+		return "args error"
+	}
+	inputsMutex.Lock()
+	f := ev.inputs.Rand.Float64()
+	inputsMutex.Unlock()
+	ev.ports[1].ch <- NewString(fmt.Sprintf("%g", f))
+	return ""
+}
+
+// randInt outputs a random integer in [low, high).
+func randInt(ev *Evaluator, args []Value) string {
+	if len(args) != 2 {
+		return "args error"
+	}
+	low, err := strconv.Atoi(args[0].String())
+	if err != nil {
+		return err.Error()
+	}
+	high, err := strconv.Atoi(args[1].String())
+	if err != nil {
+		return err.Error()
+	}
+	if high <= low {
+		return "high must be larger than low"
+	}
+	inputsMutex.Lock()
+	n := low + ev.inputs.Rand.Intn(high-low)
+	inputsMutex.Unlock()
+	ev.ports[1].ch <- NewString(strconv.Itoa(n))
+	return ""
+}
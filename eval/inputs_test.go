@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"reflect"
+	"testing"
+)
+
+var deterministicTests = []struct {
+	src    string
+	values []string
+}{
+	{"now; now", []string{"2000-01-01T00:00:00Z", "2000-01-01T00:00:01Z"}},
+	{"put $pid", []string{"1"}},
+	{"randint 5 6", []string{"5"}},
+}
+
+func evalDeterministic(t *testing.T, seed int64, src string) []string {
+	ev := NewEvaluatorWithInputs(DeterministicInputs(seed))
+	ev.SetStatusCallback(nil)
+	values, err := ev.EvalSource("<test>", src)
+	if err != nil {
+		t.Errorf("EvalSource(%q) => error %v", src, err)
+	}
+	var strs []string
+	for _, v := range values {
+		strs = append(strs, v.String())
+	}
+	return strs
+}
+
+func TestDeterministicInputs(t *testing.T) {
+	for _, tt := range deterministicTests {
+		if out := evalDeterministic(t, 0, tt.src); !reflect.DeepEqual(out, tt.values) {
+			t.Errorf("EvalSource(%q) => %v, want %v", tt.src, out, tt.values)
+		}
+	}
+
+	src := "rand; randint 0 1000000"
+	first := evalDeterministic(t, 42, src)
+	if again := evalDeterministic(t, 42, src); !reflect.DeepEqual(again, first) {
+		t.Errorf("EvalSource(%q) with the same seed => %v, then %v", src, first, again)
+	}
+	if other := evalDeterministic(t, 43, src); reflect.DeepEqual(other, first) {
+		t.Errorf("EvalSource(%q) with different seeds => %v both times", src, first)
+	}
+}
+
+func TestDeterministicEnv(t *testing.T) {
+	ev := NewEvaluatorWithInputs(DeterministicInputs(0))
+	if env := ev.env.Export(); !reflect.DeepEqual(env, []string{"PATH=" + DeterministicPath}) {
+		t.Errorf("environment => %v, want only PATH", env)
+	}
+}
@@ -2,7 +2,6 @@ package eval
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/xiaq/elvish/parse"
 )
@@ -36,7 +35,7 @@ func combineChunk(ops []valuesOp) Op {
 	}
 }
 
-func combineClosure(ops []valuesOp, enclosed map[string]Type, bounds [2]StreamType) valuesOp {
+func combineClosure(ops []valuesOp, enclosed map[string]Type, bounds [2]StreamType, location string) valuesOp {
 	op := combineChunk(ops)
 	// BUG(xiaq): Closure arguments is (again) not supported
 	ts := []Type{&ClosureType{bounds}}
@@ -45,7 +44,9 @@ func combineClosure(ops []valuesOp, enclosed map[string]Type, bounds [2]StreamTy
 		for name := range enclosed {
 			enclosed[name] = ev.scope[name]
 		}
-		return []Value{NewClosure(nil, op, enclosed, bounds)}
+		c := NewClosure(nil, op, enclosed, bounds)
+		c.Location = location
+		return []Value{c}
 	}
 	return valuesOp{ts, f}
 }
@@ -63,47 +64,7 @@ func combinePipeline(n parse.Node, ops []stateUpdatesOp, bounds [2]StreamType, i
 		if !ev.ports[1].compatible(bounds[1]) {
 			ev.errorfNode(n, "pipeline output not satisfiable")
 		}
-		var nextIn *port
-		updates := make([]<-chan *StateUpdate, len(ops))
-		// For each form, create a dedicated Evaluator and run
-		for i, op := range ops {
-			newEv := ev.copy(fmt.Sprintf("<form op %v>", op), false)
-			if i > 0 {
-				newEv.ports[0] = nextIn
-			}
-			if i < len(ops)-1 {
-				switch internals[i] {
-				case unusedStream:
-					newEv.ports[1] = nil
-					nextIn = nil
-				case fdStream:
-					// os.Pipe sets O_CLOEXEC, which is what we want.
-					reader, writer, e := os.Pipe()
-					if e != nil {
-						ev.errorfNode(n, "failed to create pipe: %s", e)
-					}
-					newEv.ports[1] = &port{f: writer, shouldClose: true}
-					nextIn = &port{f: reader, shouldClose: true}
-				case chanStream:
-					// TODO Buffered channel?
-					ch := make(chan Value)
-					// Only the writer closes the channel port
-					newEv.ports[1] = &port{ch: ch, shouldClose: true}
-					nextIn = &port{ch: ch}
-				default:
-					panic("bad StreamType value")
-				}
-			}
-			updates[i] = op(newEv)
-		}
-		// Collect exit values
-		exits := make([]Value, len(ops))
-		for i, update := range updates {
-			for up := range update {
-				exits[i] = NewString(up.Msg)
-			}
-		}
-		return exits
+		return ev.runPipeline(n, ops, internals)
 	}
 	return valuesOp{ts, f}
 }
@@ -147,9 +108,24 @@ func combineForm(n parse.Node, cmd valuesOp, tlist valuesOp, ports []portOp, a *
 
 		newEv := ev.copy(fmt.Sprintf("<form redir %v>", fm), true)
 		newEv.growPorts(len(ports))
+		// The ports now belong to newEv, which has to close them if a
+		// redirection fails
+		defer func() {
+			if r := recover(); r != nil {
+				newEv.closePorts()
+				panic(r)
+			}
+		}()
 
 		for i, op := range ports {
 			if op != nil {
+				// A redirected port, like the end of a pipe, may need to be
+				// closed so that the other end is not left waiting
+				if old := newEv.ports[i]; old != nil && old.shouldClose {
+					old.shouldClose = false
+					newEv.ports[i] = nil
+					old.close()
+				}
 				newEv.ports[i] = op(ev)
 			}
 		}
@@ -278,13 +254,19 @@ func combineOutputCapture(op valuesOp, bounds [2]StreamType) valuesOp {
 		newEv.ports = make([]*port, len(ev.ports))
 		copy(newEv.ports, ev.ports)
 		ch := make(chan Value)
+		done := make(chan struct{})
 		newEv.ports[1] = &port{ch: ch}
 		go func() {
 			for v := range ch {
 				vs = append(vs, v)
 			}
+			close(done)
 		}()
 		op.f(newEv)
+		// The pipelines of op have terminated; wait for values still being
+		// collected
+		close(ch)
+		<-done
 		return vs
 	}
 	return valuesOp{ts, f}
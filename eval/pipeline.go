@@ -0,0 +1,196 @@
+package eval
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/xiaq/elvish/parse"
+	"github.com/xiaq/elvish/util"
+)
+
+// The forms of a pipeline like "a | b | c" run concurrently, with the output
+// of each form connected to the input of the next one by a pipe, or by a
+// channel if both pass values.
+//
+// All the pipes are made before any form is started. Each end belongs to
+// exactly one form, which closes it when it terminates, or as soon as it has
+// been passed to an external command, so that the next form sees the end of
+// its input and the previous one a broken pipe when a form is done. A form
+// that cannot be started, like that of a command not found, has its ends
+// closed right away, and values sent to a form that is no longer reading them
+// are discarded, so that the other forms never wait for it forever. The
+// error is raised once all of them have terminated.
+//
+// Each form is started in its own goroutine, so that evaluating the command
+// and arguments of one, which may run output captures, doesn't hold off the
+// others.
+//
+// External commands run with the default action of SIGPIPE, since the Go
+// runtime resets the signals it handles when executing them; SIGPIPE must
+// not be ignored with signal.Ignore, or they would inherit that. A form
+// killed by SIGPIPE is taken as having succeeded unless it is the last one,
+// since that is how a form is usually stopped when the next one exits
+// without reading all of its input, like in "yes | head".
+//
+// The exit codes of all the forms are kept in $pipestatus; the exit code of
+// the pipeline, kept in $status, is determined from them by pipelineStatus.
+// Pipelines run within the forms, like in output captures, don't change
+// these variables, which all the forms share.
+
+// exitBrokenPipe is the exit code of a command killed by SIGPIPE.
+const exitBrokenPipe = ExitSignalBase + int(syscall.SIGPIPE)
+
+// pipelineForm is a form of a pipeline being run.
+type pipelineForm struct {
+	ev *Evaluator
+	// The channel the form reads values from, if any.
+	in chan Value
+	// The last state update of the form, or the error that kept it from
+	// starting.
+	msg  string
+	code int
+	err  error
+}
+
+// runPipeline runs ops as the forms of a pipeline, connecting the output of
+// each with the input of the next as given by internals, and waits for all of
+// them to terminate. It returns the exit messages of the forms, and records
+// their exit codes.
+func (ev *Evaluator) runPipeline(n parse.Node, ops []stateUpdatesOp, internals []StreamType) []Value {
+	forms := make([]*pipelineForm, len(ops))
+	for i := range ops {
+		// The ports of ev stay with it; only the ends of pipes made here
+		// are closed by the forms
+		forms[i] = &pipelineForm{ev: ev.copy(ev.name, false)}
+		if len(ops) > 1 {
+			forms[i].ev.concurrent = true
+		}
+	}
+	for i := 0; i < len(ops)-1; i++ {
+		w, r := forms[i].ev, forms[i+1].ev
+		switch internals[i] {
+		case unusedStream:
+			w.ports[1] = nil
+			r.ports[0] = nil
+		case fdStream:
+			// os.Pipe sets O_CLOEXEC, so that the ends are only inherited
+			// by the commands they are passed to.
+			reader, writer, e := os.Pipe()
+			if e != nil {
+				for _, f := range forms {
+					f.ev.closePorts()
+				}
+				ev.errorfNode(n, "failed to create pipe: %s", e)
+			}
+			w.ports[1] = &port{f: writer, shouldClose: true}
+			r.ports[0] = &port{f: reader, shouldClose: true}
+		case chanStream:
+			// TODO Buffered channel?
+			ch := make(chan Value)
+			// Only the writer closes the channel port
+			w.ports[1] = &port{ch: ch, shouldClose: true}
+			r.ports[0] = &port{ch: ch}
+			forms[i+1].in = ch
+		default:
+			panic("bad StreamType value")
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(forms))
+	for i, f := range forms {
+		go func(f *pipelineForm, op stateUpdatesOp) {
+			defer wg.Done()
+			f.run(op)
+		}(f, ops[i])
+	}
+	wg.Wait()
+
+	exits := make([]Value, len(forms))
+	codes := make([]int, len(forms))
+	var err error
+	for i, f := range forms {
+		msg := f.msg
+		if i < len(forms)-1 && f.code == exitBrokenPipe {
+			msg = ""
+		}
+		exits[i] = NewString(msg)
+		codes[i] = f.code
+		if err == nil {
+			err = f.err
+		}
+	}
+	ev.setStatus(pipelineStatus(codes), codes)
+	if err != nil {
+		util.Panic(err)
+	}
+	return exits
+}
+
+// run starts the form and waits for it to terminate, and then discards the
+// values still sent to it.
+func (f *pipelineForm) run(op stateUpdatesOp) {
+	updates, err := f.start(op)
+	if err != nil {
+		f.ev.closePorts()
+		f.code, f.err = ExitException, err
+	} else {
+		for up := range updates {
+			f.msg, f.code = up.Msg, up.Code
+		}
+	}
+	if f.in != nil {
+		for range f.in {
+		}
+	}
+}
+
+// start starts the form, returning the error raised if it cannot be started.
+func (f *pipelineForm) start(op stateUpdatesOp) (updates <-chan *StateUpdate, err error) {
+	defer util.Recover(&err)
+	return op(f.ev), nil
+}
+
+// pipelineStatus determines the exit code of a pipeline from those of its
+// forms. It is that of the last failed form, or ExitOK if all forms succeeded,
+// so that a failure is never masked by a later form in the pipeline. Forms
+// other than the last one killed by SIGPIPE have not failed.
+func pipelineStatus(codes []int) int {
+	for i := len(codes) - 1; i >= 0; i-- {
+		if codes[i] != ExitOK && (codes[i] != exitBrokenPipe || i == len(codes)-1) {
+			return codes[i]
+		}
+	}
+	return ExitOK
+}
+
+// setStatus records the exit code of a pipeline and those of its forms,
+// exposing them as $status and $pipestatus if the variables are visible in
+// the current scope. Evaluators that run concurrently, and those derived from
+// them, like for output captures in the forms of a pipeline, only record
+// them, since the variables are shared; the pipeline sets them once all its
+// forms have terminated.
+func (ev *Evaluator) setStatus(code int, codes []int) {
+	ev.status = code
+	ev.pipeStatus = codes
+	if ev.concurrent {
+		return
+	}
+	if p, ok := ev.scope["status"]; ok {
+		*p = NewString(strconv.Itoa(code))
+	}
+	if p, ok := ev.scope["pipestatus"]; ok {
+		*p = newStatusTable(codes)
+	}
+}
+
+// newStatusTable returns a list of exit codes as a table.
+func newStatusTable(codes []int) *Table {
+	t := NewTable()
+	for _, c := range codes {
+		t.append(NewString(strconv.Itoa(c)))
+	}
+	return t
+}
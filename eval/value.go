@@ -246,11 +246,17 @@ func NewEnv() *Env {
 }
 
 func (e *Env) fill() {
+	e.fillFrom(os.Environ())
+}
+
+// fillFrom fills e with environ, in the form of os.Environ, unless it is
+// already filled.
+func (e *Env) fillFrom(environ []string) {
 	if e.m != nil {
 		return
 	}
 	e.m = make(map[string]string)
-	for _, s := range os.Environ() {
+	for _, s := range environ {
 		arr := strings.SplitN(s, "=", 2)
 		if len(arr) == 2 {
 			e.m[arr[0]] = arr[1]
@@ -310,6 +316,8 @@ type Closure struct {
 	Op       Op
 	Enclosed map[string]*Value
 	Bounds   [2]StreamType
+	// Where the closure is defined, as "name:line:col", or "" if unknown.
+	Location string
 }
 
 func (c *Closure) Type() Type {
@@ -317,7 +325,7 @@ func (c *Closure) Type() Type {
 }
 
 func NewClosure(a []string, op Op, e map[string]*Value, b [2]StreamType) *Closure {
-	return &Closure{a, op, e, b}
+	return &Closure{a, op, e, b, ""}
 }
 
 func (c *Closure) Repr() string {
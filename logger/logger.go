@@ -0,0 +1,229 @@
+// Package logger writes debug logs of elvish to a file. Logs are never
+// written to the terminal, where they would mess up what the editor draws.
+//
+// Each package logs as a module, which is logged at its own level:
+//
+//	var logger = logger.New("edit")
+//	logger.Debug("key read", "key", k)
+//
+// Lines are in logfmt, like
+//
+//	time=15:04:05.000000 level=debug module=edit msg="key read" key=Ctrl-A
+//
+// What is logged is set by a spec, like "warn" for every module, or
+// "edit=debug,eval=info" for some of them; Init reads it from $ELVISH_LOG,
+// and the file to write to from $ELVISH_LOG_FILE. Nothing is logged by
+// default.
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important a log is.
+type Level int
+
+// Possible values for Level. Off is above all levels, and logs nothing.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+	Off
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a Level.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("bad log level %q, want one of %s", s, strings.Join(levelNames, ", "))
+}
+
+var (
+	mutex sync.Mutex
+	// Where logs are written, or nil if nowhere, and the file opened for it.
+	output io.Writer
+	file   *os.File
+	// The levels of modules, and of the others.
+	levels       map[string]Level
+	defaultLevel = Off
+)
+
+// parseSpec parses a spec of what to log.
+func parseSpec(spec string) (map[string]Level, Level, error) {
+	modules := make(map[string]Level)
+	all := Off
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		module, name := "", item
+		if i := strings.IndexByte(item, '='); i != -1 {
+			module, name = item[:i], item[i+1:]
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return nil, Off, err
+		}
+		if module == "" || module == "*" {
+			all = level
+		} else {
+			modules[module] = level
+		}
+	}
+	return modules, all, nil
+}
+
+// Configure sets what to log by spec, and where to write it. Logs are kept
+// when w is nil, like when no file is given; the file opened by Open, if any,
+// is closed.
+func Configure(spec string, w io.Writer) error {
+	modules, all, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	levels, defaultLevel, output = modules, all, w
+	return nil
+}
+
+// Open sets what to log by spec, and appends the logs to the named file.
+func Open(spec, name string) error {
+	if _, _, err := parseSpec(spec); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	Configure(spec, f)
+	mutex.Lock()
+	file = f
+	mutex.Unlock()
+	return nil
+}
+
+// DefaultFile returns the file logs are written to if $ELVISH_LOG_FILE is not
+// set, in the temporary directory.
+func DefaultFile() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("elvish-%d.log", os.Getuid()))
+}
+
+// Init sets what to log from $ELVISH_LOG and $ELVISH_LOG_FILE, if
+// $ELVISH_LOG is set.
+func Init() error {
+	spec := os.Getenv("ELVISH_LOG")
+	if spec == "" {
+		return nil
+	}
+	name := os.Getenv("ELVISH_LOG_FILE")
+	if name == "" {
+		name = DefaultFile()
+	}
+	return Open(spec, name)
+}
+
+// Logger logs as a module.
+type Logger struct {
+	module string
+}
+
+// New returns a Logger logging as module.
+func New(module string) *Logger {
+	return &Logger{module}
+}
+
+// Enabled returns whether logs at level are written, which can save computing
+// values that are expensive to log.
+func (l *Logger) Enabled(level Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return l.enabled(level)
+}
+
+func (l *Logger) enabled(level Level) bool {
+	if output == nil {
+		return false
+	}
+	min, ok := levels[l.module]
+	if !ok {
+		min = defaultLevel
+	}
+	return level >= min && level < Off
+}
+
+// Log writes msg at level, with pairs of keys and values.
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !l.enabled(level) {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "time=%s level=%s module=%s msg=%s",
+		time.Now().Format("15:04:05.000000"), level, quote(l.module), quote(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %s=%s", quote(fmt.Sprint(keyvals[i])), quote(fmt.Sprint(value)))
+	}
+	b.WriteByte('\n')
+	output.Write(b.Bytes())
+}
+
+// Debug writes msg at level Debug, with pairs of keys and values.
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(Debug, msg, keyvals...)
+}
+
+// Info writes msg at level Info, with pairs of keys and values.
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(Info, msg, keyvals...)
+}
+
+// Warn writes msg at level Warn, with pairs of keys and values.
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(Warn, msg, keyvals...)
+}
+
+// Error writes msg at level Error, with pairs of keys and values.
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(Error, msg, keyvals...)
+}
+
+// quote quotes s if it is empty or has spaces, quotes, equal signs or
+// characters that are not printable.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseSpecTests = []struct {
+	spec    string
+	modules map[string]Level
+	all     Level
+	ok      bool
+}{
+	{"", map[string]Level{}, Off, true},
+	{"debug", map[string]Level{}, Debug, true},
+	{"edit=debug, eval=info", map[string]Level{"edit": Debug, "eval": Info}, Off, true},
+	{"*=warn,tty=off", map[string]Level{"tty": Off}, Warn, true},
+	{"edit=loud", nil, Off, false},
+}
+
+func TestParseSpec(t *testing.T) {
+	for _, tt := range parseSpecTests {
+		modules, all, err := parseSpec(tt.spec)
+		if (err == nil) != tt.ok || (tt.ok && (!reflect.DeepEqual(modules, tt.modules) || all != tt.all)) {
+			t.Errorf("parseSpec(%q) => (%v, %v, %v), want (%v, %v, ok %v)",
+				tt.spec, modules, all, err, tt.modules, tt.all, tt.ok)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	if err := Configure("warn,edit=debug", &b); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure("", nil)
+
+	edit, eval := New("edit"), New("eval")
+	edit.Debug("key read", "key", "Ctrl-A", "seq", "")
+	eval.Info("not logged")
+	eval.Error("cannot exec", "path", "/bin/x y")
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	wants := []string{
+		` level=debug module=edit msg="key read" key=Ctrl-A seq=""`,
+		` level=error module=eval msg="cannot exec" path="/bin/x y"`,
+	}
+	if len(lines) != len(wants) {
+		t.Fatalf("logged %q, want %d lines", b.String(), len(wants))
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(lines[i], "time=") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("line %d => %q, want time=... %s", i, lines[i], want)
+		}
+	}
+
+	Configure("debug", nil)
+	if edit.Enabled(Error) {
+		t.Errorf("logs are enabled without a writer")
+	}
+}
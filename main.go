@@ -3,16 +3,27 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/syslog"
 	"os"
 	"os/signal"
 	"os/user"
+	"path"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/xiaq/elvish/edit"
+	"github.com/xiaq/elvish/edit/styled"
+	"github.com/xiaq/elvish/edit/tty"
 	"github.com/xiaq/elvish/eval"
+	"github.com/xiaq/elvish/logger"
 	"github.com/xiaq/elvish/parse"
+	"github.com/xiaq/elvish/upgrade"
 	"github.com/xiaq/elvish/util"
 )
 
@@ -20,15 +31,114 @@ const (
 	sigchSize = 32
 )
 
-// TODO(xiaq): Currently only the editor deals with signals.
-func interact() {
-	ev := eval.NewEvaluator()
-	cmdNum := 0
+var (
+	restricted  = flag.Bool("restricted", false, "run in restricted mode")
+	useTerminfo = flag.Bool("terminfo", false, "use terminfo for escape sequences")
+	hscroll     = flag.Bool("hscroll", false, "scroll long lines horizontally instead of wrapping them")
+	escTimeout  = flag.Duration("esc-timeout", edit.EscTimeout, "how long to wait for another key after Escape before reading it alone")
+	escInstant  = flag.Bool("esc-immediate", false, "read Escape immediately instead of as a prefix for Alt- keys")
+	flowControl = flag.Bool("flow-control", false, "keep Ctrl-S and Ctrl-Q for flow control instead of reading them as keys")
+	ttyMode     = flag.String("tty", "auto", "when to edit on /dev/tty instead of stdin and stdout: auto, always or never")
+	record      = flag.String("record", "", "record the frames drawn and keys read to a file, for reporting drawing problems")
+	replay      = flag.String("replay", "", "draw the frames of a recording made with -record, and exit")
+	doUpgrade   = flag.Bool("upgrade", false, "replace this binary with the latest release and exit")
+	upgradeURL  = flag.String("upgrade-url", upgrade.DefaultBaseURL, "where -upgrade downloads releases from")
+	audit       = flag.String("audit", "", "log external commands to a file, or syslog if \"syslog\"")
+	whitelist   = flag.String("whitelist", "", "comma-separated list of the only external commands allowed")
+	fakeInputs  = flag.Bool("deterministic", false, "use fake time, randomness and environment, for reproducible tests of scripts")
+	seed        = flag.Int64("seed", 0, "the seed of randomness with -deterministic")
+	coverage    = flag.String("coverage", "", "write a coverage report of the script to a file, as HTML if it ends with .html")
+	logSpec     = flag.String("log", "", "what to log, like \"debug\" or \"edit=debug,eval=info\"; overrides $ELVISH_LOG")
+	logFile     = flag.String("log-file", "", "the file to log to; overrides $ELVISH_LOG_FILE")
+)
+
+func newEvaluator() *eval.Evaluator {
+	var ev *eval.Evaluator
+	if *fakeInputs {
+		ev = eval.NewEvaluatorWithInputs(eval.DeterministicInputs(*seed))
+	} else {
+		ev = eval.NewEvaluator()
+	}
+	if *restricted {
+		ev.SetRestricted()
+	}
+
+	var filters []eval.ExecFilter
+	if *audit != "" {
+		var w io.Writer
+		var err error
+		if *audit == "syslog" {
+			w, err = syslog.New(syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "elvish")
+		} else {
+			w, err = os.OpenFile(*audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot open audit log:", err)
+			os.Exit(1)
+		}
+		filters = append(filters, eval.LogExecFilter(w))
+	}
+	if *whitelist != "" {
+		filters = append(filters,
+			eval.WhitelistExecFilter(strings.Split(*whitelist, ",")))
+	}
+	if filters != nil {
+		ev.SetExecFilter(eval.ChainExecFilters(filters...))
+	}
+	return ev
+}
+
+// lineReader reads the lines run by interact.
+type lineReader interface {
+	ReadLine(ctx context.Context) (string, error)
+	// CommandFinished is called with the exit status of each line run.
+	CommandFinished(status int) error
+	Close() error
+}
+
+// plainReader reads lines from input that is not a terminal, like a pipe,
+// without prompts or editing.
+type plainReader struct {
+	r *bufio.Reader
+}
+
+// ReadLine reads a line, without the newline. The last line may lack one.
+// Reads can't be canceled, so ctx is ignored.
+func (pr plainReader) ReadLine(ctx context.Context) (string, error) {
+	line, err := pr.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func (pr plainReader) CommandFinished(status int) error {
+	return nil
+}
+
+func (pr plainReader) Close() error {
+	return nil
+}
+
+// newLineReader returns the editor if stdin is a terminal, and a plainReader
+// otherwise, so that elvish can be fed commands with a pipe. With -tty
+// always, the editor is used on /dev/tty regardless.
+func newLineReader(ev *eval.Evaluator) lineReader {
+	mode, err := edit.ParseTTYMode(*ttyMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if mode != edit.TTYAlways && !tty.IsTerminal(int(os.Stdin.Fd())) {
+		return plainReader{bufio.NewReader(os.Stdin)}
+	}
 
 	username := "???"
+	historyFile := ""
 	user, err := user.Current()
 	if err == nil {
 		username = user.Username
+		historyFile = path.Join(user.HomeDir, ".elvish_history")
 	}
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -39,44 +149,131 @@ func interact() {
 	sigch := make(chan os.Signal, sigchSize)
 	signal.Notify(sigch)
 
-	ed := edit.NewEditor(os.Stdin, ev, sigch)
+	ed, err := edit.New(os.Stdin, os.Stdout, ev, edit.Config{Signals: sigch, TTY: mode})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot open terminal:", err)
+		os.Exit(1)
+	}
+	if *useTerminfo {
+		if err := ed.UseTerminfo(); err != nil {
+			fmt.Println("Cannot use terminfo:", err)
+		}
+	}
+	ed.SetHorizontalScroll(*hscroll)
+	ed.SetFlowControl(*flowControl)
+	ed.SetEscape(*escTimeout, !*escInstant)
+	theme := "auto"
+	if user != nil {
+		if themeFile := path.Join(user.HomeDir, edit.ThemeFile); fileExists(themeFile) {
+			theme = themeFile
+		}
+	}
+	if err := ed.LoadTheme(theme); err != nil {
+		fmt.Println("Cannot load theme:", err)
+	}
+	if historyFile != "" {
+		if err := ed.LoadHistory(historyFile); err != nil {
+			fmt.Println("Cannot load history:", err)
+		}
+	}
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err == nil {
+			err = ed.Record(f)
+		}
+		if err != nil {
+			fmt.Println("Cannot record:", err)
+		}
+	}
+	ed.SetPrompts(func() styled.Text {
+		return styled.Plain(util.Getwd() + "> ")
+	}, func() styled.Text {
+		return styled.Plain(rpromptStr)
+	})
+	return ed
+}
 
-	for {
-		cmdNum++
-		name := fmt.Sprintf("<tty %d>", cmdNum)
+// replaySession draws the frames of the recording in the named file.
+func replaySession(name string) {
+	f, err := os.Open(name)
+	if err == nil {
+		err = edit.Replay(f, os.Stdout)
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot replay:", err)
+		os.Exit(1)
+	}
+}
 
-		prompt := func() string {
-			return util.Getwd() + "> "
+// initLogger sets what to log from the environment and the flags. Logs are
+// never written to the terminal.
+func initLogger() {
+	var err error
+	if *logSpec != "" || *logFile != "" {
+		spec, name := *logSpec, *logFile
+		if spec == "" {
+			spec = os.Getenv("ELVISH_LOG")
+		}
+		if name == "" {
+			name = os.Getenv("ELVISH_LOG_FILE")
 		}
-		rprompt := func() string {
-			return rpromptStr
+		if name == "" {
+			name = logger.DefaultFile()
 		}
+		err = logger.Open(spec, name)
+	} else {
+		err = logger.Init()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot log:", err)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
 
-		lr := ed.ReadLine(prompt, rprompt)
+// TODO(xiaq): Currently only the editor deals with signals.
+func interact() {
+	ev := newEvaluator()
+	cmdNum := 0
+	lr := newLineReader(ev)
+
+	for {
+		cmdNum++
+		name := fmt.Sprintf("<tty %d>", cmdNum)
+
+		line, err := lr.ReadLine(context.Background())
 
-		if lr.EOF {
+		if err == io.EOF {
 			break
-		} else if lr.Err != nil {
-			fmt.Println("Editor error:", lr.Err)
+		} else if err == edit.ErrInterrupted {
+			// Start over
+			continue
+		} else if err != nil {
+			fmt.Println("Editor error:", err)
 			fmt.Println("My pid is", os.Getpid())
 		}
 
-		n, pe := parse.Parse(name, lr.Line)
+		n, pe := parse.Parse(name, line)
 		if pe != nil {
 			fmt.Print(pe.(*util.ContextualError).Pprint())
-			continue
-		}
-
-		ee := ev.Eval(name, lr.Line, n)
-		if ee != nil {
+			ev.SetStatus(eval.ExitException)
+		} else if ee := ev.Eval(name, line, n); ee != nil {
 			if ce, ok := ee.(*util.ContextualError); ok {
 				fmt.Print(ce.Pprint())
 			} else {
 				fmt.Println(ee)
 			}
-			continue
+		}
+		if err := lr.CommandFinished(ev.Status()); err != nil {
+			fmt.Println("Cannot write history:", err)
 		}
 	}
+	// Restore the terminal input for whatever is run after us
+	lr.Close()
 }
 
 func script(name string) {
@@ -96,34 +293,91 @@ func script(name string) {
 	}
 	src := string(bytes)
 
-	ev := eval.NewEvaluator()
+	ev := newEvaluator()
+	var cov *eval.Coverage
+	if *coverage != "" {
+		cov = eval.NewCoverage()
+		ev.SetCoverage(cov)
+	}
 
 	n, pe := parse.Parse(name, src)
 	if pe != nil {
 		fmt.Print(pe.(*util.ContextualError).Pprint())
-		os.Exit(1)
+		os.Exit(eval.ExitException)
 	}
 
 	ee := ev.Eval(name, src, n)
+	if cov != nil {
+		writeCoverage(cov, *coverage)
+	}
 	if ee != nil {
 		fmt.Print(ee.(*util.ContextualError).Pprint())
+		os.Exit(eval.ExitException)
+	}
+	os.Exit(ev.Status())
+}
+
+// writeCoverage writes the coverage report to the named file.
+func writeCoverage(cov *eval.Coverage, name string) {
+	f, err := os.Create(name)
+	if err == nil {
+		if strings.HasSuffix(name, ".html") {
+			err = cov.WriteHTML(f)
+		} else {
+			err = cov.WriteText(f)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot write coverage report:", err)
+	}
+}
+
+func upgradeSelf() {
+	exe, err := os.Executable()
+	if err == nil {
+		err = upgrade.Upgrade(*upgradeURL, exe)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot upgrade:", err)
 		os.Exit(1)
 	}
+	fmt.Println("Upgraded", exe+"; restart elvish to use the new version")
 }
 
 var usage = `Usage:
-    elvish
-    elvish <script>
+    elvish [-restricted] [-audit <file>] [-whitelist <cmds>] [-terminfo] [-hscroll]
+           [-flow-control] [-tty auto|always|never] [-record <file>] [-log <spec> [-log-file <file>]]
+    elvish [-restricted] [-audit <file>] [-whitelist <cmds>]
+           [-deterministic [-seed <n>]] [-coverage <file>] [-log <spec> [-log-file <file>]] <script>
+    elvish -upgrade [-upgrade-url <url>]
+    elvish -replay <file>
 `
 
 func main() {
-	switch len(os.Args) {
-	case 1:
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	initLogger()
+	if *doUpgrade {
+		upgradeSelf()
+		return
+	}
+	if *replay != "" {
+		replaySession(*replay)
+		return
+	}
+	args := flag.Args()
+	switch len(args) {
+	case 0:
 		interact()
-	case 2:
-		script(os.Args[1])
+	case 1:
+		script(args[0])
 	default:
-		fmt.Fprintf(os.Stderr, usage)
+		flag.Usage()
 		os.Exit(1)
 	}
 }
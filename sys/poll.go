@@ -0,0 +1,59 @@
+package sys
+
+/*
+#include <poll.h>
+*/
+import "C"
+
+import (
+	"syscall"
+	"time"
+)
+
+// Events of PollFd.
+const (
+	POLLIN   = C.POLLIN
+	POLLOUT  = C.POLLOUT
+	POLLERR  = C.POLLERR
+	POLLHUP  = C.POLLHUP
+	POLLNVAL = C.POLLNVAL
+)
+
+// PollFd is an fd to wait for with Poll: the events waited for, and those that
+// happened.
+type PollFd struct {
+	Fd      int
+	Events  int16
+	Revents int16
+}
+
+// Poll waits until events happen on one of fds, or timeout passes, and sets
+// their Revents. A negative timeout waits forever. Unlike Select, it works
+// with fds of any number. It returns the number of fds with events, which is
+// 0 on timeout.
+func Poll(fds []PollFd, timeout time.Duration) (int, error) {
+	cfds := make([]C.struct_pollfd, len(fds))
+	for i, fd := range fds {
+		cfds[i] = C.struct_pollfd{fd: C.int(fd.Fd), events: C.short(fd.Events)}
+	}
+	ms := -1
+	if timeout >= 0 {
+		// Round up, so that a short timeout doesn't become a busy loop
+		ms = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	var p *C.struct_pollfd
+	if len(cfds) > 0 {
+		p = &cfds[0]
+	}
+	n, err := C.poll(p, C.nfds_t(len(cfds)), C.int(ms))
+	if n < 0 {
+		if err == nil {
+			err = syscall.EINVAL
+		}
+		return 0, err
+	}
+	for i := range fds {
+		fds[i].Revents = int16(cfds[i].revents)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,17 @@
+package sys
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Prlimit sets the limit of resource, one of syscall.RLIMIT_*, for the
+// process pid.
+func Prlimit(pid int, resource int, lim *syscall.Rlimit) error {
+	_, _, e := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid),
+		uintptr(resource), uintptr(unsafe.Pointer(lim)), 0, 0, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
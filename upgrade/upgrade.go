@@ -0,0 +1,121 @@
+// Package upgrade replaces the elvish binary with the latest release.
+package upgrade
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// DefaultBaseURL is where releases are downloaded from by default. For each
+// platform, a release consists of a binary named by BinaryName, and a file
+// with the same name plus ".sha256" containing its SHA-256 checksum in the
+// output format of sha256sum.
+const DefaultBaseURL = "https://github.com/xiaq/elvish/releases/latest/download/"
+
+// maxChecksumSize limits the size of checksum files read.
+const maxChecksumSize = 1024
+
+// BinaryName returns the name of the release binary for the current
+// platform.
+func BinaryName() string {
+	return "elvish-" + runtime.GOOS + "-" + runtime.GOARCH
+}
+
+func get(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parseChecksum finds the checksum of the file called name in the content of
+// a checksum file.
+func parseChecksum(content, name string) ([]byte, error) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// The name may be prefixed by '*' for binary mode
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("bad checksum for %s", name)
+		}
+		return sum, nil
+	}
+	return nil, fmt.Errorf("no checksum for %s", name)
+}
+
+// Upgrade downloads the release for the current platform from baseURL,
+// verifies its checksum and replaces the file exe with it. The new binary is
+// written to a temporary file in the same directory and then renamed over
+// exe, so exe is replaced atomically and left untouched on any error.
+func Upgrade(baseURL, exe string) error {
+	name := BinaryName()
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	body, err := get(baseURL + name + ".sha256")
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(body, maxChecksumSize))
+	body.Close()
+	if err != nil {
+		return err
+	}
+	wanted, err := parseChecksum(string(content), name)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(exe), ".elvish-upgrade-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	body, err = get(baseURL + name)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, wanted) {
+		return fmt.Errorf("checksum mismatch: got %x, want %x", sum, wanted)
+	}
+
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), exe)
+}
@@ -0,0 +1,89 @@
+package upgrade
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+var parseChecksumTests = []struct {
+	content string
+	ok      bool
+}{
+	{"", false},
+	{hexSum("x") + "  other\n", false},
+	{hexSum("x") + "  other\n" + hexSum("x") + "  elvish\n", true},
+	{hexSum("x") + " *elvish\n", true},
+	{"abcd  elvish\n", false},
+}
+
+func hexSum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseChecksum(t *testing.T) {
+	for _, tt := range parseChecksumTests {
+		_, err := parseChecksum(tt.content, "elvish")
+		if (err == nil) != tt.ok {
+			t.Errorf("parseChecksum(%q) => error %v, want ok = %v", tt.content, err, tt.ok)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	const release = "new binary"
+	checksum := hexSum(release)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good/"+BinaryName(), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(release))
+	})
+	mux.HandleFunc("/good/"+BinaryName()+".sha256", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(checksum + "  " + BinaryName() + "\n"))
+	})
+	mux.HandleFunc("/bad/"+BinaryName(), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	})
+	mux.HandleFunc("/bad/"+BinaryName()+".sha256", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(checksum + "  " + BinaryName() + "\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "elvish-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := path.Join(dir, "elvish")
+	if err := ioutil.WriteFile(exe, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, base := range []string{"/bad/", "/missing/"} {
+		if err := Upgrade(server.URL+base, exe); err == nil {
+			t.Errorf("Upgrade(%q) => no error, want error", base)
+		}
+		if content, _ := ioutil.ReadFile(exe); string(content) != "old binary" {
+			t.Errorf("Upgrade(%q) changed the binary to %q", base, content)
+		}
+	}
+
+	if err := Upgrade(server.URL+"/good", exe); err != nil {
+		t.Errorf("Upgrade => error %v", err)
+	}
+	if content, _ := ioutil.ReadFile(exe); string(content) != release {
+		t.Errorf("Upgrade changed the binary to %q, want %q", content, release)
+	}
+	if info, err := os.Stat(exe); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Upgrade changed the mode to %v (error %v), want 0755", info.Mode(), err)
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Errorf("Upgrade left %d files, want 1", len(names))
+	}
+}
@@ -1,39 +1,66 @@
 package util
 
 import (
-	"bufio"
 	"io"
 	"os"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/xiaq/elvish/sys"
 )
 
 const (
 	asyncReaderChanSize int = 128
+	asyncReaderBufSize  int = 256
 )
 
+// asyncReaderUTF8Timeout is how long AsyncReader waits for the rest of an
+// incomplete UTF-8 sequence before delivering its bytes as utf8.RuneError.
+const asyncReaderUTF8Timeout = 50 * time.Millisecond
+
 const (
 	asyncReaderStop     byte = 's'
 	asyncReaderContinue      = 'c'
 	asyncReaderQuit          = 'q'
+	asyncReaderWatch         = 'w'
 )
 
-// AsyncReader delivers a Unix fd stream to a channel of runes.
+// AsyncReader delivers a Unix fd stream to a channel of runes. The stream is
+// decoded with a UTF8Decoder. The channel is closed at the end of the stream,
+// or when the AsyncReader is closed.
+//
+// It can also wait on other fds along with the stream, like those of signals
+// or sockets, so that a program waits on all of them in one place; see Watch.
 type AsyncReader struct {
 	rd           *os.File
-	bufrd        *bufio.Reader
+	dec          UTF8Decoder
 	rCtrl, wCtrl *os.File
 	ackCtrl      chan bool // Used to synchronize receiving of ctrl message
 	ch           chan rune
+	// quit is closed by Close, and done when the goroutine exits.
+	quit, done chan struct{}
+	closeOnce  sync.Once
+	// The fds watched, guarded by watchMutex.
+	watchMutex sync.Mutex
+	watches    map[int]*asyncWatch
+}
+
+// asyncWatch is an fd watched by an AsyncReader, with the channel its
+// readiness is delivered to, and whether it is waited on.
+type asyncWatch struct {
+	ch      chan struct{}
+	waiting bool
 }
 
 func NewAsyncReader(rd *os.File) *AsyncReader {
 	ar := &AsyncReader{
 		rd:      rd,
-		bufrd:   bufio.NewReaderSize(rd, 0),
 		ackCtrl: make(chan bool),
 		ch:      make(chan rune, asyncReaderChanSize),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		watches: make(map[int]*asyncWatch),
 	}
 
 	r, w, err := os.Pipe()
@@ -49,20 +76,92 @@ func (ar *AsyncReader) Chan() <-chan rune {
 	return ar.ch
 }
 
+// Watch makes the AsyncReader wait on fd too, and returns a channel that
+// receives a value when fd becomes readable. The AsyncReader then stops
+// waiting on fd, so that it doesn't deliver the same readiness again before
+// the caller has read from fd; calling Watch again waits on it again, and
+// returns the same channel. Fds are not waited on while the AsyncReader is
+// stopped.
+func (ar *AsyncReader) Watch(fd int) <-chan struct{} {
+	ar.watchMutex.Lock()
+	w := ar.watches[fd]
+	if w == nil {
+		w = &asyncWatch{ch: make(chan struct{}, 1)}
+		ar.watches[fd] = w
+	}
+	w.waiting = true
+	ar.watchMutex.Unlock()
+	ar.ctrl(asyncReaderWatch)
+	return w.ch
+}
+
+// Unwatch makes the AsyncReader stop waiting on fd. No more values are sent
+// to the channel returned by Watch.
+func (ar *AsyncReader) Unwatch(fd int) {
+	ar.watchMutex.Lock()
+	delete(ar.watches, fd)
+	ar.watchMutex.Unlock()
+	ar.ctrl(asyncReaderWatch)
+}
+
+// pollFds returns the fds to wait on: the stream, the control pipe, and the
+// fds watched and waiting.
+func (ar *AsyncReader) pollFds() []sys.PollFd {
+	fds := []sys.PollFd{
+		{Fd: int(ar.rd.Fd()), Events: sys.POLLIN},
+		{Fd: int(ar.rCtrl.Fd()), Events: sys.POLLIN}}
+	ar.watchMutex.Lock()
+	defer ar.watchMutex.Unlock()
+	for fd, w := range ar.watches {
+		if w.waiting {
+			fds = append(fds, sys.PollFd{Fd: fd, Events: sys.POLLIN})
+		}
+	}
+	return fds
+}
+
+// deliverWatched delivers the readiness of the fds watched in fds, and
+// returns whether there were any.
+func (ar *AsyncReader) deliverWatched(fds []sys.PollFd) bool {
+	ar.watchMutex.Lock()
+	defer ar.watchMutex.Unlock()
+	delivered := false
+	for _, pfd := range fds {
+		if pfd.Revents == 0 {
+			continue
+		}
+		if w := ar.watches[pfd.Fd]; w != nil && w.waiting {
+			w.waiting = false
+			select {
+			case w.ch <- struct{}{}:
+			default:
+				// The last readiness is still not received
+			}
+		}
+		delivered = true
+	}
+	return delivered
+}
+
 func (ar *AsyncReader) run() {
 	fd := int(ar.rd.Fd())
-	cfd := int(ar.rCtrl.Fd())
-	maxfd := MaxInt(fd, cfd)
-	fs := sys.NewFdSet()
+	fds := ar.pollFds()
 	var cBuf [1]byte
+	var buf [asyncReaderBufSize]byte
+	var runes []rune
 
+	defer close(ar.done)
 	defer close(ar.ch)
 
 	sys.SetNonblock(fd, true)
+	defer sys.SetNonblock(fd, false)
 
 	for {
-		fs.Set(fd, cfd)
-		_, err := sys.Select(maxfd+1, fs, nil, nil, nil)
+		timeout := time.Duration(-1)
+		if ar.dec.Pending() {
+			timeout = asyncReaderUTF8Timeout
+		}
+		n, err := sys.Poll(fds, timeout)
 		if err != nil {
 			switch err {
 			case syscall.EINTR:
@@ -71,16 +170,28 @@ func (ar *AsyncReader) run() {
 				panic(err)
 			}
 		}
-		if fs.IsSet(cfd) {
+		if n == 0 {
+			// Timed out waiting for the rest of a UTF-8 sequence
+			if !ar.send(ar.dec.Flush(runes[:0])) {
+				return
+			}
+			continue
+		}
+		ctrlReady, inReady := fds[1].Revents != 0, fds[0].Revents != 0
+		if ar.deliverWatched(fds[2:]) {
+			fds = ar.pollFds()
+		}
+		if ctrlReady {
 			// Consume the written byte
 			ar.rCtrl.Read(cBuf[:])
 			switch cBuf[0] {
 			case asyncReaderQuit:
-				sys.SetNonblock(fd, false)
-				ar.ackCtrl <- true
 				return
 			case asyncReaderContinue:
 				ar.ackCtrl <- true
+			case asyncReaderWatch:
+				fds = ar.pollFds()
+				ar.ackCtrl <- true
 			case asyncReaderStop:
 				sys.SetNonblock(fd, false)
 				ar.ackCtrl <- true
@@ -89,32 +200,38 @@ func (ar *AsyncReader) run() {
 					ar.rCtrl.Read(cBuf[:])
 					switch cBuf[0] {
 					case asyncReaderQuit:
-						ar.ackCtrl <- true
 						return
 					case asyncReaderContinue:
 						sys.SetNonblock(fd, true)
+						fds = ar.pollFds()
 						ar.ackCtrl <- true
 						break Stop
-					case asyncReaderStop:
+					case asyncReaderStop, asyncReaderWatch:
 						ar.ackCtrl <- true
 					}
 				}
 			}
-		} else {
-		ReadRune:
+		} else if inReady {
+		Read:
 			for {
-				r, _, err := ar.bufrd.ReadRune()
+				nr, err := ar.rd.Read(buf[:])
+				if nr > 0 {
+					runes = ar.dec.Decode(runes[:0], buf[:nr])
+					if !ar.send(runes) {
+						return
+					}
+				}
 				switch err {
 				case nil:
-					ar.ch <- r
 				case io.EOF:
+					ar.send(ar.dec.Flush(runes[:0]))
 					return
 				default:
 					// BUG(xiaq): AsyncReader relies on the undocumented fact
 					// that (*os.File).Read returns an *os.File.PathError
 					e := err.(*os.PathError).Err
 					if e == syscall.EWOULDBLOCK || e == syscall.EAGAIN {
-						break ReadRune
+						break Read
 					} else {
 						panic(err)
 					}
@@ -124,12 +241,29 @@ func (ar *AsyncReader) run() {
 	}
 }
 
+// send delivers runes to the channel. It returns false if the AsyncReader has
+// been closed in the meantime.
+func (ar *AsyncReader) send(runes []rune) bool {
+	for _, r := range runes {
+		select {
+		case ar.ch <- r:
+		case <-ar.quit:
+			return false
+		}
+	}
+	return true
+}
+
 func (ar *AsyncReader) ctrl(r byte) {
 	_, err := ar.wCtrl.Write([]byte{r})
 	if err != nil {
 		panic(err)
 	}
-	<-ar.ackCtrl
+	select {
+	case <-ar.ackCtrl:
+	case <-ar.done:
+		// The goroutine has exited at the end of the stream
+	}
 }
 
 func (ar *AsyncReader) Stop() {
@@ -140,6 +274,21 @@ func (ar *AsyncReader) Continue() {
 	ar.ctrl(asyncReaderContinue)
 }
 
-func (ar *AsyncReader) Quit() {
-	ar.ctrl(asyncReaderQuit)
+// Close stops the goroutine of the AsyncReader and waits for it to exit,
+// restoring the blocking mode of the fd, and releases the pipe used to control
+// it. The channel is closed. It can be called more than once, and after the end
+// of the stream.
+func (ar *AsyncReader) Close() error {
+	var err error
+	ar.closeOnce.Do(func() {
+		close(ar.quit)
+		// Wake up the goroutine if it is waiting in select or stopped
+		ar.wCtrl.Write([]byte{asyncReaderQuit})
+		<-ar.done
+		err = ar.rCtrl.Close()
+		if err2 := ar.wCtrl.Close(); err == nil {
+			err = err2
+		}
+	})
+	return err
 }
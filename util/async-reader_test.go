@@ -0,0 +1,163 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newPipe(t *testing.T) (r, w *os.File) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	return os.NewFile(uintptr(fds[0]), "r"), os.NewFile(uintptr(fds[1]), "w")
+}
+
+// closeWithin calls ar.Close, failing if it does not return within a second.
+func closeWithin(t *testing.T, ar *AsyncReader) {
+	done := make(chan error)
+	go func() { done <- ar.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Close() => %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not return")
+	}
+}
+
+func TestAsyncReaderClose(t *testing.T) {
+	r, w := newPipe(t)
+	defer r.Close()
+	defer w.Close()
+
+	ar := NewAsyncReader(r)
+	w.WriteString("a")
+	if got := <-ar.Chan(); got != 'a' {
+		t.Errorf("read %q, want 'a'", got)
+	}
+	// Fill the channel, so that the goroutine is blocked sending
+	w.WriteString(strings.Repeat("b", asyncReaderChanSize*2))
+	time.Sleep(10 * time.Millisecond)
+	closeWithin(t, ar)
+	for range ar.Chan() {
+	}
+	// Closing again is fine
+	closeWithin(t, ar)
+
+	// Closing a stopped AsyncReader
+	ar = NewAsyncReader(r)
+	ar.Stop()
+	closeWithin(t, ar)
+}
+
+func TestAsyncReaderEOF(t *testing.T) {
+	r, w := newPipe(t)
+	defer r.Close()
+
+	ar := NewAsyncReader(r)
+	w.WriteString("a")
+	w.Close()
+	var runes []rune
+	for r := range ar.Chan() {
+		runes = append(runes, r)
+	}
+	if string(runes) != "a" {
+		t.Errorf("read %q, want \"a\"", string(runes))
+	}
+	// Controlling the AsyncReader after the end of the stream does not block
+	ar.Stop()
+	closeWithin(t, ar)
+}
+
+func TestAsyncReaderHighFd(t *testing.T) {
+	// Fds from 1024 are out of the range of fd sets of select
+	const highFd = 1500
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil || lim.Max <= highFd {
+		t.Skip("cannot open fd", highFd)
+	}
+	if lim.Cur <= highFd {
+		saved := lim
+		lim.Cur = highFd + 1
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+			t.Skip("cannot open fd", highFd)
+		}
+		defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &saved)
+	}
+	r0, w := newPipe(t)
+	defer w.Close()
+	if err := syscall.Dup2(int(r0.Fd()), highFd); err != nil {
+		t.Skip("cannot open fd", highFd)
+	}
+	r0.Close()
+	r := os.NewFile(highFd, "r")
+	defer r.Close()
+
+	ar := NewAsyncReader(r)
+	w.WriteString("a")
+	select {
+	case got := <-ar.Chan():
+		if got != 'a' {
+			t.Errorf("read %q, want 'a'", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("nothing read from fd %d", highFd)
+	}
+	closeWithin(t, ar)
+}
+
+// readyWithin returns whether ch receives a value within d.
+func readyWithin(ch <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestAsyncReaderWatch(t *testing.T) {
+	r, w := newPipe(t)
+	defer r.Close()
+	defer w.Close()
+	r2, w2 := newPipe(t)
+	defer r2.Close()
+	defer w2.Close()
+
+	ar := NewAsyncReader(r)
+	defer closeWithin(t, ar)
+	ready := ar.Watch(int(r2.Fd()))
+	if readyWithin(ready, 10*time.Millisecond) {
+		t.Errorf("watched fd ready before it is written")
+	}
+	w2.WriteString("x")
+	if !readyWithin(ready, time.Second) {
+		t.Fatalf("watched fd not ready after it is written")
+	}
+	// Not waited on again until watched again, even though it is not read
+	// from, and the stream is still read
+	w.WriteString("a")
+	if got := <-ar.Chan(); got != 'a' {
+		t.Errorf("read %q, want 'a' with an fd watched", got)
+	}
+	if readyWithin(ready, 10*time.Millisecond) {
+		t.Errorf("watched fd ready again before being watched again")
+	}
+	if ar.Watch(int(r2.Fd())) != ready || !readyWithin(ready, time.Second) {
+		t.Errorf("fd still readable not ready after being watched again")
+	}
+
+	var buf [1]byte
+	r2.Read(buf[:])
+	ar.Watch(int(r2.Fd()))
+	ar.Unwatch(int(r2.Fd()))
+	w2.WriteString("y")
+	if readyWithin(ready, 10*time.Millisecond) {
+		t.Errorf("fd ready after being unwatched")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 type ContextualError struct {
 	name   string
+	pos    int
 	lineno int
 	colno  int
 	line   string
@@ -16,7 +17,17 @@ type ContextualError struct {
 
 func NewContextualError(name string, text string, pos int, format string, args ...interface{}) *ContextualError {
 	lineno, colno, line := FindContext(text, pos)
-	return &ContextualError{name, lineno, colno, line, fmt.Sprintf(format, args...)}
+	return &ContextualError{name, pos, lineno, colno, line, fmt.Sprintf(format, args...)}
+}
+
+// Pos returns the byte position of the error in the text.
+func (e *ContextualError) Pos() int {
+	return e.pos
+}
+
+// Msg returns the error message without the position information.
+func (e *ContextualError) Msg() string {
+	return e.msg
 }
 
 func (e *ContextualError) Error() string {
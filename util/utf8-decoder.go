@@ -0,0 +1,39 @@
+package util
+
+import "unicode/utf8"
+
+// UTF8Decoder decodes a byte stream that arrives in chunks into runes. A
+// sequence split across chunks is kept until it is complete, and each byte
+// that doesn't start a valid sequence is decoded as utf8.RuneError, so that
+// invalid input (like latin-1 text) never desynchronizes the stream.
+type UTF8Decoder struct {
+	pending []byte
+}
+
+// Decode decodes p, appending the runes to rs. An incomplete sequence at the
+// end of p is kept for the next call to Decode.
+func (d *UTF8Decoder) Decode(rs []rune, p []byte) []rune {
+	buf := append(d.pending, p...)
+	for len(buf) > 0 && utf8.FullRune(buf) {
+		r, size := utf8.DecodeRune(buf)
+		rs = append(rs, r)
+		buf = buf[size:]
+	}
+	d.pending = append(d.pending[:0], buf...)
+	return rs
+}
+
+// Pending returns whether an incomplete sequence is kept.
+func (d *UTF8Decoder) Pending() bool {
+	return len(d.pending) > 0
+}
+
+// Flush gives up on the incomplete sequence kept, appending a utf8.RuneError
+// to rs for each of its bytes.
+func (d *UTF8Decoder) Flush(rs []rune) []rune {
+	for range d.pending {
+		rs = append(rs, utf8.RuneError)
+	}
+	d.pending = d.pending[:0]
+	return rs
+}
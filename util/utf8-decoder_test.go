@@ -0,0 +1,39 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+var utf8DecoderTests = []struct {
+	chunks []string
+	out    []rune
+}{
+	{[]string{"ab"}, []rune("ab")},
+	{[]string{"\xe4", "\xbd", "\xa0a"}, []rune("你a")},
+	{[]string{"a\xe4\xbd", "\xa0"}, []rune("a你")},
+	// Latin-1
+	{[]string{"caf\xe9"}, []rune("caf�")},
+	{[]string{"caf\xe9 x"}, []rune("caf� x")},
+	{[]string{"\xe4\xbd", "a"}, []rune("��a")},
+	{[]string{"\xff\xfe"}, []rune("��")},
+	// A U+FFFD in the input is kept
+	{[]string{"\xef\xbf", "\xbd"}, []rune("�")},
+}
+
+func TestUTF8Decoder(t *testing.T) {
+	for _, tt := range utf8DecoderTests {
+		var d UTF8Decoder
+		var out []rune
+		for _, chunk := range tt.chunks {
+			out = d.Decode(out, []byte(chunk))
+		}
+		out = d.Flush(out)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("Decode(%q) => %q, want %q", tt.chunks, string(out), string(tt.out))
+		}
+		if d.Pending() {
+			t.Errorf("Decode(%q) leaves pending bytes after Flush", tt.chunks)
+		}
+	}
+}